@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+)
+
+// SetActivator serves POST /activate, which calls activate to claim the
+// addresses of BMCs started in standby
+func (s *Server) SetActivator(activate func(ctx context.Context) error) {
+	s.mux.HandleFunc("POST /activate", func(w http.ResponseWriter, r *http.Request) {
+		if err := activate(r.Context()); err != nil {
+			s.log.Errorf("Activation failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server is the HTTP admin API. It has no authentication, so it should
+// only listen on loopback or a management network.
+type Server struct {
+	http     *http.Server
+	listener net.Listener
+	mux      *http.ServeMux
+	log      *logrus.Entry
+}
+
+// NewServer creates an admin API listening on addr, streaming logs from hub
+func NewServer(addr string, hub *LogHub) *Server {
+	mux := http.NewServeMux()
+	s := &Server{
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		mux: mux,
+		log: logrus.WithField("component", "admin"),
+	}
+	mux.Handle("/logs", hub)
+	return s
+}
+
+// Start listens on the admin address and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.http.Addr, err)
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("Admin API stopped: %v", err)
+		}
+	}()
+	s.log.Infof("Admin API listening on %s", listener.Addr())
+	return nil
+}
+
+// Stop closes the listener and open connections, including log streams
+func (s *Server) Stop(ctx context.Context) error {
+	if err := s.http.Shutdown(ctx); err != nil {
+		return s.http.Close()
+	}
+	return nil
+}
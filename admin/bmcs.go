@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// BMC describes a virtual BMC and its VM
+type BMC struct {
+	VM         string              `json:"vm"`
+	MoRef      string              `json:"moref,omitempty"`   // Managed object reference value, e.g. vm-42
+	VCenter    string              `json:"vcenter,omitempty"` // Name of the VM's vCenter, when several are managed
+	UUID       string              `json:"uuid"`
+	IP         string              `json:"ip"`
+	Port       int                 `json:"port"`
+	PowerState string              `json:"power_state,omitempty"` // Listings only, when it can be read
+	NumCPU     int                 `json:"num_cpu,omitempty"`     // Cached VM sizing, once a client has read the device ID
+	MemoryMB   int                 `json:"memory_mb,omitempty"`   // Likewise
+	Tags       map[string][]string `json:"tags,omitempty"`        // Tag names by category, when the tagging service is available
+
+	DuplicateUUID bool `json:"duplicate_instance_uuid,omitempty"` // Another VM has the same instance UUID
+}
+
+// bmcConflict is the response when several VMs share the requested UUID
+type bmcConflict struct {
+	Error string `json:"error"`
+	BMCs  []*BMC `json:"bmcs"`
+}
+
+// Inventory looks up BMCs by their VM's BIOS UUID. Bad clones can leave
+// several VMs with the same UUID, so every match is returned. List returns
+// every BMC with its VM's power state.
+type Inventory interface {
+	BMCs(ctx context.Context, uuid string) []*BMC
+	List(ctx context.Context) []*BMC
+}
+
+// SetInventory serves /bmcs and /bmcs/{uuid} from inv. A UUID shared by
+// several VMs is answered with 409 Conflict listing all of them.
+func (s *Server) SetInventory(inv Inventory) {
+	s.mux.HandleFunc("GET /bmcs", func(w http.ResponseWriter, r *http.Request) {
+		bmcs := inv.List(r.Context())
+		if bmcs == nil {
+			bmcs = []*BMC{} // An empty list rather than null
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(bmcs); err != nil {
+			s.log.Debugf("Failed to write BMC list: %v", err)
+		}
+	})
+
+	s.mux.HandleFunc("GET /bmcs/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		uuid := r.PathValue("uuid")
+		bmcs := inv.BMCs(r.Context(), uuid)
+		if len(bmcs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		var body interface{} = bmcs[0]
+		if len(bmcs) > 1 {
+			w.WriteHeader(http.StatusConflict)
+			body = &bmcConflict{Error: "UUID " + uuid + " is shared by several VMs", BMCs: bmcs}
+		}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			s.log.Debugf("Failed to write BMC response: %v", err)
+		}
+	})
+}
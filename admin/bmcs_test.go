@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeInventory serves fixed BMCs
+type fakeInventory []*BMC
+
+// BMCs returns the BMCs with the UUID
+func (inv fakeInventory) BMCs(ctx context.Context, uuid string) []*BMC {
+	var bmcs []*BMC
+	for _, bmc := range inv {
+		if strings.EqualFold(bmc.UUID, uuid) {
+			bmcs = append(bmcs, bmc)
+		}
+	}
+	return bmcs
+}
+
+// List returns every BMC
+func (inv fakeInventory) List(ctx context.Context) []*BMC {
+	return inv
+}
+
+func TestBMCList(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		inv  fakeInventory
+		want string
+	}{
+		{"empty", nil, "[]\n"},
+		{"listed", fakeInventory{
+			{VM: "web-01", MoRef: "vm-42", UUID: "4211", IP: "192.168.1.200", Port: 623, PowerState: "poweredOn"},
+		}, `[{"vm":"web-01","moref":"vm-42","uuid":"4211","ip":"192.168.1.200","port":623,"power_state":"poweredOn"}]` + "\n"},
+	} {
+		s := NewServer("127.0.0.1:0", NewLogHub())
+		s.SetInventory(tc.inv)
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bmcs", nil))
+		if w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("%s: GET /bmcs answered %d %q, want %q", tc.name, w.Code, w.Body.String(), tc.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: content type is %q", tc.name, ct)
+		}
+	}
+}
+
+func TestBMCLookup(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewLogHub())
+	s.SetInventory(fakeInventory{
+		{VM: "web-01", UUID: "4211-a", IP: "192.168.1.200", Port: 623},
+		{VM: "clone-1", UUID: "4211-b", IP: "192.168.1.201", Port: 623},
+		{VM: "clone-2", UUID: "4211-b", IP: "192.168.1.202", Port: 623},
+	})
+	get := func(uuid string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bmcs/"+uuid, nil))
+		return w
+	}
+
+	w := get("4211-A")
+	var bmc BMC
+	if err := json.NewDecoder(w.Body).Decode(&bmc); w.Code != http.StatusOK || err != nil || bmc.VM != "web-01" {
+		t.Errorf("looking up 4211-A answered %d with %+v (%v), want web-01", w.Code, bmc, err)
+	}
+
+	w = get("4211-b")
+	var conflict bmcConflict
+	if err := json.NewDecoder(w.Body).Decode(&conflict); w.Code != http.StatusConflict || err != nil || len(conflict.BMCs) != 2 {
+		t.Errorf("looking up a shared UUID answered %d with %+v (%v), want 409 listing both", w.Code, conflict, err)
+	}
+
+	if w := get("unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("looking up an unknown UUID answered %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// SetCacheInvalidator serves POST /cache/invalidate, which calls
+// invalidate with an empty UUID to drop the vCenter data cached for every
+// VM, and POST /bmcs/{uuid}/cache/invalidate, which drops only that of the
+// VM with that UUID. It answers 404 for an unknown UUID.
+func (s *Server) SetCacheInvalidator(invalidate func(uuid string) error) {
+	serve := func(w http.ResponseWriter, r *http.Request, uuid string) {
+		err := invalidate(uuid)
+		switch {
+		case errors.Is(err, ErrBMCNotFound):
+			http.NotFound(w, r)
+		case err != nil:
+			s.log.Errorf("Failed to invalidate cache: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	s.mux.HandleFunc("POST /cache/invalidate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, "")
+	})
+	s.mux.HandleFunc("POST /bmcs/{uuid}/cache/invalidate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, r.PathValue("uuid"))
+	})
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheInvalidate(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewLogHub())
+	var invalidated []string
+	s.SetCacheInvalidator(func(uuid string) error {
+		if uuid == "unknown" {
+			return ErrBMCNotFound
+		}
+		invalidated = append(invalidated, uuid)
+		return nil
+	})
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/bmcs/4211/cache/invalidate", http.StatusNoContent},
+		{"/bmcs/unknown/cache/invalidate", http.StatusNotFound},
+		{"/cache/invalidate", http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s answered %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+	if len(invalidated) != 2 || invalidated[0] != "4211" || invalidated[1] != "" {
+		t.Errorf("invalidated %q, want the VM's UUID then every VM", invalidated)
+	}
+}
@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrBMCNotFound is returned by BMC actions for a UUID no BMC has
+var ErrBMCNotFound = errors.New("no BMC with that UUID")
+
+// ErrNoTask is returned by a canceller when the VM has no task running
+var ErrNoTask = errors.New("no task in progress for the VM")
+
+// SetCanceller serves POST /bmcs/{uuid}/cancel, which calls cancel to abort
+// the vCenter task running for the BMC's VM, e.g. a power-on stuck on
+// storage. It answers 404 for an unknown UUID and 409 when no task is
+// running.
+func (s *Server) SetCanceller(cancel func(ctx context.Context, uuid string) error) {
+	s.mux.HandleFunc("POST /bmcs/{uuid}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		err := cancel(r.Context(), r.PathValue("uuid"))
+		switch {
+		case errors.Is(err, ErrBMCNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, ErrNoTask):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			s.log.Errorf("Failed to cancel task: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCancel(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewLogHub())
+	s.SetCanceller(func(ctx context.Context, uuid string) error {
+		switch uuid {
+		case "running":
+			return nil
+		case "idle":
+			return fmt.Errorf("%w: vm-42", ErrNoTask)
+		case "broken":
+			return fmt.Errorf("vCenter is unreachable")
+		}
+		return ErrBMCNotFound
+	})
+
+	for uuid, want := range map[string]int{
+		"running": http.StatusNoContent,
+		"idle":    http.StatusConflict,
+		"broken":  http.StatusInternalServerError,
+		"unknown": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bmcs/"+uuid+"/cancel", nil))
+		if w.Code != want {
+			t.Errorf("cancelling %s answered %d, want %d", uuid, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDuplicateUUID is returned by BMC changes for a UUID several VMs share,
+// which would change more than the intended BMC
+var ErrDuplicateUUID = errors.New("several VMs share that UUID")
+
+// ErrInvalidCredentials is returned by a credential rotator for credentials
+// the configuration wouldn't accept
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Session policies of a credential rotation
+const (
+	SessionsKeep       = "keep"       // Open sessions go on with the old password
+	SessionsInvalidate = "invalidate" // Open sessions are closed
+)
+
+// CredentialChange is the body of PUT /bmcs/{uuid}/credentials
+type CredentialChange struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Sessions string `json:"sessions,omitempty"` // keep (default) or invalidate
+}
+
+// SetCredentialRotator serves PUT /bmcs/{uuid}/credentials, which calls
+// rotate to replace the IPMI credentials of the BMC of the VM with that
+// UUID while it runs. Every change is audit-logged without the password.
+// It answers 404 for an unknown UUID, 409 for a UUID several VMs share and
+// 400 for credentials that are rejected.
+func (s *Server) SetCredentialRotator(rotate func(uuid string, change CredentialChange) error) {
+	s.mux.HandleFunc("PUT /bmcs/{uuid}/credentials", func(w http.ResponseWriter, r *http.Request) {
+		var change CredentialChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if change.Sessions == "" {
+			change.Sessions = SessionsKeep
+		}
+		if change.Sessions != SessionsKeep && change.Sessions != SessionsInvalidate {
+			http.Error(w, fmt.Sprintf("invalid sessions %q (must be keep or invalidate)", change.Sessions), http.StatusBadRequest)
+			return
+		}
+
+		audit := s.log.WithFields(logrus.Fields{
+			"audit":    "credential_rotation",
+			"uuid":     r.PathValue("uuid"),
+			"user":     change.User,
+			"sessions": change.Sessions,
+			"remote":   r.RemoteAddr,
+		})
+		err := rotate(r.PathValue("uuid"), change)
+		switch {
+		case errors.Is(err, ErrBMCNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, ErrDuplicateUUID):
+			audit.Warnf("Refused IPMI credential change: %v", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrInvalidCredentials):
+			audit.Warnf("Refused IPMI credential change: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			audit.Errorf("Failed to change IPMI credentials: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			audit.Info("Changed IPMI credentials")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCredentialRotation(t *testing.T) {
+	var logs bytes.Buffer
+	saved := logrus.StandardLogger().Out
+	logrus.SetOutput(&logs)
+	t.Cleanup(func() { logrus.SetOutput(saved) })
+
+	s := NewServer("127.0.0.1:0", NewLogHub())
+	var got CredentialChange
+	s.SetCredentialRotator(func(uuid string, change CredentialChange) error {
+		switch uuid {
+		case "vm":
+			got = change
+			return nil
+		case "cloned":
+			return fmt.Errorf("%w: cloned", ErrDuplicateUUID)
+		case "weak":
+			return fmt.Errorf("%w: user must be 1 to 16 characters", ErrInvalidCredentials)
+		}
+		return ErrBMCNotFound
+	})
+
+	for _, tc := range []struct {
+		uuid, body string
+		want       int
+	}{
+		{"vm", `{"user": "ops", "password": "s3cret-rotated"}`, http.StatusNoContent},
+		{"vm", `{"user": "ops", "password": "s3cret-rotated", "sessions": "drop"}`, http.StatusBadRequest},
+		{"vm", `not json`, http.StatusBadRequest},
+		{"cloned", `{"user": "ops", "password": "s3cret-rotated"}`, http.StatusConflict},
+		{"weak", `{"user": "", "password": "s3cret-rotated"}`, http.StatusBadRequest},
+		{"unknown", `{"user": "ops", "password": "s3cret-rotated"}`, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/bmcs/"+tc.uuid+"/credentials", strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("rotating %s with %s answered %d, want %d", tc.uuid, tc.body, w.Code, tc.want)
+		}
+	}
+
+	if got.User != "ops" || got.Password != "s3cret-rotated" || got.Sessions != SessionsKeep {
+		t.Errorf("rotator got %+v, want ops's new password keeping sessions", got)
+	}
+	if !strings.Contains(logs.String(), "Changed IPMI credentials") {
+		t.Errorf("credential change wasn't audit-logged:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "s3cret-rotated") {
+		t.Errorf("audit log contains the password:\n%s", logs.String())
+	}
+}
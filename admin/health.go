@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+)
+
+// SetHealthCheck serves GET /healthz, which answers 200 when check passes
+// and 503 with its error otherwise
+func (s *Server) SetHealthCheck(check func(ctx context.Context) error) {
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := check(r.Context()); err != nil {
+			s.log.Warnf("Health check failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+		body string
+	}{
+		{nil, http.StatusOK, "ok"},
+		{errors.New("vCenter is unreachable"), http.StatusServiceUnavailable, "vCenter is unreachable"},
+	} {
+		s := NewServer("127.0.0.1:0", NewLogHub())
+		s.SetHealthCheck(func(ctx context.Context) error { return tc.err })
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("check returning %v answered %d %q, want %d %q", tc.err, w.Code, w.Body.String(), tc.code, tc.body)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logBuffer bounds the entries queued for a slow stream. Entries arriving
+// while it is full are dropped so streaming never blocks logging.
+const logBuffer = 256
+
+// logKeepalive is how often an idle stream is sent a comment, so proxies
+// and clients don't time it out
+const logKeepalive = 15 * time.Second
+
+// Log fields identifying the VM an entry is about
+const (
+	FieldVM     = "vm"
+	FieldVMUUID = "vm_uuid"
+)
+
+// logStream is one client following a VM's log entries
+type logStream struct {
+	vm      string
+	entries chan []byte
+	dropped atomic.Uint64
+}
+
+// LogHub is a logrus hook that fans out entries about a VM to clients
+// streaming that VM's log
+type LogHub struct {
+	mu        sync.Mutex
+	streams   map[*logStream]struct{}
+	formatter logrus.Formatter
+}
+
+// NewLogHub creates a hub with no streams
+func NewLogHub() *LogHub {
+	return &LogHub{
+		streams:   make(map[*logStream]struct{}),
+		formatter: &logrus.JSONFormatter{},
+	}
+}
+
+// Levels returns the levels the hook fires for
+func (h *LogHub) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues the entry on every stream following its VM without blocking
+func (h *LogHub) Fire(entry *logrus.Entry) error {
+	name, _ := entry.Data[FieldVM].(string)
+	uuid, _ := entry.Data[FieldVMUUID].(string)
+	if name == "" && uuid == "" {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var data []byte
+	for stream := range h.streams {
+		if stream.vm != name && stream.vm != uuid {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = h.formatter.Format(entry); err != nil {
+				return err
+			}
+		}
+		select {
+		case stream.entries <- data:
+		default:
+			stream.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP streams the log entries of the VM named by the vm query
+// parameter, by name or BIOS UUID, as server-sent events until the client
+// disconnects
+func (h *LogHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	vm := r.URL.Query().Get("vm")
+	if vm == "" {
+		http.Error(w, "vm query parameter is required", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := &logStream{vm: vm, entries: make(chan []byte, logBuffer)}
+	h.mu.Lock()
+	h.streams[stream] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.streams, stream)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(logKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-stream.entries:
+			if n := stream.dropped.Swap(0); n > 0 {
+				if _, err := fmt.Fprintf(w, ": dropped %d entries\n\n", n); err != nil {
+					return
+				}
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n", data); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
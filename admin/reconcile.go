@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Status is the service state reported by GET /status
+type Status struct {
+	BMCs            int  `json:"bmcs"`             // Running BMCs
+	ReconcilePaused bool `json:"reconcile_paused"` // BMCs aren't added or removed
+}
+
+// SetStatus serves GET /status from status
+func (s *Server) SetStatus(status func() Status) {
+	s.mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status()); err != nil {
+			s.log.Debugf("Failed to write status: %v", err)
+		}
+	})
+}
+
+// SetReconcilePauser serves POST /reconcile/pause and POST
+// /reconcile/resume, which call pause with true and false. Pausing a
+// paused loop or resuming a running one does nothing.
+func (s *Server) SetReconcilePauser(pause func(paused bool)) {
+	s.mux.HandleFunc("POST /reconcile/pause", func(w http.ResponseWriter, r *http.Request) {
+		pause(true)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.mux.HandleFunc("POST /reconcile/resume", func(w http.ResponseWriter, r *http.Request) {
+		pause(false)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
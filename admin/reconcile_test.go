@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReconcilePause(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewLogHub())
+	paused := false
+	s.SetReconcilePauser(func(p bool) { paused = p })
+	s.SetStatus(func() Status { return Status{BMCs: 3, ReconcilePaused: paused} })
+
+	status := func() Status {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var st Status
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatalf("bad status: %v", err)
+		}
+		return st
+	}
+	post := func(path string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s answered %d, want %d", path, w.Code, http.StatusNoContent)
+		}
+	}
+
+	post("/reconcile/pause")
+	if st := status(); !st.ReconcilePaused || st.BMCs != 3 {
+		t.Errorf("status after pausing is %+v", st)
+	}
+	post("/reconcile/resume")
+	if st := status(); st.ReconcilePaused {
+		t.Errorf("status after resuming is %+v", st)
+	}
+}
@@ -6,73 +6,626 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 // VCenterConfig holds the vCenter specific configuration
 type VCenterConfig struct {
-	IP         string `json:"ip"`
-	User       string `json:"user"`
-	Password   string `json:"password"`
-	Datacenter string `json:"datacenter"`
-	Folder     string `json:"folder,omitempty"` // Optional
+	IP               string `json:"ip"`
+	User             string `json:"user"`
+	Password         string `json:"password"`
+	Datacenter       string `json:"datacenter"`
+	Folder           string `json:"folder,omitempty"`             // Optional
+	PowerStateFilter string `json:"power_state_filter,omitempty"` // Optional: poweredOn, poweredOff or suspended
+	SourceIP         string `json:"source_ip,omitempty"`          // Optional local address for the vCenter connection
+	SourceInterface  string `json:"source_interface,omitempty"`   // Optional interface whose address is used instead
+	TaskAttempts     int    `json:"task_attempts"`                // Tries of a power or boot task that hits a transient fault
+	TaskRetryDelayMs int    `json:"task_retry_delay_ms"`          // Milliseconds before the first retry, doubling for each one after
+	Name             string `json:"name,omitempty"`               // Required in vcenters, prefixes the IP database keys of its VMs
+
+	// Optional sub-range of server.ip_range the BMCs of this vCenter's VMs
+	// get their addresses from
+	IPRange *IPRange `json:"ip_range,omitempty"`
+
+	// Optional credentials used for commands from IPMI sessions at a
+	// privilege level (user, operator or administrator)
+	PrivilegeCredentials map[string]Credentials `json:"privilege_credentials,omitempty"`
+}
+
+// defaultVCenter returns the vCenter settings before the file is applied
+func defaultVCenter() VCenterConfig {
+	return VCenterConfig{
+		TaskAttempts:     3,   // ride out a briefly locked VM
+		TaskRetryDelayMs: 500, // retries at 0.5s and 1s
+	}
+}
+
+// UnmarshalJSON decodes vCenter settings over the defaults, so entries of
+// vcenters get them like vcenter does
+func (v *VCenterConfig) UnmarshalJSON(data []byte) error {
+	type plain VCenterConfig
+	p := plain(*v)
+	if p.TaskAttempts == 0 {
+		p = plain(defaultVCenter()) // A fresh entry of vcenters
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*v = VCenterConfig(p)
+	return nil
+}
+
+// Credentials is a user name and password
+type Credentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
 }
 
 // IPRange represents an IP address range
 type IPRange struct {
-	Start string `json:"start"`
-	End   string `json:"end"`
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	Exclude []string `json:"exclude,omitempty"` // Addresses or "first-last" sub-ranges never allocated
+}
+
+// ParseIP parses an IPv4 or IPv6 address, returning IPv4 addresses in
+// their 4-byte form so addresses of one family compare and increment
+// alike. It returns nil if s isn't an address.
+func ParseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// parseExclusion parses an exclusion entry into its first and last address
+func parseExclusion(entry string) (net.IP, net.IP, error) {
+	first, last, isRange := strings.Cut(entry, "-")
+	lo := ParseIP(strings.TrimSpace(first))
+	hi := lo
+	if isRange {
+		hi = ParseIP(strings.TrimSpace(last))
+	}
+	if lo == nil || hi == nil {
+		return nil, nil, fmt.Errorf("invalid address in %q", entry)
+	}
+	if len(lo) != len(hi) {
+		return nil, nil, fmt.Errorf("range %q mixes IPv4 and IPv6", entry)
+	}
+	if bytes.Compare(hi, lo) < 0 {
+		return nil, nil, fmt.Errorf("reversed range %q", entry)
+	}
+	return lo, hi, nil
+}
+
+// Contains reports whether ip lies between the start and end of the range
+func (r IPRange) Contains(ip net.IP) bool {
+	start, end := ParseIP(r.Start), ParseIP(r.End)
+	ip = ParseIP(ip.String())
+	return len(ip) == len(start) && bytes.Compare(ip, start) >= 0 && bytes.Compare(ip, end) <= 0
+}
+
+// Excludes reports whether ip falls in one of the excluded addresses or
+// sub-ranges
+func (r IPRange) Excludes(ip net.IP) bool {
+	ip = ParseIP(ip.String())
+	for _, entry := range r.Exclude {
+		lo, hi, err := parseExclusion(entry)
+		if err != nil || len(lo) != len(ip) {
+			continue // Rejected by Validate
+		}
+		if bytes.Compare(ip, lo) >= 0 && bytes.Compare(ip, hi) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludedSpans returns the excluded addresses as first and last address
+// pairs, sorted and with overlapping sub-ranges merged, so they can be
+// counted without enumerating them
+func (r IPRange) ExcludedSpans() [][2]net.IP {
+	var spans [][2]net.IP
+	for _, entry := range r.Exclude {
+		lo, hi, err := parseExclusion(entry)
+		if err != nil {
+			continue // Rejected by Validate
+		}
+		spans = append(spans, [2]net.IP{lo, hi})
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return bytes.Compare(spans[i][0], spans[j][0]) < 0
+	})
+
+	var merged [][2]net.IP
+	for _, span := range spans {
+		if n := len(merged); n > 0 && bytes.Compare(span[0], merged[n-1][1]) <= 0 {
+			if bytes.Compare(span[1], merged[n-1][1]) > 0 {
+				merged[n-1][1] = span[1]
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
 }
 
 // ServerConfig holds the BMC server configuration
 // LogConfig holds logging configuration
 type LogConfig struct {
-	Level string `json:"level"` // debug, info, warn, error
+	Level           string `json:"level"`                      // debug, info, warn, error
+	Format          string `json:"format,omitempty"`           // text or json
+	TimestampFormat string `json:"timestamp_format,omitempty"` // Go time layout, e.g. 2006-01-02T15:04:05Z07:00
 }
 
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
 // NetworkConfig holds network-specific configuration
 type NetworkConfig struct {
-	Netmask string `json:"netmask"`
-	Gateway string `json:"gateway"`
+	Netmask      string `json:"netmask"`
+	PrefixLength *int   `json:"prefix_length,omitempty"` // Alternative to netmask, e.g. 24 for 255.255.255.0
+	Gateway      string `json:"gateway"`
+}
+
+// Mask returns the BMC subnet mask, from the prefix length if set and the
+// netmask otherwise. IPv6 masks are 128 bits and their netmask is written
+// as an IPv6 address, e.g. ffff:ffff:ffff:ffff::.
+func (n NetworkConfig) Mask(ipv6 bool) (net.IPMask, error) {
+	bits := 8 * net.IPv4len
+	if ipv6 {
+		bits = 8 * net.IPv6len
+	}
+	if n.PrefixLength != nil {
+		if *n.PrefixLength < 0 || *n.PrefixLength > bits {
+			return nil, fmt.Errorf("invalid server.network.prefix_length: %d (must be 0 to %d)", *n.PrefixLength, bits)
+		}
+		return net.CIDRMask(*n.PrefixLength, bits), nil
+	}
+
+	if n.Netmask == "" {
+		return nil, fmt.Errorf("server.network.netmask or server.network.prefix_length is required")
+	}
+	ip := ParseIP(n.Netmask)
+	if ip == nil || len(ip) != bits/8 {
+		return nil, fmt.Errorf("invalid netmask: %s", n.Netmask)
+	}
+	mask := net.IPMask(ip)
+	if ones, bits := mask.Size(); ones == 0 && bits == 0 {
+		return nil, fmt.Errorf("invalid netmask: %s (ones must be contiguous, not a host address)", n.Netmask)
+	}
+	return mask, nil
+}
+
+// Supported IPMI transports
+const (
+	TransportUDP  = "udp"
+	TransportTCP  = "tcp"
+	TransportBoth = "both"
+)
+
+// SelfPingConfig controls the post-start reachability check
+type SelfPingConfig struct {
+	Enabled        bool `json:"enabled"`
+	Sample         int  `json:"sample,omitempty"`          // Number of BMCs to ping, 0 for all
+	TimeoutSeconds int  `json:"timeout_seconds,omitempty"` // Time to wait for each pong
+}
+
+// ConflictProbeConfig controls the check that a BMC address isn't already
+// used by another host before it is added to the NIC
+type ConflictProbeConfig struct {
+	Enabled   bool `json:"enabled"`
+	TimeoutMs int  `json:"timeout_ms,omitempty"` // Time to wait for an answer to the probe
+}
+
+// ManagerConfig controls the manager BMC, an extra BMC without a VM whose
+// sensors summarize the whole fleet
+type ManagerConfig struct {
+	Enabled bool   `json:"enabled"`
+	IP      string `json:"ip,omitempty"` // Dedicated address, never allocated to a VM's BMC
+}
+
+// When BMCs claim their addresses
+const (
+	StartupEager   = "eager"   // At startup
+	StartupStandby = "standby" // When activated through the admin API
+)
+
+// How BMC addresses are added to and removed from the NIC
+const (
+	IPConfiguratorNetlink = "netlink" // rtnetlink, no external tools needed
+	IPConfiguratorCommand = "ip"      // The ip command from iproute2
+)
+
+// Responses to an IP range outside the NIC's subnets
+const (
+	SubnetCheckWarn  = "warn"  // Log a warning at startup
+	SubnetCheckError = "error" // Reject the configuration
+	SubnetCheckOff   = "off"   // Don't check
+)
+
+// Responses to a change of the NIC's own addresses
+const (
+	NICWatchWarn  = "warn"  // Log a prominent warning
+	NICWatchReadd = "readd" // Re-add BMC addresses that went missing
+)
+
+// Power restore policies, applied to each VM when the service starts
+const (
+	PowerRestoreAlwaysOff = "always-off"       // Leave VMs as found
+	PowerRestoreAlwaysOn  = "always-on"        // Power on VMs found powered off
+	PowerRestorePrevious  = "restore-previous" // Power on VMs that were on when last seen
+)
+
+// NICWatchConfig controls monitoring of the NIC's addresses
+type NICWatchConfig struct {
+	Enabled bool   `json:"enabled"`
+	Action  string `json:"action,omitempty"` // warn or readd
+}
+
+// DeviceIDConfig is the identity the BMCs report in Get Device ID, so
+// discovery tools can classify them
+type DeviceIDConfig struct {
+	DeviceID       int `json:"device_id,omitempty"`
+	DeviceRevision int `json:"device_revision,omitempty"` // 0 to 15
+	FirmwareMajor  int `json:"firmware_major,omitempty"`  // 0 to 127
+	FirmwareMinor  int `json:"firmware_minor,omitempty"`  // 0 to 99, sent as BCD
+	ManufacturerID int `json:"manufacturer_id,omitempty"` // IANA private enterprise number, 0 to 1048575
+	ProductID      int `json:"product_id,omitempty"`
+}
+
+// Kinds of synthetic sensors
+const (
+	SensorFan         = "fan"         // Reads in RPM, up to 25500
+	SensorTemperature = "temperature" // Reads in degrees C, up to 255
+)
+
+// MaxSyntheticSensors is the number of synthetic sensors a BMC can report
+const MaxSyntheticSensors = 16
+
+// SensorConfig describes a synthetic fan or temperature sensor that always
+// reads the same nominal value, for guest firmware and agents that expect
+// them
+type SensorConfig struct {
+	Name          string `json:"name"`           // Sensor ID string, up to 16 characters
+	Type          string `json:"type"`           // fan or temperature
+	Reading       int    `json:"reading"`        // RPM for fans, degrees C for temperatures
+	LowerCritical int    `json:"lower_critical"` // Critical thresholds the reading lies between
+	UpperCritical int    `json:"upper_critical"`
+}
+
+// GuestShutdownConfig controls the ACPI soft-off poll loop
+type GuestShutdownConfig struct {
+	PollIntervalSeconds int    `json:"poll_interval_seconds"`        // Time between power state checks
+	TimeoutSeconds      int    `json:"timeout_seconds"`              // Time the guest is given to power off
+	ForceOnTimeout      bool   `json:"force_on_timeout"`             // Hard power off once the timeout expires
+	OverrideAttribute   string `json:"override_attribute,omitempty"` // vSphere custom attribute with per-VM overrides
+}
+
+// WithOverride applies a per-VM override of the form
+// "timeout=600,poll=10,force=false" on top of the global settings
+func (g GuestShutdownConfig) WithOverride(value string) (GuestShutdownConfig, error) {
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return g, fmt.Errorf("invalid guest shutdown override %q", field)
+		}
+		var err error
+		switch strings.TrimSpace(key) {
+		case "timeout":
+			g.TimeoutSeconds, err = strconv.Atoi(strings.TrimSpace(val))
+		case "poll":
+			g.PollIntervalSeconds, err = strconv.Atoi(strings.TrimSpace(val))
+		case "force":
+			g.ForceOnTimeout, err = strconv.ParseBool(strings.TrimSpace(val))
+		default:
+			return g, fmt.Errorf("unknown guest shutdown override %q", key)
+		}
+		if err != nil {
+			return g, fmt.Errorf("invalid guest shutdown override %q: %v", field, err)
+		}
+	}
+	if g.PollIntervalSeconds <= 0 || g.TimeoutSeconds <= 0 {
+		return g, fmt.Errorf("guest shutdown poll interval and timeout must be positive")
+	}
+	return g, nil
+}
+
+// How BMCs get their addresses
+const (
+	AllocationIPPerVM   = "ip-per-vm"   // A distinct IP from ip_range, on the IPMI port
+	AllocationPortPerVM = "port-per-vm" // host_ip, on a distinct port from port_range
+)
+
+// PortRange represents a range of ports
+type PortRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
 // ServerConfig holds the BMC server configuration
 type ServerConfig struct {
-	IPRange  IPRange      `json:"ip_range"`
-	NIC      string       `json:"nic"` // Network interface to bind IPs to
-	Network  NetworkConfig `json:"network"`
+	AllocationMode      string              `json:"allocation_mode,omitempty"` // ip-per-vm or port-per-vm
+	IPRange             IPRange             `json:"ip_range"`
+	IPReservations      map[string]string   `json:"ip_reservations,omitempty"` // VM key -> IP pinned to its BMC, in ip-per-vm mode
+	HostIP              string              `json:"host_ip,omitempty"`         // Address shared by every BMC in port-per-vm mode
+	PortRange           PortRange           `json:"port_range,omitempty"`      // Ports allocated in port-per-vm mode
+	NIC                 string              `json:"nic"`                       // Network interface to bind IPs to
+	ManageIPs           bool                `json:"manage_ips"`                // Add and remove BMC addresses on the NIC, false when they are configured externally
+	IPConfigurator      string              `json:"ip_configurator,omitempty"` // netlink or ip
+	SubnetCheck         string              `json:"subnet_check,omitempty"`    // warn, error or off when the IP range isn't on a NIC subnet
+	Network             NetworkConfig       `json:"network"`
+	Transport           string              `json:"transport,omitempty"`             // udp, tcp or both
+	IPMIPort            int                 `json:"ipmi_port,omitempty"`             // Port the BMCs listen on
+	Startup             string              `json:"startup,omitempty"`               // eager or standby
+	MaxInflightCommands int                 `json:"max_inflight_commands,omitempty"` // vCenter-backed commands in flight across all BMCs
+	SelfPing            SelfPingConfig      `json:"self_ping,omitempty"`
+	ConflictProbe       ConflictProbeConfig `json:"conflict_probe,omitempty"`
+	BootOrder           map[string][]string `json:"boot_order,omitempty"`            // IPMI boot device -> vSphere boot order
+	PowerCycleDelay     int                 `json:"power_cycle_delay_seconds"`       // Settle time between off and on in a power cycle
+	GracefulShutdown    int                 `json:"graceful_shutdown_timeout"`       // Seconds power down waits for a guest shutdown, 0 for a hard power off
+	StopTimeout         int                 `json:"stop_timeout_seconds"`            // Seconds stopping a BMC waits for commands in flight
+	PreferGuestReboot   bool                `json:"prefer_guest_reboot,omitempty"`   // Reset reboots the guest through VMware Tools, hard resetting if that fails
+	BusyCompletionCode  int                 `json:"busy_completion_code"`            // Returned when another vCenter task is running on the VM
+	DefaultBootDevice   string              `json:"default_boot_device,omitempty"`   // Applied on first start to VMs without a boot order
+	MaxVMs              int                 `json:"max_vms,omitempty"`               // Maximum number of managed VMs, 0 for unlimited
+	MaxSessions         int                 `json:"max_sessions,omitempty"`          // Concurrent IPMI sessions per BMC
+	SELCapacity         int                 `json:"sel_capacity,omitempty"`          // System event log records kept per BMC, oldest evicted first
+	AssetTagAttribute   string              `json:"asset_tag_attribute,omitempty"`   // vSphere custom attribute mirroring the asset tag
+	PowerOnDiscovered   bool                `json:"power_on_discovered,omitempty"`   // Power on managed VMs found powered off. Dangerous, opt-in
+	PowerOnDelay        int                 `json:"power_on_delay_seconds"`          // Seconds between the power-ons of discovered VMs
+	PowerRestorePolicy  string              `json:"power_restore_policy,omitempty"`  // always-off, always-on or restore-previous, applied at startup
+	IdentifyAnnotation  bool                `json:"identify_annotation,omitempty"`   // Mark VMs being identified with chassis identify in their notes
+	AllowResize         bool                `json:"allow_resize,omitempty"`          // Allow IPMI clients to change VM vCPU and memory
+	EnableSuspend       bool                `json:"enable_suspend,omitempty"`        // Accept the OEM chassis control code that suspends the VM
+	FloppyFallback      string              `json:"floppy_fallback,omitempty"`       // Boot device used instead of floppy on VMs without one
+	IPLeaseSeconds      int                 `json:"ip_lease_seconds,omitempty"`      // Free IPs of VMs unseen for this long, 0 to free them at once
+	PXENetwork          string              `json:"pxe_network,omitempty"`           // NIC device name or network PXE boots from
+	PXENetworkAttribute string              `json:"pxe_network_attribute,omitempty"` // vSphere custom attribute overriding pxe_network per VM
+	GuestShutdown       GuestShutdownConfig `json:"guest_shutdown"`
+	NICWatch            NICWatchConfig      `json:"nic_watch,omitempty"`
+	DeviceID            DeviceIDConfig      `json:"device_id,omitempty"`
+	SyntheticSensors    []SensorConfig      `json:"synthetic_sensors,omitempty"`          // Always-nominal fan and temperature sensors
+	ReconcileInterval   int                 `json:"reconcile_interval_seconds,omitempty"` // Seconds between re-listing VMs to add and remove BMCs, 0 to list once at startup
+	MetricsAddr         string              `json:"metrics_addr,omitempty"`               // host:port serving Prometheus metrics, empty to disable
+	Manager             ManagerConfig       `json:"manager,omitempty"`                    // BMC reporting fleet-wide counts as sensors
+}
+
+// ManagesIPs reports whether BMC addresses are added to and removed from
+// the NIC. The shared address of port-per-vm mode belongs to the host, so
+// it is never managed.
+func (c ServerConfig) ManagesIPs() bool {
+	return c.ManageIPs && c.AllocationMode != AllocationPortPerVM
+}
+
+// IPMIConfig holds the IPMI credentials of the BMCs
+type IPMIConfig struct {
+	DefaultUser     string `json:"default_user"`
+	DefaultPassword string `json:"default_password"`
+
+	// Optional credentials replacing the defaults for a VM, keyed like
+	// server.ip_reservations
+	VMCredentials map[string]Credentials `json:"vm_credentials,omitempty"`
+}
+
+// CredentialsFor returns the IPMI user name and password of the BMC of a
+// VM of the named vCenter
+func (c IPMIConfig) CredentialsFor(vcenter, vmName string) (string, string) {
+	if creds, ok := lookupVM(c.VMCredentials, vcenter, vmName); ok {
+		return creds.User, creds.Password
+	}
+	return c.DefaultUser, c.DefaultPassword
 }
 
+// ReservationFor returns the address reserved for the BMC of a VM of the
+// named vCenter, if it has one
+func (c ServerConfig) ReservationFor(vcenter, vmName string) (string, bool) {
+	return lookupVM(c.IPReservations, vcenter, vmName)
+}
+
+// lookupVM returns the entry of a map keyed by VM for a VM of the named
+// vCenter. Keys are VM names, optionally prefixed with the vCenter name
+// and a slash, e.g. lab-east/web-01; the prefixed key wins.
+func lookupVM[T any](entries map[string]T, vcenter, vmName string) (T, bool) {
+	if vcenter != "" {
+		if entry, ok := entries[vcenter+"/"+vmName]; ok {
+			return entry, true
+		}
+	}
+	entry, ok := entries[vmName]
+	return entry, ok
+}
+
+// vmTarget returns the vCenter a key of server.ip_reservations or
+// ipmi.vm_credentials, named field in errors, refers to. With several
+// vCenters a bare VM name could match a VM of each, so keys must be
+// prefixed with a vCenter name.
+func (c *Config) vmTarget(field, key string) (VCenterConfig, error) {
+	targets := c.VCenterTargets()
+	if prefix, _, ok := strings.Cut(key, "/"); ok {
+		for _, v := range targets {
+			if v.Name != "" && v.Name == prefix {
+				return v, nil
+			}
+		}
+	}
+	if len(targets) > 1 {
+		return VCenterConfig{}, fmt.Errorf("%s key %s is ambiguous with several vCenters, prefix it with a vCenter name, e.g. %s/%s",
+			field, key, targets[0].Name, key)
+	}
+	return targets[0], nil
+}
+
+// SyslogConfig controls forwarding of power and boot events to a remote
+// syslog server
+type SyslogConfig struct {
+	Address  string `json:"address,omitempty"`  // host:port, empty to disable
+	Network  string `json:"network,omitempty"`  // udp or tcp
+	Facility string `json:"facility,omitempty"` // e.g. daemon, local0
+	Severity string `json:"severity,omitempty"` // Severity of forwarded events, e.g. notice
+}
+
+// Built-in IPMI credentials, only allowed on loopback addresses
+const (
+	insecureDefaultUser     = "admin"
+	insecureDefaultPassword = "password"
+)
+
+// bootOrderDevices lists the IPMI boot devices that can be remapped
+var bootOrderDevices = map[string]bool{
+	"pxe":    true,
+	"disk":   true,
+	"cdrom":  true,
+	"floppy": true,
+}
+
+// bootableTypes lists the vSphere bootable device types
+var bootableTypes = []string{"disk", "cdrom", "ethernet", "floppy"}
+
 // Config holds the complete configuration for the virtual BMC
 type Config struct {
-	VCenter VCenterConfig `json:"vcenter"`
-	Server  ServerConfig  `json:"server"`
-	Logging LogConfig     `json:"logging,omitempty"`
+	VCenter  VCenterConfig   `json:"vcenter"`
+	VCenters []VCenterConfig `json:"vcenters,omitempty"` // Several vCenters managed by one service, instead of vcenter
+	Server   ServerConfig    `json:"server"`
+	IPMI     IPMIConfig      `json:"ipmi"`
+	Logging  LogConfig       `json:"logging,omitempty"`
+	Syslog   SyslogConfig    `json:"syslog,omitempty"`
+	Admin    AdminConfig     `json:"admin,omitempty"`
+	DryRun   DryRunConfig    `json:"dry_run,omitempty"`
+}
+
+// VCenterTargets returns the vCenters whose VMs get BMCs: the entries of
+// vcenters, or else vcenter
+func (c *Config) VCenterTargets() []VCenterConfig {
+	if len(c.VCenters) > 0 {
+		return c.VCenters
+	}
+	return []VCenterConfig{c.VCenter}
+}
+
+// AdminConfig controls the HTTP admin API
+type AdminConfig struct {
+	Listen string `json:"listen,omitempty"` // host:port to serve the admin API on, empty to disable
+}
+
+// DryRunConfig runs the service against an in-process vCenter simulator,
+// logging the NIC changes it would make instead of making them
+type DryRunConfig struct {
+	Enabled bool     `json:"enabled"`
+	VMs     []string `json:"vms,omitempty"` // Names of the simulated VMs, 3 unnamed VMs if empty
 }
 
 // NewConfig creates a new configuration with default values
 func NewConfig() *Config {
 	return &Config{
+		VCenter: defaultVCenter(),
 		Logging: LogConfig{
-			Level: "info", // default log level
+			Level:  "info", // default log level
+			Format: LogFormatText,
+		},
+		Syslog: SyslogConfig{
+			Network:  "udp",
+			Facility: "daemon",
+			Severity: "notice",
 		},
 		Server: ServerConfig{
-			NIC: "eth0", // default network interface
+			AllocationMode:      AllocationIPPerVM,
+			NIC:                 "eth0", // default network interface
+			ManageIPs:           true,   // configure BMC addresses on the NIC
+			IPConfigurator:      IPConfiguratorNetlink,
+			SubnetCheck:         SubnetCheckWarn,
+			PowerRestorePolicy:  PowerRestoreAlwaysOff,
+			Transport:           TransportUDP, // standard IPMI over UDP
+			IPMIPort:            623,          // standard RMCP port
+			Startup:             StartupEager, // claim addresses at once
+			MaxInflightCommands: 64,           // reject with NodeBusy beyond this
+			MaxSessions:         4,            // like a typical physical BMC
+			SELCapacity:         256,          // a few weeks of power actions
+			PowerCycleDelay:     2,            // let the hypervisor release resources
+			PowerOnDelay:        5,            // spread the boot load of discovered VMs
+			StopTimeout:         30,           // long enough for a power action's task
+			BusyCompletionCode:  0xc0,         // Node Busy
+			SelfPing: SelfPingConfig{
+				TimeoutSeconds: 2,
+			},
+			ConflictProbe: ConflictProbeConfig{
+				Enabled:   true,
+				TimeoutMs: 500,
+			},
+			GuestShutdown: GuestShutdownConfig{
+				PollIntervalSeconds: 5,
+				TimeoutSeconds:      300,
+				ForceOnTimeout:      true,
+			},
+			NICWatch: NICWatchConfig{
+				Action: NICWatchWarn,
+			},
+		},
+		IPMI: IPMIConfig{
+			DefaultUser:     insecureDefaultUser,
+			DefaultPassword: insecureDefaultPassword,
 		},
 	}
 }
 
-// LoadFromFile loads configuration from a JSON file
+// LoadFromFile loads configuration from a JSON file, or a YAML file when
+// its extension is .yaml or .yml. Files with other extensions are parsed
+// as JSON.
 func LoadFromFile(path string) (*Config, error) {
+	return load(path, false)
+}
+
+// LoadDryRunFromFile loads configuration like LoadFromFile with dry_run
+// enabled, so the vCenter settings can be left out
+func LoadDryRunFromFile(path string) (*Config, error) {
+	return load(path, true)
+}
+
+// load loads configuration from a file, enabling dry_run if dryRun is set
+func load(path string, dryRun bool) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
 	config := NewConfig()
-	if err := json.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	format := "JSON"
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		format = "YAML"
+		err = unmarshalYAML(data, config)
+	case ".json":
+		err = json.Unmarshal(data, config)
+	default:
+		logrus.Warnf("Unknown config file extension %q, parsing %s as JSON", ext, path)
+		err = json.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file as %s: %v", format, err)
+	}
+	if len(config.VCenters) == 0 {
+		config.VCenter.applyEnv(vcenterEnvPrefix)
+	}
+	for i := range config.VCenters {
+		config.VCenters[i].applyEnv(envPrefix(config.VCenters[i].Name))
+	}
+	if dryRun {
+		config.DryRun.Enabled = true
 	}
 
 	if err := config.Validate(); err != nil {
@@ -82,6 +635,100 @@ func LoadFromFile(path string) (*Config, error) {
 	return config, nil
 }
 
+// vcenterEnvPrefix starts the names of the environment variables that
+// override vCenter settings
+const vcenterEnvPrefix = "VBMC_VCENTER_"
+
+// vcenterEnv maps the environment variable suffixes that override vCenter
+// settings to the settings, so credentials can be kept out of the config
+// file
+var vcenterEnv = map[string]func(*VCenterConfig) *string{
+	"IP":       func(v *VCenterConfig) *string { return &v.IP },
+	"USER":     func(v *VCenterConfig) *string { return &v.User },
+	"PASSWORD": func(v *VCenterConfig) *string { return &v.Password },
+}
+
+// applyEnv overlays the vCenter environment variables starting with prefix
+// on the settings from the file. A set variable wins over the file; an
+// empty one is ignored.
+func (v *VCenterConfig) applyEnv(prefix string) {
+	for suffix, field := range vcenterEnv {
+		if value := os.Getenv(prefix + suffix); value != "" {
+			*field(v) = value
+		}
+	}
+}
+
+// envPrefix returns the prefix of the environment variables overriding the
+// settings of the named entry of vcenters: its name upper-cased, with
+// anything but letters and digits replaced by underscores, e.g.
+// VBMC_VCENTER_LAB_EAST_ for lab-east
+func envPrefix(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return vcenterEnvPrefix + name + "_"
+}
+
+// unmarshalYAML decodes a YAML document into config by way of JSON, so the
+// json struct tags name the fields in both formats
+func unmarshalYAML(data []byte, config *Config) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, config)
+}
+
+// SourceAddress returns the local address to use for the vCenter connection,
+// or nil if none is configured. The address must belong to this host.
+func (v *VCenterConfig) SourceAddress() (net.IP, error) {
+	if v.SourceInterface != "" {
+		iface, err := net.InterfaceByName(v.SourceInterface)
+		if err != nil {
+			return nil, fmt.Errorf("vcenter.source_interface %s: %v", v.SourceInterface, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %v", v.SourceInterface, err)
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP, nil
+			}
+		}
+		return nil, fmt.Errorf("vcenter.source_interface %s has no IPv4 address", v.SourceInterface)
+	}
+
+	if v.SourceIP == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(v.SourceIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid vcenter.source_ip: %s", v.SourceIP)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("vcenter.source_ip %s is not assigned to this host", v.SourceIP)
+}
+
 // Validate checks if the configuration is valid
 // GetLogLevel returns the log level as a logrus.Level
 func (c *Config) GetLogLevel() logrus.Level {
@@ -97,27 +744,106 @@ func (c *Config) GetLogLevel() logrus.Level {
 	}
 }
 
-func (c *Config) Validate() error {
-	// Validate vCenter configuration
-	if c.VCenter.IP == "" {
-		return fmt.Errorf("vcenter.ip is required")
+// GetLogFormatter returns the formatter for the configured log format.
+// Without a timestamp format, text logs show local time to the second and
+// JSON logs RFC 3339 time to the nanosecond.
+func (c *Config) GetLogFormatter() logrus.Formatter {
+	if c.Logging.Format == LogFormatJSON {
+		layout := c.Logging.TimestampFormat
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		return &logrus.JSONFormatter{TimestampFormat: layout}
 	}
-	if c.VCenter.User == "" {
-		return fmt.Errorf("vcenter.user is required")
+
+	layout := c.Logging.TimestampFormat
+	if layout == "" {
+		layout = "2006-01-02 15:04:05"
 	}
-	if c.VCenter.Password == "" {
-		return fmt.Errorf("vcenter.password is required")
+	return &logrus.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: layout,
 	}
-	if c.VCenter.Datacenter == "" {
-		return fmt.Errorf("vcenter.datacenter is required")
+}
+
+func (c *Config) Validate() error {
+	// Validate vCenter configuration, which a dry run replaces with the
+	// simulator's
+	if len(c.VCenters) > 0 {
+		if c.VCenter.IP != "" || c.VCenter.Datacenter != "" {
+			return fmt.Errorf("vcenter and vcenters are mutually exclusive")
+		}
+		if c.DryRun.Enabled {
+			return fmt.Errorf("dry_run only simulates a single vcenter, not vcenters")
+		}
+		names := make(map[string]bool, len(c.VCenters))
+		prefixes := make(map[string]string, len(c.VCenters))
+		for i := range c.VCenters {
+			v := &c.VCenters[i]
+			field := fmt.Sprintf("vcenters[%d]", i)
+			if err := v.validate(field, false); err != nil {
+				return err
+			}
+			if v.Name == "" || strings.Contains(v.Name, "/") {
+				return fmt.Errorf("%s.name is required and must not contain /", field)
+			}
+			if names[v.Name] {
+				return fmt.Errorf("%s.name %s is used by another vCenter", field, v.Name)
+			}
+			names[v.Name] = true
+			if other, ok := prefixes[envPrefix(v.Name)]; ok {
+				return fmt.Errorf("%s.name %s and vCenter %s share the environment variables %s*", field, v.Name, other, envPrefix(v.Name))
+			}
+			prefixes[envPrefix(v.Name)] = v.Name
+		}
+	} else if err := c.VCenter.validate("vcenter", c.DryRun.Enabled); err != nil {
+		return err
 	}
 
-	// Validate server configuration
-	if c.Server.IPRange.Start == "" {
-		return fmt.Errorf("server.ip_range.start is required")
+	// Validate logging
+	switch c.Logging.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid logging.format: %s (must be text or json)", c.Logging.Format)
 	}
-	if c.Server.IPRange.End == "" {
-		return fmt.Errorf("server.ip_range.end is required")
+
+	// Validate server configuration. Each allocation mode takes its
+	// addresses from exactly one of ip_range and port_range.
+	switch c.Server.AllocationMode {
+	case AllocationIPPerVM:
+		if c.Server.IPRange.Start == "" {
+			return fmt.Errorf("server.ip_range.start is required")
+		}
+		if c.Server.IPRange.End == "" {
+			return fmt.Errorf("server.ip_range.end is required")
+		}
+		if c.Server.PortRange != (PortRange{}) {
+			return fmt.Errorf("server.port_range requires server.allocation_mode port-per-vm")
+		}
+	case AllocationPortPerVM:
+		if c.Server.HostIP == "" {
+			return fmt.Errorf("server.host_ip is required in port-per-vm mode")
+		}
+		if ParseIP(c.Server.HostIP) == nil {
+			return fmt.Errorf("invalid server.host_ip: %s", c.Server.HostIP)
+		}
+		if c.Server.IPRange.Start != "" || c.Server.IPRange.End != "" {
+			return fmt.Errorf("server.ip_range must not be set in port-per-vm mode")
+		}
+		if len(c.Server.IPReservations) > 0 {
+			return fmt.Errorf("server.ip_reservations must not be set in port-per-vm mode")
+		}
+		for _, v := range c.VCenterTargets() {
+			if v.IPRange != nil {
+				return fmt.Errorf("vCenter ip_range must not be set in port-per-vm mode")
+			}
+		}
+		r := c.Server.PortRange
+		if r.Start < 1 || r.End > 65535 || r.End < r.Start {
+			return fmt.Errorf("server.port_range must be set, with start <= end, between 1 and 65535")
+		}
+	default:
+		return fmt.Errorf("invalid server.allocation_mode: %s (must be ip-per-vm or port-per-vm)", c.Server.AllocationMode)
 	}
 
 	// Validate NIC
@@ -125,14 +851,215 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("server.nic is required")
 	}
 
+	switch c.Server.IPConfigurator {
+	case IPConfiguratorNetlink, IPConfiguratorCommand:
+	default:
+		return fmt.Errorf("invalid server.ip_configurator: %s (must be netlink or ip)", c.Server.IPConfigurator)
+	}
+
+	// Validate transport
+	switch c.Server.Transport {
+	case TransportUDP, TransportTCP, TransportBoth:
+	default:
+		return fmt.Errorf("invalid server.transport: %s (must be udp, tcp or both)", c.Server.Transport)
+	}
+
+	if c.Server.MaxVMs < 0 {
+		return fmt.Errorf("server.max_vms must not be negative")
+	}
+
+	if c.Server.MaxInflightCommands <= 0 {
+		return fmt.Errorf("server.max_inflight_commands must be positive")
+	}
+
+	if c.Server.MaxSessions <= 0 {
+		return fmt.Errorf("server.max_sessions must be positive")
+	}
+
+	if c.Server.SELCapacity <= 0 || c.Server.SELCapacity >= 0xfffe {
+		return fmt.Errorf("server.sel_capacity must be between 1 and 65533")
+	}
+
+	// Validate device ID
+	d := c.Server.DeviceID
+	if d.DeviceID < 0 || d.DeviceID > 0xff {
+		return fmt.Errorf("server.device_id.device_id must be between 0 and 255")
+	}
+	if d.DeviceRevision < 0 || d.DeviceRevision > 0x0f {
+		return fmt.Errorf("server.device_id.device_revision must be between 0 and 15")
+	}
+	if d.FirmwareMajor < 0 || d.FirmwareMajor > 0x7f {
+		return fmt.Errorf("server.device_id.firmware_major must be between 0 and 127")
+	}
+	if d.FirmwareMinor < 0 || d.FirmwareMinor > 99 {
+		return fmt.Errorf("server.device_id.firmware_minor must be between 0 and 99")
+	}
+	if d.ManufacturerID < 0 || d.ManufacturerID > 0xfffff {
+		return fmt.Errorf("server.device_id.manufacturer_id must be between 0 and 1048575")
+	}
+	if d.ProductID < 0 || d.ProductID > 0xffff {
+		return fmt.Errorf("server.device_id.product_id must be between 0 and 65535")
+	}
+
+	// Validate synthetic sensors, whose readings must lie between their
+	// thresholds
+	if len(c.Server.SyntheticSensors) > MaxSyntheticSensors {
+		return fmt.Errorf("server.synthetic_sensors lists %d sensors, at most %d are supported", len(c.Server.SyntheticSensors), MaxSyntheticSensors)
+	}
+	sensorNames := make(map[string]bool, len(c.Server.SyntheticSensors))
+	for _, sensor := range c.Server.SyntheticSensors {
+		if sensor.Name == "" || len(sensor.Name) > 16 {
+			return fmt.Errorf("server.synthetic_sensors names must be 1 to 16 characters: %q", sensor.Name)
+		}
+		if sensorNames[sensor.Name] {
+			return fmt.Errorf("server.synthetic_sensors lists %q twice", sensor.Name)
+		}
+		sensorNames[sensor.Name] = true
+
+		var max int
+		switch sensor.Type {
+		case SensorFan:
+			max = 25500
+		case SensorTemperature:
+			max = 255
+		default:
+			return fmt.Errorf("invalid server.synthetic_sensors type for %s: %s (must be fan or temperature)", sensor.Name, sensor.Type)
+		}
+		if sensor.LowerCritical < 0 || sensor.UpperCritical > max {
+			return fmt.Errorf("server.synthetic_sensors thresholds of %s must be between 0 and %d", sensor.Name, max)
+		}
+		if sensor.Reading < sensor.LowerCritical || sensor.Reading > sensor.UpperCritical {
+			return fmt.Errorf("server.synthetic_sensors reading of %s must lie between its thresholds", sensor.Name)
+		}
+	}
+
+	// Validate self ping
+	if c.Server.SelfPing.Sample < 0 {
+		return fmt.Errorf("server.self_ping.sample must not be negative")
+	}
+	if c.Server.SelfPing.Enabled && c.Server.SelfPing.TimeoutSeconds <= 0 {
+		return fmt.Errorf("server.self_ping.timeout_seconds must be positive")
+	}
+
+	if c.Server.ConflictProbe.Enabled && c.Server.ConflictProbe.TimeoutMs <= 0 {
+		return fmt.Errorf("server.conflict_probe.timeout_ms must be positive")
+	}
+
+	if c.Server.IPMIPort <= 0 || c.Server.IPMIPort > 65535 {
+		return fmt.Errorf("server.ipmi_port must be between 1 and 65535")
+	}
+
+	if c.Server.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Server.MetricsAddr); err != nil {
+			return fmt.Errorf("invalid server.metrics_addr: %v", err)
+		}
+	}
+
+	if c.Server.ReconcileInterval < 0 {
+		return fmt.Errorf("server.reconcile_interval_seconds must not be negative")
+	}
+
+	// Validate the manager BMC, whose sensors are refreshed by the
+	// reconcile loop and whose address no VM's BMC may take
+	if m := c.Server.Manager; m.Enabled {
+		ip := ParseIP(m.IP)
+		if ip == nil {
+			return fmt.Errorf("invalid server.manager.ip: %s", m.IP)
+		}
+		if c.Server.ReconcileInterval == 0 {
+			return fmt.Errorf("server.manager requires server.reconcile_interval_seconds")
+		}
+		switch c.Server.AllocationMode {
+		case AllocationIPPerVM:
+			for _, v := range c.VCenterTargets() {
+				if r := c.Server.RangeFor(v); r.Contains(ip) && !r.Excludes(ip) {
+					return fmt.Errorf("server.manager.ip %s is allocated to VMs from ip_range %s-%s, exclude it", m.IP, r.Start, r.End)
+				}
+			}
+		case AllocationPortPerVM:
+			r := c.Server.PortRange
+			if ip.Equal(ParseIP(c.Server.HostIP)) && c.Server.IPMIPort >= r.Start && c.Server.IPMIPort <= r.End {
+				return fmt.Errorf("server.manager.ip is server.host_ip and server.ipmi_port %d is allocated to VMs from server.port_range", c.Server.IPMIPort)
+			}
+		}
+	}
+
+	if c.Server.IPLeaseSeconds < 0 {
+		return fmt.Errorf("server.ip_lease_seconds must not be negative")
+	}
+
+	if c.Server.PowerCycleDelay < 0 {
+		return fmt.Errorf("server.power_cycle_delay_seconds must not be negative")
+	}
+
+	if c.Server.PowerOnDelay < 0 {
+		return fmt.Errorf("server.power_on_delay_seconds must not be negative")
+	}
+
+	if c.Server.GracefulShutdown < 0 {
+		return fmt.Errorf("server.graceful_shutdown_timeout must not be negative")
+	}
+
+	if c.Server.StopTimeout < 0 {
+		return fmt.Errorf("server.stop_timeout_seconds must not be negative")
+	}
+
+	if c.Server.GuestShutdown.PollIntervalSeconds <= 0 || c.Server.GuestShutdown.TimeoutSeconds <= 0 {
+		return fmt.Errorf("server.guest_shutdown.poll_interval_seconds and timeout_seconds must be positive")
+	}
+
+	switch c.Server.NICWatch.Action {
+	case NICWatchWarn, NICWatchReadd:
+	default:
+		return fmt.Errorf("invalid server.nic_watch.action: %s (must be warn or readd)", c.Server.NICWatch.Action)
+	}
+	if c.Server.NICWatch.Enabled && c.Server.NICWatch.Action == NICWatchReadd && !c.Server.ManagesIPs() {
+		return fmt.Errorf("server.nic_watch.action readd requires server.manage_ips and ip-per-vm mode")
+	}
+
+	switch c.Server.PowerRestorePolicy {
+	case PowerRestoreAlwaysOff, PowerRestoreAlwaysOn, PowerRestorePrevious:
+	default:
+		return fmt.Errorf("invalid server.power_restore_policy: %s (must be always-off, always-on or restore-previous)", c.Server.PowerRestorePolicy)
+	}
+
+	if c.Server.BusyCompletionCode <= 0 || c.Server.BusyCompletionCode > 0xff {
+		return fmt.Errorf("server.busy_completion_code must be between 1 and 255")
+	}
+
+	if c.Server.DefaultBootDevice != "" && !bootOrderDevices[c.Server.DefaultBootDevice] {
+		return fmt.Errorf("invalid server.default_boot_device: %s (must be pxe, disk, cdrom or floppy)", c.Server.DefaultBootDevice)
+	}
+
+	if f := c.Server.FloppyFallback; f != "" && (!bootOrderDevices[f] || f == "floppy") {
+		return fmt.Errorf("invalid server.floppy_fallback: %s (must be pxe, disk or cdrom)", f)
+	}
+
+	// Validate boot order mapping
+	for device, order := range c.Server.BootOrder {
+		if !bootOrderDevices[device] {
+			return fmt.Errorf("invalid server.boot_order device: %s (must be pxe, disk, cdrom or floppy)", device)
+		}
+		if len(order) == 0 {
+			return fmt.Errorf("server.boot_order.%s must not be empty", device)
+		}
+		for _, entry := range order {
+			if !isBootableDevice(entry) {
+				return fmt.Errorf("invalid server.boot_order.%s entry: %s", device, entry)
+			}
+		}
+	}
+
 	// Validate network configuration
-	if c.Server.Network.Netmask == "" {
-		return fmt.Errorf("server.network.netmask is required")
+	mask, err := c.Server.Network.Mask(c.Server.IPv6())
+	if err != nil {
+		return err
 	}
-	// Validate netmask format
-	netmask := net.ParseIP(c.Server.Network.Netmask)
-	if netmask == nil {
-		return fmt.Errorf("invalid netmask: %s", c.Server.Network.Netmask)
+	if c.Server.Network.PrefixLength != nil && c.Server.Network.Netmask != "" {
+		netmask := ParseIP(c.Server.Network.Netmask)
+		if netmask == nil || !bytes.Equal(netmask, mask) {
+			return fmt.Errorf("server.network.netmask %s conflicts with prefix_length %d", c.Server.Network.Netmask, *c.Server.Network.PrefixLength)
+		}
 	}
 
 	// Validate gateway if provided
@@ -159,21 +1086,359 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("network interface %s does not exist", c.Server.NIC)
 	}
 
-	// Validate IP addresses
-	start := net.ParseIP(c.Server.IPRange.Start)
+	// Validate the IP range BMC addresses are allocated from, and that it
+	// is on a subnet of the NIC
+	if c.Server.AllocationMode == AllocationIPPerVM {
+		if err := c.Server.validateIPRange(mask); err != nil {
+			return err
+		}
+		if err := c.validateVCenterRanges(); err != nil {
+			return err
+		}
+		if err := c.validateReservationTargets(); err != nil {
+			return err
+		}
+		switch c.Server.SubnetCheck {
+		case SubnetCheckOff:
+		case SubnetCheckWarn, SubnetCheckError:
+			if err := c.Server.checkNICSubnets(); err != nil {
+				if c.Server.SubnetCheck == SubnetCheckError {
+					return err
+				}
+				logrus.Warnf("%v, BMCs may be unreachable (set server.subnet_check to off to silence)", err)
+			}
+		default:
+			return fmt.Errorf("invalid server.subnet_check: %s (must be warn, error or off)", c.Server.SubnetCheck)
+		}
+	}
+
+	// Validate admin API
+	if c.Admin.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Listen); err != nil {
+			return fmt.Errorf("invalid admin.listen: %v", err)
+		}
+	}
+
+	// Validate startup mode. Standby BMCs can only be activated through the admin API.
+	switch c.Server.Startup {
+	case StartupEager:
+	case StartupStandby:
+		if c.Admin.Listen == "" {
+			return fmt.Errorf("server.startup standby requires admin.listen")
+		}
+	default:
+		return fmt.Errorf("invalid server.startup: %s (must be eager or standby)", c.Server.Startup)
+	}
+
+	// Validate syslog forwarding
+	if c.Syslog.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Syslog.Address); err != nil {
+			return fmt.Errorf("invalid syslog.address: %v", err)
+		}
+		if c.Syslog.Network != "udp" && c.Syslog.Network != "tcp" {
+			return fmt.Errorf("invalid syslog.network: %s (must be udp or tcp)", c.Syslog.Network)
+		}
+	}
+
+	// Validate IPMI credentials
+	if c.IPMI.DefaultUser == "" || len(c.IPMI.DefaultUser) > 16 {
+		return fmt.Errorf("ipmi.default_user must be 1 to 16 characters")
+	}
+	if len(c.IPMI.DefaultPassword) > 16 {
+		return fmt.Errorf("ipmi.default_password must be at most 16 characters")
+	}
+	if c.IPMI.DefaultUser == insecureDefaultUser && c.IPMI.DefaultPassword == insecureDefaultPassword &&
+		!c.Server.loopback() {
+		return fmt.Errorf("the built-in IPMI credentials admin/password are not allowed on non-loopback addresses, set ipmi.default_user and ipmi.default_password")
+	}
+	for name, creds := range c.IPMI.VMCredentials {
+		if _, err := c.vmTarget("ipmi.vm_credentials", name); err != nil {
+			return err
+		}
+		if err := c.CheckCredentials(creds.User, creds.Password); err != nil {
+			return fmt.Errorf("invalid ipmi.vm_credentials.%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckCredentials checks the IPMI credentials of a VM's BMC fit IPMI's 16
+// byte user name and password fields, and aren't the built-in ones on
+// non-loopback addresses
+func (c *Config) CheckCredentials(user, password string) error {
+	if user == "" || len(user) > 16 {
+		return fmt.Errorf("user must be 1 to 16 characters")
+	}
+	if len(password) > 16 {
+		return fmt.Errorf("password must be at most 16 characters")
+	}
+	if user == insecureDefaultUser && password == insecureDefaultPassword && !c.Server.loopback() {
+		return fmt.Errorf("the built-in IPMI credentials admin/password are not allowed on non-loopback addresses")
+	}
+	return nil
+}
+
+// validate checks the settings of a vCenter, named field in errors.
+// Connection settings aren't needed in a dry run.
+func (v *VCenterConfig) validate(field string, dryRun bool) error {
+	if !dryRun {
+		if v.IP == "" {
+			return fmt.Errorf("%s.ip is required", field)
+		}
+		if v.User == "" {
+			return fmt.Errorf("%s.user is required", field)
+		}
+		if v.Password == "" {
+			return fmt.Errorf("%s.password is required", field)
+		}
+		if v.Datacenter == "" {
+			return fmt.Errorf("%s.datacenter is required", field)
+		}
+	}
+	if v.SourceIP != "" && v.SourceInterface != "" {
+		return fmt.Errorf("%s.source_ip and %s.source_interface are mutually exclusive", field, field)
+	}
+	if _, err := v.SourceAddress(); err != nil {
+		return err
+	}
+	switch v.PowerStateFilter {
+	case "", "poweredOn", "poweredOff", "suspended":
+	default:
+		return fmt.Errorf("invalid %s.power_state_filter: %s (must be poweredOn, poweredOff or suspended)", field, v.PowerStateFilter)
+	}
+	if v.TaskAttempts < 1 {
+		return fmt.Errorf("%s.task_attempts must be at least 1", field)
+	}
+	if v.TaskRetryDelayMs < 0 {
+		return fmt.Errorf("%s.task_retry_delay_ms must not be negative", field)
+	}
+
+	// Validate per-privilege vCenter credentials
+	for level, creds := range v.PrivilegeCredentials {
+		if level != "user" && level != "operator" && level != "administrator" {
+			return fmt.Errorf("invalid %s.privilege_credentials level: %s (must be user, operator or administrator)", field, level)
+		}
+		if creds.User == "" {
+			return fmt.Errorf("%s.privilege_credentials.%s.user is required", field, level)
+		}
+	}
+	return nil
+}
+
+// validateVCenterRanges checks the vCenters' sub-ranges lie within
+// server.ip_range without overlapping. Either every vCenter has one or none
+// does, so no vCenter's VMs draw from another's sub-range.
+func (c *Config) validateVCenterRanges() error {
+	targets := c.VCenterTargets()
+	start, end := ParseIP(c.Server.IPRange.Start), ParseIP(c.Server.IPRange.End)
+	var spans [][2]net.IP
+	for i, v := range targets {
+		if v.IPRange == nil {
+			continue
+		}
+		field := "vcenter"
+		if len(c.VCenters) > 0 {
+			field = fmt.Sprintf("vcenters[%d]", i)
+		}
+		if len(v.IPRange.Exclude) > 0 {
+			return fmt.Errorf("%s.ip_range.exclude is not supported, use server.ip_range.exclude", field)
+		}
+		lo, hi := ParseIP(v.IPRange.Start), ParseIP(v.IPRange.End)
+		if lo == nil || hi == nil || len(lo) != len(start) || bytes.Compare(hi, lo) < 0 {
+			return fmt.Errorf("invalid %s.ip_range: %s-%s", field, v.IPRange.Start, v.IPRange.End)
+		}
+		if bytes.Compare(lo, start) < 0 || bytes.Compare(hi, end) > 0 {
+			return fmt.Errorf("%s.ip_range %s-%s is outside server.ip_range", field, lo, hi)
+		}
+		for _, span := range spans {
+			if bytes.Compare(lo, span[1]) <= 0 && bytes.Compare(hi, span[0]) >= 0 {
+				return fmt.Errorf("%s.ip_range %s-%s overlaps the range of another vCenter", field, lo, hi)
+			}
+		}
+		spans = append(spans, [2]net.IP{lo, hi})
+	}
+	if len(spans) > 0 && len(spans) < len(targets) {
+		return fmt.Errorf("either every vCenter or none must have an ip_range")
+	}
+	return nil
+}
+
+// validateReservationTargets checks each reserved address lies in the
+// range of the vCenter its VM belongs to, since VMs only get addresses
+// from their vCenter's sub-range
+func (c *Config) validateReservationTargets() error {
+	for key, addr := range c.Server.IPReservations {
+		v, err := c.vmTarget("server.ip_reservations", key)
+		if err != nil {
+			return err
+		}
+		if r := c.Server.RangeFor(v); !r.Contains(ParseIP(addr)) {
+			return fmt.Errorf("server.ip_reservations address %s for VM %s is outside the ip_range %s-%s of vCenter %s",
+				addr, key, r.Start, r.End, v.Name)
+		}
+	}
+	return nil
+}
+
+// RangeFor returns the IP range BMCs of a vCenter's VMs are allocated
+// from: its own sub-range if it has one, with the exclusions of ip_range
+func (c ServerConfig) RangeFor(v VCenterConfig) IPRange {
+	if v.IPRange == nil {
+		return c.IPRange
+	}
+	return IPRange{Start: v.IPRange.Start, End: v.IPRange.End, Exclude: c.IPRange.Exclude}
+}
+
+// validateIPRange checks the IP range and its exclusions lie in one subnet
+// of mask, along with the gateway if set
+func (c ServerConfig) validateIPRange(mask net.IPMask) error {
+	start := ParseIP(c.IPRange.Start)
 	if start == nil {
-		return fmt.Errorf("invalid start IP address: %s", c.Server.IPRange.Start)
+		return fmt.Errorf("invalid start IP address: %s", c.IPRange.Start)
 	}
 
-	end := net.ParseIP(c.Server.IPRange.End)
+	end := ParseIP(c.IPRange.End)
 	if end == nil {
-		return fmt.Errorf("invalid end IP address: %s", c.Server.IPRange.End)
+		return fmt.Errorf("invalid end IP address: %s", c.IPRange.End)
+	}
+	if len(start) != len(end) {
+		return fmt.Errorf("start IP %s and end IP %s must both be IPv4 or both IPv6", start, end)
 	}
 
 	// Ensure end IP is greater than start IP
-	if bytes.Compare(end.To4(), start.To4()) < 0 {
+	if bytes.Compare(end, start) < 0 {
 		return fmt.Errorf("end IP must be greater than start IP")
 	}
 
+	// The range, and the gateway if set, must lie in a single subnet
+	subnet := &net.IPNet{IP: start.Mask(mask), Mask: mask}
+	if !subnet.Contains(end) {
+		return fmt.Errorf("end IP %s is outside the subnet %s of start IP %s", end, subnet, start)
+	}
+	if gateway := ParseIP(c.Network.Gateway); gateway != nil && (len(gateway) != len(start) || !subnet.Contains(gateway)) {
+		return fmt.Errorf("gateway %s is outside the subnet %s of the IP range", gateway, subnet)
+	}
+
+	// Validate exclusions, which must lie within the range
+	for _, entry := range c.IPRange.Exclude {
+		lo, hi, err := parseExclusion(entry)
+		if err != nil {
+			return fmt.Errorf("invalid server.ip_range.exclude entry: %v", err)
+		}
+		if len(lo) != len(start) || bytes.Compare(lo, start) < 0 || bytes.Compare(hi, end) > 0 {
+			return fmt.Errorf("server.ip_range.exclude entry %q is outside the IP range", entry)
+		}
+	}
+
+	// Validate reservations, which must lie within the range, outside the
+	// exclusions and each on their own address
+	reservedBy := make(map[string]string, len(c.IPReservations))
+	for name, addr := range c.IPReservations {
+		ip := ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("invalid server.ip_reservations address for VM %s: %s", name, addr)
+		}
+		if len(ip) != len(start) || bytes.Compare(ip, start) < 0 || bytes.Compare(ip, end) > 0 {
+			return fmt.Errorf("server.ip_reservations address %s for VM %s is outside the IP range", ip, name)
+		}
+		if c.IPRange.Excludes(ip) {
+			return fmt.Errorf("server.ip_reservations address %s for VM %s is excluded by server.ip_range.exclude", ip, name)
+		}
+		if other, ok := reservedBy[ip.String()]; ok {
+			return fmt.Errorf("server.ip_reservations address %s is reserved for both VM %s and VM %s", ip, other, name)
+		}
+		reservedBy[ip.String()] = name
+	}
+
 	return nil
 }
+
+// ReservedIPs returns the addresses pinned by ip_reservations
+func (c ServerConfig) ReservedIPs() map[string]bool {
+	reserved := make(map[string]bool, len(c.IPReservations))
+	for _, addr := range c.IPReservations {
+		if ip := ParseIP(addr); ip != nil {
+			reserved[ip.String()] = true
+		}
+	}
+	return reserved
+}
+
+// IPv6 reports whether the BMC addresses are IPv6 addresses
+func (c ServerConfig) IPv6() bool {
+	first := c.IPRange.Start
+	if c.AllocationMode == AllocationPortPerVM {
+		first = c.HostIP
+	}
+	ip := ParseIP(first)
+	return ip != nil && ip.To4() == nil
+}
+
+// interfaceAddrs lists the addresses of a network interface. Tests replace
+// it to check subnets without depending on the host's interfaces.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", name, err)
+	}
+	return addrs, nil
+}
+
+// checkNICSubnets returns an error if the ends of the IP range, or the
+// gateway if set, aren't on a subnet of one of the NIC's addresses
+func (c ServerConfig) checkNICSubnets() error {
+	addrs, err := interfaceAddrs(c.NIC)
+	if err != nil {
+		return err
+	}
+	var subnets []*net.IPNet
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			subnets = append(subnets, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+		}
+	}
+
+	onNIC := func(ip net.IP) bool {
+		for _, subnet := range subnets {
+			if subnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	checks := []struct{ name, addr string }{
+		{"server.ip_range.start", c.IPRange.Start},
+		{"server.ip_range.end", c.IPRange.End},
+		{"server.network.gateway", c.Network.Gateway},
+	}
+	for _, check := range checks {
+		if check.addr != "" && !onNIC(ParseIP(check.addr)) {
+			return fmt.Errorf("%s %s is not on any subnet of interface %s %v", check.name, check.addr, c.NIC, subnets)
+		}
+	}
+	return nil
+}
+
+// loopback reports whether every BMC address is a loopback address
+func (c ServerConfig) loopback() bool {
+	if c.AllocationMode == AllocationPortPerVM {
+		return net.ParseIP(c.HostIP).IsLoopback()
+	}
+	return net.ParseIP(c.IPRange.Start).IsLoopback() && net.ParseIP(c.IPRange.End).IsLoopback()
+}
+
+// isBootableDevice reports whether entry is a bootable device type or a
+// device name of a bootable type such as "ethernet-1"
+func isBootableDevice(entry string) bool {
+	for _, t := range bootableTypes {
+		if entry == t || strings.HasPrefix(entry, t+"-") {
+			return true
+		}
+	}
+	return false
+}
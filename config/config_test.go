@@ -0,0 +1,480 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testConfig returns a valid configuration managing loopback addresses
+func testConfig() *Config {
+	c := NewConfig()
+	c.VCenter.IP = "vcenter.example.com"
+	c.VCenter.User = "administrator@vsphere.local"
+	c.VCenter.Password = "secret"
+	c.VCenter.Datacenter = "DC0"
+	c.Server.NIC = "lo"
+	c.Server.Network.Netmask = "255.0.0.0"
+	c.Server.IPRange = IPRange{Start: "127.0.0.10", End: "127.0.0.20"}
+	return c
+}
+
+func TestTestConfigIsValid(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Fatalf("test configuration is invalid: %v", err)
+	}
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	c := testConfig()
+	c.Logging.Format = LogFormatJSON
+	c.Logging.TimestampFormat = time.RFC3339
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(c.GetLogFormatter())
+
+	log.WithFields(logrus.Fields{"vm": "db-01", "addr": "127.0.0.10:623"}).Info("Started virtual BMC")
+	var entry map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line %q isn't JSON: %v", buf.String(), err)
+	}
+	if entry["vm"] != "db-01" || entry["addr"] != "127.0.0.10:623" || entry["msg"] != "Started virtual BMC" {
+		t.Errorf("log entry is %v, want the message with its vm and addr fields", entry)
+	}
+	if _, err := time.Parse(time.RFC3339, entry["time"]); err != nil {
+		t.Errorf("timestamp %q isn't in the configured format: %v", entry["time"], err)
+	}
+}
+
+func TestVMCredentialsOverrideDefault(t *testing.T) {
+	c := testConfig()
+	c.IPMI.DefaultUser, c.IPMI.DefaultPassword = "operator", "fleet-secret"
+	c.IPMI.VMCredentials = map[string]Credentials{"web-01": {User: "web", Password: "web-secret"}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, password := c.IPMI.CredentialsFor("", "web-01"); user != "web" || password != "web-secret" {
+		t.Errorf("web-01 gets %s/%s, want its own credentials", user, password)
+	}
+	if user, password := c.IPMI.CredentialsFor("", "web-02"); user != "operator" || password != "fleet-secret" {
+		t.Errorf("web-02 gets %s/%s, want the default credentials", user, password)
+	}
+}
+
+func TestBuiltInCredentialsOnlyOnLoopback(t *testing.T) {
+	c := testConfig()
+	if err := c.Validate(); err != nil {
+		t.Errorf("built-in credentials rejected on loopback: %v", err)
+	}
+
+	c.Server.AllocationMode = AllocationPortPerVM
+	c.Server.HostIP = "192.0.2.10"
+	c.Server.PortRange = PortRange{Start: 6230, End: 6239}
+	c.Server.IPRange = IPRange{}
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "built-in IPMI credentials") {
+		t.Errorf("built-in credentials on %s gave %v, want an error", c.Server.HostIP, err)
+	}
+
+	c.IPMI.DefaultPassword = "changed"
+	if err := c.Validate(); err != nil {
+		t.Errorf("changed credentials rejected: %v", err)
+	}
+}
+
+func TestAllocationModeTakesOneRange(t *testing.T) {
+	ips := IPRange{Start: "127.0.0.10", End: "127.0.0.20"}
+	ports := PortRange{Start: 6230, End: 6239}
+	for _, tc := range []struct {
+		name  string
+		mode  string
+		ips   IPRange
+		ports PortRange
+		valid bool
+	}{
+		{"ip-per-vm with ip_range", AllocationIPPerVM, ips, PortRange{}, true},
+		{"ip-per-vm with both", AllocationIPPerVM, ips, ports, false},
+		{"ip-per-vm with port_range", AllocationIPPerVM, IPRange{}, ports, false},
+		{"ip-per-vm with neither", AllocationIPPerVM, IPRange{}, PortRange{}, false},
+		{"port-per-vm with port_range", AllocationPortPerVM, IPRange{}, ports, true},
+		{"port-per-vm with both", AllocationPortPerVM, ips, ports, false},
+		{"port-per-vm with ip_range", AllocationPortPerVM, ips, PortRange{}, false},
+		{"port-per-vm with neither", AllocationPortPerVM, IPRange{}, PortRange{}, false},
+	} {
+		c := testConfig()
+		c.Server.AllocationMode = tc.mode
+		c.Server.HostIP = "127.0.0.1"
+		c.Server.IPRange = tc.ips
+		c.Server.PortRange = tc.ports
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s gave %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestExclusionsMustLieInRange(t *testing.T) {
+	for _, tc := range []struct {
+		exclude []string
+		valid   bool
+	}{
+		{[]string{"127.0.0.10", "127.0.0.15-127.0.0.20"}, true},
+		{[]string{"127.0.0.9"}, false},
+		{[]string{"127.0.0.18-127.0.0.21"}, false},
+		{[]string{"127.0.0.15-127.0.0.12"}, false},
+		{[]string{"not-an-ip"}, false},
+	} {
+		c := testConfig()
+		c.Server.IPRange.Exclude = tc.exclude
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("exclusions %v: got error %v, want valid %v", tc.exclude, err, tc.valid)
+		}
+	}
+}
+
+func TestExcludes(t *testing.T) {
+	r := IPRange{Start: "127.0.0.10", End: "127.0.0.20", Exclude: []string{"127.0.0.12", "127.0.0.15-127.0.0.17"}}
+	for ip, want := range map[string]bool{
+		"127.0.0.11": false,
+		"127.0.0.12": true,
+		"127.0.0.15": true,
+		"127.0.0.17": true,
+		"127.0.0.18": false,
+		"::1":        false,
+	} {
+		if got := r.Excludes(net.ParseIP(ip)); got != want {
+			t.Errorf("Excludes(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestSyntheticSensorsValidation(t *testing.T) {
+	fan := SensorConfig{Name: "FAN1", Type: SensorFan, Reading: 4200, LowerCritical: 600, UpperCritical: 12000}
+	for _, tc := range []struct {
+		name    string
+		sensors []SensorConfig
+		valid   bool
+	}{
+		{"fan and temperature", []SensorConfig{fan, {Name: "Temp", Type: SensorTemperature, Reading: 24, LowerCritical: 5, UpperCritical: 42}}, true},
+		{"duplicate name", []SensorConfig{fan, fan}, false},
+		{"unknown type", []SensorConfig{{Name: "PSU1", Type: "voltage", Reading: 12, UpperCritical: 13}}, false},
+		{"reading above threshold", []SensorConfig{{Name: "FAN1", Type: SensorFan, Reading: 13000, UpperCritical: 12000}}, false},
+		{"threshold out of range", []SensorConfig{{Name: "Temp", Type: SensorTemperature, Reading: 24, UpperCritical: 300}}, false},
+		{"long name", []SensorConfig{{Name: "Inlet Temperature 1", Type: SensorTemperature, Reading: 24, UpperCritical: 42}}, false},
+	} {
+		c := testConfig()
+		c.Server.SyntheticSensors = tc.sensors
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestManagerValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ip        string
+		exclude   []string
+		reconcile int
+		valid     bool
+	}{
+		{"outside the range", "127.0.0.5", nil, 30, true},
+		{"excluded from the range", "127.0.0.15", []string{"127.0.0.15"}, 30, true},
+		{"in the range", "127.0.0.15", nil, 30, false},
+		{"no reconcile loop", "127.0.0.5", nil, 0, false},
+		{"invalid address", "manager", nil, 30, false},
+	} {
+		c := testConfig()
+		c.Server.IPRange.Exclude = tc.exclude
+		c.Server.ReconcileInterval = tc.reconcile
+		c.Server.Manager = ManagerConfig{Enabled: true, IP: tc.ip}
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	prefix := func(n int) *int { return &n }
+	for _, tc := range []struct {
+		name       string
+		netmask    string
+		prefix     *int
+		start, end string
+		valid      bool
+	}{
+		{"/24", "", prefix(24), "127.0.0.10", "127.0.0.20", true},
+		{"/24 with matching netmask", "255.255.255.0", prefix(24), "127.0.0.10", "127.0.0.20", true},
+		{"/24 with conflicting netmask", "255.255.0.0", prefix(24), "127.0.0.10", "127.0.0.20", false},
+		{"/30", "", prefix(30), "127.0.0.9", "127.0.0.10", true},
+		{"/30 too small for the range", "", prefix(30), "127.0.0.10", "127.0.0.20", false},
+		{"start outside the end's subnet", "", prefix(24), "127.0.0.250", "127.0.1.5", false},
+		{"prefix too long", "", prefix(33), "127.0.0.10", "127.0.0.20", false},
+	} {
+		c := testConfig()
+		c.Server.Network.Netmask = tc.netmask
+		c.Server.Network.PrefixLength = tc.prefix
+		c.Server.IPRange = IPRange{Start: tc.start, End: tc.end}
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+
+	mask, err := NetworkConfig{PrefixLength: prefix(30)}.Mask(false)
+	if err != nil || net.IP(mask).String() != "255.255.255.252" {
+		t.Errorf("/30 gives mask %v (%v), want 255.255.255.252", net.IP(mask), err)
+	}
+}
+
+func TestStartOutsideGatewaySubnet(t *testing.T) {
+	c := testConfig()
+	c.Server.Network.Netmask = "255.255.255.0"
+	c.Server.Network.Gateway = "127.0.0.1"
+	c.Server.IPRange = IPRange{Start: "127.0.1.10", End: "127.0.1.20"}
+	if err := c.Validate(); err == nil {
+		t.Error("range outside the gateway's /24 accepted")
+	}
+}
+
+// writeConfig writes a config file named name in a temporary directory and
+// returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const jsonConfig = `{
+  "vcenter": {"ip": "vcenter.example.com", "user": "administrator@vsphere.local", "password": "secret", "datacenter": "DC0", "task_attempts": 5},
+  "server": {
+    "nic": "lo",
+    "ip_range": {"start": "127.0.0.10", "end": "127.0.0.20", "exclude": ["127.0.0.12"]},
+    "network": {"prefix_length": 8, "gateway": ""}
+  },
+  "ipmi": {"default_user": "operator", "default_password": "fleet-secret"}
+}`
+
+const yamlConfig = `
+vcenter:
+  ip: vcenter.example.com
+  user: administrator@vsphere.local
+  password: secret
+  datacenter: DC0
+  task_attempts: 5
+server:
+  nic: lo
+  ip_range:
+    start: 127.0.0.10
+    end: 127.0.0.20
+    exclude: [127.0.0.12]
+  network:
+    prefix_length: 8
+    gateway: ""
+ipmi:
+  default_user: operator
+  default_password: fleet-secret
+`
+
+func TestYAMLMatchesJSON(t *testing.T) {
+	fromJSON, err := LoadFromFile(writeConfig(t, "config.json", jsonConfig))
+	if err != nil {
+		t.Fatalf("loading JSON: %v", err)
+	}
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		fromYAML, err := LoadFromFile(writeConfig(t, name, yamlConfig))
+		if err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(fromJSON, fromYAML) {
+			t.Errorf("%s loads as\n%+v\nwant\n%+v", name, fromYAML, fromJSON)
+		}
+	}
+	if fromJSON.VCenter.TaskAttempts != 5 || fromJSON.VCenter.TaskRetryDelayMs != 500 {
+		t.Errorf("vCenter retries are %d every %dms, want the file's 5 and the default 500ms",
+			fromJSON.VCenter.TaskAttempts, fromJSON.VCenter.TaskRetryDelayMs)
+	}
+}
+
+func TestConfigFormatByExtension(t *testing.T) {
+	_, err := LoadFromFile(writeConfig(t, "config.json", yamlConfig))
+	if err == nil || !strings.Contains(err.Error(), "as JSON") {
+		t.Errorf("YAML in a .json file gave %v, want a JSON parse error", err)
+	}
+	if _, err := LoadFromFile(writeConfig(t, "config.conf", jsonConfig)); err != nil {
+		t.Errorf("JSON in a file with an unknown extension: %v", err)
+	}
+}
+
+func TestVCenterEnvOverrides(t *testing.T) {
+	t.Setenv("VBMC_VCENTER_PASSWORD", "from-env")
+	t.Setenv("VBMC_VCENTER_USER", "env-user@vsphere.local")
+	t.Setenv("VBMC_VCENTER_IP", "")
+	path := writeConfig(t, "config.json", strings.Replace(jsonConfig, `"password": "secret"`, `"password": ""`, 1))
+
+	c, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("loading a config with its password in the environment: %v", err)
+	}
+	if c.VCenter.Password != "from-env" {
+		t.Errorf("password is %q, want the environment's", c.VCenter.Password)
+	}
+	if c.VCenter.User != "env-user@vsphere.local" {
+		t.Errorf("user is %q, want the environment's over the file's", c.VCenter.User)
+	}
+	if c.VCenter.IP != "vcenter.example.com" {
+		t.Errorf("IP is %q, want the file's when the variable is empty", c.VCenter.IP)
+	}
+}
+
+func TestVCenterEntryEnvOverrides(t *testing.T) {
+	t.Setenv("VBMC_VCENTER_LAB_EAST_PASSWORD", "east-secret")
+	t.Setenv("VBMC_VCENTER_PASSWORD", "ignored")
+	path := writeConfig(t, "config.json", `{
+  "vcenters": [
+    {"name": "lab-east", "ip": "east.example.com", "user": "administrator@vsphere.local", "datacenter": "DC0", "task_attempts": 3},
+    {"name": "lab-west", "ip": "west.example.com", "user": "administrator@vsphere.local", "password": "west-secret", "datacenter": "DC0", "task_attempts": 3}
+  ],
+  "server": {"nic": "lo", "ip_range": {"start": "127.0.0.10", "end": "127.0.0.20"}, "network": {"prefix_length": 8}},
+  "ipmi": {"default_user": "operator", "default_password": "fleet-secret"}
+}`)
+
+	c, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("loading vcenters with a password in the environment: %v", err)
+	}
+	if c.VCenters[0].Password != "east-secret" {
+		t.Errorf("lab-east password is %q, want its own variable's", c.VCenters[0].Password)
+	}
+	if c.VCenters[1].Password != "west-secret" {
+		t.Errorf("lab-west password is %q, want the file's", c.VCenters[1].Password)
+	}
+
+	c.VCenters[1].Name = "lab_east"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "VBMC_VCENTER_LAB_EAST_") {
+		t.Errorf("vCenters sharing environment variables gave %v, want an error", err)
+	}
+}
+
+// twoVCenters returns a valid configuration with the vCenters lab-east and
+// lab-west, each with half of the IP range
+func twoVCenters() *Config {
+	c := testConfig()
+	east, west := c.VCenter, c.VCenter
+	east.Name, east.IPRange = "lab-east", &IPRange{Start: "127.0.0.10", End: "127.0.0.14"}
+	west.Name, west.IPRange = "lab-west", &IPRange{Start: "127.0.0.15", End: "127.0.0.20"}
+	c.VCenter = VCenterConfig{}
+	c.VCenters = []VCenterConfig{east, west}
+	c.IPMI.DefaultUser, c.IPMI.DefaultPassword = "operator", "fleet-secret"
+	return c
+}
+
+func TestVMKeysWithSeveralVCenters(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		reservations map[string]string
+		credentials  map[string]Credentials
+		wantErr      string
+	}{
+		{"prefixed keys", map[string]string{"lab-east/db-01": "127.0.0.11", "lab-west/db-01": "127.0.0.16"},
+			map[string]Credentials{"lab-west/web-01": {User: "web"}}, ""},
+		{"bare reservation", map[string]string{"db-01": "127.0.0.11"}, nil, "ambiguous"},
+		{"bare credentials", nil, map[string]Credentials{"web-01": {User: "web"}}, "ambiguous"},
+		{"unknown vCenter", map[string]string{"lab-north/db-01": "127.0.0.11"}, nil, "ambiguous"},
+		{"other vCenter's range", map[string]string{"lab-west/db-01": "127.0.0.11"}, nil, "outside the ip_range 127.0.0.15-127.0.0.20 of vCenter lab-west"},
+	} {
+		c := twoVCenters()
+		c.Server.IPReservations = tc.reservations
+		c.IPMI.VMCredentials = tc.credentials
+		err := c.Validate()
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s gave %v, want an error containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+
+	c := twoVCenters()
+	c.Server.IPReservations = map[string]string{"lab-east/db-01": "127.0.0.11"}
+	if addr, ok := c.Server.ReservationFor("lab-east", "db-01"); !ok || addr != "127.0.0.11" {
+		t.Errorf("lab-east db-01 is reserved %q (%v), want 127.0.0.11", addr, ok)
+	}
+	if addr, ok := c.Server.ReservationFor("lab-west", "db-01"); ok {
+		t.Errorf("lab-west db-01 is reserved %s, want no reservation", addr)
+	}
+}
+
+func TestPrefixedKeyWinsWithOneVCenter(t *testing.T) {
+	c := testConfig()
+	c.VCenter.Name = "lab-east"
+	c.IPMI.DefaultUser, c.IPMI.DefaultPassword = "operator", "fleet-secret"
+	c.IPMI.VMCredentials = map[string]Credentials{
+		"web-01":          {User: "bare", Password: "bare-secret"},
+		"lab-east/web-01": {User: "prefixed", Password: "pre-secret"},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := c.IPMI.CredentialsFor("lab-east", "web-01"); user != "prefixed" {
+		t.Errorf("web-01 gets user %s, want the prefixed key's", user)
+	}
+}
+
+// fakeInterface makes every interface have the addresses of cidrs until
+// the test ends
+func fakeInterface(t *testing.T, cidrs ...string) {
+	t.Helper()
+	var addrs []net.Addr
+	for _, cidr := range cidrs {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+	}
+	saved := interfaceAddrs
+	interfaceAddrs = func(string) ([]net.Addr, error) { return addrs, nil }
+	t.Cleanup(func() { interfaceAddrs = saved })
+}
+
+func TestSubnetCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		nic     []string
+		gateway string
+		valid   bool
+	}{
+		{"range on the NIC's subnet", []string{"127.0.0.1/8"}, "", true},
+		{"range on a second address", []string{"192.0.2.1/24", "127.0.0.1/24"}, "", true},
+		{"range off the NIC", []string{"192.0.2.1/24"}, "", false},
+		{"range end off the NIC", []string{"127.0.0.1/28"}, "", false},
+		{"gateway on the NIC's subnet", []string{"127.0.0.1/24"}, "127.0.0.1", true},
+		{"gateway off the NIC", []string{"127.0.0.1/24"}, "127.0.1.1", false},
+	} {
+		fakeInterface(t, tc.nic...)
+		c := testConfig()
+		c.Server.SubnetCheck = SubnetCheckError
+		c.Server.Network.Gateway = tc.gateway
+		if err := c.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid %v", tc.name, err, tc.valid)
+		}
+
+		// Warnings never reject the configuration
+		c.Server.SubnetCheck = SubnetCheckWarn
+		if err := c.Validate(); err != nil {
+			t.Errorf("%s: warn mode rejected the configuration: %v", tc.name, err)
+		}
+	}
+}
@@ -2,20 +2,50 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vbmc-vsphere/clock"
 )
 
+// ErrClosed is returned by operations on a closed database
+var ErrClosed = errors.New("IP database is closed")
+
 // dbOperation represents a function to be executed on the database
 type dbOperation func(*IPDB) interface{}
 
-// IPDB represents the IP address database
+// Lease records when a VM holding an IP was last seen. Static leases never
+// expire.
+type Lease struct {
+	LastSeen time.Time `json:"last_seen"`
+	Static   bool      `json:"static,omitempty"`
+}
+
+// IPDB represents the IP address database. VMs are keyed by their managed
+// object reference value (vsphere.VMKey), so renaming a VM keeps its IP.
 type IPDB struct {
-	VMToIP map[string]string `json:"vm_to_ip"` // Maps VM ID to IP address
-	path   string            `json:"-"`        // Path to the database file
-	opChan chan dbOperation  `json:"-"`        // Channel for serializing operations
-	done   chan struct{}     `json:"-"`        // Channel to signal shutdown
+	VMToIP    map[string]string `json:"vm_to_ip"`             // Maps VM ID to IP address
+	VMToPort  map[string]int    `json:"vm_to_port,omitempty"` // Maps VM ID to IPMI port, in port-per-vm mode
+	AssetTags map[string]string `json:"asset_tags,omitempty"` // Maps VM ID to asset tag
+	Leases    map[string]Lease  `json:"leases,omitempty"`     // Maps VM ID to its IP or port lease
+
+	PowerStates     map[string]string `json:"power_states,omitempty"`     // Maps VM ID to its last known power state
+	RestorePolicies map[string]string `json:"restore_policies,omitempty"` // Maps VM ID to a power restore policy set through IPMI
+	OneTimeBoot     map[string]bool   `json:"one_time_boot,omitempty"`    // VMs whose boot override is cleared after their next power-on
+
+	path    string           `json:"-"` // Path to the database file
+	opChan  chan dbOperation `json:"-"` // Channel for serializing operations
+	done    chan struct{}    `json:"-"` // Channel to signal shutdown
+	closed  sync.Once        `json:"-"` // Closes done once
+	clock   clock.Clock      `json:"-"` // Time source for leases
+	version uint64           `json:"-"` // Incremented for each snapshot, owned by the operation handler
+	writeMu sync.Mutex       `json:"-"` // Serializes file writes
+	written uint64           `json:"-"` // Version of the snapshot on disk, guarded by writeMu
 }
 
 // NewIPDB creates a new IP database
@@ -27,10 +57,19 @@ func NewIPDB(dbPath string) (*IPDB, error) {
 	}
 
 	db := &IPDB{
-		VMToIP: make(map[string]string),
+		VMToIP:    make(map[string]string),
+		VMToPort:  make(map[string]int),
+		AssetTags: make(map[string]string),
+		Leases:    make(map[string]Lease),
+
+		PowerStates:     make(map[string]string),
+		RestorePolicies: make(map[string]string),
+		OneTimeBoot:     make(map[string]bool),
+
 		path:   dbPath,
 		opChan: make(chan dbOperation),
 		done:   make(chan struct{}),
+		clock:  clock.Real{},
 	}
 
 	// Load existing database if it exists
@@ -42,6 +81,24 @@ func NewIPDB(dbPath string) (*IPDB, error) {
 		if err := json.Unmarshal(data, db); err != nil {
 			return nil, fmt.Errorf("failed to parse database: %v", err)
 		}
+		if db.VMToPort == nil {
+			db.VMToPort = make(map[string]int)
+		}
+		if db.AssetTags == nil {
+			db.AssetTags = make(map[string]string)
+		}
+		if db.Leases == nil {
+			db.Leases = make(map[string]Lease)
+		}
+		if db.PowerStates == nil {
+			db.PowerStates = make(map[string]string)
+		}
+		if db.RestorePolicies == nil {
+			db.RestorePolicies = make(map[string]string)
+		}
+		if db.OneTimeBoot == nil {
+			db.OneTimeBoot = make(map[string]bool)
+		}
 	}
 
 	// Start the database operation handler
@@ -52,11 +109,56 @@ func NewIPDB(dbPath string) (*IPDB, error) {
 
 // save writes the database to disk
 func (db *IPDB) save() error {
+	data, version, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	return db.write(data, version)
+}
+
+// snapshot encodes the database for writing. It must be called by the
+// operation handler.
+func (db *IPDB) snapshot() ([]byte, uint64, error) {
 	data, err := json.MarshalIndent(db, "", "    ")
+	if err != nil {
+		return nil, 0, err
+	}
+	db.version++
+	return data, db.version, nil
+}
+
+// write atomically replaces the database file with a snapshot, unless a
+// newer snapshot has already been written. It can be called outside the
+// operation handler so slow disks don't hold up other operations.
+func (db *IPDB) write(data []byte, version uint64) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if version <= db.written {
+		return nil // Superseded by a later write
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".tmp*")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(db.path, data, 0644)
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), db.path); err != nil {
+		return err
+	}
+	db.written = version
+	return nil
 }
 
 // handleOperations processes database operations sequentially
@@ -72,19 +174,50 @@ func (db *IPDB) handleOperations() {
 	}
 }
 
-// Close shuts down the database operation handler
+// Close shuts down the database operation handler. Operations after Close
+// return ErrClosed; closing again does nothing.
 func (db *IPDB) Close() {
-	close(db.done)
+	db.closed.Do(func() { close(db.done) })
+}
+
+// submit hands an operation to the operation handler, or returns ErrClosed
+// if the database is closed. Once submitted, the operation runs and sends
+// its response.
+func (db *IPDB) submit(op dbOperation) error {
+	select {
+	case db.opChan <- op:
+		return nil
+	case <-db.done:
+		return ErrClosed
+	}
+}
+
+// GetAll returns a copy of the VM ID to IP map
+func (db *IPDB) GetAll() (map[string]string, error) {
+	response := make(chan map[string]string)
+	if err := db.submit(func(db *IPDB) interface{} {
+		all := make(map[string]string, len(db.VMToIP))
+		for vmID, ip := range db.VMToIP {
+			all[vmID] = ip
+		}
+		response <- all
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return <-response, nil
 }
 
 // AssignIP assigns an IP address to a VM
 func (db *IPDB) AssignIP(vmID, ip string) error {
 	response := make(chan error)
-	db.opChan <- func(db *IPDB) interface{} {
+	if err := db.submit(func(db *IPDB) interface{} {
 		db.VMToIP[vmID] = ip
 		err := db.save()
 		response <- err
 		return nil
+	}); err != nil {
+		return err
 	}
 	return <-response
 }
@@ -96,7 +229,7 @@ func (db *IPDB) GetIP(vmID string) (string, bool, error) {
 		exists bool
 		err    error
 	})
-	db.opChan <- func(db *IPDB) interface{} {
+	if err := db.submit(func(db *IPDB) interface{} {
 		ip, exists := db.VMToIP[vmID]
 		response <- struct {
 			ip     string
@@ -104,19 +237,193 @@ func (db *IPDB) GetIP(vmID string) (string, bool, error) {
 			err    error
 		}{ip, exists, nil}
 		return nil
+	}); err != nil {
+		return "", false, err
 	}
 	result := <-response
 	return result.ip, result.exists, result.err
 }
 
+// AssignPort assigns an IPMI port to a VM
+func (db *IPDB) AssignPort(vmID string, port int) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		db.VMToPort[vmID] = port
+		err := db.save()
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// GetPort gets the IPMI port assigned to a VM
+func (db *IPDB) GetPort(vmID string) (int, bool, error) {
+	response := make(chan struct {
+		port   int
+		exists bool
+	})
+	if err := db.submit(func(db *IPDB) interface{} {
+		port, exists := db.VMToPort[vmID]
+		response <- struct {
+			port   int
+			exists bool
+		}{port, exists}
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+	result := <-response
+	return result.port, result.exists, nil
+}
+
+// SetAssetTag stores the asset tag of a VM
+func (db *IPDB) SetAssetTag(vmID, tag string) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		db.AssetTags[vmID] = tag
+		err := db.save()
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// GetAssetTag gets the asset tag stored for a VM
+func (db *IPDB) GetAssetTag(vmID string) (string, error) {
+	response := make(chan string)
+	if err := db.submit(func(db *IPDB) interface{} {
+		response <- db.AssetTags[vmID]
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return <-response, nil
+}
+
+// SetPowerStates records the power states of VMs, keyed by VM ID, as their
+// last known state. The file is only written when a state changed.
+func (db *IPDB) SetPowerStates(states map[string]string) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		changed := false
+		for vmID, state := range states {
+			if db.PowerStates[vmID] != state {
+				db.PowerStates[vmID] = state
+				changed = true
+			}
+		}
+		var err error
+		if changed {
+			err = db.save()
+		}
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// GetPowerState gets the last known power state of a VM, empty if none
+// was recorded
+func (db *IPDB) GetPowerState(vmID string) (string, error) {
+	response := make(chan string)
+	if err := db.submit(func(db *IPDB) interface{} {
+		response <- db.PowerStates[vmID]
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return <-response, nil
+}
+
+// SetRestorePolicy stores the power restore policy of a VM, overriding the
+// configured one
+func (db *IPDB) SetRestorePolicy(vmID, policy string) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		db.RestorePolicies[vmID] = policy
+		err := db.save()
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// GetRestorePolicy gets the power restore policy stored for a VM
+func (db *IPDB) GetRestorePolicy(vmID string) (string, bool, error) {
+	response := make(chan struct {
+		policy string
+		exists bool
+	})
+	if err := db.submit(func(db *IPDB) interface{} {
+		policy, exists := db.RestorePolicies[vmID]
+		response <- struct {
+			policy string
+			exists bool
+		}{policy, exists}
+		return nil
+	}); err != nil {
+		return "", false, err
+	}
+	result := <-response
+	return result.policy, result.exists, nil
+}
+
+// SetOneTimeBoot records whether a VM's boot override applies to its next
+// boot only
+func (db *IPDB) SetOneTimeBoot(vmID string, oneTime bool) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		if oneTime {
+			db.OneTimeBoot[vmID] = true
+		} else {
+			delete(db.OneTimeBoot, vmID)
+		}
+		err := db.save()
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// GetOneTimeBoot reports whether a VM's boot override applies to its next
+// boot only
+func (db *IPDB) GetOneTimeBoot(vmID string) (bool, error) {
+	response := make(chan bool)
+	if err := db.submit(func(db *IPDB) interface{} {
+		response <- db.OneTimeBoot[vmID]
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return <-response, nil
+}
+
 // RemoveVM removes a VM from the database
 func (db *IPDB) RemoveVM(vmID string) error {
 	response := make(chan error)
-	db.opChan <- func(db *IPDB) interface{} {
+	if err := db.submit(func(db *IPDB) interface{} {
 		delete(db.VMToIP, vmID)
+		delete(db.VMToPort, vmID)
+		delete(db.AssetTags, vmID)
+		delete(db.Leases, vmID)
+		delete(db.PowerStates, vmID)
+		delete(db.RestorePolicies, vmID)
+		delete(db.OneTimeBoot, vmID)
 		err := db.save()
 		response <- err
 		return nil
+	}); err != nil {
+		return err
 	}
 	return <-response
 }
@@ -127,7 +434,7 @@ func (db *IPDB) GetAssignedIPs() (map[string]bool, error) {
 		ips map[string]bool
 		err error
 	})
-	db.opChan <- func(db *IPDB) interface{} {
+	if err := db.submit(func(db *IPDB) interface{} {
 		ips := make(map[string]bool)
 		for _, ip := range db.VMToIP {
 			ips[ip] = true
@@ -137,23 +444,196 @@ func (db *IPDB) GetAssignedIPs() (map[string]bool, error) {
 			err error
 		}{ips, nil}
 		return nil
+	}); err != nil {
+		return nil, err
 	}
 	result := <-response
 	return result.ips, result.err
 }
 
-// Cleanup removes entries for VMs that no longer exist
+// GetAssignedPorts returns a map of all assigned IPMI ports
+func (db *IPDB) GetAssignedPorts() (map[int]bool, error) {
+	response := make(chan map[int]bool)
+	if err := db.submit(func(db *IPDB) interface{} {
+		ports := make(map[int]bool)
+		for _, port := range db.VMToPort {
+			ports[port] = true
+		}
+		response <- ports
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return <-response, nil
+}
+
+// Cleanup removes entries for VMs that no longer exist. The entries are
+// removed in one pass and the file is written after the operation handler
+// is released, so lookups aren't held up by the write.
 func (db *IPDB) Cleanup(existingVMs map[string]bool) error {
-	response := make(chan error)
-	db.opChan <- func(db *IPDB) interface{} {
+	response := make(chan struct {
+		data    []byte
+		version uint64
+		err     error
+	})
+	if err := db.submit(func(db *IPDB) interface{} {
 		for vmID := range db.VMToIP {
 			if !existingVMs[vmID] {
 				delete(db.VMToIP, vmID)
 			}
 		}
+		for vmID := range db.VMToPort {
+			if !existingVMs[vmID] {
+				delete(db.VMToPort, vmID)
+			}
+		}
+		for vmID := range db.AssetTags {
+			if !existingVMs[vmID] {
+				delete(db.AssetTags, vmID)
+			}
+		}
+		for vmID := range db.Leases {
+			if !existingVMs[vmID] {
+				delete(db.Leases, vmID)
+			}
+		}
+		for vmID := range db.PowerStates {
+			if !existingVMs[vmID] {
+				delete(db.PowerStates, vmID)
+			}
+		}
+		for vmID := range db.RestorePolicies {
+			if !existingVMs[vmID] {
+				delete(db.RestorePolicies, vmID)
+			}
+		}
+		for vmID := range db.OneTimeBoot {
+			if !existingVMs[vmID] {
+				delete(db.OneTimeBoot, vmID)
+			}
+		}
+		data, version, err := db.snapshot()
+		response <- struct {
+			data    []byte
+			version uint64
+			err     error
+		}{data, version, err}
+		return nil
+	}); err != nil {
+		return err
+	}
+	result := <-response
+	if result.err != nil {
+		return result.err
+	}
+	return db.write(result.data, result.version)
+}
+
+// MarkSeen renews the leases of VMs found in the inventory
+func (db *IPDB) MarkSeen(vmIDs []string) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		now := db.clock.Now()
+		for _, vmID := range vmIDs {
+			lease := db.Leases[vmID]
+			lease.LastSeen = now
+			db.Leases[vmID] = lease
+		}
+		err := db.save()
+		response <- err
+		return nil
+	}); err != nil {
+		return err
+	}
+	return <-response
+}
+
+// SetStatic marks a VM's lease as static, exempting it from expiry, or
+// makes it expire normally again
+func (db *IPDB) SetStatic(vmID string, static bool) error {
+	response := make(chan error)
+	if err := db.submit(func(db *IPDB) interface{} {
+		lease := db.Leases[vmID]
+		if lease.LastSeen.IsZero() {
+			lease.LastSeen = db.clock.Now()
+		}
+		lease.Static = static
+		db.Leases[vmID] = lease
 		err := db.save()
 		response <- err
 		return nil
+	}); err != nil {
+		return err
 	}
 	return <-response
 }
+
+// IsStatic reports whether a VM's lease is static
+func (db *IPDB) IsStatic(vmID string) (bool, error) {
+	response := make(chan bool)
+	if err := db.submit(func(db *IPDB) interface{} {
+		response <- db.Leases[vmID].Static
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return <-response, nil
+}
+
+// PruneExpired removes the entries of VMs not seen for longer than maxAge,
+// freeing their IPs and ports, and returns the removed VM IDs. Static leases are
+// kept. Entries from before leases were recorded start a lease now.
+func (db *IPDB) PruneExpired(maxAge time.Duration) ([]string, error) {
+	response := make(chan struct {
+		removed []string
+		err     error
+	})
+	if err := db.submit(func(db *IPDB) interface{} {
+		now := db.clock.Now()
+		var removed []string
+		for _, vmID := range db.assignedVMs() {
+			lease, ok := db.Leases[vmID]
+			if !ok {
+				db.Leases[vmID] = Lease{LastSeen: now}
+				continue
+			}
+			if lease.Static || now.Sub(lease.LastSeen) <= maxAge {
+				continue
+			}
+			delete(db.VMToIP, vmID)
+			delete(db.VMToPort, vmID)
+			delete(db.AssetTags, vmID)
+			delete(db.Leases, vmID)
+			delete(db.PowerStates, vmID)
+			delete(db.RestorePolicies, vmID)
+			delete(db.OneTimeBoot, vmID)
+			removed = append(removed, vmID)
+		}
+		sort.Strings(removed)
+		err := db.save()
+		response <- struct {
+			removed []string
+			err     error
+		}{removed, err}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	result := <-response
+	return result.removed, result.err
+}
+
+// assignedVMs returns the IDs of VMs holding an IP or a port. It must be
+// called by the operation handler.
+func (db *IPDB) assignedVMs() []string {
+	vmIDs := make([]string, 0, len(db.VMToIP)+len(db.VMToPort))
+	for vmID := range db.VMToIP {
+		vmIDs = append(vmIDs, vmID)
+	}
+	for vmID := range db.VMToPort {
+		if _, ok := db.VMToIP[vmID]; !ok {
+			vmIDs = append(vmIDs, vmID)
+		}
+	}
+	return vmIDs
+}
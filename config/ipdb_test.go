@@ -0,0 +1,262 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vbmc-vsphere/clock"
+)
+
+// newTestIPDB opens an empty database in a temporary directory, driven by
+// a fake clock. It is closed when the test ends.
+func newTestIPDB(t testing.TB) (*IPDB, *clock.Fake) {
+	t.Helper()
+	db, err := NewIPDB(filepath.Join(t.TempDir(), "ipdb.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db.clock = fake
+	t.Cleanup(db.Close)
+	return db, fake
+}
+
+func TestPruneExpired(t *testing.T) {
+	db, fake := newTestIPDB(t)
+	for i, vmID := range []string{"vm-1", "vm-2", "vm-3"} {
+		if err := db.AssignIP(vmID, fmt.Sprintf("127.0.0.%d", 10+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MarkSeen([]string{"vm-1", "vm-2", "vm-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetStatic("vm-3", true); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Advance(30 * time.Minute)
+	if err := db.MarkSeen([]string{"vm-1"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(45 * time.Minute)
+
+	removed, err := db.PruneExpired(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"vm-2"}; !slices.Equal(removed, want) {
+		t.Errorf("pruned %v, want %v", removed, want)
+	}
+	if _, ok, _ := db.GetIP("vm-2"); ok {
+		t.Error("pruned VM still holds its IP")
+	}
+	for _, vmID := range []string{"vm-1", "vm-3"} {
+		if _, ok, _ := db.GetIP(vmID); !ok {
+			t.Errorf("%s lost its IP", vmID)
+		}
+	}
+}
+
+func TestPruneExpiredStartsMissingLeases(t *testing.T) {
+	db, fake := newTestIPDB(t)
+	// Assigning a port doesn't start a lease, as in databases from before
+	// leases were recorded
+	if err := db.AssignPort("vm-1", 6230); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Advance(24 * time.Hour)
+	if removed, err := db.PruneExpired(time.Hour); err != nil || len(removed) != 0 {
+		t.Fatalf("pruned %v (%v) on the first pass, want nothing", removed, err)
+	}
+	fake.Advance(2 * time.Hour)
+	if removed, err := db.PruneExpired(time.Hour); err != nil || !slices.Equal(removed, []string{"vm-1"}) {
+		t.Errorf("pruned %v (%v) once the new lease expired, want [vm-1]", removed, err)
+	}
+}
+
+func TestStaleSnapshotNotWritten(t *testing.T) {
+	db, _ := newTestIPDB(t)
+	if err := db.AssignIP("vm-1", "127.0.0.10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.write([]byte(`{"vm_to_ip": {}}`), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewIPDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if ip, ok, _ := reopened.GetIP("vm-1"); !ok || ip != "127.0.0.10" {
+		t.Errorf("reopened database has %q for vm-1, want the newer snapshot's 127.0.0.10", ip)
+	}
+}
+
+func TestOneTimeBootPersists(t *testing.T) {
+	db, _ := newTestIPDB(t)
+	for _, vmID := range []string{"vm-1", "vm-2"} {
+		if err := db.AssignIP(vmID, "127.0.0.10"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetOneTimeBoot(vmID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RemoveVM("vm-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewIPDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if oneTime, _ := reopened.GetOneTimeBoot("vm-1"); !oneTime {
+		t.Error("reopened database lost vm-1's one-time boot")
+	}
+	if oneTime, _ := reopened.GetOneTimeBoot("vm-2"); oneTime {
+		t.Error("removed VM still has a one-time boot")
+	}
+
+	if err := reopened.SetOneTimeBoot("vm-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if oneTime, _ := reopened.GetOneTimeBoot("vm-1"); oneTime {
+		t.Error("cleared one-time boot still recorded")
+	}
+}
+
+func TestOperationsAfterClose(t *testing.T) {
+	db, _ := newTestIPDB(t)
+	db.Close()
+	db.Close() // Closing again does nothing
+
+	done := make(chan error)
+	go func() { done <- db.AssignIP("vm-1", "127.0.0.10") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("AssignIP after Close returned %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AssignIP after Close blocked")
+	}
+	if _, err := db.GetAll(); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetAll after Close returned %v, want %v", err, ErrClosed)
+	}
+	if _, _, err := db.GetIP("vm-1"); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetIP after Close returned %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestGetAllDuringAssignIP(t *testing.T) {
+	db, _ := newTestIPDB(t)
+	const vms = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < vms; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.AssignIP(fmt.Sprintf("vm-%d", i), fmt.Sprintf("127.0.1.%d", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for seen := 0; seen < vms; {
+			all, err := db.GetAll()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(all) < seen {
+				t.Errorf("GetAll went from %d to %d VMs", seen, len(all))
+			}
+			seen = len(all)
+			all["extra"] = "127.0.2.1" // The copy is the caller's own
+		}
+	}()
+	wg.Wait()
+
+	all, err := db.GetAll()
+	if err != nil || len(all) != vms {
+		t.Fatalf("GetAll returned %d VMs (%v), want %d", len(all), err, vms)
+	}
+	if ip := all["vm-7"]; ip != "127.0.1.7" {
+		t.Errorf("vm-7 has %q, want 127.0.1.7", ip)
+	}
+}
+
+// fillIPDB assigns IPs to n VMs
+func fillIPDB(b *testing.B, db *IPDB, n int) map[string]bool {
+	b.Helper()
+	existing := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		vmID := fmt.Sprintf("vm-%d", i)
+		db.VMToIP[vmID] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		existing[vmID] = true
+	}
+	if err := db.MarkSeen(nil); err != nil { // Writes the file
+		b.Fatal(err)
+	}
+	return existing
+}
+
+func BenchmarkCleanup(b *testing.B) {
+	db, _ := newTestIPDB(b)
+	existing := fillIPDB(b, db, 5000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.Cleanup(existing); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetIPDuringCleanup measures lookups while cleanups of a large
+// database keep writing it
+func BenchmarkGetIPDuringCleanup(b *testing.B) {
+	db, _ := newTestIPDB(b)
+	existing := fillIPDB(b, db, 5000)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := db.Cleanup(existing); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := db.GetIP("vm-42"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(done)
+	<-stopped
+}
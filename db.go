@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/vbmc-vsphere/config"
+)
+
+// ipdbPath is the location of the IP address database
+const ipdbPath = "/var/lib/vbmc-vsphere/ipdb.json"
+
+// dbUsage describes the db subcommand
+const dbUsage = `Usage: vbmc-vsphere db <validate|dump|repair|prune> [flags]
+
+  validate  Check the IP database for unparseable entries and duplicate IPs
+  dump      Print the IP database
+  repair    Drop unparseable entries and deduplicate IPs
+  prune     Remove entries for VMs no longer in the vCenter inventory
+`
+
+// dbFile is the raw content of the IP database. Entries are kept as raw
+// JSON so that values which don't parse can be reported and dropped
+// instead of failing the whole file.
+type dbFile struct {
+	VMToIP    map[string]json.RawMessage `json:"vm_to_ip"`
+	VMToPort  map[string]int             `json:"vm_to_port,omitempty"`
+	AssetTags map[string]json.RawMessage `json:"asset_tags,omitempty"`
+	Leases    map[string]config.Lease    `json:"leases,omitempty"`
+
+	PowerStates     map[string]string `json:"power_states,omitempty"`
+	RestorePolicies map[string]string `json:"restore_policies,omitempty"`
+	OneTimeBoot     map[string]bool   `json:"one_time_boot,omitempty"`
+}
+
+// dbReport summarises the problems found in the IP database
+type dbReport struct {
+	entries   map[string]string       // Valid VM ID to IP entries
+	ports     map[string]int          // VM ID to port entries, in port-per-vm mode
+	tags      map[string]string       // Valid VM ID to asset tag entries
+	leases    map[string]config.Lease // VM ID to IP lease
+	states    map[string]string       // VM ID to last known power state
+	policies  map[string]string       // VM ID to power restore policy
+	oneTime   map[string]bool         // VM IDs with a one-time boot override
+	invalid   []string                // VM IDs with an unparseable entry
+	conflicts map[string][]string     // IP to the VM IDs sharing it
+}
+
+// runDB runs the db subcommand and returns the process exit code
+func runDB(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("db "+args[0], flag.ExitOnError)
+	dbPath := flags.String("db", ipdbPath, "Path to the IP database")
+	configFile := flags.String("config", "config.json", "Path to configuration file (prune only)")
+	dryRun := flags.Bool("dry-run", false, "Report changes without writing them")
+	_ = flags.Parse(args[1:])
+
+	var err error
+	switch args[0] {
+	case "validate":
+		err = dbValidate(*dbPath)
+	case "dump":
+		err = dbDump(*dbPath)
+	case "repair":
+		err = dbRepair(*dbPath, *dryRun)
+	case "prune":
+		err = dbPrune(*dbPath, *configFile, *dryRun)
+	default:
+		fmt.Fprint(os.Stderr, dbUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readDBFile reads and checks the IP database without opening it for use
+func readDBFile(path string) (*dbReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database: %v", err)
+	}
+
+	var raw dbFile
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse database: %v", err)
+	}
+
+	report := &dbReport{
+		entries:   make(map[string]string),
+		ports:     raw.VMToPort,
+		tags:      make(map[string]string),
+		leases:    raw.Leases,
+		states:    raw.PowerStates,
+		policies:  raw.RestorePolicies,
+		oneTime:   raw.OneTimeBoot,
+		conflicts: make(map[string][]string),
+	}
+
+	owners := make(map[string][]string)
+	for vmID, value := range raw.VMToIP {
+		var ip string
+		if err := json.Unmarshal(value, &ip); err != nil || net.ParseIP(ip) == nil {
+			report.invalid = append(report.invalid, vmID)
+			continue
+		}
+		report.entries[vmID] = ip
+		owners[ip] = append(owners[ip], vmID)
+	}
+	for ip, vmIDs := range owners {
+		if len(vmIDs) > 1 {
+			sort.Strings(vmIDs)
+			report.conflicts[ip] = vmIDs
+		}
+	}
+
+	for vmID, value := range raw.AssetTags {
+		var tag string
+		if err := json.Unmarshal(value, &tag); err != nil {
+			report.invalid = append(report.invalid, vmID)
+			continue
+		}
+		report.tags[vmID] = tag
+	}
+	sort.Strings(report.invalid)
+
+	return report, nil
+}
+
+// writeDBFile writes the IP database in the format used by the service,
+// keeping the leases, power states, restore policies and one-time boot
+// overrides of VMs that still have an entry
+func writeDBFile(path string, report *dbReport) error {
+	hasEntry := func(vmID string) bool {
+		_, hasIP := report.entries[vmID]
+		_, hasPort := report.ports[vmID]
+		return hasIP || hasPort
+	}
+	kept := make(map[string]config.Lease, len(report.leases))
+	for vmID, lease := range report.leases {
+		if hasEntry(vmID) {
+			kept[vmID] = lease
+		}
+	}
+	states := make(map[string]string, len(report.states))
+	for vmID, state := range report.states {
+		if hasEntry(vmID) {
+			states[vmID] = state
+		}
+	}
+	policies := make(map[string]string, len(report.policies))
+	for vmID, policy := range report.policies {
+		if hasEntry(vmID) {
+			policies[vmID] = policy
+		}
+	}
+	oneTime := make(map[string]bool, len(report.oneTime))
+	for vmID := range report.oneTime {
+		if hasEntry(vmID) {
+			oneTime[vmID] = true
+		}
+	}
+
+	db := &config.IPDB{
+		VMToIP:          report.entries,
+		VMToPort:        report.ports,
+		AssetTags:       report.tags,
+		Leases:          kept,
+		PowerStates:     states,
+		RestorePolicies: policies,
+		OneTimeBoot:     oneTime,
+	}
+	data, err := json.MarshalIndent(db, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode database: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write database: %v", err)
+	}
+	return nil
+}
+
+// printConflicts prints IPs assigned to more than one VM
+func printConflicts(conflicts map[string][]string) {
+	ips := make([]string, 0, len(conflicts))
+	for ip := range conflicts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		fmt.Printf("  conflict: %s assigned to %v\n", ip, conflicts[ip])
+	}
+}
+
+// dbValidate reports problems with the IP database
+func dbValidate(path string) error {
+	report, err := readDBFile(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d IP assignments, %d asset tags\n", len(report.entries), len(report.tags))
+	for _, vmID := range report.invalid {
+		fmt.Printf("  invalid: entry for VM %s does not parse\n", vmID)
+	}
+	printConflicts(report.conflicts)
+
+	if len(report.invalid) > 0 || len(report.conflicts) > 0 {
+		return fmt.Errorf("%d invalid entries, %d conflicting IPs", len(report.invalid), len(report.conflicts))
+	}
+	fmt.Println("Database is valid")
+	return nil
+}
+
+// dbDump prints the IP database sorted by VM ID
+func dbDump(path string) error {
+	report, err := readDBFile(path)
+	if err != nil {
+		return err
+	}
+
+	vmIDs := make([]string, 0, len(report.entries))
+	for vmID := range report.entries {
+		vmIDs = append(vmIDs, vmID)
+	}
+	sort.Strings(vmIDs)
+	for _, vmID := range vmIDs {
+		if tag, ok := report.tags[vmID]; ok {
+			fmt.Printf("%s\t%s\t%q\n", vmID, report.entries[vmID], tag)
+		} else {
+			fmt.Printf("%s\t%s\n", vmID, report.entries[vmID])
+		}
+	}
+	portVMs := make([]string, 0, len(report.ports))
+	for vmID := range report.ports {
+		portVMs = append(portVMs, vmID)
+	}
+	sort.Strings(portVMs)
+	for _, vmID := range portVMs {
+		fmt.Printf("%s\tport %d\n", vmID, report.ports[vmID])
+	}
+	fmt.Printf("%d IP assignments, %d port assignments, %d asset tags, %d invalid entries\n",
+		len(report.entries), len(report.ports), len(report.tags), len(report.invalid))
+	return nil
+}
+
+// dbRepair drops unparseable entries and keeps only the first VM, by ID,
+// for each IP assigned more than once. VMs that lose their IP are given a
+// fresh one by the service on its next start.
+func dbRepair(path string, dryRun bool) error {
+	report, err := readDBFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, vmID := range report.invalid {
+		fmt.Printf("  dropped: unparseable entry for VM %s\n", vmID)
+	}
+	printConflicts(report.conflicts)
+	deduped := 0
+	for ip, vmIDs := range report.conflicts {
+		for _, vmID := range vmIDs[1:] {
+			fmt.Printf("  dropped: %s from VM %s, kept for VM %s\n", ip, vmID, vmIDs[0])
+			delete(report.entries, vmID)
+			deduped++
+		}
+	}
+
+	fmt.Printf("Repair: %d unparseable entries dropped, %d duplicate IPs removed\n", len(report.invalid), deduped)
+	if dryRun || len(report.invalid)+deduped == 0 {
+		return nil
+	}
+	return writeDBFile(path, report)
+}
+
+// dbPrune removes entries for VMs that are no longer in the inventory
+// folders of the vCenters named in the configuration
+func dbPrune(path, configFile string, dryRun bool) error {
+	report, err := readDBFile(path)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFromFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	existing := make(map[string]bool)
+	for _, v := range cfg.VCenterTargets() {
+		vsClient, err := newVCenterClient(ctx, v)
+		if err != nil {
+			return err
+		}
+		vms, err := vsClient.GetVMs(ctx, v.Folder)
+		if err != nil {
+			return fmt.Errorf("failed to get VMs: %v", err)
+		}
+		for _, vm := range vms {
+			existing[vmKey(v, vm)] = true
+		}
+	}
+
+	pruned := 0
+	for vmID, ip := range report.entries {
+		if !existing[vmID] {
+			fmt.Printf("  pruned: VM %s (%s) not in inventory\n", vmID, ip)
+			delete(report.entries, vmID)
+			pruned++
+		}
+	}
+	for vmID, port := range report.ports {
+		if !existing[vmID] {
+			fmt.Printf("  pruned: VM %s (port %d) not in inventory\n", vmID, port)
+			delete(report.ports, vmID)
+			pruned++
+		}
+	}
+	for vmID := range report.tags {
+		if !existing[vmID] {
+			delete(report.tags, vmID)
+		}
+	}
+
+	fmt.Printf("Prune: %d entries removed, %d remaining\n", pruned, len(report.entries)+len(report.ports))
+	if dryRun || pruned == 0 {
+		return nil
+	}
+	return writeDBFile(path, report)
+}
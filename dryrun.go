@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+)
+
+// defaultDryRunVMs is the number of VMs simulated when none are named
+const defaultDryRunVMs = 3
+
+// dryRun is the in-process vCenter simulator a dry run connects to
+type dryRun struct {
+	model  *simulator.Model
+	server *simulator.Server
+	dbDir  string // Holds a throwaway IP database, so the real one is untouched
+}
+
+// startDryRun starts a vCenter simulator with the configured VMs on a
+// single host and points cfg.VCenter at it
+func startDryRun(ctx context.Context, log *logrus.Logger, cfg *config.Config) (*dryRun, error) {
+	count := len(cfg.DryRun.VMs)
+	if count == 0 {
+		count = defaultDryRunVMs
+	}
+
+	model := simulator.VPX()
+	model.Cluster = 0
+	model.Host = 1
+	model.Machine = count
+	if err := model.Create(); err != nil {
+		model.Remove()
+		return nil, fmt.Errorf("failed to create simulated inventory: %v", err)
+	}
+	dbDir, err := os.MkdirTemp("", "vbmc-dry-run")
+	if err != nil {
+		model.Remove()
+		return nil, fmt.Errorf("failed to create IP database directory: %v", err)
+	}
+	model.Service.TLS = new(tls.Config)
+	d := &dryRun{model: model, server: model.Service.NewServer(), dbDir: dbDir}
+
+	if err := d.renameVMs(ctx, cfg.DryRun.VMs); err != nil {
+		d.stop()
+		return nil, err
+	}
+
+	password, _ := d.server.URL.User.Password()
+	cfg.VCenter.IP = d.server.URL.Host
+	cfg.VCenter.User = d.server.URL.User.Username()
+	cfg.VCenter.Password = password
+	cfg.VCenter.Datacenter = "DC0"
+	cfg.VCenter.Folder = ""
+	cfg.VCenter.SourceIP = ""
+	cfg.VCenter.SourceInterface = ""
+	log.Warnf("Dry run: using a simulated vCenter at %s with %d VMs, interface addresses are left alone", cfg.VCenter.IP, count)
+	return d, nil
+}
+
+// renameVMs gives the simulated VMs the configured names
+func (d *dryRun) renameVMs(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	client, err := govmomi.NewClient(ctx, d.server.URL, true)
+	if err != nil {
+		return fmt.Errorf("failed to connect to simulated vCenter: %v", err)
+	}
+	defer client.Logout(ctx)
+
+	finder := find.NewFinder(client.Client, true)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to find simulated datacenter: %v", err)
+	}
+	finder.SetDatacenter(dc)
+	vms, err := finder.VirtualMachineList(ctx, "*")
+	if err != nil {
+		return fmt.Errorf("failed to list simulated VMs: %v", err)
+	}
+	for i, vm := range vms {
+		task, err := vm.Rename(ctx, names[i])
+		if err == nil {
+			err = task.Wait(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to name simulated VM %s: %v", names[i], err)
+		}
+	}
+	return nil
+}
+
+// ipdbPath returns the path of the throwaway IP database
+func (d *dryRun) ipdbPath() string {
+	return filepath.Join(d.dbDir, "ipdb.json")
+}
+
+// stop shuts the simulator down and removes the IP database
+func (d *dryRun) stop() {
+	d.server.Close()
+	d.model.Remove()
+	os.RemoveAll(d.dbDir)
+}
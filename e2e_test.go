@@ -0,0 +1,120 @@
+//go:build e2e
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// freeUDPPort returns a UDP port nothing listens on at the moment
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// TestDryRunEndToEnd builds the service and runs it in a dry run against
+// a simulated 3-VM vCenter, driving each BMC over IPMI before stopping the
+// service with SIGTERM. Run with: go test -tags e2e -run TestDryRunEndToEnd
+func TestDryRunEndToEnd(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "vbmc-vsphere")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("building the service: %v\n%s", err, out)
+	}
+
+	port := freeUDPPort(t)
+	cfg := map[string]interface{}{
+		"server": map[string]interface{}{
+			"nic":                  "lo",
+			"ip_range":             map[string]string{"start": "127.0.0.10", "end": "127.0.0.20"},
+			"network":              map[string]string{"netmask": "255.0.0.0", "gateway": ""},
+			"ipmi_port":            port,
+			"stop_timeout_seconds": 5,
+		},
+		"dry_run": map[string]interface{}{"enabled": true, "vms": []string{"e2e-1", "e2e-2", "e2e-3"}},
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command(binary, "-config", configFile, "-dry-run")
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	// kill stops the service and returns its output, which is only read
+	// once it has exited
+	kill := func() string {
+		_ = cmd.Process.Kill()
+		<-exited
+		return output.String()
+	}
+
+	for _, host := range []string{"127.0.0.10", "127.0.0.11", "127.0.0.12"} {
+		client, err := goipmi.NewClient(&goipmi.Connection{
+			Hostname:  host,
+			Port:      port,
+			Username:  "admin",
+			Password:  "password",
+			Interface: "lan",
+		})
+		if err != nil {
+			t.Fatalf("creating a client for %s: %v\n%s", host, err, kill())
+		}
+
+		// The BMCs come up once the simulator and the servers have started
+		deadline := time.Now().Add(30 * time.Second)
+		for err = client.Open(); err != nil && time.Now().Before(deadline); err = client.Open() {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("no BMC answered on %s:%d: %v\n%s", host, port, err, kill())
+		}
+		if err := client.Control(goipmi.ControlPowerDown); err != nil {
+			t.Errorf("powering off through %s: %v", host, err)
+		}
+		if status, err := client.GetPowerStatus(); err != nil || status != goipmi.StatusPowerOffString {
+			t.Errorf("BMC on %s reports power %q (%v), want %q", host, status, err, goipmi.StatusPowerOffString)
+		}
+		_ = client.Close()
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("signalling the service: %v\n%s", err, kill())
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("service exited with %v after SIGTERM\n%s", err, output.String())
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("service still running 30s after SIGTERM\n%s", kill())
+	}
+	if !strings.Contains(output.String(), "Shutdown complete") {
+		t.Errorf("service didn't report a clean shutdown\n%s", output.String())
+	}
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/admin"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/ipmi"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/netconfig"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vmware/govmomi/object"
+)
+
+// fleet owns the running BMCs, keyed by VM, so the reconcile loop can add
+// and remove them while the admin API, NIC watcher and shutdown see the
+// current set
+type fleet struct {
+	cfg     *config.Config
+	log     *logrus.Logger
+	targets []*target
+	limiter *ipmi.Limiter
+	ipdb    *config.IPDB
+	netmask net.IP
+	prober  netconfig.Prober       // Replaces the BMCs' conflict probe when set, e.g. in tests
+	netcfg  netconfig.Configurator // Replaces the BMCs' address configuration when set, e.g. in tests
+
+	mu        sync.Mutex
+	servers   map[string]*ipmi.Server // By VM key
+	owners    map[string]*target      // vCenter of each server, by VM key
+	usedIPs   map[string]bool
+	usedPorts map[int]bool    // In port-per-vm mode
+	conflicts map[string]bool // IPs another host answered for, never allocated again
+	activated bool            // Standby BMCs were activated, so added ones are too
+	stopped   bool            // Shutdown started, so no more BMCs are added
+	paused    bool            // Reconciliation is paused, so BMCs are neither added nor removed
+	refreshed time.Time       // Power states were last refreshed, zero before the first refresh
+
+	loops sync.WaitGroup // Reconcile loop, waited for at shutdown
+}
+
+// newServer creates the BMC of a target's VM on an IP and port
+func (f *fleet) newServer(t *target, vm *object.VirtualMachine, ip net.IP, port int, duplicate bool) (*ipmi.Server, error) {
+	server := ipmi.NewServer(vm, t.vsClient, ip, f.netmask, f.cfg.Server, f.limiter, f.ipdb)
+	server.SetKey(t.key(vm))
+	server.SetPort(port)
+	if f.cfg.DryRun.Enabled {
+		dryRun := netconfig.DryRun{Log: f.log.WithField("vm", vm.Name())}
+		server.SetConfigurator(dryRun)
+		server.SetProber(dryRun)
+	}
+	if f.prober != nil {
+		server.SetProber(f.prober)
+	}
+	if f.netcfg != nil {
+		server.SetConfigurator(f.netcfg)
+	}
+	server.SetCredentials(f.cfg.IPMI.CredentialsFor(t.cfg.Name, vm.Name()))
+	server.SetDuplicateUUID(duplicate)
+	if err := server.SetPrivilegeClients(t.privClients); err != nil {
+		return nil, fmt.Errorf("failed to set vSphere clients for privilege levels: %v", err)
+	}
+	return server, nil
+}
+
+// track records a started BMC and its vCenter. It must be called with
+// f.mu held.
+func (f *fleet) track(t *target, server *ipmi.Server) {
+	f.servers[server.Key()] = server
+	f.owners[server.Key()] = t
+}
+
+// targetOf returns the vCenter of a running BMC's VM
+func (f *fleet) targetOf(server *ipmi.Server) *target {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owners[server.Key()]
+}
+
+// list returns the running BMCs, ordered by IP and port
+func (f *fleet) list() []*ipmi.Server {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	servers := make([]*ipmi.Server, 0, len(f.servers))
+	for _, server := range f.servers {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if c := bytes.Compare(servers[i].IP().To16(), servers[j].IP().To16()); c != 0 {
+			return c < 0
+		}
+		return servers[i].Port() < servers[j].Port()
+	})
+	return servers
+}
+
+// activate activates the BMCs started in standby, and those added later
+func (f *fleet) activate(ctx context.Context) error {
+	f.mu.Lock()
+	f.activated = true
+	f.mu.Unlock()
+	return activate(ctx, f.log, f.list(), f.cfg.Server.SelfPing)
+}
+
+// cancelTask cancels the vCenter tasks running for the VMs with a BIOS
+// UUID, for the admin API. Bad clones can share a UUID, so every match is
+// cancelled.
+func (f *fleet) cancelTask(ctx context.Context, uuid string) error {
+	var found, cancelled bool
+	for _, server := range f.list() {
+		if server.UUID() == "" || !strings.EqualFold(server.UUID(), uuid) {
+			continue
+		}
+		found = true
+		err := server.CancelTask(ctx)
+		if errors.Is(err, vsphere.ErrNoTask) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		cancelled = true
+	}
+	if !found {
+		return admin.ErrBMCNotFound
+	}
+	if !cancelled {
+		return fmt.Errorf("%w: %s", admin.ErrNoTask, uuid)
+	}
+	return nil
+}
+
+// rotateCredentials replaces the IPMI credentials of the BMC of the VM
+// with a BIOS UUID, for the admin API. A UUID shared by several VMs is
+// refused rather than changing every match, and other BMCs and their
+// sessions are left alone. The change lasts until the BMC is recreated,
+// e.g. by a restart, which applies ipmi.vm_credentials again.
+func (f *fleet) rotateCredentials(uuid string, change admin.CredentialChange) error {
+	var matches []*ipmi.Server
+	for _, server := range f.list() {
+		if server.UUID() != "" && strings.EqualFold(server.UUID(), uuid) {
+			matches = append(matches, server)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return admin.ErrBMCNotFound
+	case len(matches) > 1:
+		return fmt.Errorf("%w: %s", admin.ErrDuplicateUUID, uuid)
+	}
+	if err := f.cfg.CheckCredentials(change.User, change.Password); err != nil {
+		return fmt.Errorf("%w: %v", admin.ErrInvalidCredentials, err)
+	}
+
+	server := matches[0]
+	closed := server.RotateCredentials(change.User, change.Password, change.Sessions == admin.SessionsInvalidate)
+	f.log.Infof("Changed IPMI credentials of VM %s, closing %d sessions", server.Key(), closed)
+	return nil
+}
+
+// invalidateCache drops the data cached for the VMs with a BIOS UUID, or
+// for every VM when uuid is empty, for the admin API. Bad clones can share
+// a UUID, so every match is cleared.
+func (f *fleet) invalidateCache(uuid string) error {
+	if uuid == "" {
+		for _, t := range f.targets {
+			t.vsClient.InvalidateCache("")
+		}
+		for _, server := range f.list() {
+			server.InvalidateCache()
+		}
+		f.log.Info("Dropped the cached data of every VM")
+		return nil
+	}
+
+	found := false
+	for _, server := range f.list() {
+		if server.UUID() == "" || !strings.EqualFold(server.UUID(), uuid) {
+			continue
+		}
+		found = true
+		if t := f.targetOf(server); t != nil {
+			t.vsClient.InvalidateCache(vsphere.VMKey(server.VM()))
+		}
+		server.InvalidateCache()
+		f.log.Infof("Dropped the cached data of VM %s", server.Key())
+	}
+	if !found {
+		return admin.ErrBMCNotFound
+	}
+	return nil
+}
+
+// invalidateReconfigured drops the data cached for the managed VMs that
+// vCenter reports as reconfigured since the last check
+func (f *fleet) invalidateReconfigured(ctx context.Context) {
+	for t, vms := range f.managedVMs() {
+		reconfigured, err := t.vsClient.ReconfiguredVMs(ctx)
+		if err != nil {
+			f.log.Warnf("Failed to check for reconfigured VMs of vCenter %s: %v", t.label(), err)
+			continue
+		}
+		changed := make(map[string]bool, len(reconfigured))
+		for _, id := range reconfigured {
+			changed[id] = true
+		}
+		for _, vm := range vms {
+			if !changed[vsphere.VMKey(vm)] {
+				continue
+			}
+			f.mu.Lock()
+			server, ok := f.servers[t.key(vm)]
+			f.mu.Unlock()
+			if ok {
+				server.InvalidateCache()
+				f.log.Debugf("VM %s was reconfigured, dropped its cached data", t.key(vm))
+			}
+		}
+	}
+}
+
+// allocate returns the IP and port of the BMC of a target's VM, per the
+// allocation mode. IPs come from the target's sub-range, if it has one.
+func (f *fleet) allocate(t *target, vm *object.VirtualMachine) (net.IP, int, error) {
+	vmID := t.key(vm)
+	if f.cfg.Server.AllocationMode == config.AllocationPortPerVM {
+		port, err := f.allocatePort(vmID)
+		return config.ParseIP(f.cfg.Server.HostIP), port, err
+	}
+	ip, err := f.allocateIP(vmID, t.cfg.Name, vm.Name(), f.cfg.Server.RangeFor(t.cfg))
+	return ip, f.cfg.Server.IPMIPort, err
+}
+
+// allocateIP returns the IP of a VM of the named vCenter: its reserved IP
+// if it has one, else its recorded IP while that is still in ipRange,
+// neither excluded nor reserved and not used by another host, and otherwise
+// the first free address in ipRange that isn't any of those
+func (f *fleet) allocateIP(vmID, vcenter, name string, ipRange config.IPRange) (net.IP, error) {
+	reserved := f.cfg.Server.ReservedIPs()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assigned, exists, err := f.ipdb.GetIP(vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IP: %v", err)
+	}
+	if addr, ok := f.cfg.Server.ReservationFor(vcenter, name); ok {
+		ip := config.ParseIP(addr)
+		f.usedIPs[ip.String()] = true
+		if !exists || !config.ParseIP(assigned).Equal(ip) {
+			if err := f.ipdb.AssignIP(vmID, ip.String()); err != nil {
+				f.log.Errorf("Failed to save IP assignment for VM %s: %v", vmID, err)
+			}
+		}
+		f.markStatic(vmID, true)
+		return ip, nil
+	}
+	f.markStatic(vmID, false)
+	if exists && ipRange.Contains(config.ParseIP(assigned)) && !ipRange.Excludes(net.ParseIP(assigned)) &&
+		!reserved[config.ParseIP(assigned).String()] && !f.conflicts[config.ParseIP(assigned).String()] {
+		return config.ParseIP(assigned), nil
+	}
+	if exists {
+		f.log.Warnf("Previously assigned IP %s for VM %s is now outside its range, excluded, reserved or used by another host, assigning a new one", assigned, vmID)
+	}
+
+	ip := config.ParseIP(ipRange.Start)
+	end := config.ParseIP(ipRange.End)
+	for f.usedIPs[ip.String()] || ipRange.Excludes(ip) || reserved[ip.String()] || f.conflicts[ip.String()] {
+		if ip.Equal(end) {
+			return nil, fmt.Errorf("no more available IPs in range")
+		}
+		incrementIP(ip)
+	}
+	f.usedIPs[ip.String()] = true
+	if err := f.ipdb.AssignIP(vmID, ip.String()); err != nil {
+		f.log.Errorf("Failed to save IP assignment for VM %s: %v", vmID, err)
+	}
+	return ip, nil
+}
+
+// markStatic records whether a VM's lease is static, as it is while the VM
+// has a reserved IP, so a reserved VM that is gone for a while keeps its IP
+func (f *fleet) markStatic(vmID string, static bool) {
+	if current, err := f.ipdb.IsStatic(vmID); err == nil && current == static {
+		return
+	}
+	if err := f.ipdb.SetStatic(vmID, static); err != nil {
+		f.log.Errorf("Failed to save the lease of VM %s: %v", vmID, err)
+	}
+}
+
+// allocatePort returns the port of a VM, reusing its recorded port while it
+// is still in the range and otherwise taking the first free port
+func (f *fleet) allocatePort(vmID string) (int, error) {
+	portRange := f.cfg.Server.PortRange
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assigned, exists, err := f.ipdb.GetPort(vmID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get port: %v", err)
+	}
+	if exists && assigned >= portRange.Start && assigned <= portRange.End {
+		return assigned, nil
+	}
+
+	port := portRange.Start
+	for f.usedPorts[port] {
+		if port == portRange.End {
+			return 0, fmt.Errorf("no more available ports in range")
+		}
+		port++
+	}
+	f.usedPorts[port] = true
+	if err := f.ipdb.AssignPort(vmID, port); err != nil {
+		f.log.Errorf("Failed to save port assignment for VM %s: %v", vmID, err)
+	}
+	return port, nil
+}
+
+// release frees the IP or port of a VM whose BMC was removed, unless
+// leases keep it for the VM until they expire
+func (f *fleet) release(vmID string, ip net.IP, port int) {
+	if f.cfg.Server.IPLeaseSeconds > 0 {
+		return
+	}
+	if err := f.ipdb.RemoveVM(vmID); err != nil {
+		f.log.Errorf("Failed to free address of VM %s: %v", vmID, err)
+		return
+	}
+	f.mu.Lock()
+	if f.cfg.Server.AllocationMode == config.AllocationPortPerVM {
+		delete(f.usedPorts, port)
+	} else {
+		delete(f.usedIPs, ip.String())
+	}
+	f.mu.Unlock()
+}
+
+// add starts the BMC of a target's VM found by the reconcile loop
+func (f *fleet) add(ctx context.Context, t *target, vm *object.VirtualMachine, duplicate bool) error {
+	ip, port, err := f.allocate(t, vm)
+	if err != nil {
+		return err
+	}
+	return f.launch(ctx, t, vm, ip, port, duplicate)
+}
+
+// launch starts the BMC of a target's VM on its allocated address and
+// tracks it
+func (f *fleet) launch(ctx context.Context, t *target, vm *object.VirtualMachine, ip net.IP, port int, duplicate bool) error {
+	server, err := f.start(ctx, t, vm, ip, port, duplicate)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return server.Stop(ctx)
+	}
+	f.track(t, server)
+	activate := f.activated && f.cfg.Server.Startup == config.StartupStandby
+	f.mu.Unlock()
+
+	if activate {
+		if err := server.Activate(ctx); err != nil {
+			return fmt.Errorf("failed to activate: %v", err)
+		}
+	}
+	f.log.Infof("Started virtual BMC for VM %s on %s", vm.Name(), server.Addr())
+	return nil
+}
+
+// start creates and starts the BMC of a target's VM. When another host
+// answers for its IP, the IP is given up for good and the VM moved to the
+// next free one, unless the IP is reserved for it. The address is
+// released if the BMC can't start.
+func (f *fleet) start(ctx context.Context, t *target, vm *object.VirtualMachine, ip net.IP, port int, duplicate bool) (*ipmi.Server, error) {
+	vmID := t.key(vm)
+	for {
+		server, err := f.newServer(t, vm, ip, port, duplicate)
+		if err != nil {
+			f.release(vmID, ip, port)
+			return nil, err
+		}
+		err = server.Start(ctx)
+		if err == nil {
+			return server, nil
+		}
+		_ = server.Stop(ctx)
+		if _, reserved := f.cfg.Server.ReservationFor(t.cfg.Name, vm.Name()); reserved || !errors.Is(err, netconfig.ErrInUse) {
+			f.release(vmID, ip, port)
+			return nil, err
+		}
+
+		f.log.Warnf("IP %s of VM %s is used by another host, moving it to the next free IP", ip, vm.Name())
+		f.mu.Lock()
+		delete(f.usedIPs, ip.String())
+		f.conflicts[ip.String()] = true
+		f.mu.Unlock()
+		next, nextPort, err := f.allocate(t, vm)
+		if err != nil {
+			f.release(vmID, ip, port)
+			return nil, err
+		}
+		ip, port = next, nextPort
+	}
+}
+
+// remove stops the BMC of a VM that is gone and frees its address
+func (f *fleet) remove(vmID string) {
+	f.mu.Lock()
+	server, ok := f.servers[vmID]
+	delete(f.servers, vmID)
+	delete(f.owners, vmID)
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	f.log.Infof("VM %s is gone, stopping its virtual BMC on %s", server.VM().Name(), server.Addr())
+	metrics.ForgetVM(server.VM().Name())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(f.cfg.Server.StopTimeout)*time.Second)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		f.log.Errorf("Failed to stop BMC on %s: %v", server.Addr(), err)
+	}
+	f.release(vmID, server.IP(), server.Port())
+}
+
+// reconcile re-lists the VMs every interval until ctx is canceled, starting
+// BMCs for VMs that appeared and stopping those of VMs that are gone
+func (f *fleet) reconcile(ctx context.Context, interval time.Duration) {
+	f.loops.Add(1)
+	go func() {
+		defer f.loops.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.reconcileOnce(ctx)
+			}
+		}
+	}()
+}
+
+// pauseReconcile pauses or resumes reconciliation. While paused, the
+// running BMCs keep serving but none are added or removed, e.g. during
+// mass clones or migrations.
+func (f *fleet) pauseReconcile(paused bool) {
+	f.mu.Lock()
+	changed := f.paused != paused
+	f.paused = paused
+	f.mu.Unlock()
+
+	metrics.SetReconcilePaused(paused)
+	switch {
+	case !changed:
+	case paused:
+		f.log.Info("Reconciliation paused through the admin API")
+	default:
+		f.log.Info("Reconciliation resumed through the admin API")
+	}
+}
+
+// status reports the number of BMCs and whether reconciliation is paused
+func (f *fleet) status() admin.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return admin.Status{BMCs: len(f.servers), ReconcilePaused: f.paused}
+}
+
+// reconcileOnce brings the BMCs in line with the VMs currently listed.
+// Power state filtering and the max_vms cap only apply to new VMs, so a BMC
+// isn't lost when its VM is powered off. The BMCs of a vCenter that can't
+// be listed are left alone. While reconciliation is paused only the power
+// state metrics and the caches of reconfigured VMs are refreshed.
+func (f *fleet) reconcileOnce(ctx context.Context) {
+	f.mu.Lock()
+	paused := f.paused
+	f.mu.Unlock()
+	if paused {
+		f.log.Debug("Reconciliation is paused, not listing VMs")
+		f.invalidateReconfigured(ctx)
+		f.refreshPowerStates(ctx)
+		return
+	}
+
+	listed := make(map[string]bool)
+	unlisted := make(map[*target]bool)
+	var vms []targetVM
+	for _, t := range f.targets {
+		tvms, err := t.vsClient.GetVMs(ctx, t.cfg.Folder)
+		if err != nil {
+			f.log.Errorf("Failed to list VMs of vCenter %s, skipping its reconciliation: %v", t.label(), err)
+			unlisted[t] = true
+			continue
+		}
+		for _, vm := range tvms {
+			listed[t.key(vm)] = true
+			vms = append(vms, targetVM{vm: vm, target: t})
+		}
+	}
+
+	f.mu.Lock()
+	var gone []string
+	for vmID := range f.servers {
+		if !listed[vmID] && !unlisted[f.owners[vmID]] {
+			gone = append(gone, vmID)
+		}
+	}
+	var found []targetVM
+	for _, v := range vms {
+		if _, ok := f.servers[v.key()]; !ok {
+			found = append(found, v)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, vmID := range gone {
+		f.remove(vmID)
+	}
+	f.renewLeases()
+	f.invalidateReconfigured(ctx)
+	f.refreshPowerStates(ctx)
+	if len(found) == 0 {
+		metrics.SetUnmanagedVMs(0)
+		return
+	}
+
+	var selected []targetVM
+	for t, group := range byTarget(found) {
+		group, err := selectVMs(ctx, f.log, t.vsClient, t.cfg.PowerStateFilter, group)
+		if err != nil {
+			f.log.Errorf("Failed to filter new VMs of vCenter %s: %v", t.label(), err)
+			continue
+		}
+		for _, vm := range group {
+			selected = append(selected, targetVM{vm: vm, target: t})
+		}
+	}
+	sortTargetVMs(selected)
+	found = selected
+	if max := f.cfg.Server.MaxVMs; max > 0 {
+		room := max - len(f.list())
+		if room < 0 {
+			room = 0
+		}
+		unmanaged := 0
+		if len(found) > room {
+			f.log.Warnf("Found %d new VMs but server.max_vms is %d, not managing %d VMs", len(found), max, len(found)-room)
+			unmanaged = len(found) - room
+			found = found[:room]
+		}
+		metrics.SetUnmanagedVMs(unmanaged)
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	managed := make([]targetVM, 0, len(found))
+	for _, server := range f.list() {
+		if t := f.targetOf(server); t != nil {
+			managed = append(managed, targetVM{vm: server.VM(), target: t})
+		}
+	}
+	duplicates := duplicateKeys(ctx, f.log, append(managed, found...))
+	for _, v := range found {
+		if err := f.add(ctx, v.target, v.vm, duplicates[v.key()]); err != nil {
+			f.log.Errorf("Failed to start virtual BMC for new VM %s: %v", v.vm.Name(), err)
+		}
+	}
+	if f.cfg.Server.PowerOnDiscovered {
+		for t, group := range byTarget(found) {
+			powerOnDiscovered(ctx, f.log, t.vsClient, group, time.Duration(f.cfg.Server.PowerOnDelay)*time.Second)
+		}
+	}
+}
+
+// managedVMs returns the VMs of the running BMCs, grouped by vCenter
+func (f *fleet) managedVMs() map[*target][]*object.VirtualMachine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	groups := make(map[*target][]*object.VirtualMachine)
+	for vmID, server := range f.servers {
+		t := f.owners[vmID]
+		groups[t] = append(groups[t], server.VM())
+	}
+	return groups
+}
+
+// refreshPowerStates updates the power state metrics of the managed VMs
+// and records their states for the restore-previous policy. VMs found
+// powered on after the last refresh found them off have booted, so their
+// BMCs consume any one-time boot device.
+func (f *fleet) refreshPowerStates(ctx context.Context) {
+	f.mu.Lock()
+	previous := f.refreshed
+	f.refreshed = time.Now()
+	f.mu.Unlock()
+
+	for t, vms := range f.managedVMs() {
+		states, err := t.vsClient.GetPowerStates(ctx, vms)
+		if err != nil {
+			f.log.Warnf("Failed to refresh power state metrics of VMs of vCenter %s: %v", t.label(), err)
+			continue
+		}
+		keyed := make(map[string]string, len(vms))
+		for _, vm := range vms {
+			state := states[vsphere.VMKey(vm)]
+			metrics.SetPowerState(vm.Name(), state == "poweredOn")
+			if state != "" {
+				keyed[t.key(vm)] = state
+			}
+			if state == "poweredOn" && !previous.IsZero() {
+				f.observePowerOn(ctx, t.key(vm), previous)
+			}
+		}
+		if err := f.ipdb.SetPowerStates(keyed); err != nil {
+			f.log.Errorf("Failed to record VM power states: %v", err)
+		}
+	}
+}
+
+// observePowerOn tells the BMC of a VM found powered on that it booted, if
+// the refresh at offAt recorded the VM as powered off
+func (f *fleet) observePowerOn(ctx context.Context, vmID string, offAt time.Time) {
+	was, err := f.ipdb.GetPowerState(vmID)
+	if err != nil || was != "poweredOff" {
+		return
+	}
+	f.mu.Lock()
+	server, ok := f.servers[vmID]
+	f.mu.Unlock()
+	if ok {
+		server.ObservePowerOn(ctx, offAt)
+	}
+}
+
+// renewLeases renews the leases of the managed VMs and frees the addresses
+// of VMs whose leases expired, when leases are enabled
+func (f *fleet) renewLeases() {
+	if f.cfg.Server.IPLeaseSeconds <= 0 {
+		return
+	}
+	f.mu.Lock()
+	seen := make([]string, 0, len(f.servers))
+	for vmID := range f.servers {
+		seen = append(seen, vmID)
+	}
+	f.mu.Unlock()
+
+	if err := f.ipdb.MarkSeen(seen); err != nil {
+		f.log.Errorf("Failed to renew IP leases: %v", err)
+	}
+	expired, err := f.ipdb.PruneExpired(time.Duration(f.cfg.Server.IPLeaseSeconds) * time.Second)
+	if err != nil {
+		f.log.Errorf("Failed to prune expired IP leases: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+	f.log.Infof("Freed the addresses of %d VMs whose leases expired: %v", len(expired), expired)
+	usedIPs, err := f.ipdb.GetAssignedIPs()
+	if err != nil {
+		f.log.Errorf("Failed to get assigned IPs: %v", err)
+		return
+	}
+	usedPorts, err := f.ipdb.GetAssignedPorts()
+	if err != nil {
+		f.log.Errorf("Failed to get assigned ports: %v", err)
+		return
+	}
+	f.mu.Lock()
+	f.usedIPs = usedIPs
+	f.usedPorts = usedPorts
+	f.mu.Unlock()
+}
+
+// stop waits for the reconcile loop, whose context must be canceled, then
+// stops every BMC, waiting for their commands in flight until ctx is done.
+// It returns the number stopped and the IPs whose cleanup failed.
+func (f *fleet) stop(ctx context.Context) (int, []string) {
+	f.loops.Wait()
+
+	servers := f.list()
+	f.mu.Lock()
+	f.stopped = true
+	f.servers = make(map[string]*ipmi.Server)
+	f.owners = make(map[string]*target)
+	f.mu.Unlock()
+
+	var failedIPs []string
+	for _, server := range servers {
+		if err := server.Stop(ctx); err != nil {
+			f.log.Errorf("Failed to stop BMC on %s: %v", server.Addr(), err)
+			failedIPs = append(failedIPs, server.IP().String())
+		}
+	}
+	return len(servers) - len(failedIPs), failedIPs
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/admin"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/ipmi"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTestTarget starts a simulated vCenter with a host and vms powered-on
+// VMs, and returns a target logged in to it
+func newTestTarget(t *testing.T, vms int) *target {
+	t.Helper()
+	model := simulator.VPX()
+	model.Cluster = 0
+	model.Host = 1
+	model.Machine = vms
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	password, _ := server.URL.User.Password()
+	v := config.VCenterConfig{IP: server.URL.Host, User: server.URL.User.Username(), Password: password, Datacenter: "DC0"}
+	vsClient, err := vsphere.NewClient(context.Background(), v.IP, v.User, v.Password, v.Datacenter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &target{cfg: v, vsClient: vsClient}
+}
+
+// newTestFleet returns a fleet of dry-run BMCs for the VMs of targets,
+// listening on loopback addresses from 127.0.0.10. It manages no VMs until
+// reconciled.
+func newTestFleet(t *testing.T, targets ...*target) *fleet {
+	t.Helper()
+	cfg := config.NewConfig()
+	cfg.DryRun.Enabled = true
+	cfg.Server.NIC = "lo"
+	cfg.Server.IPRange = config.IPRange{Start: "127.0.0.10", End: "127.0.0.20"}
+	cfg.Server.IPMIPort = 0 // Any free port
+	cfg.Server.StopTimeout = 1
+
+	db, err := config.NewIPDB(filepath.Join(t.TempDir(), "ipdb.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fleet{
+		cfg:       cfg,
+		log:       testLogger(),
+		targets:   targets,
+		limiter:   ipmi.NewLimiter(cfg.Server.MaxInflightCommands),
+		ipdb:      db,
+		netmask:   net.IPv4(255, 0, 0, 0),
+		servers:   make(map[string]*ipmi.Server),
+		owners:    make(map[string]*target),
+		usedIPs:   make(map[string]bool),
+		usedPorts: make(map[int]bool),
+		conflicts: make(map[string]bool),
+	}
+	t.Cleanup(func() {
+		f.stop(context.Background())
+		db.Close()
+	})
+	return f
+}
+
+// targetVMList lists the VMs of a target
+func targetVMList(t *testing.T, vc *target) []*object.VirtualMachine {
+	t.Helper()
+	vms, err := vc.vsClient.GetVMs(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vms
+}
+
+// powerState returns the power state of a VM
+func powerState(t *testing.T, vc *target, vm *object.VirtualMachine) string {
+	t.Helper()
+	state, err := vc.vsClient.GetVMPowerState(context.Background(), vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestPowerOnDiscovered(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		vc := newTestTarget(t, 2)
+		f := newTestFleet(t, vc)
+		f.cfg.Server.PowerOnDiscovered = enabled
+
+		ctx := context.Background()
+		vm := targetVMList(t, vc)[0]
+		if err := vc.vsClient.PowerOffVM(ctx, vm); err != nil {
+			t.Fatal(err)
+		}
+
+		f.reconcileOnce(ctx)
+		if n := len(f.list()); n != 2 {
+			t.Fatalf("reconcile started %d BMCs, want 2", n)
+		}
+		want := "poweredOff"
+		if enabled {
+			want = "poweredOn"
+		}
+		if state := powerState(t, vc, vm); state != want {
+			t.Errorf("with power_on_discovered %v the discovered VM is %s, want %s", enabled, state, want)
+		}
+	}
+}
+
+func TestPowerOnDiscoveredWaitsBetweenPowerOns(t *testing.T) {
+	vc := newTestTarget(t, 3)
+	vms := targetVMList(t, vc)
+	ctx := context.Background()
+	for _, vm := range vms {
+		if err := vc.vsClient.PowerOffVM(ctx, vm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const delay = 100 * time.Millisecond
+	start := time.Now()
+	powerOnDiscovered(ctx, testLogger(), vc.vsClient, vms, delay)
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("powering on 3 VMs took %v, want at least %v", elapsed, 2*delay)
+	}
+	for _, vm := range vms {
+		if state := powerState(t, vc, vm); state != "poweredOn" {
+			t.Errorf("VM %s is %s, want poweredOn", vm.Name(), state)
+		}
+	}
+
+	// Cancelling stops the remaining power-ons
+	for _, vm := range vms {
+		if err := vc.vsClient.PowerOffVM(ctx, vm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancelCtx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(delay/2, cancel)
+	powerOnDiscovered(cancelCtx, testLogger(), vc.vsClient, vms, time.Hour)
+	on := 0
+	for _, vm := range vms {
+		if powerState(t, vc, vm) == "poweredOn" {
+			on++
+		}
+	}
+	if on != 1 {
+		t.Errorf("%d VMs powered on before the cancellation, want 1", on)
+	}
+}
+
+func TestAllocateIPSkipsExclusions(t *testing.T) {
+	f := newTestFleet(t)
+	ipRange := config.IPRange{Start: "127.0.0.10", End: "127.0.0.17", Exclude: []string{"127.0.0.10", "127.0.0.12-127.0.0.14"}}
+
+	var got []string
+	for _, vm := range []string{"vm-1", "vm-2", "vm-3", "vm-4"} {
+		ip, err := f.allocateIP(vm, "", vm, ipRange)
+		if err != nil {
+			t.Fatalf("allocating an IP for %s: %v", vm, err)
+		}
+		got = append(got, ip.String())
+	}
+	if want := []string{"127.0.0.11", "127.0.0.15", "127.0.0.16", "127.0.0.17"}; !slices.Equal(got, want) {
+		t.Errorf("allocated %v, want %v", got, want)
+	}
+
+	if ip, err := f.allocateIP("vm-5", "", "vm-5", ipRange); err == nil {
+		t.Errorf("allocated %s once only excluded addresses were left", ip)
+	}
+}
+
+func TestAllocateIPReplacesNewlyExcluded(t *testing.T) {
+	f := newTestFleet(t)
+	if err := f.ipdb.AssignIP("vm-1", "127.0.0.10"); err != nil {
+		t.Fatal(err)
+	}
+	ipRange := config.IPRange{Start: "127.0.0.10", End: "127.0.0.20", Exclude: []string{"127.0.0.10"}}
+
+	ip, err := f.allocateIP("vm-1", "", "vm-1", ipRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "127.0.0.11" {
+		t.Errorf("VM whose IP is now excluded got %s, want 127.0.0.11", ip)
+	}
+	if stored, _, _ := f.ipdb.GetIP("vm-1"); stored != "127.0.0.11" {
+		t.Errorf("IP database still records %s", stored)
+	}
+}
+
+func TestAllocateIPReservationOfOneVCenter(t *testing.T) {
+	f := newTestFleet(t)
+	f.cfg.Server.IPReservations = map[string]string{"lab-east/db-01": "127.0.0.15"}
+	ipRange := config.IPRange{Start: "127.0.0.10", End: "127.0.0.20"}
+
+	ip, err := f.allocateIP("lab-west/db-01", "lab-west", "db-01", ipRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() == "127.0.0.15" {
+		t.Errorf("lab-west db-01 got the address reserved for lab-east db-01")
+	}
+	if ip, err = f.allocateIP("lab-east/db-01", "lab-east", "db-01", ipRange); err != nil || ip.String() != "127.0.0.15" {
+		t.Errorf("lab-east db-01 got %s (%v), want its reserved 127.0.0.15", ip, err)
+	}
+}
+
+func TestAllocatePortExhaustion(t *testing.T) {
+	f := newTestFleet(t)
+	f.cfg.Server.AllocationMode = config.AllocationPortPerVM
+	f.cfg.Server.PortRange = config.PortRange{Start: 6230, End: 6231}
+
+	var got []int
+	for _, vm := range []string{"vm-1", "vm-2"} {
+		port, err := f.allocatePort(vm)
+		if err != nil {
+			t.Fatalf("allocating a port for %s: %v", vm, err)
+		}
+		got = append(got, port)
+	}
+	if want := []int{6230, 6231}; !slices.Equal(got, want) {
+		t.Errorf("allocated %v, want %v", got, want)
+	}
+	if port, err := f.allocatePort("vm-3"); err == nil {
+		t.Errorf("allocated port %d beyond the end of the range", port)
+	}
+
+	// Freeing a port makes room again
+	f.release("vm-1", nil, 6230)
+	if port, err := f.allocatePort("vm-3"); err != nil || port != 6230 {
+		t.Errorf("after a port was freed, allocated %d (%v), want 6230", port, err)
+	}
+}
+
+func TestReservedLeaseIsStatic(t *testing.T) {
+	f := newTestFleet(t)
+	f.cfg.Server.IPReservations = map[string]string{"db-01": "127.0.0.15"}
+	ipRange := config.IPRange{Start: "127.0.0.10", End: "127.0.0.20"}
+
+	if _, err := f.allocateIP("vm-1", "", "db-01", ipRange); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.allocateIP("vm-2", "", "web-01", ipRange); err != nil {
+		t.Fatal(err)
+	}
+	if static, _ := f.ipdb.IsStatic("vm-1"); !static {
+		t.Error("lease of the VM with a reserved IP isn't static, so it can expire")
+	}
+	if static, _ := f.ipdb.IsStatic("vm-2"); static {
+		t.Error("lease of a VM without a reservation is static")
+	}
+
+	// Dropping the reservation lets the lease expire again
+	f.cfg.Server.IPReservations = nil
+	if _, err := f.allocateIP("vm-1", "", "db-01", ipRange); err != nil {
+		t.Fatal(err)
+	}
+	if static, _ := f.ipdb.IsStatic("vm-1"); static {
+		t.Error("lease still static after the reservation was dropped")
+	}
+}
+
+// occupiedNetwork is a network where some addresses are taken by other hosts
+type occupiedNetwork map[string]bool
+
+// Probe reports whether another host holds ip
+func (n occupiedNetwork) Probe(nic string, ip net.IP, timeout time.Duration) (bool, error) {
+	return n[ip.String()], nil
+}
+
+func TestOccupiedIPsAreSkipped(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	f.prober = occupiedNetwork{"127.0.0.10": true, "127.0.0.12": true}
+	vms := targetVMList(t, vc)
+	if err := f.ipdb.AssignIP(vc.key(vms[1]), "127.0.0.12"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.reconcileOnce(context.Background())
+	var got []string
+	for _, server := range f.list() {
+		got = append(got, server.IP().String())
+	}
+	if want := []string{"127.0.0.11", "127.0.0.13"}; !slices.Equal(got, want) {
+		t.Errorf("BMCs started on %v, want the free addresses %v", got, want)
+	}
+	if ip, _, _ := f.ipdb.GetIP(vc.key(vms[1])); ip == "127.0.0.12" {
+		t.Error("IP database still records the occupied address")
+	}
+}
+
+// stuckNetwork is a NIC some addresses can be added to but not removed from
+type stuckNetwork map[string]bool
+
+// AddAddress accepts every address
+func (n stuckNetwork) AddAddress(nic string, addr *net.IPNet) error {
+	return nil
+}
+
+// RemoveAddress fails for the stuck addresses
+func (n stuckNetwork) RemoveAddress(nic string, addr *net.IPNet) error {
+	if n[addr.IP.String()] {
+		return errors.New("operation not permitted")
+	}
+	return nil
+}
+
+func TestStopReportsFailedCleanup(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	f.netcfg = stuckNetwork{"127.0.0.11": true}
+	f.reconcileOnce(context.Background())
+	if n := len(f.list()); n != 2 {
+		t.Fatalf("%d BMCs started, want 2", n)
+	}
+
+	stopped, failedIPs := f.stop(context.Background())
+	if stopped != 1 || !slices.Equal(failedIPs, []string{"127.0.0.11"}) {
+		t.Errorf("stop reported %d stopped and failures on %v, want 1 and [127.0.0.11]", stopped, failedIPs)
+	}
+}
+
+func TestInventoryListsPowerStates(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	vms := targetVMList(t, vc)
+	ctx := context.Background()
+	if err := vc.vsClient.PowerOffVM(ctx, vms[1]); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFleet(t, vc)
+	f.reconcileOnce(ctx)
+
+	inv := &bmcInventory{bmcs: f, log: testLogger()}
+	bmcs := inv.List(ctx)
+	if len(bmcs) != 2 {
+		t.Fatalf("listed %d BMCs, want 2", len(bmcs))
+	}
+	want := map[string]string{vms[0].Name(): "poweredOn", vms[1].Name(): "poweredOff"}
+	for _, bmc := range bmcs {
+		if bmc.PowerState != want[bmc.VM] || bmc.IP == "" || bmc.MoRef == "" {
+			t.Errorf("listed %+v, want VM %s %s with its address", bmc, bmc.VM, want[bmc.VM])
+		}
+	}
+	if err := healthCheck([]*target{vc})(ctx); err != nil {
+		t.Errorf("health check of a connected vCenter failed: %v", err)
+	}
+}
+
+func TestRotateCredentials(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	f.reconcileOnce(context.Background())
+	uuid := bmcOf(f, targetVMList(t, vc)[0]).UUID()
+
+	change := admin.CredentialChange{User: "ops", Password: "rotated", Sessions: admin.SessionsInvalidate}
+	if err := f.rotateCredentials(strings.ToUpper(uuid), change); err != nil {
+		t.Errorf("rotating the credentials of %s: %v", uuid, err)
+	}
+	if err := f.rotateCredentials("4211c2e4-0000-0000-0000-000000000000", change); !errors.Is(err, admin.ErrBMCNotFound) {
+		t.Errorf("rotating an unknown UUID gave %v, want ErrBMCNotFound", err)
+	}
+	change.User = "a-user-name-too-long"
+	if err := f.rotateCredentials(uuid, change); !errors.Is(err, admin.ErrInvalidCredentials) {
+		t.Errorf("rotating to a 20 character user name gave %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestPowerOnFromVCenterConsumesOneTimeBoot(t *testing.T) {
+	vc := newTestTarget(t, 1)
+	f := newTestFleet(t, vc)
+	ctx := context.Background()
+	vm := targetVMList(t, vc)[0]
+	if err := vc.vsClient.PowerOffVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := vc.vsClient.SetNextBoot(ctx, vm, vsphere.BootDeviceCDROM, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ipdb.SetOneTimeBoot(vc.key(vm), true); err != nil {
+		t.Fatal(err)
+	}
+
+	f.reconcileOnce(ctx) // Starts the BMC with the one-time override
+	f.reconcileOnce(ctx) // Records the VM off
+	if err := vc.vsClient.PowerOnVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	f.reconcileOnce(ctx)
+	if device, err := vc.vsClient.GetNextBoot(ctx, vm); err != nil || device != vsphere.BootDeviceNone {
+		t.Errorf("next boot after a power-on from vCenter is %v (%v), want the one-time device cleared", device, err)
+	}
+}
+
+// freeUDPPort returns a UDP port that was free on loopback
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// logsIn reports whether a session can be opened to a BMC with a user and
+// password
+func logsIn(t *testing.T, server *ipmi.Server, user, password string) bool {
+	t.Helper()
+	client, err := goipmi.NewClient(&goipmi.Connection{
+		Hostname:  server.IP().String(),
+		Port:      server.Port(),
+		Username:  user,
+		Password:  password,
+		Interface: "lan",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		return false
+	}
+	_ = client.Close()
+	return true
+}
+
+func TestVMAcceptsOnlyItsCredentials(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	f.cfg.Server.IPMIPort = freeUDPPort(t)
+	f.cfg.IPMI.VMCredentials = map[string]config.Credentials{"db01": {User: "dbadmin", Password: "db-secret"}}
+	ctx := context.Background()
+	vms := targetVMList(t, vc)
+	task, err := vms[0].Rename(ctx, "db01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f.reconcileOnce(ctx)
+	db01, other := bmcOf(f, vms[0]), bmcOf(f, vms[1])
+	if db01 == nil || other == nil {
+		t.Fatalf("reconcile started %d BMCs, want both VMs' BMCs", len(f.list()))
+	}
+	if !logsIn(t, db01, "dbadmin", "db-secret") {
+		t.Error("db01 rejects its configured credentials")
+	}
+	if logsIn(t, db01, "admin", "password") {
+		t.Error("db01 accepts the default credentials")
+	}
+	if logsIn(t, other, "dbadmin", "db-secret") {
+		t.Error("another VM accepts the credentials of db01")
+	}
+	if !logsIn(t, other, "admin", "password") {
+		t.Error("another VM rejects the default credentials")
+	}
+}
+
+// destroyVM powers off and deletes a VM from its vCenter
+func destroyVM(t *testing.T, vc *target, vm *object.VirtualMachine) {
+	t.Helper()
+	ctx := context.Background()
+	if err := vc.vsClient.PowerOffVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	task, err := vm.Destroy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPausedReconcileIgnoresInventoryChanges(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	ctx := context.Background()
+
+	f.reconcileOnce(ctx)
+	if n := len(f.list()); n != 2 {
+		t.Fatalf("reconcile started %d BMCs, want 2", n)
+	}
+
+	f.pauseReconcile(true)
+	destroyVM(t, vc, targetVMList(t, vc)[0])
+	f.reconcileOnce(ctx)
+	if st := f.status(); st.BMCs != 2 || !st.ReconcilePaused {
+		t.Fatalf("status while paused is %+v, want 2 BMCs and paused", st)
+	}
+
+	f.pauseReconcile(false)
+	f.reconcileOnce(ctx)
+	if st := f.status(); st.BMCs != 1 || st.ReconcilePaused {
+		t.Errorf("status after resuming is %+v, want the deleted VM's BMC removed", st)
+	}
+}
+
+// bmcOf returns the BMC of a VM, or nil if it has none
+func bmcOf(f *fleet, vm *object.VirtualMachine) *ipmi.Server {
+	for _, server := range f.list() {
+		if server.VM().Reference() == vm.Reference() {
+			return server
+		}
+	}
+	return nil
+}
+
+func TestRenamedVMKeepsIP(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	ctx := context.Background()
+	vm := targetVMList(t, vc)[1]
+
+	f.reconcileOnce(ctx)
+	before := bmcOf(f, vm)
+	if before == nil {
+		t.Fatal("reconcile started no BMC for the VM")
+	}
+
+	task, err := vm.Rename(ctx, "renamed-vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.reconcileOnce(ctx)
+
+	after := bmcOf(f, vm)
+	if after == nil {
+		t.Fatal("renamed VM lost its BMC")
+	}
+	if !after.IP().Equal(before.IP()) {
+		t.Errorf("renamed VM's BMC moved from %s to %s", before.IP(), after.IP())
+	}
+	if ip, _, _ := f.ipdb.GetIP(vc.key(vm)); ip != before.IP().String() {
+		t.Errorf("IP database records %q for the renamed VM, want %s", ip, before.IP())
+	}
+}
+
+// cloneVM clones a VM of a target under a new name and returns the clone
+func cloneVM(t *testing.T, vc *target, vm *object.VirtualMachine, name string) *object.VirtualMachine {
+	t.Helper()
+	ctx := context.Background()
+	folder, err := find.NewFinder(vm.Client()).Folder(ctx, "/DC0/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := vm.Clone(ctx, folder, name, types.VirtualMachineCloneSpec{PowerOn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := task.WaitForResult(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return object.NewVirtualMachine(vm.Client(), info.Result.(types.ManagedObjectReference))
+}
+
+func TestReconcileFollowsInventory(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	ctx := context.Background()
+	vms := targetVMList(t, vc)
+
+	f.reconcileOnce(ctx)
+	if n := len(f.list()); n != 2 {
+		t.Fatalf("reconcile started %d BMCs, want 2", n)
+	}
+
+	clone := cloneVM(t, vc, vms[0], "new-vm")
+	f.reconcileOnce(ctx)
+	if n := len(f.list()); n != 3 {
+		t.Fatalf("%d BMCs after a VM was created, want 3", n)
+	}
+	if bmcOf(f, clone) == nil {
+		t.Fatal("new VM got no BMC")
+	}
+
+	gone := bmcOf(f, vms[0])
+	destroyVM(t, vc, vms[0])
+	destroyVM(t, vc, clone)
+	f.reconcileOnce(ctx)
+	if n := len(f.list()); n != 1 {
+		t.Fatalf("%d BMCs after two VMs were deleted, want 1", n)
+	}
+	if bmcOf(f, vms[1]) == nil {
+		t.Error("remaining VM lost its BMC")
+	}
+	if _, ok, _ := f.ipdb.GetIP(vc.key(vms[0])); ok {
+		t.Errorf("deleted VM still holds %s in the IP database", gone.IP())
+	}
+}
@@ -0,0 +1,87 @@
+// Package fru encodes IPMI Field Replaceable Unit (FRU) inventory data
+package fru
+
+// Fields of the common header and info areas
+const (
+	formatVersion  = 0x01
+	areaUnit       = 8    // Area offsets and lengths count 8-byte multiples
+	languageCode   = 0x00 // English
+	fieldASCII     = 0xc0 // 8-bit ASCII, in the type/length byte
+	maxField       = 63   // Field length is 6 bits
+	endOfFields    = 0xc1
+	headerSize     = 8
+	unspecifiedMfg = 0 // Manufacturing date/time
+)
+
+// Inventory is the data a FRU describes
+type Inventory struct {
+	Manufacturer string
+	ProductName  string
+	SerialNumber string
+	AssetTag     string
+}
+
+// Encode returns the FRU image: the common header followed by a board
+// info area and a product info area. Fields longer than 63 bytes are
+// truncated.
+func Encode(inv Inventory) []byte {
+	board := area(
+		[]byte{formatVersion, 0, languageCode, unspecifiedMfg, unspecifiedMfg, unspecifiedMfg},
+		inv.Manufacturer, inv.ProductName, inv.SerialNumber,
+		"", // Part number
+		"", // FRU file ID
+	)
+	product := area(
+		[]byte{formatVersion, 0, languageCode},
+		inv.Manufacturer, inv.ProductName,
+		"", // Part/model number
+		"", // Version
+		inv.SerialNumber, inv.AssetTag,
+		"", // FRU file ID
+	)
+
+	header := []byte{
+		formatVersion,
+		0, // Internal use area
+		0, // Chassis info area
+		headerSize / areaUnit,
+		uint8((headerSize + len(board)) / areaUnit),
+		0, // Multi-record area
+		0, // Pad
+		0,
+	}
+	header[7] = checksum(header[:7])
+
+	image := append(header, board...)
+	return append(image, product...)
+}
+
+// area encodes an info area from its fixed fields and its type/length
+// fields, padding it to a multiple of 8 bytes and setting its length and
+// checksum
+func area(fixed []byte, fields ...string) []byte {
+	buf := append([]byte{}, fixed...)
+	for _, f := range fields {
+		if len(f) > maxField {
+			f = f[:maxField]
+		}
+		buf = append(buf, fieldASCII|uint8(len(f)))
+		buf = append(buf, f...)
+	}
+	buf = append(buf, endOfFields)
+
+	size := (len(buf) + 1 + areaUnit - 1) / areaUnit * areaUnit // With the checksum
+	buf = append(buf, make([]byte, size-len(buf))...)
+	buf[1] = uint8(size / areaUnit)
+	buf[size-1] = checksum(buf[:size-1])
+	return buf
+}
+
+// checksum is the zero checksum of an area, making its bytes sum to zero
+func checksum(b []byte) uint8 {
+	var sum uint8
+	for _, x := range b {
+		sum += x
+	}
+	return -sum
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/admin"
+	"github.com/vbmc-vsphere/ipmi"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// bmcInventory serves the admin API's BMC lookups from the started servers
+type bmcInventory struct {
+	bmcs *fleet
+	log  *logrus.Logger
+}
+
+// describe returns the admin API's description of a server, without its
+// VM's power state and tags
+func describe(t *target, server *ipmi.Server) *admin.BMC {
+	bmc := &admin.BMC{
+		VM:            server.VM().Name(),
+		MoRef:         vsphere.VMKey(server.VM()),
+		UUID:          server.UUID(),
+		IP:            server.IP().String(),
+		Port:          server.Port(),
+		DuplicateUUID: server.DuplicateUUID(),
+	}
+	if t != nil {
+		bmc.VCenter = t.cfg.Name
+	}
+	if hw := server.Hardware(); hw != nil {
+		bmc.NumCPU = hw.NumCPU
+		bmc.MemoryMB = hw.MemoryMB
+	}
+	return bmc
+}
+
+// BMCs finds the servers of the VMs with the given BIOS UUID. Tags are
+// left out when they can't be read.
+func (inv *bmcInventory) BMCs(ctx context.Context, uuid string) []*admin.BMC {
+	var bmcs []*admin.BMC
+	for _, server := range inv.bmcs.list() {
+		if server.UUID() == "" || !strings.EqualFold(server.UUID(), uuid) {
+			continue
+		}
+
+		t := inv.bmcs.targetOf(server)
+		bmc := describe(t, server)
+		if t == nil {
+			bmcs = append(bmcs, bmc) // Removed meanwhile
+			continue
+		}
+		tags, err := t.vsClient.GetVMTags(ctx, server.VM())
+		switch {
+		case errors.Is(err, vsphere.ErrTagsUnavailable):
+			inv.log.Debugf("Not reporting tags of VM %s: %v", bmc.VM, err)
+		case err != nil:
+			inv.log.Warnf("Failed to get tags of VM %s: %v", bmc.VM, err)
+		default:
+			bmc.Tags = tags
+		}
+		bmcs = append(bmcs, bmc)
+	}
+	return bmcs
+}
+
+// List returns every BMC with its VM's power state, read in one call per
+// vCenter. Power states are left out when they can't be read.
+func (inv *bmcInventory) List(ctx context.Context) []*admin.BMC {
+	states := make(map[string]string)
+	for t, vms := range inv.bmcs.managedVMs() {
+		if t == nil {
+			continue
+		}
+		tstates, err := t.vsClient.GetPowerStates(ctx, vms)
+		if err != nil {
+			inv.log.Warnf("Failed to get power states for BMC list: %v", err)
+			continue
+		}
+		for _, vm := range vms {
+			states[t.key(vm)] = tstates[vsphere.VMKey(vm)]
+		}
+	}
+
+	servers := inv.bmcs.list()
+	bmcs := make([]*admin.BMC, len(servers))
+	for i, server := range servers {
+		bmcs[i] = describe(inv.bmcs.targetOf(server), server)
+		bmcs[i].PowerState = states[server.Key()]
+	}
+	return bmcs
+}
@@ -0,0 +1,106 @@
+package ipmi
+
+import (
+	goipmi "github.com/ooneko/goipmi"
+)
+
+const (
+	channelCurrent        = 0x0e // Channel number meaning the one the request arrived on
+	channelLAN            = 0x01 // Channel number reported for the LAN channel
+	payloadTypeIPMI       = 0x00
+	cipherSuiteRecordSize = 16   // Record data bytes returned per list index
+	cipherSuiteMaxIndex   = 0x3f // List index is 6 bits
+	cipherSuiteStandard   = 0xc0 // Start of a standard cipher suite record
+	cipherSuiteIntegrity  = 0x40 // Tag of an integrity algorithm
+	cipherSuiteConfident  = 0x80 // Tag of a confidentiality algorithm
+)
+
+// cipherSuite is a supported cipher suite and its algorithm numbers
+type cipherSuite struct {
+	id              uint8
+	auth            uint8
+	integrity       uint8
+	confidentiality uint8
+}
+
+// cipherSuites are the suites reported to clients: 0 (none), 1
+// (RAKP-HMAC-SHA1) and 3 (RAKP-HMAC-SHA1, HMAC-SHA1-96, AES-CBC-128)
+var cipherSuites = []cipherSuite{
+	{id: 0, auth: 0x00, integrity: 0x00, confidentiality: 0x00},
+	{id: 1, auth: 0x01, integrity: 0x00, confidentiality: 0x00},
+	{id: 3, auth: 0x01, integrity: 0x01, confidentiality: 0x01},
+}
+
+// cipherSuitesResponse is the Get Channel Cipher Suites response
+type cipherSuitesResponse struct {
+	goipmi.CompletionCode
+	Channel uint8
+	Data    []byte
+}
+
+// MarshalBinary encodes the response
+func (r *cipherSuitesResponse) MarshalBinary() ([]byte, error) {
+	return append([]byte{byte(r.CompletionCode), r.Channel}, r.Data...), nil
+}
+
+// cipherSuiteRecords encodes every supported suite as a standard cipher
+// suite record, or just the distinct algorithm numbers when bySuite is false
+func cipherSuiteRecords(bySuite bool) []byte {
+	var data []byte
+	seen := make(map[byte]bool)
+	for _, suite := range cipherSuites {
+		algorithms := []byte{
+			suite.auth,
+			cipherSuiteIntegrity | suite.integrity,
+			cipherSuiteConfident | suite.confidentiality,
+		}
+		if bySuite {
+			data = append(data, cipherSuiteStandard, suite.id)
+			data = append(data, algorithms...)
+			continue
+		}
+		for _, alg := range algorithms {
+			if !seen[alg] {
+				seen[alg] = true
+				data = append(data, alg)
+			}
+		}
+	}
+	return data
+}
+
+// handleGetChannelCipherSuites handles IPMI get channel cipher suites
+// commands. Records are returned 16 bytes per list index; a shorter
+// response marks the last page.
+func (s *Server) handleGetChannelCipherSuites(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 3 {
+		return goipmi.ErrShortPacket
+	}
+
+	channel := m.Data[0] & 0x0f
+	if channel != channelCurrent && channel != channelLAN {
+		return goipmi.ErrParamRange
+	}
+	if m.Data[1] != payloadTypeIPMI {
+		return goipmi.ErrParamRange
+	}
+
+	bySuite := m.Data[2]&0x80 != 0
+	index := int(m.Data[2] & cipherSuiteMaxIndex)
+
+	records := cipherSuiteRecords(bySuite)
+	start := index * cipherSuiteRecordSize
+	if start > len(records) {
+		start = len(records)
+	}
+	end := start + cipherSuiteRecordSize
+	if end > len(records) {
+		end = len(records)
+	}
+
+	return &cipherSuitesResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		Channel:        channelLAN,
+		Data:           records[start:end],
+	}
+}
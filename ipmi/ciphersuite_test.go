@@ -0,0 +1,75 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// readCipherSuites pages through Get Channel Cipher Suites outside a
+// session, as clients do before choosing a suite, and returns the records
+func readCipherSuites(t *testing.T, s *Server, bySuite bool) []byte {
+	t.Helper()
+	var records []byte
+	for index := uint8(0); index <= cipherSuiteMaxIndex; index++ {
+		selector := index
+		if bySuite {
+			selector |= 0x80
+		}
+		resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), CommandGetChannelCipherSuites, channelCurrent, payloadTypeIPMI, selector)
+		if len(resp) < 2 || resp[0] != uint8(goipmi.CommandCompleted) || resp[1] != channelLAN {
+			t.Fatalf("cipher suites page %d is % x", index, resp)
+		}
+		records = append(records, resp[2:]...)
+		if len(resp[2:]) < cipherSuiteRecordSize {
+			return records
+		}
+	}
+	t.Fatal("cipher suite list has no last page")
+	return nil
+}
+
+func TestCipherSuiteRecordsDecode(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	startTestServer(t, s)
+
+	// Decode each standard record: start byte, suite ID, then the tagged
+	// authentication, integrity and confidentiality algorithms
+	records := readCipherSuites(t, s, true)
+	var decoded []cipherSuite
+	for len(records) > 0 {
+		if len(records) < 5 || records[0] != cipherSuiteStandard {
+			t.Fatalf("bad cipher suite record at % x", records)
+		}
+		if records[3]&0xc0 != cipherSuiteIntegrity || records[4]&0xc0 != cipherSuiteConfident {
+			t.Fatalf("algorithm tags of suite %d are % x", records[1], records[2:5])
+		}
+		decoded = append(decoded, cipherSuite{
+			id:              records[1],
+			auth:            records[2],
+			integrity:       records[3] &^ cipherSuiteIntegrity,
+			confidentiality: records[4] &^ cipherSuiteConfident,
+		})
+		records = records[5:]
+	}
+	want := []cipherSuite{
+		{id: 0, auth: 0x00, integrity: 0x00, confidentiality: 0x00},
+		{id: 1, auth: 0x01, integrity: 0x00, confidentiality: 0x00},
+		{id: 3, auth: 0x01, integrity: 0x01, confidentiality: 0x01},
+	}
+	if len(decoded) != len(want) {
+		t.Fatalf("decoded suites %+v, want %+v", decoded, want)
+	}
+	for i := range want {
+		if decoded[i] != want[i] {
+			t.Errorf("suite %d decodes as %+v, want %+v", i, decoded[i], want[i])
+		}
+	}
+
+	// Listed by algorithm, each tagged algorithm appears once
+	algorithms := readCipherSuites(t, s, false)
+	if want := []byte{0x00, 0x40, 0x80, 0x01, 0x41, 0x81}; !bytes.Equal(algorithms, want) {
+		t.Errorf("algorithms are % x, want % x", algorithms, want)
+	}
+}
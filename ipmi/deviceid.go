@@ -0,0 +1,92 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// Bits of the additional device support field, which clients check before
+// reading the SDR repository or the SEL
+const (
+	deviceSupportSensor = 0x01
+	deviceSupportSDR    = 0x02
+	deviceSupportSEL    = 0x04
+)
+
+// deviceIDResponse is the Get Device ID response. The simulator's own
+// response has no auxiliary firmware revision field.
+type deviceIDResponse struct {
+	goipmi.CompletionCode
+	ID  config.DeviceIDConfig
+	Aux []byte // Auxiliary firmware revision, omitted when empty
+}
+
+// MarshalBinary encodes the response
+func (r *deviceIDResponse) MarshalBinary() ([]byte, error) {
+	data := []byte{
+		byte(r.CompletionCode),
+		uint8(r.ID.DeviceID),
+		uint8(r.ID.DeviceRevision),
+		uint8(r.ID.FirmwareMajor),
+		uint8(r.ID.FirmwareMinor/10<<4 | r.ID.FirmwareMinor%10), // BCD
+		0x51, // IPMI version 1.5
+		deviceSupportSensor | deviceSupportSDR | deviceSupportSEL,
+		uint8(r.ID.ManufacturerID), uint8(r.ID.ManufacturerID >> 8), uint8(r.ID.ManufacturerID >> 16),
+		uint8(r.ID.ProductID), uint8(r.ID.ProductID >> 8),
+	}
+	return append(data, r.Aux...), nil
+}
+
+// Hardware returns the VM's sizing as last read by Get Device ID, or nil
+// until a client has asked for it
+func (s *Server) Hardware() *vsphere.VMHardware {
+	return s.hardware.Load()
+}
+
+// InvalidateCache drops the VM's cached sizing, so the next Get Device ID
+// reads it from vCenter again, e.g. after the VM was reconfigured out of
+// band
+func (s *Server) InvalidateCache() {
+	s.hardware.Store(nil)
+}
+
+// handleGetDeviceID handles IPMI get device ID commands. The identity
+// fields come from server.device_id. The auxiliary
+// firmware revision carries the VM's vCPU count and memory in GiB, each
+// as a 16-bit little-endian value. Clients send it as a session keepalive,
+// so it always succeeds, omitting the sizing when it can't be read.
+func (s *Server) handleGetDeviceID(m *goipmi.Message) goipmi.Response {
+	s.log.Debug("Getting device ID")
+
+	// Hardware sizing is read once; VMs whose config can't be read are
+	// retried on the next request
+	hw := s.hardware.Load()
+	if hw == nil {
+		if !s.limiter.Acquire() {
+			s.log.Debug("Too many commands in flight, omitting VM hardware from device ID")
+			return &deviceIDResponse{CompletionCode: goipmi.CommandCompleted, ID: s.cfg.DeviceID}
+		}
+		var err error
+		hw, err = s.vsClient.GetVMHardware(context.Background(), s.vm)
+		s.limiter.Release()
+		if err != nil {
+			s.log.Warnf("Failed to get VM hardware, omitting it from device ID: %v", err)
+			return &deviceIDResponse{CompletionCode: goipmi.CommandCompleted, ID: s.cfg.DeviceID}
+		}
+		s.hardware.Store(hw)
+	}
+
+	aux := make([]byte, 4)
+	binary.LittleEndian.PutUint16(aux[0:2], uint16(hw.NumCPU))
+	binary.LittleEndian.PutUint16(aux[2:4], uint16((hw.MemoryMB+1023)/1024))
+
+	return &deviceIDResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		ID:             s.cfg.DeviceID,
+		Aux:            aux,
+	}
+}
@@ -0,0 +1,75 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+func TestDeviceIDCarriesVMSizing(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", Hardware: vsphere.VMHardware{NumCPU: 260, MemoryMB: 6 * 1024}})
+	startTestServer(t, s)
+
+	resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+	if len(resp) != 16 {
+		t.Fatalf("got %d bytes of device ID, want 16 with the auxiliary firmware revision: % x", len(resp), resp)
+	}
+	aux := resp[12:]
+	if cpus := binary.LittleEndian.Uint16(aux[0:2]); cpus != 260 {
+		t.Errorf("vCPU count is %d, want 260", cpus)
+	}
+	if gib := binary.LittleEndian.Uint16(aux[2:4]); gib != 6 {
+		t.Errorf("memory is %d GiB, want 6", gib)
+	}
+	if hw := s.Hardware(); hw == nil || hw.NumCPU != 260 {
+		t.Errorf("cached hardware is %+v, want 260 vCPUs", hw)
+	}
+}
+
+func TestDeviceIDRoundsMemoryUp(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", Hardware: vsphere.VMHardware{NumCPU: 2, MemoryMB: 1536}})
+	startTestServer(t, s)
+
+	resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+	if len(resp) != 16 {
+		t.Fatalf("got %d bytes of device ID, want 16: % x", len(resp), resp)
+	}
+	if gib := binary.LittleEndian.Uint16(resp[14:16]); gib != 2 {
+		t.Errorf("1536 MB reported as %d GiB, want 2", gib)
+	}
+}
+
+func TestDeviceIDOmitsUnreadableSizing(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	startTestServer(t, s)
+	vc.SetError("GetVMHardware", vsphere.ErrUnreachable)
+
+	resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+	if len(resp) != 12 || resp[0] != uint8(goipmi.CommandCompleted) {
+		t.Errorf("device ID with vCenter down is % x, want 12 bytes and success", resp)
+	}
+}
+
+func TestInvalidateCacheRereadsSizing(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", Hardware: vsphere.VMHardware{NumCPU: 2, MemoryMB: 2048}})
+	startTestServer(t, s)
+	sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+
+	// Resized out of band, the cached sizing is reported until invalidated
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", Hardware: vsphere.VMHardware{NumCPU: 8, MemoryMB: 2048}})
+	resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+	if cpus := binary.LittleEndian.Uint16(resp[12:14]); cpus != 2 {
+		t.Errorf("vCPU count is %d before invalidating, want the cached 2", cpus)
+	}
+	s.InvalidateCache()
+	resp = sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetDeviceID))
+	if cpus := binary.LittleEndian.Uint16(resp[12:14]); cpus != 8 {
+		t.Errorf("vCPU count is %d after invalidating, want 8", cpus)
+	}
+}
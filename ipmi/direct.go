@@ -0,0 +1,161 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/metrics"
+)
+
+// Network functions the simulator can't route
+const (
+	NetworkFunctionSensor  = 0x04
+	NetworkFunctionStorage = 0x0a
+)
+
+// directTimeout bounds the vCenter calls behind a direct handler, so a slow
+// vCenter can't keep the handlers of a listener piling up
+const directTimeout = 10 * time.Second
+
+// request is an IPMI v1.5 request answered by the listeners instead of the
+// simulator. The simulator only routes the App and Chassis network
+// functions and answers any other with invalid command.
+type request struct {
+	AuthType  uint8
+	Sequence  uint32
+	SessionID uint32
+	AuthCode  [16]byte
+	NetFn     uint8
+	Command   uint8
+	Data      []byte
+	signed    []byte // Responder address to payload checksum, covered by the auth code
+}
+
+// directHandler handles a request, returning the completion code followed
+// by the response data
+type directHandler func(r *request) []byte
+
+// answerFunc claims a packet to answer in the listener instead of relaying
+// it to the simulator, see Server.answer. respond builds the response, nil
+// to drop the packet. It may call vCenter, so the UDP listener runs it off
+// its read loop.
+type answerFunc func(buf []byte) (respond func() []byte, ok bool)
+
+// directKey identifies the handler of a request
+type directKey struct {
+	netfn   uint8
+	command uint8
+}
+
+// handleDirect registers a handler the listeners call for a command in a
+// network function the simulator can't route. Handlers run for
+// authenticated requests only.
+func (s *Server) handleDirect(netfn, command uint8, handler directHandler) {
+	s.direct[directKey{netfn, command}] = handler
+}
+
+// limitDirect wraps a vCenter-backed direct handler so it is rejected with
+// NodeBusy while the shared limiter is saturated, as limit does for the
+// simulator's handlers
+func (s *Server) limitDirect(handler directHandler) directHandler {
+	return func(r *request) []byte {
+		if !s.limiter.Acquire() {
+			s.log.Warnf("Too many commands in flight, rejecting command 0x%02x", r.Command)
+			return []byte{uint8(goipmi.ErrNodeBusy)}
+		}
+		defer s.limiter.Release()
+		return handler(r)
+	}
+}
+
+// answer returns the func building the response to a packet with a direct
+// handler. It returns false for packets to relay to the simulator, and a
+// nil func for packets to drop, as the simulator does with packets failing
+// their checksums. The packet is copied, so buf can be reused.
+func (s *Server) answer(buf []byte) (func() []byte, bool) {
+	if len(s.direct) == 0 || buf[3] != rmcpClassIPMI {
+		return nil, false
+	}
+
+	// checkPacket has already validated the lengths
+	r := &request{
+		AuthType:  buf[rmcpHeaderSize],
+		Sequence:  binary.LittleEndian.Uint32(buf[rmcpHeaderSize+1:]),
+		SessionID: binary.LittleEndian.Uint32(buf[rmcpHeaderSize+5:]),
+	}
+	offset := rmcpHeaderSize + sessionHeaderSize
+	if r.AuthType != AuthTypeNone {
+		copy(r.AuthCode[:], buf[offset:])
+		offset += authCodeSize
+	}
+	header := buf[offset : offset+ipmiHeaderSize]
+	r.NetFn = header[2] >> 2
+	r.Command = header[6]
+	handler, ok := s.direct[directKey{r.NetFn, r.Command}]
+	if !ok {
+		return nil, false
+	}
+	buf = append([]byte{}, buf...)
+	header = buf[offset : offset+ipmiHeaderSize]
+
+	msgLen := int(header[0])
+	r.Data = buf[offset+ipmiHeaderSize : offset+msgLen]
+	r.signed = buf[offset+1 : offset+1+msgLen]
+	if checksum(header[1], header[2]) != header[3] || checksum(r.signed[3:len(r.signed)-1]...) != r.signed[len(r.signed)-1] {
+		return nil, true
+	}
+
+	return func() []byte {
+		data := s.runDirect(r, handler)
+
+		// Like the simulator, echo the request's headers around the response
+		resp := append([]byte{}, buf[:offset+ipmiHeaderSize]...)
+		resp[offset] = uint8(ipmiHeaderSize + len(data))
+		resp = append(resp, data...)
+		return append(resp, checksum(append(header[4:7:7], data...)...))
+	}, true
+}
+
+// runDirect runs a direct handler for an authenticated request, recovering
+// from panics and tracking it in flight as guard does for the simulator's
+// handlers
+func (s *Server) runDirect(r *request, handler directHandler) (data []byte) {
+	if !s.inflight.begin() {
+		return []byte{uint8(goipmi.ErrNodeBusy)}
+	}
+	defer s.inflight.end()
+	defer func() {
+		if p := recover(); p != nil {
+			s.log.Warnf("Recovered from panic handling command 0x%02x: %v", r.Command, p)
+			countMalformed(malformedHandlerPanic)
+			data = []byte{uint8(goipmi.ErrUnspecified)}
+		}
+	}()
+
+	if !checkAuthCode(s.sessionPassword(r.SessionID), r.AuthType, r.AuthCode, r.SessionID, r.Sequence, r.signed) {
+		s.log.Warnf("Rejecting unauthenticated command 0x%02x", r.Command)
+		return []byte{uint8(goipmi.ErrPrivLevel)}
+	}
+	if s.privilege(r.SessionID) == PrivLevelNone {
+		s.log.Warnf("Rejecting command 0x%02x from unknown session 0x%08x", r.Command, r.SessionID)
+		return []byte{uint8(goipmi.ErrPrivLevel)}
+	}
+	s.touchSession(r.SessionID)
+	metrics.CountCommand(r.NetFn, r.Command)
+
+	data = handler(r)
+	if data[0] == uint8(goipmi.ErrShortPacket) {
+		countMalformed(malformedData)
+	}
+	return data
+}
+
+// checksum is the two's complement checksum of an IPMI message
+func checksum(b ...uint8) uint8 {
+	var sum uint8
+	for _, x := range b {
+		sum += x
+	}
+	return -sum
+}
@@ -0,0 +1,73 @@
+package ipmi
+
+import (
+	"context"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/syslog"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// factoryResetConfirm must be written to the factory reset parameter, so
+// a stray write of zero doesn't reset the BMC
+const factoryResetConfirm = 0x01
+
+// handleFactoryReset returns the BMC to its configured baseline: the VM's
+// boot override is cleared, identify is turned off, the configured user
+// name and password are restored and the user enabled, partial parameter
+// writes are discarded and every tracked session except the caller's is
+// forgotten. The VM's power state and asset tag are left alone.
+func (s *Server) handleFactoryReset(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 2 {
+		return goipmi.ErrShortPacket
+	}
+	if m.Data[1] != factoryResetConfirm {
+		return goipmi.ErrParamRange
+	}
+	if s.privilege(m.SessionID) < PrivLevelAdmin {
+		s.log.Warn("Rejecting factory reset from a session below Administrator")
+		return goipmi.ErrPrivLevel
+	}
+
+	if err := s.factoryReset(context.Background(), s.client(m), m.SessionID); err != nil {
+		s.log.Errorf("Factory reset failed: %v", err)
+		return s.errorCode(err)
+	}
+	return goipmi.CommandCompleted
+}
+
+// factoryReset clears the BMC's state, keeping the session it was
+// requested from
+func (s *Server) factoryReset(ctx context.Context, vc vsphere.VMClient, sessionID uint32) error {
+	if err := vc.ClearBootOrder(ctx, s.vm); err != nil {
+		return err
+	}
+	s.setOneTimeBoot(false)
+
+	// Turn identify off, ending any interval still running
+	s.identifyMu.Lock()
+	s.identifySeq++
+	s.identifyState = 0
+	s.identifyMu.Unlock()
+	if err := s.setIdentifyAnnotation(ctx, vc, false); err != nil {
+		return err
+	}
+
+	s.assetTagWriter.reset()
+
+	s.sessionMu.Lock()
+	s.user, s.password = s.configUser, s.configPassword
+	s.userDisabled = false
+	cleared := 0
+	for id := range s.sessions {
+		if id != sessionID {
+			delete(s.sessions, id)
+			cleared++
+		}
+	}
+	s.sessionMu.Unlock()
+
+	s.log.WithField(syslog.EventField, "factory_reset").
+		Warnf("BMC factory reset: boot override cleared, identify off, credentials restored, %d other sessions closed", cleared)
+	return nil
+}
@@ -0,0 +1,63 @@
+package ipmi
+
+import (
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+func TestFactoryResetClearsEachState(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	s.cfg.IdentifyAnnotation = true
+	client := startTestServer(t, s)
+	other := newTestClient(t, s, "password")
+	if err := other.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Move every piece of state away from the baseline
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatal(err)
+	}
+	identify(t, client, 0, identifyForceOn)
+	if _, ok, code := s.assetTagWriter.write([]byte{0, systemInfoEncodingUTF8, 40, 'r', 'a', 'c', 'k'}); ok || code != goipmi.CommandCompleted {
+		t.Fatalf("partial asset tag write completed %v with 0x%02x", ok, code)
+	}
+	s.RotateCredentials("ops", "rotated", false)
+	if err := setUserPassword(client, configuredUserID, passwordDisable, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandSetSystemInfoParameters, SystemInfoParamFactoryReset, factoryResetConfirm); err != nil {
+		t.Fatalf("factory reset: %v", err)
+	}
+
+	if order := vc.VM(s.vm).BootOrder; len(order) != 0 {
+		t.Errorf("boot order is %v after a factory reset, want cleared", order)
+	}
+	if oneTime, err := s.db.GetOneTimeBoot(s.key); err != nil || oneTime {
+		t.Errorf("one-time boot still recorded (%v) after a factory reset", err)
+	}
+	if resp, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus)); err != nil || resp[2]&(identifyTimed|identifyIndefinite) != 0 {
+		t.Errorf("chassis status % x (%v) after a factory reset, want identify off", resp, err)
+	}
+	if notes := vc.VM(s.vm).Annotation; notes != "vBMC identify: off" {
+		t.Errorf("VM notes are %q after a factory reset", notes)
+	}
+	if _, _, code := s.assetTagWriter.write([]byte{1, 'x'}); code != goipmi.ErrInvalidState {
+		t.Errorf("continuing the partial asset tag write returned 0x%02x, want it discarded", code)
+	}
+	if canLogIn(t, s, "ops", "rotated") {
+		t.Error("rotated credentials still accepted after a factory reset")
+	}
+	if !canLogIn(t, s, "admin", "password") {
+		t.Error("configured credentials refused after a factory reset")
+	}
+	if _, err := send(other, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus)); err != goipmi.ErrPrivLevel {
+		t.Errorf("other session's chassis status returned %v after a factory reset, want it closed", err)
+	}
+	if _, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus)); err != nil {
+		t.Errorf("resetting session closed by the factory reset: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/fru"
+)
+
+// IPMI FRU inventory commands, in the Storage network function
+const (
+	CommandGetFRUInventoryAreaInfo = 0x10
+	CommandReadFRUData             = 0x11
+)
+
+// fruManufacturer is reported as the board and product manufacturer
+const fruManufacturer = "VMware"
+
+// maxFRURead bounds the bytes returned by one Read FRU Data command
+const maxFRURead = 64
+
+// handleGetFRUInventoryAreaInfo handles IPMI get FRU inventory area info
+// commands. The FRU is built from the VM's identity here, as clients ask
+// for the area size before reading it, and reads are served from it until
+// the next request, so a rename can't change it halfway through a read.
+func (s *Server) handleGetFRUInventoryAreaInfo(r *request) []byte {
+	if len(r.Data) < 1 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	if r.Data[0] != 0 {
+		return []byte{CompletionCodeDataNotPresent} // Only the BMC's own FRU
+	}
+
+	image, err := s.buildFRU()
+	if err != nil {
+		s.log.Errorf("Failed to get VM identity: %v", err)
+		return []byte{uint8(s.errorCode(err))}
+	}
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0, 0} // Accessed by bytes
+	binary.LittleEndian.PutUint16(resp[1:], uint16(len(image)))
+	return resp
+}
+
+// handleReadFRUData handles IPMI read FRU data commands
+func (s *Server) handleReadFRUData(r *request) []byte {
+	if len(r.Data) < 4 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	if r.Data[0] != 0 {
+		return []byte{CompletionCodeDataNotPresent}
+	}
+	offset := int(binary.LittleEndian.Uint16(r.Data[1:]))
+	count := min(int(r.Data[3]), maxFRURead)
+
+	s.fruMu.Lock()
+	image := s.fru
+	s.fruMu.Unlock()
+	if image == nil {
+		var err error
+		if image, err = s.buildFRU(); err != nil {
+			s.log.Errorf("Failed to get VM identity: %v", err)
+			return []byte{uint8(s.errorCode(err))}
+		}
+	}
+
+	if offset >= len(image) {
+		return []byte{uint8(goipmi.ErrParamRange)}
+	}
+	count = min(count, len(image)-offset)
+	resp := []byte{uint8(goipmi.CommandCompleted), uint8(count)}
+	return append(resp, image[offset:offset+count]...)
+}
+
+// buildFRU encodes the FRU from the VM's current identity and keeps it for
+// reads. The asset tag is the one set over IPMI, or the VM's managed
+// object reference when none was.
+func (s *Server) buildFRU() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), directTimeout)
+	defer cancel()
+	id, err := s.vsClient.GetVMIdentity(ctx, s.vm)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := s.db.GetAssetTag(s.key)
+	if err != nil || tag == "" {
+		tag = id.MoRef
+	}
+
+	image := fru.Encode(fru.Inventory{
+		Manufacturer: fruManufacturer,
+		ProductName:  id.Name,
+		SerialNumber: id.UUID,
+		AssetTag:     tag,
+	})
+	s.fruMu.Lock()
+	s.fru = image
+	s.fruMu.Unlock()
+	return image, nil
+}
@@ -0,0 +1,120 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vbmc-vsphere/vsphere/mock"
+	"github.com/vmware/govmomi/object"
+)
+
+// readFRU reads a server's whole FRU image, in chunks as ipmitool does
+func readFRU(t *testing.T, client *goipmi.Client) []byte {
+	t.Helper()
+	info, err := send(client, NetworkFunctionStorage, CommandGetFRUInventoryAreaInfo, 0)
+	if err != nil {
+		t.Fatalf("getting the FRU area info: %v", err)
+	}
+	size := int(binary.LittleEndian.Uint16(info))
+
+	var image []byte
+	for len(image) < size {
+		offset := uint16(len(image))
+		data, err := send(client, NetworkFunctionStorage, CommandReadFRUData, 0, uint8(offset), uint8(offset>>8), 32)
+		if err != nil {
+			t.Fatalf("reading FRU data at %d: %v", offset, err)
+		}
+		if int(data[0]) != len(data)-1 || data[0] == 0 {
+			t.Fatalf("read at %d returned count %d with %d bytes", offset, data[0], len(data)-1)
+		}
+		image = append(image, data[1:]...)
+	}
+	if len(image) != size {
+		t.Fatalf("read %d bytes of a %d byte FRU", len(image), size)
+	}
+	return image
+}
+
+// fruArea checks the checksum of the FRU area at the offset given in
+// header byte n and returns its type/length fields
+func fruArea(t *testing.T, image []byte, n int, fixed int) []string {
+	t.Helper()
+	start := int(image[n]) * 8
+	if start == 0 || start+2 > len(image) {
+		t.Fatalf("header byte %d points at %d in a %d byte FRU", n, start, len(image))
+	}
+	area := image[start : start+int(image[start+1])*8]
+	var sum uint8
+	for _, b := range area {
+		sum += b
+	}
+	if sum != 0 {
+		t.Errorf("area at %d has a bad checksum: % x", start, area)
+	}
+
+	var fields []string
+	for i := fixed; area[i] != 0xc1; {
+		length := int(area[i] & 0x3f)
+		fields = append(fields, string(area[i+1:i+1+length]))
+		i += 1 + length
+	}
+	return fields
+}
+
+func TestFRUParsesBack(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{Name: "web-01", UUID: "42a1b2c3-0000-1111-2222-333344445555"})
+	client := startTestServer(t, s)
+
+	image := readFRU(t, client)
+	var sum uint8
+	for _, b := range image[:8] {
+		sum += b
+	}
+	if image[0] != 0x01 || sum != 0 {
+		t.Fatalf("bad common header % x", image[:8])
+	}
+
+	board := fruArea(t, image, 3, 6)
+	if want := []string{"VMware", "web-01", "42a1b2c3-0000-1111-2222-333344445555", "", ""}; !slices.Equal(board, want) {
+		t.Errorf("board area fields are %q, want %q", board, want)
+	}
+	product := fruArea(t, image, 4, 3)
+	want := []string{"VMware", "web-01", "", "", "42a1b2c3-0000-1111-2222-333344445555", vsphere.VMKey(s.vm), ""}
+	if !slices.Equal(product, want) {
+		t.Errorf("product area fields are %q, want %q", product, want)
+	}
+}
+
+// deadlineClient is a fake vSphere client recording whether the last
+// identity read was bounded by a deadline
+type deadlineClient struct {
+	*mock.Client
+	bounded atomic.Bool
+}
+
+// GetVMIdentity records whether ctx has a deadline
+func (c *deadlineClient) GetVMIdentity(ctx context.Context, vm *object.VirtualMachine) (*vsphere.VMIdentity, error) {
+	_, ok := ctx.Deadline()
+	c.bounded.Store(ok)
+	return c.Client.GetVMIdentity(ctx, vm)
+}
+
+func TestFRUIdentityReadIsBounded(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	dc := &deadlineClient{Client: vc}
+	s.vsClient = dc
+	client := startTestServer(t, s)
+
+	if _, err := send(client, NetworkFunctionStorage, CommandGetFRUInventoryAreaInfo, 0); err != nil {
+		t.Fatalf("getting the FRU area info: %v", err)
+	}
+	if !dc.bounded.Load() {
+		t.Error("FRU identity read from vCenter without a deadline")
+	}
+}
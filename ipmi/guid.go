@@ -0,0 +1,66 @@
+package ipmi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// guidResponse is the Get Device GUID and Get System GUID response
+type guidResponse struct {
+	goipmi.CompletionCode
+	GUID [16]byte
+}
+
+// MarshalBinary encodes the response
+func (r *guidResponse) MarshalBinary() ([]byte, error) {
+	return append([]byte{byte(r.CompletionCode)}, r.GUID[:]...), nil
+}
+
+// vmGUID derives the BMC's GUID from the VM's instance UUID, falling back
+// to its BIOS UUID. The GUID is encoded least significant byte first, as
+// the spec requires. When another VM shares the instance UUID, the GUID is
+// hashed from the managed object ID instead so the two BMCs differ.
+func (s *Server) vmGUID(ctx context.Context, biosUUID string) [16]byte {
+	id, err := s.vsClient.GetInstanceUUID(ctx, s.vm)
+	if err != nil || id == "" {
+		if err != nil {
+			s.log.Warnf("Failed to get instance UUID, deriving GUID from BIOS UUID: %v", err)
+		}
+		id = biosUUID
+	}
+
+	var guid [16]byte
+	raw, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+	if err != nil || len(raw) != len(guid) || s.duplicateUUID {
+		// Not a UUID, e.g. the managed object ID fallback, or not unique;
+		// hash the managed object ID instead
+		sum := sha256.Sum256([]byte(s.vm.Reference().Value))
+		raw = sum[:len(guid)]
+	}
+	for i := range guid {
+		guid[i] = raw[len(raw)-1-i]
+	}
+	return guid
+}
+
+// SetDuplicateUUID marks the VM as sharing its instance UUID with another
+// VM, e.g. after a bad clone. It must be called before Start.
+func (s *Server) SetDuplicateUUID(duplicate bool) {
+	s.duplicateUUID = duplicate
+}
+
+// DuplicateUUID reports whether the VM shares its instance UUID
+func (s *Server) DuplicateUUID() bool {
+	return s.duplicateUUID
+}
+
+// handleGetGUID handles IPMI get device GUID and get system GUID commands.
+// Both report the same GUID, so clients see one identity whether they ask
+// before or after opening a session.
+func (s *Server) handleGetGUID(m *goipmi.Message) goipmi.Response {
+	return &guidResponse{CompletionCode: goipmi.CommandCompleted, GUID: s.guid}
+}
@@ -0,0 +1,42 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+func TestGUIDSameBeforeAndAfterSession(t *testing.T) {
+	// The instance UUID, least significant byte first
+	want := []byte{0xff, 0xee, 0xdd, 0xcc, 0xbb, 0xaa, 0x99, 0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x42}
+	for _, tc := range []struct {
+		name         string
+		instanceUUID string
+		biosUUID     string
+	}{
+		{"instance UUID", "42112233-4455-6677-8899-aabbccddeeff", "00000000-0000-0000-0000-000000000001"},
+		{"BIOS UUID fallback", "", "42112233-4455-6677-8899-aabbccddeeff"},
+	} {
+		s, vc, _ := newTestServer(t)
+		vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", InstanceUUID: tc.instanceUUID, UUID: tc.biosUUID})
+		client := startTestServer(t, s)
+
+		// Clients ask for the system GUID while setting up a session
+		setup := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), CommandGetSystemGUID)
+		if len(setup) != 17 || setup[0] != uint8(goipmi.CommandCompleted) {
+			t.Fatalf("%s: Get System GUID outside a session returned % x", tc.name, setup)
+		}
+		device, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandGetDeviceGUID)
+		if err != nil {
+			t.Fatalf("%s: Get Device GUID: %v", tc.name, err)
+		}
+		if !bytes.Equal(setup[1:], device) {
+			t.Errorf("%s: GUID is % x during session setup but % x in the session", tc.name, setup[1:], device)
+		}
+		if !bytes.Equal(device, want) {
+			t.Errorf("%s: GUID is % x, want % x", tc.name, device, want)
+		}
+	}
+}
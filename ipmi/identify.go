@@ -0,0 +1,104 @@
+package ipmi
+
+import (
+	"context"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// CommandChassisIdentify is the IPMI chassis identify command
+const CommandChassisIdentify = 0x04
+
+// defaultIdentifyInterval is how long identify lasts when the request has
+// no interval, per the spec
+const defaultIdentifyInterval = 15 * time.Second
+
+// identifyForceOn is the bit of the second request byte that keeps
+// identify on until it is turned off
+const identifyForceOn = 0x01
+
+// handleChassisIdentify handles IPMI chassis identify commands. A VM has no
+// LED to blink, so identify is logged and, when enabled, marked in the VM's
+// notes until the interval elapses or identify is turned off.
+func (s *Server) handleChassisIdentify(m *goipmi.Message) goipmi.Response {
+	interval := defaultIdentifyInterval
+	if len(m.Data) >= 1 {
+		interval = time.Duration(m.Data[0]) * time.Second
+	}
+	force := len(m.Data) >= 2 && m.Data[1]&identifyForceOn != 0
+	on := force || interval > 0
+
+	s.identifyMu.Lock()
+	s.identifySeq++
+	seq := s.identifySeq
+	switch {
+	case force:
+		s.identifyState = identifyIndefinite
+	case on:
+		s.identifyState = identifyTimed
+	default:
+		s.identifyState = 0
+	}
+	s.identifyMu.Unlock()
+
+	switch {
+	case force:
+		s.log.Infof("Identify on for VM %s until turned off", s.vm.Name())
+	case on:
+		s.log.Infof("Identify on for VM %s for %s", s.vm.Name(), interval)
+	default:
+		s.log.Infof("Identify off for VM %s", s.vm.Name())
+	}
+
+	if err := s.setIdentifyAnnotation(context.Background(), s.client(m), on); err != nil {
+		s.log.Errorf("Failed to mark identify in VM notes: %v", err)
+		return s.errorCode(err)
+	}
+
+	if on && !force {
+		go s.identifyOff(seq, interval)
+	}
+	return goipmi.CommandCompleted
+}
+
+// identifyOff turns identify off once the interval elapses, unless another
+// identify command arrived in the meantime
+func (s *Server) identifyOff(seq uint64, interval time.Duration) {
+	<-s.clock.After(interval)
+
+	s.identifyMu.Lock()
+	current := seq == s.identifySeq
+	if current {
+		s.identifyState = 0
+	}
+	s.identifyMu.Unlock()
+	if !current {
+		return
+	}
+
+	s.log.Infof("Identify interval elapsed for VM %s", s.vm.Name())
+	if err := s.setIdentifyAnnotation(context.Background(), s.vsClient, false); err != nil {
+		s.log.Errorf("Failed to clear identify from VM notes: %v", err)
+	}
+}
+
+// setIdentifyAnnotation updates the VM's notes when identify_annotation is
+// enabled and the identify state changes
+func (s *Server) setIdentifyAnnotation(ctx context.Context, vc vsphere.VMClient, on bool) error {
+	if !s.cfg.IdentifyAnnotation {
+		return nil
+	}
+
+	s.identifyMu.Lock()
+	defer s.identifyMu.Unlock()
+	if s.identifying == on {
+		return nil
+	}
+	if err := vc.SetIdentifyAnnotation(ctx, s.vm, on); err != nil {
+		return err
+	}
+	s.identifying = on
+	return nil
+}
@@ -0,0 +1,54 @@
+package ipmi
+
+import (
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// identify sends Chassis Identify with the given request bytes
+func identify(t *testing.T, client *goipmi.Client, data ...byte) {
+	t.Helper()
+	if _, err := send(client, uint8(goipmi.NetworkFunctionChassis), CommandChassisIdentify, data...); err != nil {
+		t.Fatalf("Chassis Identify % x: %v", data, err)
+	}
+}
+
+func TestIdentifyOnThenOffAnnotatesTwice(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	s.cfg.IdentifyAnnotation = true
+	client := startTestServer(t, s)
+
+	identify(t, client, 0, identifyForceOn)
+	if notes := vc.VM(s.vm).Annotation; notes != "vBMC identify: on" {
+		t.Errorf("VM notes are %q after identify on", notes)
+	}
+	identify(t, client, 0, 0)
+	if notes := vc.VM(s.vm).Annotation; notes != "vBMC identify: off" {
+		t.Errorf("VM notes are %q after identify off", notes)
+	}
+
+	if n := called(vc, "SetIdentifyAnnotation"); n != 2 {
+		t.Errorf("VM notes updated %d times, want 2", n)
+	}
+}
+
+func TestIdentifyIntervalElapses(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	s.cfg.IdentifyAnnotation = true
+	client := startTestServer(t, s)
+
+	identify(t, client, 5)
+	resp, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus))
+	if err != nil || resp[2]&identifyTimed == 0 {
+		t.Errorf("chassis status % x (%v) during identify, want it timed", resp, err)
+	}
+
+	waitFor(t, "the identify interval", func() bool { return fake.Waiters() == 1 })
+	fake.Advance(5 * time.Second)
+	waitFor(t, "identify to turn off", func() bool { return vc.VM(s.vm).Annotation == "vBMC identify: off" })
+	if n := called(vc, "SetIdentifyAnnotation"); n != 2 {
+		t.Errorf("VM notes updated %d times, want 2", n)
+	}
+}
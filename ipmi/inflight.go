@@ -0,0 +1,63 @@
+package ipmi
+
+import (
+	"context"
+	"sync"
+)
+
+// inflight counts the commands a server is handling, so stopping it can
+// wait for them before the address they answer from goes away
+type inflight struct {
+	mu       sync.Mutex
+	count    int
+	draining bool          // Stop has started, so no more commands begin
+	idle     chan struct{} // Closed once count drops to zero while draining
+}
+
+// begin registers a command, returning false once the server is stopping
+func (f *inflight) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.count++
+	return true
+}
+
+// end unregisters a command registered by begin
+func (f *inflight) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count--
+	if f.count == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+// drain stops new commands from beginning and waits for those in flight to
+// end or ctx to be done. It returns how many were still in flight and
+// whether there were any to wait for.
+func (f *inflight) drain(ctx context.Context) (int, bool) {
+	f.mu.Lock()
+	f.draining = true
+	if f.count == 0 {
+		f.mu.Unlock()
+		return 0, false
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return 0, true
+	case <-ctx.Done():
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.count, true
+	}
+}
@@ -0,0 +1,55 @@
+package ipmi
+
+import "sync/atomic"
+
+// Limiter bounds the number of vCenter-backed IPMI commands in flight across
+// all servers. Commands arriving while the limiter is saturated are rejected
+// immediately instead of queueing behind a slow vCenter.
+type Limiter struct {
+	slots    chan struct{}
+	rejected atomic.Uint64
+}
+
+// NewLimiter creates a limiter allowing max concurrent commands
+func NewLimiter(max int) *Limiter {
+	return &Limiter{
+		slots: make(chan struct{}, max),
+	}
+}
+
+// Acquire reserves a slot, returning false if none is free
+func (l *Limiter) Acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		l.rejected.Add(1)
+		return false
+	}
+}
+
+// Release frees a slot reserved by Acquire
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// Depth returns the number of commands currently in flight
+func (l *Limiter) Depth() int {
+	return len(l.slots)
+}
+
+// Capacity returns the maximum number of commands in flight
+func (l *Limiter) Capacity() int {
+	return cap(l.slots)
+}
+
+// Rejected returns the number of commands rejected because the limiter was full
+func (l *Limiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
@@ -0,0 +1,177 @@
+package ipmi
+
+import (
+	"bytes"
+	"sync"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/metrics"
+)
+
+// Coarse reasons malformed requests are counted under
+const (
+	malformedShort        = "short"         // Shorter than its headers
+	malformedVersion      = "bad_version"   // Not RMCP version 1.0
+	malformedClass        = "bad_class"     // Neither ASF nor IPMI
+	malformedLength       = "bad_length"    // IPMI message length inconsistent with the packet
+	malformedData         = "short_data"    // Command data too short for the command
+	malformedHandlerPanic = "handler_panic" // A handler panicked on the request
+)
+
+// Header sizes of the RMCP, IPMI v1.5 session and IPMI message headers
+const (
+	rmcpHeaderSize    = 4
+	sessionHeaderSize = 9
+	authCodeSize      = 16
+	ipmiHeaderSize    = 7
+	rmcpVersion1      = 0x06
+	rmcpClassIPMI     = 0x07
+)
+
+// malformed counts malformed requests across all servers
+var malformed = struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}{counts: make(map[string]uint64)}
+
+// countMalformed records a malformed request
+func countMalformed(reason string) {
+	malformed.mu.Lock()
+	malformed.counts[reason]++
+	malformed.mu.Unlock()
+}
+
+// MalformedPackets returns the number of malformed requests received by
+// all servers, by reason
+func MalformedPackets() map[string]uint64 {
+	malformed.mu.Lock()
+	defer malformed.mu.Unlock()
+	counts := make(map[string]uint64, len(malformed.counts))
+	for reason, n := range malformed.counts {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// checkPacket returns the reason an RMCP packet is malformed, or an empty
+// string if the simulator can safely parse it
+func checkPacket(buf []byte) string {
+	if len(buf) < rmcpHeaderSize {
+		return malformedShort
+	}
+	if buf[0] != rmcpVersion1 {
+		return malformedVersion
+	}
+
+	switch buf[3] {
+	case rmcpClassASF:
+		if len(buf) < rmcpHeaderSize+8 {
+			return malformedShort
+		}
+		return ""
+	case rmcpClassIPMI:
+	default:
+		return malformedClass
+	}
+
+	offset := rmcpHeaderSize + sessionHeaderSize
+	if len(buf) < offset {
+		return malformedShort
+	}
+	if buf[rmcpHeaderSize] != AuthTypeNone { // Auth type
+		offset += authCodeSize
+	}
+	if len(buf) < offset+ipmiHeaderSize {
+		return malformedShort
+	}
+
+	// The message length covers the IPMI header and data, followed by a
+	// checksum byte
+	msgLen := int(buf[offset])
+	if msgLen < ipmiHeaderSize || offset+1+msgLen > len(buf) {
+		return malformedLength
+	}
+	return ""
+}
+
+// guard wraps a handler so a panic on a malformed request is recovered and
+// counted instead of stopping the simulator, and short command data is
+// counted as malformed. Every request keeps its session from going idle and
+// is counted in the metrics. Commands arriving while the server stops are
+// rejected as busy.
+func (s *Server) guard(handler goipmi.Handler) goipmi.Handler {
+	return func(m *goipmi.Message) (response goipmi.Response) {
+		if !s.inflight.begin() {
+			return goipmi.ErrNodeBusy
+		}
+		defer s.inflight.end()
+		defer func() {
+			if r := recover(); r != nil {
+				s.log.Warnf("Recovered from panic handling command 0x%02x: %v", uint8(m.Command), r)
+				countMalformed(malformedHandlerPanic)
+				response = goipmi.ErrUnspecified
+			}
+		}()
+
+		s.touchSession(m.SessionID)
+		metrics.CountCommand(uint8(m.NetFn()), uint8(m.Command))
+		response = handler(m)
+		if response == goipmi.ErrShortPacket {
+			countMalformed(malformedData)
+		}
+		return response
+	}
+}
+
+// maxUsers is the number of user IDs the BMC reports
+const maxUsers = 5
+
+// handleGetUserName replaces the simulator's handler, which doesn't check
+// the user ID
+func (s *Server) handleGetUserName(m *goipmi.Message) goipmi.Response {
+	req := &goipmi.GetUserNameRequest{}
+	if err := m.Request(req); err != nil {
+		return err
+	}
+	if req.UserID >= maxUsers {
+		return goipmi.ErrParamRange
+	}
+
+	var name string
+	if req.UserID == configuredUserID {
+		name, _ = s.credentials()
+	}
+	return &goipmi.GetUserNameResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		Username:       name,
+	}
+}
+
+// handleSetUserName replaces the simulator's handler, which changes user
+// names shared by every BMC. It renames the configured user of this BMC
+// only; open sessions are kept. Other user IDs can't be added.
+func (s *Server) handleSetUserName(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 17 {
+		return goipmi.ErrShortPacket
+	}
+	if s.privilege(m.SessionID) < PrivLevelAdmin {
+		return goipmi.ErrPrivLevel
+	}
+	id := m.Data[0] & 0x3f
+	if id == 0 || id >= maxUsers {
+		return goipmi.ErrParamRange
+	}
+	if id != configuredUserID {
+		s.log.Warnf("Rejecting name of user %d, only user %d can be changed", id, configuredUserID)
+		return goipmi.ErrInvalidCommand
+	}
+	name := string(bytes.TrimRight(m.Data[1:17], "\000"))
+	if name == "" {
+		return goipmi.CompletionCode(CompletionCodeInvalidField)
+	}
+
+	_, password := s.credentials()
+	s.RotateCredentials(name, string(bytes.TrimRight(password[:], "\000")), false)
+	s.log.Infof("User %d renamed to %q through IPMI", id, name)
+	return goipmi.CommandCompleted
+}
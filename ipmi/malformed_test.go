@@ -0,0 +1,53 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzCheckPacket(f *testing.F) {
+	f.Add(testPacket(1, 0x06, 0x01))
+	f.Add(testPacket(2, 0x2c, 0x00, 0x00, 0x01))
+	authed := testPacket(3, 0x06, 0x01)
+	authed[rmcpHeaderSize] = AuthTypeMD5
+	f.Add(append(authed[:rmcpHeaderSize+sessionHeaderSize:rmcpHeaderSize+sessionHeaderSize],
+		append(make([]byte, authCodeSize), authed[rmcpHeaderSize+sessionHeaderSize:]...)...))
+	f.Add([]byte{rmcpVersion1, 0x00, 0xff, rmcpClassASF, 0x00, 0x00, 0x11, 0xbe, 0x80, 0x00, 0x00, 0x00})
+	f.Add([]byte{rmcpVersion1, 0x00, 0xff})
+
+	// Direct handlers parse packets checkPacket accepts without checking
+	// the lengths again
+	s, _, _ := newTestServer(f)
+	for _, netfn := range []uint8{0x06, 0x2c} {
+		for command := 0; command < 0x100; command++ {
+			s.handleDirect(netfn, uint8(command), func(r *request) []byte {
+				return append([]byte{0x00}, r.Data...)
+			})
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		orig := bytes.Clone(buf)
+		if checkPacket(buf) != "" {
+			return
+		}
+		respond, ok := s.answer(buf)
+		if !bytes.Equal(buf, orig) {
+			t.Fatalf("answer modified the request")
+		}
+		if ok && respond != nil {
+			// The UDP listener reads the next packet into buf before the
+			// response is built
+			for i := range buf {
+				buf[i] = 0xff
+			}
+			resp := respond()
+			if reason := checkPacket(resp); reason != "" {
+				t.Errorf("response % x to % x is malformed: %s", resp, orig, reason)
+			}
+			if n := rmcpHeaderSize + sessionHeaderSize; !bytes.Equal(resp[:n], orig[:n]) {
+				t.Errorf("response % x doesn't echo the session header of % x", resp, orig)
+			}
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package ipmi
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/sdr"
+)
+
+// Sensor numbers of the manager BMC
+const (
+	sensorManagedVMs    = 0x01
+	sensorPoweredOnVMs  = 0x02
+	sensorFailedActions = 0x03
+)
+
+// managerRecords describe the manager BMC's sensors, which count in units
+// of 1 up to 255
+var managerRecords = []sdr.Record{
+	sdr.Analog{Number: sensorManagedVMs, Entity: sdr.EntitySystemChassis, M: 1, Name: "Managed VMs"},
+	sdr.Analog{Number: sensorPoweredOnVMs, Entity: sdr.EntitySystemChassis, M: 1, Name: "VMs Powered On"},
+	sdr.Analog{Number: sensorFailedActions, Entity: sdr.EntitySystemChassis, M: 1, Name: "Failed Actions"},
+}
+
+// NewManager creates the manager BMC, which has no VM. Its sensors report
+// the fleet-wide counts of the metrics: the managed VMs, how many of them
+// are powered on and the chassis control actions that failed. It has no
+// chassis to control.
+func NewManager(ip net.IP, netmask net.IP, cfg config.ServerConfig) *Server {
+	s := newServer(ip, netmask, cfg, logrus.WithField("bmc", "manager"))
+	s.summarize = metrics.Summarize
+	return s
+}
+
+// startManager starts the manager BMC and activates it, as it has a
+// dedicated address and no VM to activate it through. Its session tag and
+// GUID are derived from its address.
+func (s *Server) startManager(ctx context.Context) error {
+	id := "manager/" + s.Addr()
+	handle := s.newSimulator()
+	s.tag = s.sessionTag(id)
+	s.handleSessions(handle)
+
+	sum := sha256.Sum256([]byte(id))
+	copy(s.guid[:], sum[:])
+	handle(goipmi.NetworkFunctionApp, CommandGetDeviceGUID, s.handleGetGUID)
+	handle(goipmi.NetworkFunctionApp, CommandGetSystemGUID, s.handleGetGUID)
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandGetDeviceID, s.handleGetManagerDeviceID)
+
+	// Replace the simulator's built-in chassis handlers, which would
+	// report a chassis that isn't there
+	for _, command := range []goipmi.Command{goipmi.CommandChassisStatus, goipmi.CommandGetSystemBootOptions, goipmi.CommandSetSystemBootOptions} {
+		handle(goipmi.NetworkFunctionChassis, command, func(*goipmi.Message) goipmi.Response { return goipmi.ErrInvalidCommand })
+	}
+
+	// Register handlers for the SEL, which stays empty, and the sensors
+	s.handleSEL()
+	s.sdrRepo = sdr.New(s.clock.Now(), managerRecords...)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSDRRepositoryInfo, s.handleGetSDRRepositoryInfo)
+	s.handleDirect(NetworkFunctionStorage, CommandReserveSDRRepository, s.handleReserveSDRRepository)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSDR, s.handleGetSDR)
+	s.handleDirect(NetworkFunctionSensor, CommandGetSensorReading, s.handleGetManagerSensorReading)
+	s.handleDirect(NetworkFunctionSensor, CommandGetSensorThresholds, s.handleGetManagerSensorThresholds)
+
+	if err := s.ipmiServer.Run(); err != nil {
+		return fmt.Errorf("failed to start IPMI simulator: %v", err)
+	}
+	return s.Activate(ctx)
+}
+
+// handleGetManagerDeviceID handles IPMI get device ID commands on the
+// manager BMC, which has no hardware sizing to report
+func (s *Server) handleGetManagerDeviceID(m *goipmi.Message) goipmi.Response {
+	return &deviceIDResponse{CompletionCode: goipmi.CommandCompleted, ID: s.cfg.DeviceID}
+}
+
+// handleGetManagerSensorReading handles IPMI get sensor reading commands on
+// the manager BMC. Counts above 255 read as 255.
+func (s *Server) handleGetManagerSensorReading(r *request) []byte {
+	if len(r.Data) < 1 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+
+	summary := s.summarize()
+	var count int
+	switch r.Data[0] {
+	case sensorManagedVMs:
+		count = summary.ManagedVMs
+	case sensorPoweredOnVMs:
+		count = summary.PoweredOnVMs
+	case sensorFailedActions:
+		count = summary.FailedActions
+	default:
+		return []byte{CompletionCodeDataNotPresent}
+	}
+	return []byte{uint8(goipmi.CommandCompleted), uint8(min(count, 0xff)), sensorScanningEnabled, 0}
+}
+
+// handleGetManagerSensorThresholds handles IPMI get sensor thresholds
+// commands on the manager BMC, whose sensors have no thresholds
+func (s *Server) handleGetManagerSensorThresholds(r *request) []byte {
+	if len(r.Data) < 1 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	switch r.Data[0] {
+	case sensorManagedVMs, sensorPoweredOnVMs, sensorFailedActions:
+		return make([]byte, 8)
+	default:
+		return []byte{CompletionCodeDataNotPresent}
+	}
+}
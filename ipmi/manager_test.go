@@ -0,0 +1,49 @@
+package ipmi
+
+import (
+	"net"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/sdr"
+)
+
+func TestManagerSensorsReportFleet(t *testing.T) {
+	s := NewManager(net.IPv4(127, 0, 0, 1), net.IPv4(255, 0, 0, 0), config.NewConfig().Server)
+	s.summarize = func() metrics.Summary {
+		return metrics.Summary{ManagedVMs: 12, PoweredOnVMs: 7, FailedActions: 300}
+	}
+	client := startTestServer(t, s)
+
+	records := walkSDR(t, client)
+	for _, tc := range []struct {
+		name string
+		want uint8
+	}{
+		{"Managed VMs", 12},
+		{"VMs Powered On", 7},
+		{"Failed Actions", 255},
+	} {
+		record, ok := records[tc.name]
+		if !ok {
+			t.Errorf("SDR walk found no %q sensor", tc.name)
+			continue
+		}
+		reading, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, record[sdr.HeaderSize+2])
+		if err != nil {
+			t.Fatalf("reading %s: %v", tc.name, err)
+		}
+		if reading[0] != tc.want {
+			t.Errorf("%s reads %d, want %d", tc.name, reading[0], tc.want)
+		}
+	}
+	if len(records) != len(managerRecords) {
+		t.Errorf("SDR walk found %d sensors, want only the %d fleet sensors", len(records), len(managerRecords))
+	}
+
+	if _, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus)); err != goipmi.ErrInvalidCommand {
+		t.Errorf("chassis status of the manager BMC returned %v, want invalid command", err)
+	}
+}
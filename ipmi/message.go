@@ -29,10 +29,16 @@ const (
 	CommandActivateSession          = 0x3a
 	CommandSetSessionPrivilegeLevel = 0x3b
 	CommandCloseSession             = 0x3c
+	CommandGetSessionInfo           = 0x3d
 	CommandChassisControl          = 0x02
 	CommandChassisStatus           = 0x01
 	CommandSetSystemBootOptions     = 0x08
 	CommandGetSystemBootOptions     = 0x09
+	CommandSetSystemInfoParameters  = 0x58
+	CommandGetSystemInfoParameters  = 0x59
+	CommandGetChannelCipherSuites   = 0x54
+	CommandGetDeviceGUID            = 0x08
+	CommandGetSystemGUID            = 0x37
 )
 
 // IPMI Network Functions
@@ -44,6 +50,11 @@ const (
 // IPMI Completion Codes
 const (
 	CompletionCodeNormal           = 0x00
+	CompletionCodeParamUnsupported = 0x80
+	CompletionCodeInvalidUserName  = 0x81 // Get Session Challenge
+	CompletionCodeNoSessionSlot    = 0x81 // Activate Session
+	CompletionCodeParamReadOnly    = 0x82 // Set System Info Parameters
+	CompletionCodePrivExceedsLimit = 0x81 // Set Session Privilege Level
 	CompletionCodeNodeBusy         = 0xc0
 	CompletionCodeInvalidCommand   = 0xc1
 	CompletionCodeInvalidLUN       = 0xc2
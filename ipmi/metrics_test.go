@@ -0,0 +1,65 @@
+package ipmi
+
+import (
+	"errors"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+// scrape returns the value of a counter in the metrics registry with the
+// given labels, or 0 if it wasn't counted yet
+func scrape(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestChassisControlCounted(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	control := map[string]string{"netfn": "0x00", "command": "0x02"}
+	success := map[string]string{"action": "power_up", "result": "success"}
+	failure := map[string]string{"action": "power_up", "result": "failure"}
+	commands := scrape(t, "vbmc_ipmi_commands_total", control)
+	succeeded := scrape(t, "vbmc_power_actions_total", success)
+	failed := scrape(t, "vbmc_power_actions_total", failure)
+
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "connected"})
+	vc.SetError("PowerOnVM", errors.New("host is in maintenance mode"))
+	if err := client.Control(goipmi.ControlPowerUp); err == nil {
+		t.Fatal("power up succeeded while vCenter fails it")
+	}
+
+	if n := scrape(t, "vbmc_ipmi_commands_total", control) - commands; n != 2 {
+		t.Errorf("chassis control counted %v times, want 2", n)
+	}
+	if n := scrape(t, "vbmc_power_actions_total", success) - succeeded; n != 1 {
+		t.Errorf("successful power up counted %v times, want 1", n)
+	}
+	if n := scrape(t, "vbmc_power_actions_total", failure) - failed; n != 1 {
+		t.Errorf("failed power up counted %v times, want 1", n)
+	}
+}
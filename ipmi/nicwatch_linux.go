@@ -0,0 +1,200 @@
+//go:build linux
+
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/syslog"
+)
+
+// nicWatchSettle is how long address events must stop before the NIC is
+// checked, coalescing the bursts a single change produces
+const nicWatchSettle = time.Second
+
+// rtmgrpIPv4IfAddr is the RTMGRP_IPV4_IFADDR netlink multicast group
+const rtmgrpIPv4IfAddr = 0x10
+
+// nicWatcher checks the NIC's addresses after netlink reports a change
+type nicWatcher struct {
+	iface   *net.Interface
+	servers func() []*Server // Current BMCs
+	action  string
+	subnets []string // Subnets of the NIC's own, non-BMC addresses
+	log     *logrus.Entry
+}
+
+// WatchNIC subscribes to address changes on the NIC until ctx is canceled.
+// When the NIC's own subnets change it logs a warning, and BMC addresses
+// that disappear are reported or re-added depending on cfg.Action.
+func WatchNIC(ctx context.Context, nic string, servers func() []*Server, cfg config.NICWatchConfig) error {
+	iface, err := net.InterfaceByName(nic)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %v", nic, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpIPv4IfAddr}
+	if err := syscall.Bind(fd, sa); err != nil {
+		_ = syscall.Close(fd)
+		return fmt.Errorf("failed to subscribe to address changes: %v", err)
+	}
+
+	// Reads time out so settled changes and cancellation are noticed
+	tv := syscall.NsecToTimeval(nicWatchSettle.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		_ = syscall.Close(fd)
+		return fmt.Errorf("failed to set netlink read timeout: %v", err)
+	}
+
+	w := &nicWatcher{
+		iface:   iface,
+		servers: servers,
+		action:  cfg.Action,
+		log:     logrus.WithField("nic", nic),
+	}
+	w.subnets, _, err = w.addresses()
+	if err != nil {
+		_ = syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		w.run(ctx, fd)
+	}()
+	w.log.Infof("Watching interface %s for address changes (action %s)", nic, cfg.Action)
+	return nil
+}
+
+// run reads netlink messages until ctx is canceled, checking the NIC once
+// its address events settle
+func (w *nicWatcher) run(ctx context.Context, fd int) {
+	buf := make([]byte, syscall.Getpagesize())
+	pending := false
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		switch {
+		case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EINTR):
+			if pending {
+				pending = false
+				w.check()
+			}
+			continue
+		case errors.Is(err, syscall.ENOBUFS):
+			pending = true // Events were lost, so check anyway
+			continue
+		case err != nil:
+			w.log.Errorf("Failed to read address changes, no longer watching interface: %v", err)
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.Header.Type != syscall.RTM_NEWADDR && msg.Header.Type != syscall.RTM_DELADDR {
+				continue
+			}
+			if len(msg.Data) < syscall.SizeofIfAddrmsg {
+				continue
+			}
+			index := binary.NativeEndian.Uint32(msg.Data[4:8]) // ifa_index of struct ifaddrmsg
+			if int(index) == w.iface.Index {
+				pending = true
+			}
+		}
+	}
+}
+
+// addresses returns the subnets of the NIC's own addresses and the set of
+// addresses currently on the NIC
+func (w *nicWatcher) addresses() ([]string, map[string]bool, error) {
+	addrs, err := w.iface.Addrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list addresses on %s: %v", w.iface.Name, err)
+	}
+
+	servers := w.servers()
+	bmcs := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		bmcs[s.ip.String()] = true
+	}
+
+	var subnets []string
+	present := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		// IPv6 link-local addresses come with the link, not its network
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+		present[ipnet.IP.String()] = true
+		if !bmcs[ipnet.IP.String()] {
+			subnet := &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}
+			subnets = append(subnets, subnet.String())
+		}
+	}
+	sort.Strings(subnets)
+	return subnets, present, nil
+}
+
+// check compares the NIC's addresses with the BMCs' and responds to any
+// difference
+func (w *nicWatcher) check() {
+	subnets, present, err := w.addresses()
+	if err != nil {
+		w.log.Errorf("Failed to check interface addresses: %v", err)
+		return
+	}
+
+	if strings.Join(subnets, ",") != strings.Join(w.subnets, ",") {
+		w.log.WithField(syslog.EventField, "nic_subnet_changed").
+			Errorf("Interface %s subnets changed from %v to %v, BMC addresses may no longer be reachable",
+				w.iface.Name, w.subnets, subnets)
+		w.subnets = subnets
+	}
+
+	var missing []*Server
+	for _, s := range w.servers() {
+		if s.Active() && !present[s.ip.String()] { // Standby BMCs hold no address
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if w.action != config.NICWatchReadd {
+		ips := make([]string, len(missing))
+		for i, s := range missing {
+			ips[i] = s.ip.String()
+		}
+		w.log.WithField(syslog.EventField, "bmc_address_missing").
+			Errorf("%d BMC addresses are no longer on interface %s: %s", len(ips), w.iface.Name, strings.Join(ips, ", "))
+		return
+	}
+
+	for _, s := range missing {
+		if err := s.configureIP(); err != nil {
+			s.log.Errorf("Failed to re-add BMC address after interface change: %v", err)
+			continue
+		}
+		s.log.WithField(syslog.EventField, "bmc_address_readded").
+			Warnf("Re-added BMC address %s after it disappeared from interface %s", s.ip, w.iface.Name)
+	}
+}
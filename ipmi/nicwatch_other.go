@@ -0,0 +1,15 @@
+//go:build !linux
+
+package ipmi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vbmc-vsphere/config"
+)
+
+// WatchNIC is only supported on Linux, where netlink reports address changes
+func WatchNIC(ctx context.Context, nic string, servers func() []*Server, cfg config.NICWatchConfig) error {
+	return fmt.Errorf("interface monitoring is only supported on Linux")
+}
@@ -0,0 +1,128 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/vbmc-vsphere/config"
+)
+
+// RMCP/ASF presence ping constants
+const (
+	rmcpClassASF       = 0x06
+	asfIANA            = 0x000011be
+	asfMessageTypePing = 0x80
+	asfMessageTypePong = 0x40
+	asfPongLength      = 4 + 8 + 16 // RMCP header + ASF header + pong data
+)
+
+// presencePing returns an RMCP ASF presence ping message
+func presencePing() []byte {
+	msg := []byte{
+		0x06, 0x00, 0xff, rmcpClassASF, // RMCP v1.0, no ACK requested
+		0, 0, 0, 0, // IANA enterprise number
+		asfMessageTypePing,
+		0x00, // Message tag
+		0x00, // Reserved
+		0x00, // Data length
+	}
+	binary.BigEndian.PutUint32(msg[4:8], asfIANA)
+	return msg
+}
+
+// checkPong verifies an RMCP ASF presence pong
+func checkPong(buf []byte) error {
+	if len(buf) < asfPongLength {
+		return fmt.Errorf("pong too short: %d bytes", len(buf))
+	}
+	if buf[3] != rmcpClassASF || buf[8] != asfMessageTypePong {
+		return fmt.Errorf("unexpected response to presence ping")
+	}
+	if buf[20]&0x80 == 0 { // Supported entities: IPMI
+		return fmt.Errorf("IPMI not supported by responder")
+	}
+	return nil
+}
+
+// Ping sends an RMCP presence ping to the server's own address and waits
+// for the pong, confirming the BMC answers on its assigned IP
+func (s *Server) Ping(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if s.cfg.Transport == config.TransportTCP {
+		return pingTCP(&net.TCPAddr{IP: s.ip, Port: s.port}, deadline)
+	}
+	return pingUDP(&net.UDPAddr{IP: s.ip, Port: s.port}, deadline)
+}
+
+// pingUDP sends a presence ping over UDP
+func pingUDP(addr *net.UDPAddr, deadline time.Time) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(presencePing()); err != nil {
+		return fmt.Errorf("failed to send ping to %s: %v", addr, err)
+	}
+
+	buf := make([]byte, maxFrameSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("no pong from %s: %v", addr, err)
+	}
+	return checkPong(buf[:n])
+}
+
+// pingTCP sends a presence ping using the TCP framing of the bridge
+func pingTCP(addr *net.TCPAddr, deadline time.Time) error {
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Until(deadline))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	ping := presencePing()
+	frame := make([]byte, 2, 2+len(ping))
+	binary.BigEndian.PutUint16(frame, uint16(len(ping)))
+	if _, err := conn.Write(append(frame, ping...)); err != nil {
+		return fmt.Errorf("failed to send ping to %s: %v", addr, err)
+	}
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("no pong from %s: %v", addr, err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("short pong from %s: %v", addr, err)
+	}
+	return checkPong(buf)
+}
+
+// IP returns the address the server is bound to
+func (s *Server) IP() net.IP {
+	return s.ip
+}
+
+// SetPort sets the port to listen on, replacing server.ipmi_port. It must
+// be called before Start.
+func (s *Server) SetPort(port int) {
+	s.port = port
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() int {
+	return s.port
+}
+
+// Addr returns the address and port the server listens on
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.ip.String(), strconv.Itoa(s.port))
+}
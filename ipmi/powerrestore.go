@@ -0,0 +1,94 @@
+package ipmi
+
+import (
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/config"
+)
+
+// CommandSetPowerRestorePolicy is the IPMI set power restore policy command
+const CommandSetPowerRestorePolicy = 0x06
+
+// Power restore policies as encoded in Set Power Restore Policy requests
+const (
+	restoreAlwaysOff = 0x00
+	restorePrevious  = 0x01
+	restoreAlwaysOn  = 0x02
+	restoreNoChange  = 0x03 // Only reports the supported policies
+	restoreMask      = 0x07
+)
+
+// restoreSupported is the supported policies bit mask of Set Power Restore
+// Policy responses: always off, previous and always on
+const restoreSupported = 0x07
+
+// restoreStatusShift places the policy in bits 6:5 of the chassis status
+// current power state byte
+const restoreStatusShift = 5
+
+// restorePolicies maps the IPMI policy codes to the configured policies
+var restorePolicies = map[uint8]string{
+	restoreAlwaysOff: config.PowerRestoreAlwaysOff,
+	restorePrevious:  config.PowerRestorePrevious,
+	restoreAlwaysOn:  config.PowerRestoreAlwaysOn,
+}
+
+// restorePolicyResponse is the Set Power Restore Policy response
+type restorePolicyResponse struct {
+	goipmi.CompletionCode
+	Supported uint8
+}
+
+// MarshalBinary encodes the response
+func (r *restorePolicyResponse) MarshalBinary() ([]byte, error) {
+	return []byte{byte(r.CompletionCode), r.Supported}, nil
+}
+
+// restorePolicy returns the VM's power restore policy, as set through IPMI
+// or else as configured
+func (s *Server) restorePolicy() string {
+	policy, ok, err := s.db.GetRestorePolicy(s.key)
+	if err != nil || !ok {
+		return s.cfg.PowerRestorePolicy
+	}
+	return policy
+}
+
+// restoreStatus returns the VM's power restore policy as reported in the
+// chassis status
+func (s *Server) restoreStatus() byte {
+	policy := s.restorePolicy()
+	for code, name := range restorePolicies {
+		if name == policy {
+			return code << restoreStatusShift
+		}
+	}
+	return restoreNoChange << restoreStatusShift // Unknown
+}
+
+// handleSetPowerRestorePolicy handles IPMI set power restore policy
+// commands, which need Operator privilege. The policy is kept in the IP
+// database and applied to the VM when the service next starts.
+func (s *Server) handleSetPowerRestorePolicy(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 1 {
+		return goipmi.ErrShortPacket
+	}
+	if s.privilege(m.SessionID) < PrivLevelOperator {
+		return goipmi.ErrPrivLevel
+	}
+
+	code := m.Data[0] & restoreMask
+	if code == restoreNoChange {
+		return &restorePolicyResponse{CompletionCode: goipmi.CommandCompleted, Supported: restoreSupported}
+	}
+	policy, ok := restorePolicies[code]
+	if !ok {
+		return goipmi.CompletionCode(CompletionCodeInvalidField)
+	}
+
+	if err := s.db.SetRestorePolicy(s.key, policy); err != nil {
+		s.log.Errorf("Failed to save power restore policy: %v", err)
+		return goipmi.ErrUnspecified
+	}
+	s.log.Infof("Power restore policy set to %s", policy)
+	return &restorePolicyResponse{CompletionCode: goipmi.CommandCompleted, Supported: restoreSupported}
+}
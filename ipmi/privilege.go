@@ -0,0 +1,208 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// sessionIdleTimeout is how long a session may go without a command before
+// it no longer counts against the session limit, per the spec's default
+// session inactivity timeout
+const sessionIdleTimeout = 60 * time.Second
+
+// privilegeLevels maps configuration names to IPMI privilege levels
+var privilegeLevels = map[string]uint8{
+	"user":          PrivLevelUser,
+	"operator":      PrivLevelOperator,
+	"administrator": PrivLevelAdmin,
+}
+
+// sessionPrivilege is the privilege of an active session
+type sessionPrivilege struct {
+	max      uint8     // Limit requested at activation
+	current  uint8     // Level set with Set Session Privilege Level
+	lastSeen time.Time // Time of the session's last command
+	handle   uint8     // Session handle reported by Get Session Info
+	password [16]byte  // Password the session was activated with
+}
+
+// SetPrivilegeClients sets the vSphere clients used for commands from
+// sessions at a given privilege level, keyed by level name. Sessions at
+// other levels use the default client.
+func (s *Server) SetPrivilegeClients(clients map[string]vsphere.VMClient) error {
+	s.privClients = make(map[uint8]vsphere.VMClient, len(clients))
+	for name, client := range clients {
+		level, ok := privilegeLevels[name]
+		if !ok {
+			return fmt.Errorf("unknown privilege level: %s", name)
+		}
+		s.privClients[level] = client
+	}
+	return nil
+}
+
+// client returns the vSphere client for the privilege of the message's
+// session. A level without its own credentials uses those of the closest
+// level below it, or else the least privileged ones configured, but never
+// the main account, which is often an administrator.
+func (s *Server) client(m *goipmi.Message) vsphere.VMClient {
+	if len(s.privClients) == 0 {
+		return s.vsClient
+	}
+
+	for level := s.privilege(m.SessionID); level >= PrivLevelUser; level-- {
+		if client, ok := s.privClients[level]; ok {
+			return client
+		}
+	}
+	for level := uint8(PrivLevelUser); level <= PrivLevelAdmin; level++ {
+		if client, ok := s.privClients[level]; ok {
+			return client
+		}
+	}
+	return s.vsClient // Not reached, privClients has an entry
+}
+
+// privilege returns the current privilege of a session. Sessions that
+// aren't tracked, e.g. activated before a restart, reaped while idle or
+// never activated on this BMC, have no privilege.
+func (s *Server) privilege(sessionID uint32) uint8 {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if session, ok := s.sessions[sessionID]; ok {
+		return session.current
+	}
+	return PrivLevelNone
+}
+
+// openSession records a newly activated session at User level, per the
+// spec, limited to the requested maximum privilege and authenticated with
+// password. Idle sessions are reaped first; it returns false if the BMC
+// still has no free session slot.
+func (s *Server) openSession(id uint32, limit uint8, password [16]byte) bool {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	now := s.clock.Now()
+	for old, session := range s.sessions {
+		if now.Sub(session.lastSeen) > sessionIdleTimeout {
+			delete(s.sessions, old)
+		}
+	}
+
+	// Activating the same session again, e.g. after a lost reply, reuses its slot
+	if _, ok := s.sessions[id]; !ok && len(s.sessions) >= s.cfg.MaxSessions {
+		return false
+	}
+	handle := s.sessions[id].handle
+	if handle == 0 {
+		s.sessionHandle++
+		if s.sessionHandle == 0 {
+			s.sessionHandle++ // Handle 0 is reserved
+		}
+		handle = s.sessionHandle
+	}
+	s.sessions[id] = sessionPrivilege{max: limit, current: PrivLevelUser, lastSeen: now, handle: handle, password: password}
+	return true
+}
+
+// Sessions returns the number of active sessions. Sessions idle for too
+// long are left out even before they are reaped.
+func (s *Server) Sessions() int {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	now := s.clock.Now()
+	active := 0
+	for _, session := range s.sessions {
+		if now.Sub(session.lastSeen) <= sessionIdleTimeout {
+			active++
+		}
+	}
+	return active
+}
+
+// touchSession records activity on a tracked session so it isn't reaped.
+// A session already idle for too long is reaped instead, so it can't be
+// revived after it has timed out.
+func (s *Server) touchSession(id uint32) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	now := s.clock.Now()
+	if now.Sub(session.lastSeen) > sessionIdleTimeout {
+		delete(s.sessions, id)
+		return
+	}
+	session.lastSeen = now
+	s.sessions[id] = session
+}
+
+// nextSessionID returns a session ID tagged with the VM's session tag whose
+// low 16 bits aren't used by an open session, so concurrent sessions can
+// be told apart
+func (s *Server) nextSessionID() uint32 {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	for {
+		s.sessionSeq++
+		id := uint32(s.tag)<<16 | uint32(s.sessionSeq)
+		if _, ok := s.sessions[id]; ok || id == 0 {
+			continue // In use, or reserved for sessionless messages
+		}
+		return id
+	}
+}
+
+// handleSetSessionPrivilege sets the privilege of the message's session.
+// It replaces the simulator's handler, which doesn't check the request
+// length or the session's limit.
+func (s *Server) handleSetSessionPrivilege(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 1 {
+		return goipmi.ErrShortPacket
+	}
+	requested := m.Data[0] & 0x0f
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	session, ok := s.sessions[m.SessionID]
+	if !ok {
+		return goipmi.ErrPrivLevel // Closed or reaped since it was authenticated
+	}
+	if requested == 0 { // Report the current level
+		return &goipmi.SessionPrivilegeLevelResponse{
+			CompletionCode:    goipmi.CommandCompleted,
+			NewPrivilegeLevel: session.current,
+		}
+	}
+	if requested > session.max {
+		return goipmi.CompletionCode(CompletionCodePrivExceedsLimit)
+	}
+
+	session.current = requested
+	s.sessions[m.SessionID] = session
+	return &goipmi.SessionPrivilegeLevelResponse{
+		CompletionCode:    goipmi.CommandCompleted,
+		NewPrivilegeLevel: requested,
+	}
+}
+
+// handleCloseSession forgets the privilege of a closed session
+func (s *Server) handleCloseSession(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 4 {
+		return goipmi.ErrShortPacket
+	}
+	id := binary.LittleEndian.Uint32(m.Data[0:4])
+
+	s.sessionMu.Lock()
+	delete(s.sessions, id)
+	s.sessionMu.Unlock()
+	return goipmi.CommandCompleted
+}
@@ -0,0 +1,63 @@
+package ipmi
+
+import (
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+func TestReapedSessionCannotControlChassis(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	client := startTestServer(t, s)
+
+	fake.Advance(sessionIdleTimeout + time.Second)
+	if err := client.Control(goipmi.ControlPowerUp); err != goipmi.ErrPrivLevel {
+		t.Errorf("power up from a session idle past the timeout returned %v, want %v", err, goipmi.ErrPrivLevel)
+	}
+	if n := called(vc, "PowerOnVM"); n != 0 {
+		t.Errorf("VM powered on %d times by a reaped session", n)
+	}
+}
+
+func TestPrivilegeClientSelection(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		configured []string
+		level      uint8
+		want       string
+	}{
+		{"own credentials", []string{"user", "operator", "administrator"}, PrivLevelOperator, "operator"},
+		{"closest level below", []string{"operator"}, PrivLevelAdmin, "operator"},
+		{"least privileged above", []string{"operator", "administrator"}, PrivLevelUser, "operator"},
+	} {
+		s, main, _ := newTestServer(t)
+		clients := make(map[string]*mock.Client)
+		levels := make(map[string]vsphere.VMClient)
+		for _, name := range tc.configured {
+			clients[name] = mock.New()
+			levels[name] = clients[name]
+		}
+		if err := s.SetPrivilegeClients(levels); err != nil {
+			t.Fatal(err)
+		}
+		client := startTestServer(t, s)
+		if _, err := send(client, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandSetSessionPrivilegeLevel), tc.level); err != nil {
+			t.Fatalf("%s: setting the session privilege: %v", tc.name, err)
+		}
+
+		if err := client.Control(goipmi.ControlPowerUp); err != nil {
+			t.Fatalf("%s: power up: %v", tc.name, err)
+		}
+		if n := called(main, "PowerOnVM"); n != 0 {
+			t.Errorf("%s: the main account powered the VM on", tc.name)
+		}
+		for name, vc := range clients {
+			if n := called(vc, "PowerOnVM"); (name == tc.want) != (n == 1) {
+				t.Errorf("%s: the %s credentials powered the VM on %d times", tc.name, name, n)
+			}
+		}
+	}
+}
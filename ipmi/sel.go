@@ -0,0 +1,176 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"errors"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/sel"
+)
+
+// IPMI SEL device commands, in the Storage network function
+const (
+	CommandGetSELInfo  = 0x40
+	CommandReserveSEL  = 0x42
+	CommandGetSELEntry = 0x43
+	CommandClearSEL    = 0x47
+	CommandGetSELTime  = 0x48
+)
+
+// SEL completion codes
+const (
+	CompletionCodeInvalidReservation = 0xc5
+	CompletionCodeDataNotPresent     = 0xcb
+)
+
+// Fields of the Get SEL Info and Clear SEL responses
+const (
+	selVersion        = 0x51 // IPMI v1.5 and v2.0
+	selSupportReserve = 0x02
+	selOverflow       = 0x80
+	selEraseComplete  = 0x01
+	selClearInitiate  = 0xaa
+)
+
+// Sensor numbers the BMC logs its events against
+const (
+	sensorPowerUnit     = 0x01
+	sensorSystemRestart = 0x02
+	sensorWatchdog      = 0x03
+)
+
+// SEL events logged for power actions. Power on is logged as the
+// deassertion of power off, as the power unit sensor has no power on offset.
+var (
+	selPowerOff = sel.Event{
+		SensorType:   sel.SensorTypePowerUnit,
+		SensorNumber: sensorPowerUnit,
+		EventType:    sel.EventTypeSensorSpecific,
+		Data:         [3]uint8{0x00, 0xff, 0xff}, // Power off/power down
+	}
+	selPowerOn = sel.Event{
+		SensorType:   sel.SensorTypePowerUnit,
+		SensorNumber: sensorPowerUnit,
+		EventType:    sel.EventTypeSensorSpecific | sel.Deassertion,
+		Data:         [3]uint8{0x00, 0xff, 0xff},
+	}
+	selReset = sel.Event{
+		SensorType:   sel.SensorTypeSystemRestart,
+		SensorNumber: sensorSystemRestart,
+		EventType:    sel.EventTypeSensorSpecific,
+		Data:         [3]uint8{0x01, 0xff, 0xff}, // Initiated by hard reset
+	}
+)
+
+// logEvent appends an event to the BMC's SEL
+func (s *Server) logEvent(e sel.Event) {
+	r := s.eventLog.Add(e)
+	s.log.Debugf("Logged SEL record 0x%04x", r.ID)
+}
+
+// handleSEL registers the handlers for the SEL. The simulator can't route
+// the Storage network function, so the listeners answer these.
+func (s *Server) handleSEL() {
+	s.handleDirect(NetworkFunctionStorage, CommandGetSELInfo, s.handleGetSELInfo)
+	s.handleDirect(NetworkFunctionStorage, CommandReserveSEL, s.handleReserveSEL)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSELEntry, s.handleGetSELEntry)
+	s.handleDirect(NetworkFunctionStorage, CommandClearSEL, s.handleClearSEL)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSELTime, s.handleGetSELTime)
+}
+
+// handleGetSELInfo handles IPMI get SEL info commands
+func (s *Server) handleGetSELInfo(r *request) []byte {
+	info := s.eventLog.Info()
+
+	resp := make([]byte, 15)
+	resp[0] = uint8(goipmi.CommandCompleted)
+	resp[1] = selVersion
+	binary.LittleEndian.PutUint16(resp[2:], uint16(info.Entries))
+	free := info.Free
+	if free > 0xfffe {
+		free = 0xfffe // 0xffff means unspecified
+	}
+	binary.LittleEndian.PutUint16(resp[4:], uint16(free))
+	binary.LittleEndian.PutUint32(resp[6:], sel.Timestamp(info.Added))
+	binary.LittleEndian.PutUint32(resp[10:], sel.Timestamp(info.Erased))
+	resp[14] = selSupportReserve
+	if info.Overflow {
+		resp[14] |= selOverflow
+	}
+	return resp
+}
+
+// handleReserveSEL handles IPMI reserve SEL commands
+func (s *Server) handleReserveSEL(r *request) []byte {
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0}
+	binary.LittleEndian.PutUint16(resp[1:], s.eventLog.Reserve())
+	return resp
+}
+
+// handleGetSELEntry handles IPMI get SEL entry commands. A reservation is
+// only required to read a record in parts, per the spec.
+func (s *Server) handleGetSELEntry(r *request) []byte {
+	if len(r.Data) < 6 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	reservation := binary.LittleEndian.Uint16(r.Data[0:])
+	id := binary.LittleEndian.Uint16(r.Data[2:])
+	offset, count := int(r.Data[4]), int(r.Data[5])
+
+	if offset != 0 && !s.eventLog.Reserved(reservation) {
+		return []byte{CompletionCodeInvalidReservation}
+	}
+	if offset >= sel.RecordSize {
+		return []byte{uint8(goipmi.ErrParamRange)}
+	}
+
+	record, next, err := s.eventLog.Get(id)
+	if err != nil {
+		return []byte{CompletionCodeDataNotPresent}
+	}
+	data, _ := record.MarshalBinary()
+	if count == 0xff || offset+count > len(data) { // 0xff reads the whole record
+		count = len(data) - offset
+	}
+
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0}
+	binary.LittleEndian.PutUint16(resp[1:], next)
+	return append(resp, data[offset:offset+count]...)
+}
+
+// handleClearSEL handles IPMI clear SEL commands, which need Operator
+// privilege. Erasure completes at once, so a status request always reports
+// it complete.
+func (s *Server) handleClearSEL(r *request) []byte {
+	if len(r.Data) < 6 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	if s.privilege(r.SessionID) < PrivLevelOperator {
+		return []byte{uint8(goipmi.ErrPrivLevel)}
+	}
+	if string(r.Data[2:5]) != "CLR" {
+		return []byte{CompletionCodeInvalidField}
+	}
+	reservation := binary.LittleEndian.Uint16(r.Data[0:])
+	if !s.eventLog.Reserved(reservation) {
+		return []byte{CompletionCodeInvalidReservation}
+	}
+
+	if r.Data[5] == selClearInitiate {
+		if err := s.eventLog.Clear(reservation); err != nil {
+			if errors.Is(err, sel.ErrReservation) {
+				return []byte{CompletionCodeInvalidReservation}
+			}
+			return []byte{uint8(goipmi.ErrUnspecified)}
+		}
+		s.log.Info("SEL cleared")
+	}
+	return []byte{uint8(goipmi.CommandCompleted), selEraseComplete}
+}
+
+// handleGetSELTime handles IPMI get SEL time commands
+func (s *Server) handleGetSELTime(r *request) []byte {
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(resp[1:], sel.Timestamp(s.clock.Now()))
+	return resp
+}
@@ -0,0 +1,57 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/sel"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+// readSEL reads every SEL record through Get SEL Entry, oldest first
+func readSEL(t *testing.T, client *goipmi.Client) [][]byte {
+	t.Helper()
+	var records [][]byte
+	for id := uint16(sel.FirstRecord); id != sel.LastRecord; {
+		resp, err := send(client, NetworkFunctionStorage, CommandGetSELEntry, 0, 0, uint8(id), uint8(id>>8), 0, 0xff)
+		if err != nil {
+			t.Fatalf("Get SEL Entry 0x%04x: %v", id, err)
+		}
+		if len(resp) != 2+sel.RecordSize {
+			t.Fatalf("Get SEL Entry 0x%04x returned % x", id, resp)
+		}
+		records = append(records, resp[2:])
+		id = binary.LittleEndian.Uint16(resp)
+	}
+	return records
+}
+
+func TestPowerCycleLogsTwoSELEntries(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+
+	if err := client.Control(goipmi.ControlPowerCycle); err != nil {
+		t.Fatalf("power cycle: %v", err)
+	}
+	waitFor(t, "the power cycle delay", func() bool { return fake.Waiters() == 1 })
+	fake.Advance(time.Duration(s.cfg.PowerCycleDelay) * time.Second)
+	waitFor(t, "the VM to power on", func() bool { return vc.VM(s.vm).PowerState == "poweredOn" })
+	waitFor(t, "the power on to be logged", func() bool { return s.eventLog.Info().Entries == 2 })
+
+	records := readSEL(t, client)
+	if len(records) != 2 {
+		t.Fatalf("power cycle logged %d SEL records, want 2", len(records))
+	}
+	first, second := binary.LittleEndian.Uint16(records[0]), binary.LittleEndian.Uint16(records[1])
+	if second <= first {
+		t.Errorf("record IDs 0x%04x then 0x%04x, want them increasing", first, second)
+	}
+	for i, want := range []uint8{selPowerOff.EventType, selPowerOn.EventType} {
+		if r := records[i]; r[10] != sel.SensorTypePowerUnit || r[11] != sensorPowerUnit || r[12] != want {
+			t.Errorf("record %d is % x, want a power unit event of type 0x%02x", i, r, want)
+		}
+	}
+}
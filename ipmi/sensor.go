@@ -0,0 +1,253 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/sdr"
+	"github.com/vbmc-vsphere/sel"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// IPMI sensor device commands, in the Sensor network function
+const (
+	CommandGetSensorThresholds = 0x27
+	CommandGetSensorReading    = 0x2d
+)
+
+// IPMI SDR repository commands, in the Storage network function
+const (
+	CommandGetSDRRepositoryInfo = 0x20
+	CommandReserveSDRRepository = 0x22
+	CommandGetSDR               = 0x23
+)
+
+// Bits of the second byte of the Get Sensor Reading response
+const (
+	sensorEventsEnabled   = 0x80
+	sensorScanningEnabled = 0x40
+	sensorUnavailable     = 0x20
+)
+
+// sdrSupportReserve is set in the operation support byte of the Get SDR
+// Repository Info response
+const sdrSupportReserve = 0x02
+
+// powerUnitPowerOff is the Power Off/Power Down state of the power unit
+// sensor, asserted while the VM is powered off
+const powerUnitPowerOff = 0x0001
+
+// Sensor numbers of the usage sensors, following those events are logged against
+const (
+	sensorCPUUsage     = 0x04
+	sensorActiveMemory = 0x05
+)
+
+// sensorSyntheticFirst is the number of the first synthetic sensor, the
+// others following in the order they are configured
+const sensorSyntheticFirst = 0x30
+
+// Units of a raw usage reading. A reading is a single byte, so CPU usage
+// reads up to 25.5 GHz and active memory up to 32 GiB.
+const (
+	cpuReadingMHz   = 100
+	memoryReadingMB = 128
+)
+
+// sensorRecords describe the BMC's sensors. The power unit sensor is the
+// one power actions are logged against in the SEL.
+var sensorRecords = []sdr.Record{
+	sdr.Discrete{
+		Number:    sensorPowerUnit,
+		Entity:    sdr.EntitySystemChassis,
+		Type:      sel.SensorTypePowerUnit,
+		EventType: sel.EventTypeSensorSpecific,
+		States:    powerUnitPowerOff,
+		Name:      "Power",
+	},
+	sdr.Analog{
+		Number:   sensorCPUUsage,
+		Entity:   sdr.EntityProcessor,
+		Unit:     sdr.UnitHertz,
+		M:        cpuReadingMHz,
+		Exponent: 6,
+		Name:     "CPU Usage",
+	},
+	sdr.Analog{
+		Number: sensorActiveMemory,
+		Entity: sdr.EntityMemoryDevice,
+		Unit:   sdr.UnitMegabyte,
+		M:      memoryReadingMB,
+		Name:   "Active Memory",
+	},
+}
+
+// syntheticSensors describes the configured synthetic sensors. Readings
+// and thresholds are rounded to the raw units of 100 RPM for fans and 1
+// degree C for temperatures.
+func syntheticSensors(cfgs []config.SensorConfig) []sdr.Analog {
+	sensors := make([]sdr.Analog, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		sensor := sdr.Analog{
+			Number: sensorSyntheticFirst + uint8(i),
+			Entity: sdr.EntitySystemBoard,
+			Type:   sdr.SensorTypeTemperature,
+			Unit:   sdr.UnitDegreesC,
+			M:      1,
+			Name:   cfg.Name,
+		}
+		if cfg.Type == config.SensorFan {
+			sensor.Entity, sensor.Type, sensor.Unit, sensor.M = sdr.EntityFan, sdr.SensorTypeFan, sdr.UnitRPM, 100
+		}
+		raw := func(value int) uint8 {
+			return uint8(min((value+int(sensor.M)/2)/int(sensor.M), 0xff))
+		}
+		sensor.Thresholds = &sdr.Thresholds{
+			Nominal:       raw(cfg.Reading),
+			LowerCritical: raw(cfg.LowerCritical),
+			UpperCritical: raw(cfg.UpperCritical),
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+// handleGetSensorReading handles IPMI get sensor reading commands.
+// Synthetic sensors always read their nominal value.
+func (s *Server) handleGetSensorReading(r *request) []byte {
+	if len(r.Data) < 1 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+
+	if sensor, ok := s.synthetic[r.Data[0]]; ok {
+		return []byte{uint8(goipmi.CommandCompleted), sensor.Thresholds.Nominal, sensorScanningEnabled, 0}
+	}
+	switch r.Data[0] {
+	case sensorPowerUnit:
+		return s.readPowerUnit()
+	case sensorCPUUsage, sensorActiveMemory:
+		return s.readUsage(r.Data[0])
+	default:
+		return []byte{CompletionCodeDataNotPresent}
+	}
+}
+
+// handleGetSensorThresholds handles IPMI get sensor thresholds commands.
+// Only the synthetic sensors have thresholds, their critical ones.
+func (s *Server) handleGetSensorThresholds(r *request) []byte {
+	if len(r.Data) < 1 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+
+	// Readable mask, then the lower non-critical, critical and
+	// non-recoverable and the upper ones
+	resp := make([]byte, 8)
+	if sensor, ok := s.synthetic[r.Data[0]]; ok {
+		resp[1] = 0x02 | 0x10 // Lower and upper critical
+		resp[3] = sensor.Thresholds.LowerCritical
+		resp[6] = sensor.Thresholds.UpperCritical
+		return resp
+	}
+	switch r.Data[0] {
+	case sensorCPUUsage, sensorActiveMemory:
+		return resp
+	default:
+		return []byte{CompletionCodeDataNotPresent}
+	}
+}
+
+// readPowerUnit reads the power unit sensor, asserting Power Off while the
+// VM is powered off
+func (s *Server) readPowerUnit() []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), directTimeout)
+	defer cancel()
+	state, err := s.vsClient.GetVMPowerState(ctx, s.vm)
+	if err != nil {
+		s.log.Errorf("Failed to get VM power state: %v", err)
+		return []byte{uint8(s.errorCode(err))}
+	}
+
+	var states uint8
+	if state == "poweredOff" {
+		states = powerUnitPowerOff
+	}
+	return []byte{uint8(goipmi.CommandCompleted), 0, sensorEventsEnabled | sensorScanningEnabled, states, 0}
+}
+
+// readUsage reads a usage sensor from the VM's quick stats. A VM that has
+// no stats, e.g. because it is powered off, reads as unavailable.
+func (s *Server) readUsage(sensor uint8) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), directTimeout)
+	defer cancel()
+	stats, err := s.vsClient.GetVMStats(ctx, s.vm)
+	if errors.Is(err, vsphere.ErrStatsUnavailable) {
+		return []byte{uint8(goipmi.CommandCompleted), 0, sensorScanningEnabled | sensorUnavailable, 0}
+	}
+	if err != nil {
+		s.log.Errorf("Failed to get VM stats: %v", err)
+		return []byte{uint8(s.errorCode(err))}
+	}
+
+	value, unit := stats.CPUUsageMHz, cpuReadingMHz
+	if sensor == sensorActiveMemory {
+		value, unit = stats.ActiveMemoryMB, memoryReadingMB
+	}
+	reading := min((value+unit/2)/unit, 0xff)
+	return []byte{uint8(goipmi.CommandCompleted), uint8(reading), sensorScanningEnabled, 0}
+}
+
+// handleGetSDRRepositoryInfo handles IPMI get SDR repository info commands.
+// The repository is filled when the server starts and never changes.
+func (s *Server) handleGetSDRRepositoryInfo(r *request) []byte {
+	info := s.sdrRepo.Info()
+
+	resp := make([]byte, 15)
+	resp[0] = uint8(goipmi.CommandCompleted)
+	resp[1] = sdr.Version
+	binary.LittleEndian.PutUint16(resp[2:], uint16(info.Entries))
+	binary.LittleEndian.PutUint32(resp[6:], sel.Timestamp(info.Added))
+	binary.LittleEndian.PutUint32(resp[10:], sel.Timestamp(time.Time{}))
+	resp[14] = sdrSupportReserve
+	return resp
+}
+
+// handleReserveSDRRepository handles IPMI reserve SDR repository commands
+func (s *Server) handleReserveSDRRepository(r *request) []byte {
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0}
+	binary.LittleEndian.PutUint16(resp[1:], s.sdrRepo.Reserve())
+	return resp
+}
+
+// handleGetSDR handles IPMI get SDR commands. As with SEL entries, a
+// reservation is only required to read a record in parts.
+func (s *Server) handleGetSDR(r *request) []byte {
+	if len(r.Data) < 6 {
+		return []byte{uint8(goipmi.ErrShortPacket)}
+	}
+	reservation := binary.LittleEndian.Uint16(r.Data[0:])
+	id := binary.LittleEndian.Uint16(r.Data[2:])
+	offset, count := int(r.Data[4]), int(r.Data[5])
+
+	if offset != 0 && !s.sdrRepo.Reserved(reservation) {
+		return []byte{CompletionCodeInvalidReservation}
+	}
+
+	data, next, err := s.sdrRepo.Get(id)
+	if err != nil {
+		return []byte{CompletionCodeDataNotPresent}
+	}
+	if offset >= len(data) {
+		return []byte{uint8(goipmi.ErrParamRange)}
+	}
+	if count == 0xff || offset+count > len(data) { // 0xff reads the whole record
+		count = len(data) - offset
+	}
+
+	resp := []byte{uint8(goipmi.CommandCompleted), 0, 0}
+	binary.LittleEndian.PutUint16(resp[1:], next)
+	return append(resp, data[offset:offset+count]...)
+}
@@ -0,0 +1,218 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/sdr"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vbmc-vsphere/vsphere/mock"
+	"github.com/vmware/govmomi/object"
+)
+
+// walkSDR reads every record of the SDR repository, keyed by sensor name
+func walkSDR(t *testing.T, client *goipmi.Client) map[string][]byte {
+	t.Helper()
+	records := make(map[string][]byte)
+	for id := uint16(sdr.FirstRecord); id != sdr.LastRecord; {
+		data, err := send(client, NetworkFunctionStorage, CommandGetSDR, 0, 0, uint8(id), uint8(id>>8), 0, 0xff)
+		if err != nil {
+			t.Fatalf("reading SDR record %d: %v", id, err)
+		}
+		next, record := binary.LittleEndian.Uint16(data), data[2:]
+		body := record[sdr.HeaderSize:]
+		nameAt := 26 // Compact sensor record
+		if record[3] == sdr.RecordTypeFull {
+			nameAt = 42
+		}
+		records[string(body[nameAt+1:nameAt+1+int(body[nameAt]&0x1f)])] = record
+		id = next
+	}
+	return records
+}
+
+func TestSyntheticSensorsInSDRWalk(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.cfg.SyntheticSensors = []config.SensorConfig{
+		{Name: "FAN1", Type: config.SensorFan, Reading: 4200, LowerCritical: 600, UpperCritical: 12000},
+		{Name: "Inlet Temp", Type: config.SensorTemperature, Reading: 24, LowerCritical: 5, UpperCritical: 42},
+	}
+	client := startTestServer(t, s)
+
+	records := walkSDR(t, client)
+	for _, name := range []string{"Power", "CPU Usage", "Active Memory", "FAN1", "Inlet Temp"} {
+		if _, ok := records[name]; !ok {
+			t.Errorf("SDR walk found no %q sensor", name)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		sensorType uint8
+		reading    uint8 // Raw
+		lc, uc     uint8
+	}{
+		{"FAN1", sdr.SensorTypeFan, 42, 6, 120},
+		{"Inlet Temp", sdr.SensorTypeTemperature, 24, 5, 42},
+	} {
+		record, ok := records[tc.name]
+		if !ok {
+			continue
+		}
+		body := record[sdr.HeaderSize:]
+		if body[7] != tc.sensorType || body[26] != tc.reading {
+			t.Errorf("%s has sensor type 0x%02x and nominal reading %d, want 0x%02x and %d", tc.name, body[7], body[26], tc.sensorType, tc.reading)
+		}
+
+		reading, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, body[2])
+		if err != nil {
+			t.Fatalf("reading %s: %v", tc.name, err)
+		}
+		if reading[0] != tc.reading || reading[2] != 0 {
+			t.Errorf("%s reads %d with threshold status 0x%02x, want nominal %d", tc.name, reading[0], reading[2], tc.reading)
+		}
+
+		thresholds, err := send(client, NetworkFunctionSensor, CommandGetSensorThresholds, body[2])
+		if err != nil {
+			t.Fatalf("reading the thresholds of %s: %v", tc.name, err)
+		}
+		if thresholds[2] != tc.lc || thresholds[5] != tc.uc {
+			t.Errorf("%s has critical thresholds %d and %d, want %d and %d", tc.name, thresholds[2], thresholds[5], tc.lc, tc.uc)
+		}
+	}
+}
+
+// readPowerUnit reads the power unit sensor's states
+func readPowerUnit(t *testing.T, client *goipmi.Client) uint8 {
+	t.Helper()
+	resp, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, sensorPowerUnit)
+	if err != nil {
+		t.Fatalf("reading the power unit sensor: %v", err)
+	}
+	return resp[2]
+}
+
+func TestPowerUnitTracksPowerState(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	client := startTestServer(t, s)
+
+	if states := readPowerUnit(t, client); states&powerUnitPowerOff == 0 {
+		t.Errorf("powered off VM reads states 0x%02x, want Power Off asserted", states)
+	}
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	if states := readPowerUnit(t, client); states&powerUnitPowerOff != 0 {
+		t.Errorf("powered on VM reads states 0x%02x, want Power Off deasserted", states)
+	}
+	if err := client.Control(goipmi.ControlPowerDown); err != nil {
+		t.Fatalf("power down: %v", err)
+	}
+	if states := readPowerUnit(t, client); states&powerUnitPowerOff == 0 {
+		t.Errorf("VM powered off again reads states 0x%02x, want Power Off asserted", states)
+	}
+}
+
+func TestUsageSensorsScaleQuickStats(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+
+	for _, sensor := range []uint8{sensorCPUUsage, sensorActiveMemory} {
+		resp, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, sensor)
+		if err != nil {
+			t.Fatalf("reading sensor 0x%02x: %v", sensor, err)
+		}
+		if resp[1]&sensorUnavailable == 0 {
+			t.Errorf("powered off VM reads sensor 0x%02x as % x, want it unavailable", sensor, resp)
+		}
+	}
+
+	state := vc.VM(s.vm)
+	state.PowerState = "poweredOn"
+	for _, tc := range []struct {
+		name   string
+		stats  vsphere.VMStats
+		sensor uint8
+		want   uint8
+	}{
+		{"cpu rounds down", vsphere.VMStats{CPUUsageMHz: 1234}, sensorCPUUsage, 12},
+		{"cpu rounds up", vsphere.VMStats{CPUUsageMHz: 1250}, sensorCPUUsage, 13},
+		{"cpu caps", vsphere.VMStats{CPUUsageMHz: 100000}, sensorCPUUsage, 0xff},
+		{"memory", vsphere.VMStats{ActiveMemoryMB: 2048}, sensorActiveMemory, 16},
+		{"memory caps", vsphere.VMStats{ActiveMemoryMB: 65536}, sensorActiveMemory, 0xff},
+	} {
+		state.Stats = tc.stats
+		vc.SetVM(s.vm, state)
+		resp, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, tc.sensor)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if resp[0] != tc.want || resp[1]&sensorUnavailable != 0 {
+			t.Errorf("%s: read % x, want reading %d", tc.name, resp, tc.want)
+		}
+	}
+}
+
+// slowClient is a fake vSphere client whose power state reads wait until
+// released
+type slowClient struct {
+	*mock.Client
+	started chan struct{} // Receives when a read starts waiting
+	release chan struct{}
+}
+
+// GetVMPowerState waits for the release before reading the power state
+func (c *slowClient) GetVMPowerState(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.started <- struct{}{}
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return c.Client.GetVMPowerState(ctx, vm)
+}
+
+func TestSlowSensorReadingDoesNotBlockListener(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	slow := &slowClient{Client: vc, started: make(chan struct{}, 1), release: make(chan struct{})}
+	s.vsClient = slow
+	client := startTestServer(t, s)
+	other := newTestClient(t, s, "password")
+	if err := other.Open(); err != nil {
+		t.Fatalf("failed to open a second session: %v", err)
+	}
+	defer other.Close()
+
+	read := make(chan uint8)
+	go func() {
+		resp, err := send(client, NetworkFunctionSensor, CommandGetSensorReading, sensorPowerUnit)
+		if err != nil {
+			t.Errorf("reading the power unit sensor: %v", err)
+			close(read)
+			return
+		}
+		read <- resp[2]
+	}()
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sensor reading never reached vCenter")
+	}
+
+	start := time.Now()
+	if _, err := send(other, NetworkFunctionStorage, CommandGetSELInfo); err != nil {
+		t.Fatalf("SEL info during a slow sensor reading: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("SEL info took %s behind a slow sensor reading", d)
+	}
+
+	close(slow.release)
+	if states, ok := <-read; ok && states&powerUnitPowerOff == 0 {
+		t.Errorf("released reading has states 0x%02x, want Power Off asserted", states)
+	}
+}
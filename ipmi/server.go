@@ -2,44 +2,268 @@ package ipmi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"os/exec"
-	"strings"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vmware/govmomi/object"
+	"github.com/vbmc-vsphere/clock"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/netconfig"
+	"github.com/vbmc-vsphere/sdr"
+	"github.com/vbmc-vsphere/sel"
+	"github.com/vbmc-vsphere/syslog"
 	"github.com/vbmc-vsphere/vsphere"
 	goipmi "github.com/ooneko/goipmi"
 )
 
+// errUnsupportedBootDevice is returned for IPMI boot devices with no vSphere equivalent
+var errUnsupportedBootDevice = errors.New("unsupported boot device")
+
+// bootDevices maps IPMI boot device names to boot devices
+var bootDevices = map[string]goipmi.BootDevice{
+	"pxe":    goipmi.BootDevicePxe,
+	"disk":   goipmi.BootDeviceDisk,
+	"cdrom":  goipmi.BootDeviceCdrom,
+	"floppy": goipmi.BootDeviceFloppy,
+}
+
+// ipmiBootDevices maps the device a VM boots from first to its IPMI boot device
+var ipmiBootDevices = map[vsphere.BootDevice]goipmi.BootDevice{
+	vsphere.BootDeviceHDD:    goipmi.BootDeviceDisk,
+	vsphere.BootDeviceCDROM:  goipmi.BootDeviceCdrom,
+	vsphere.BootDevicePXE:    goipmi.BootDevicePxe,
+	vsphere.BootDeviceFloppy: goipmi.BootDeviceFloppy,
+}
+
+// Bits of the first byte of the boot flags parameter
+const (
+	bootFlagsValid      = 0x80
+	bootFlagsPersistent = 0x40 // Applies to all future boots, not just the next
+	bootFlagsEFI        = 0x20 // Boot with EFI rather than legacy BIOS
+)
+
+// bootDeviceMask selects the boot device selector from the second byte of
+// the boot flags parameter, dropping the CMOS clear, keyboard lock, screen
+// blank and reset button lock bits around it
+const bootDeviceMask = 0x3c
+
+// replyGrace is how long Stop lets the last responses be relayed after the
+// commands in flight finish
+const replyGrace = 200 * time.Millisecond
+
+// powerStateTimeout bounds how long to wait for a VM to reach a power state
+const powerStateTimeout = 2 * time.Minute
+
 // Server represents an IPMI server instance
 type Server struct {
 	vm       *object.VirtualMachine
-	vsClient *vsphere.Client
+	key      string // IP database key of the VM
+	vsClient vsphere.VMClient
 	ipmiServer *goipmi.Simulator
+	tcpBridge  *tcpBridge
+	udpFront   *udpFront
 	ip       net.IP
+	port     int // IPMI port listened on
 	netmask  net.IP
 	nic      string
+	netcfg   netconfig.Configurator // Adds and removes the address on the NIC
+	prober   netconfig.Prober       // Checks the address is free before it is added
+	cfg      config.ServerConfig
+	limiter  *Limiter
+	db       *config.IPDB
 	log      *logrus.Entry
+	clock    clock.Clock
+	ctx      context.Context    // Done once Stop is called, ending background waits
+	cancel   context.CancelFunc // Cancels ctx
+
+	assetTagWriter stringWriter
+	uuid           string // VM BIOS UUID, read in Start
+	tag            uint16 // Session ID tag, see sessionTag
+	guid           [16]byte
+	duplicateUUID  bool // Another VM has the same instance UUID
+	user           string
+	password       [16]byte
+	configUser     string                             // Set by SetCredentials, restored by a factory reset
+	configPassword [16]byte                           // Set by SetCredentials, restored by a factory reset
+	userDisabled   bool                               // Set User Password disabled the user, so no new sessions are set up
+	hardware       atomic.Pointer[vsphere.VMHardware] // Cached for Get Device ID and the admin API
+	shuttingDown   atomic.Bool                        // A guest shutdown is being waited on
+	oneTimeBoot    atomic.Bool                        // The boot override is cleared after the next power-on
+	oneTimeSetAt   atomic.Int64                       // Unix nanoseconds the one-time override was set, 0 if restored at startup
+	eventLog       *sel.Log                           // Kept for the server's lifetime
+	sdrRepo        *sdr.Repository                    // Filled in Start
+	synthetic      map[uint8]sdr.Analog               // Synthetic sensors by number, filled in Start
+	summarize      func() metrics.Summary             // Fleet counts the manager BMC's sensors read
+	watchdog       watchdog
+
+	direct   map[directKey]directHandler // Commands answered by the listeners
+	inflight inflight                    // Commands being handled, waited for by Stop
+
+	activeMu sync.Mutex
+	active   bool // The address is claimed and listened on
+
+	fruMu sync.Mutex
+	fru   []byte // FRU image, rebuilt by Get FRU Inventory Area Info
+
+	identifyMu    sync.Mutex
+	identifySeq   uint64 // Counts identify commands, so only the latest one's interval turns identify off
+	identifying   bool   // The VM's notes mark it as being identified
+	identifyState uint8  // Identify state reported in the chassis status
+
+	powerMu   sync.Mutex
+	byCommand string // Power state this BMC last put the VM in, cleared once the VM is found in another
+
+	privClients   map[uint8]vsphere.VMClient // vSphere client by session privilege level
+	sessionMu     sync.Mutex
+	sessions      map[uint32]sessionPrivilege // Privilege by session ID
+	sessionSeq    uint16                      // Low 16 bits of the last session ID handed out
+	sessionHandle uint8                       // Last session handle handed out
 }
 
 // NewServer creates a new IPMI server instance
-func NewServer(vm *object.VirtualMachine, vsClient *vsphere.Client, ip net.IP, netmask net.IP, nic string) *Server {
+func NewServer(vm *object.VirtualMachine, vsClient vsphere.VMClient, ip net.IP, netmask net.IP, cfg config.ServerConfig, limiter *Limiter, db *config.IPDB) *Server {
+	s := newServer(ip, netmask, cfg, logrus.WithField("vm", vm.Name()))
+	s.vm = vm
+	s.key = vsphere.VMKey(vm)
+	s.vsClient = vsClient
+	s.limiter = limiter
+	s.db = db
+	return s
+}
+
+// newServer creates a server listening on ip, without a VM
+func newServer(ip net.IP, netmask net.IP, cfg config.ServerConfig, log *logrus.Entry) *Server {
 	s := &Server{
-		vm:       vm,
-		vsClient: vsClient,
 		ip:       ip,
+		port:     cfg.IPMIPort,
 		netmask:  netmask,
-		nic:      nic,
-		log:      logrus.WithField("vm", vm.Name()),
+		nic:      cfg.NIC,
+		cfg:      cfg,
+		log:      log,
+		clock:    clock.Real{},
+		sessions: make(map[uint32]sessionPrivilege),
+		direct:   make(map[directKey]directHandler),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.eventLog = sel.New(cfg.SELCapacity, s.clock)
+	s.netcfg = netconfig.Netlink{}
+	if cfg.IPConfigurator == config.IPConfiguratorCommand {
+		s.netcfg = netconfig.Command{}
 	}
+	s.prober = netconfig.ARP{}
 
 	return s
 }
 
+// SetConfigurator replaces the configurator that adds and removes the
+// server's address on the NIC. It must be called before Start.
+func (s *Server) SetConfigurator(c netconfig.Configurator) {
+	s.netcfg = c
+}
+
+// SetProber replaces the prober that checks the server's address is free
+// before it is added. It must be called before Start.
+func (s *Server) SetProber(p netconfig.Prober) {
+	s.prober = p
+}
+
+// SetKey replaces the key the VM's entries are stored under in the IP
+// database, e.g. to namespace it by vCenter. It must be called before Start.
+func (s *Server) SetKey(key string) {
+	s.key = key
+}
+
+// Key returns the key the VM's entries are stored under in the IP database
+func (s *Server) Key() string {
+	return s.key
+}
+
+// VM returns the VM the server manages
+func (s *Server) VM() *object.VirtualMachine {
+	return s.vm
+}
+
+// UUID returns the VM's BIOS UUID once the server has started
+func (s *Server) UUID() string {
+	return s.uuid
+}
+
+// limit wraps a vCenter-backed handler so it is rejected with NodeBusy
+// while the shared limiter is saturated
+func (s *Server) limit(handler goipmi.Handler) goipmi.Handler {
+	return func(m *goipmi.Message) goipmi.Response {
+		if !s.limiter.Acquire() {
+			s.log.Warnf("Too many commands in flight, rejecting command 0x%02x", uint8(m.Command))
+			return goipmi.ErrNodeBusy
+		}
+		defer s.limiter.Release()
+		return handler(m)
+	}
+}
+
+// connected wraps a handler that changes the VM so it is rejected with the
+// busy completion code while the VM isn't connected, e.g. while it is still
+// being cloned, and the client retries once vCenter can manage it
+func (s *Server) connected(handler goipmi.Handler) goipmi.Handler {
+	return func(m *goipmi.Message) goipmi.Response {
+		state, err := s.vsClient.GetVMConnectionState(context.Background(), s.vm)
+		if err != nil {
+			s.log.Errorf("Failed to get VM connection state: %v", err)
+			return s.errorCode(err)
+		}
+		if state != "connected" {
+			s.log.Warnf("VM is %s, rejecting command 0x%02x until it is connected", state, uint8(m.Command))
+			return goipmi.CompletionCode(s.cfg.BusyCompletionCode)
+		}
+		return handler(m)
+	}
+}
+
+// errorCode maps a vSphere error to an IPMI completion code
+func (s *Server) errorCode(err error) goipmi.CompletionCode {
+	if errors.Is(err, vsphere.ErrTaskInProgress) {
+		// Like a busy physical BMC, ask the client to retry later
+		return goipmi.CompletionCode(s.cfg.BusyCompletionCode)
+	}
+	if errors.Is(err, vsphere.ErrTaskCanceled) {
+		return goipmi.ErrNoResponse
+	}
+	if errors.Is(err, vsphere.ErrUnreachable) {
+		// A transient BMC timeout tells clients to retry
+		return goipmi.ErrCommandTimeout
+	}
+	if errors.Is(err, vsphere.ErrHardwareState) || errors.Is(err, vsphere.ErrNMIUnavailable) || errors.Is(err, vsphere.ErrSuspendState) {
+		return goipmi.ErrInvalidState
+	}
+	if errors.Is(err, vsphere.ErrHardwareLimit) {
+		return goipmi.ErrParamRange
+	}
+	return goipmi.ErrUnspecified
+}
+
+// CancelTask cancels the vCenter task currently running for this BMC's VM.
+// The IPMI command waiting on it fails with "command response could not be
+// provided" (0xCE).
+func (s *Server) CancelTask(ctx context.Context) error {
+	err := s.vsClient.CancelTask(ctx, s.vm)
+	for _, client := range s.privClients {
+		if !errors.Is(err, vsphere.ErrNoTask) {
+			break
+		}
+		err = client.CancelTask(ctx, s.vm) // Started by a privilege-level client
+	}
+	return err
+}
+
 // handleChassisControl handles IPMI chassis control commands
-func (s *Server) handleChassisControl(m *goipmi.Message) goipmi.Response {
+func (s *Server) handleChassisControl(m *goipmi.Message) (response goipmi.Response) {
 	s.log.Debug("Handling chassis control command")
 
 	// Parse command
@@ -48,38 +272,68 @@ func (s *Server) handleChassisControl(m *goipmi.Message) goipmi.Response {
 		s.log.Errorf("Failed to parse chassis control request: %v", err)
 		return goipmi.ErrInvalidCommand
 	}
+	if action, ok := controlActions[req.ChassisControl]; ok {
+		defer func() { metrics.CountPowerAction(action, response == goipmi.CommandCompleted) }()
+	}
 
 	ctx := context.Background()
+	vc := s.client(m)
 	switch req.ChassisControl {
 	case goipmi.ControlPowerDown: // PowerDown
-		s.log.Info("Power down command received")
-		if err := s.vsClient.PowerOffVM(ctx, s.vm); err != nil {
+		s.log.WithField(syslog.EventField, "power_off").Info("Power down command received")
+		if s.cfg.GracefulShutdown > 0 {
+			if err := s.gracefulPowerDown(ctx, vc); err != nil {
+				s.log.Errorf("Failed to power down VM: %v", err)
+				return s.errorCode(err)
+			}
+			s.logPower(false)
+			break
+		}
+		if err := vc.PowerOffVM(ctx, s.vm); err != nil {
 			s.log.Errorf("Failed to power off VM: %v", err)
-			return goipmi.ErrUnspecified
+			return s.errorCode(err)
 		}
+		s.logPower(false)
 	case goipmi.ControlPowerUp: // PowerUp
-		s.log.Info("Power up command received")
-		if err := s.vsClient.PowerOnVM(ctx, s.vm); err != nil {
+		s.log.WithField(syslog.EventField, "power_on").Info("Power up command received")
+		boots := s.oneTimeBoot.Load() && s.poweredOff(ctx, vc)
+		if err := vc.PowerOnVM(ctx, s.vm); err != nil {
 			s.log.Errorf("Failed to power on VM: %v", err)
-			return goipmi.ErrUnspecified
+			return s.errorCode(err)
+		}
+		s.logPower(true)
+		if boots {
+			s.consumeOneTimeBoot(ctx, vc)
+		}
+	case goipmi.ControlPowerAcpiSoft: // Soft shutdown
+		s.log.WithField(syslog.EventField, "soft_off").Info("Soft shutdown command received")
+		if err := s.softOff(ctx, vc, s.guestShutdownConfig(ctx)); err != nil {
+			s.log.Errorf("Failed to shut down guest: %v", err)
+			return s.errorCode(err)
 		}
+		s.logPower(false)
 	case goipmi.ControlPowerHardReset: // HardReset
-		s.log.Info("Reset command received")
-		if err := s.vsClient.ResetVM(ctx, s.vm); err != nil {
+		s.log.WithField(syslog.EventField, "reset").Info("Reset command received")
+		if err := s.reset(ctx, vc); err != nil {
 			s.log.Errorf("Failed to reset VM: %v", err)
-			return goipmi.ErrUnspecified
+			return s.errorCode(err)
 		}
+		s.logEvent(selReset)
+		s.consumeOneTimeBoot(ctx, vc)
 	case goipmi.ControlPowerCycle: // PowerCycle
-		s.log.Info("Power cycle command received")
-		// Power cycle is implemented as power off followed by power on
-		if err := s.vsClient.PowerOffVM(ctx, s.vm); err != nil {
-			s.log.Errorf("Failed to power off VM during cycle: %v", err)
-			return goipmi.ErrUnspecified
+		s.log.WithField(syslog.EventField, "power_cycle").Info("Power cycle command received")
+		if err := s.powerCycle(ctx, vc); err != nil {
+			s.log.Errorf("Failed to power cycle VM: %v", err)
+			return s.errorCode(err)
 		}
-		if err := s.vsClient.PowerOnVM(ctx, s.vm); err != nil {
-			s.log.Errorf("Failed to power on VM during cycle: %v", err)
-			return goipmi.ErrUnspecified
+	case goipmi.ControlPowerPulseDiag: // Diagnostic interrupt
+		s.log.WithField(syslog.EventField, "diag_interrupt").Info("Diagnostic interrupt command received")
+		if err := vc.SendNMI(ctx, s.vm); err != nil {
+			s.log.Errorf("Failed to send NMI to VM: %v", err)
+			return s.errorCode(err)
 		}
+	case ControlSuspend:
+		return s.suspend(ctx, vc)
 	default:
 		s.log.Warnf("Unsupported chassis control command: %v", req.ChassisControl)
 		return goipmi.ErrInvalidCommand
@@ -88,6 +342,65 @@ func (s *Server) handleChassisControl(m *goipmi.Message) goipmi.Response {
 	return goipmi.CommandCompleted	
 }
 
+// powerCycle powers the VM off and waits for it to report poweredOff. It
+// returns then, powering the VM back on in the background after the power
+// cycle delay, so the delay doesn't hold up the simulator. A one-time boot
+// device is consumed by the power-on. Stopping the server ends the delay
+// early rather than leaving the VM off.
+func (s *Server) powerCycle(ctx context.Context, vc vsphere.VMClient) error {
+	if err := vc.PowerOffVM(ctx, s.vm); err != nil {
+		return fmt.Errorf("failed to power off VM: %w", err)
+	}
+	// Wait for the VM to report poweredOff, then give the hypervisor time
+	// to release resources before powering back on
+	waitCtx, cancel := context.WithTimeout(ctx, powerStateTimeout)
+	err := vc.WaitForPowerState(waitCtx, s.vm, "poweredOff")
+	cancel()
+	if err != nil {
+		return fmt.Errorf("VM did not power off: %w", err)
+	}
+	s.logPower(false)
+
+	if !s.inflight.begin() {
+		return fmt.Errorf("failed to power on VM: server is stopping")
+	}
+	go func() {
+		defer s.inflight.end()
+		select {
+		case <-s.ctx.Done():
+		case <-s.clock.After(time.Duration(s.cfg.PowerCycleDelay) * time.Second):
+		}
+
+		ctx := context.WithoutCancel(ctx)
+		if err := vc.PowerOnVM(ctx, s.vm); err != nil {
+			s.log.Errorf("Failed to power on VM after power cycle: %v", err)
+			return
+		}
+		s.logPower(true)
+		s.consumeOneTimeBoot(ctx, vc)
+	}()
+	return nil
+}
+
+// controlActions names the chassis control actions in the metrics
+var controlActions = map[goipmi.ChassisControl]string{
+	goipmi.ControlPowerDown:      "power_down",
+	goipmi.ControlPowerUp:        "power_up",
+	goipmi.ControlPowerCycle:     "power_cycle",
+	goipmi.ControlPowerHardReset: "hard_reset",
+	goipmi.ControlPowerPulseDiag: "diag_interrupt",
+	goipmi.ControlPowerAcpiSoft:  "soft_off",
+	ControlSuspend:               "suspend",
+}
+
+// Bits of the chassis status beyond the current power state
+const (
+	lastPowerByCommand = 0x10 // Last power event: the VM was last powered on or off through the BMC
+	identifySupported  = 0x40 // Misc chassis state: the identify state is reported
+	identifyTimed      = 0x10 // Misc chassis state: identify is on for an interval
+	identifyIndefinite = 0x20 // Misc chassis state: identify is on until turned off
+)
+
 // handleGetChassisStatus handles IPMI get chassis status commands
 func (s *Server) handleGetChassisStatus(m *goipmi.Message) goipmi.Response {
 	s.log.Debug("Getting chassis status")
@@ -96,18 +409,64 @@ func (s *Server) handleGetChassisStatus(m *goipmi.Message) goipmi.Response {
 	powerState, err := s.vsClient.GetVMPowerState(ctx, s.vm)
 	if err != nil {
 		s.log.Errorf("Failed to get power state: %v", err)
-		return goipmi.ErrUnspecified
+		return s.errorCode(err)
 	}
 
 	// Return chassis status
 	var powerStateByte byte
-	if powerState == "poweredOn" {
+	switch powerState {
+	case "poweredOn":
 		powerStateByte = goipmi.SystemPower
+	case "suspended":
+		powerStateByte = chassisPowerSuspended
+	}
+	powerStateByte |= s.restoreStatus()
+
+	// The spec only defines the bit for power on, but ipmitool reports it
+	// as the last power event being a command, so it is kept after a power
+	// off through the BMC too
+	var lastPowerEvent byte
+	s.powerMu.Lock()
+	if s.byCommand != "" && s.byCommand != powerState {
+		s.byCommand = "" // Powered on or off in vCenter since
 	}
+	if s.byCommand != "" {
+		lastPowerEvent = lastPowerByCommand
+	}
+	s.powerMu.Unlock()
+
+	s.identifyMu.Lock()
+	state := identifySupported | s.identifyState
+	s.identifyMu.Unlock()
 
 	return &goipmi.ChassisStatusResponse{
 		CompletionCode: goipmi.CommandCompleted,
 		PowerState:     powerStateByte,
+		LastPowerEvent: lastPowerEvent,
+		State:          state,
+	}
+}
+
+// logPower records a power on or off performed through the BMC for the
+// chassis status and the power restore policy, and logs it to the SEL
+func (s *Server) logPower(on bool) {
+	state := "poweredOff"
+	if on {
+		state = "poweredOn"
+	}
+	s.powerMu.Lock()
+	s.byCommand = state
+	s.powerMu.Unlock()
+
+	// Keep the last known state for the restore-previous policy
+	if err := s.db.SetPowerStates(map[string]string{s.key: state}); err != nil {
+		s.log.Errorf("Failed to record power state: %v", err)
+	}
+
+	if on {
+		s.logEvent(selPowerOn)
+	} else {
+		s.logEvent(selPowerOff)
 	}
 }
 
@@ -127,11 +486,176 @@ func (s *Server) handleSetSystemBootOptions(m *goipmi.Message) goipmi.Response {
 		return &goipmi.SetSystemBootOptionsResponse{CompletionCode: goipmi.CommandCompleted} // Ignore non-boot flags parameters
 	}
 
+	if len(req.Data) < 2 {
+		return goipmi.ErrShortPacket
+	}
+	ipmiDevice := goipmi.BootDevice(req.Data[1] & bootDeviceMask)
+	if ipmiDevice == goipmi.BootDeviceNone { // No override
+		return &goipmi.SetSystemBootOptionsResponse{CompletionCode: goipmi.CommandCompleted}
+	}
+
+	persistent := req.Data[0]&bootFlagsPersistent != 0
+
+	// Switch the VM to EFI if asked to. Legacy boot is what clients ask for
+	// when they don't care, so it leaves the firmware alone.
+	ctx := context.Background()
+	if req.Data[0]&bootFlagsEFI != 0 {
+		s.requireEFI(ctx, s.client(m))
+	}
+
+	// Set the boot device
+	if err := s.setBootDevice(ctx, s.client(m), ipmiDevice); err != nil {
+		if errors.Is(err, errUnsupportedBootDevice) || errors.Is(err, vsphere.ErrNoBootDevice) {
+			s.log.Warnf("Unsupported boot device: %v", ipmiDevice)
+			return goipmi.ErrInvalidObjCommand
+		}
+		s.log.Errorf("Failed to set boot device: %v", err)
+		return s.errorCode(err)
+	}
+	s.setOneTimeBoot(!persistent)
+	if persistent {
+		s.log.WithField(syslog.EventField, "boot_device").Infof("Boot device set to %s (persistent)", ipmiDevice)
+	} else {
+		s.log.WithField(syslog.EventField, "boot_device").Infof("Boot device set to %s for the next boot", ipmiDevice)
+	}
+
+	return &goipmi.SetSystemBootOptionsResponse{CompletionCode: goipmi.CommandCompleted}
+}
+
+// handleGetSystemBootOptions reports the boot flags parameter from the
+// VM's current boot order, so a device set through any path reads back.
+// The simulator's handler only echoes data stored by its own set handler,
+// which is replaced.
+func (s *Server) handleGetSystemBootOptions(m *goipmi.Message) goipmi.Response {
+	req := &goipmi.SystemBootOptionsRequest{}
+	if err := m.Request(req); err != nil {
+		return err
+	}
+
+	param := req.Param & 0x7f // Bit 7 is reserved in requests
+	switch param {
+	case goipmi.BootParamSetInProgress:
+		return &goipmi.SystemBootOptionsResponse{
+			CompletionCode: goipmi.CommandCompleted,
+			Version:        0x01,
+			Param:          param,
+			Data:           []uint8{0x00}, // Set complete
+		}
+	case goipmi.BootParamBootFlags:
+	default:
+		return goipmi.CompletionCode(CompletionCodeParamUnsupported)
+	}
+
+	device, err := s.vsClient.GetNextBoot(context.Background(), s.vm)
+	if err != nil {
+		s.log.Errorf("Failed to get boot device: %v", err)
+		return s.errorCode(err)
+	}
+
+	// An empty boot order is reported as no override
+	data := make([]uint8, 5)
+	if ipmiDevice, ok := ipmiBootDevices[device]; ok {
+		data[0] = bootFlagsValid
+		if !s.oneTimeBoot.Load() {
+			data[0] |= bootFlagsPersistent
+		}
+		data[1] = uint8(ipmiDevice)
+
+		firmware, err := s.vsClient.GetFirmware(context.Background(), s.vm)
+		if err != nil {
+			s.log.Warnf("Failed to get VM firmware, reporting legacy boot: %v", err)
+		} else if firmware == vsphere.FirmwareEFI {
+			data[0] |= bootFlagsEFI
+		}
+	}
+	return &goipmi.SystemBootOptionsResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		Version:        0x01,
+		Param:          param,
+		Data:           data,
+	}
+}
+
+// requireEFI switches the VM to EFI firmware for an EFI boot request. The
+// firmware can only change while the VM is powered off, so a running VM
+// keeps its firmware with a warning and the boot device is set regardless.
+func (s *Server) requireEFI(ctx context.Context, vc vsphere.VMClient) {
+	err := vc.SetFirmware(ctx, s.vm, vsphere.FirmwareEFI)
+	switch {
+	case errors.Is(err, vsphere.ErrFirmwareState):
+		s.log.Warnf("EFI boot requested, but the VM can't switch firmware while powered on: %v", err)
+	case err != nil:
+		s.log.Warnf("EFI boot requested, but switching the VM to EFI failed: %v", err)
+	}
+}
+
+// setOneTimeBoot records whether the boot override applies to the next
+// boot only. It is kept in the IP database so the override is still
+// cleared after that boot if the service restarts in between.
+func (s *Server) setOneTimeBoot(oneTime bool) {
+	if oneTime {
+		s.oneTimeSetAt.Store(s.clock.Now().UnixNano())
+	}
+	s.oneTimeBoot.Store(oneTime)
+	if err := s.db.SetOneTimeBoot(s.key, oneTime); err != nil {
+		s.log.Errorf("Failed to record one-time boot override: %v", err)
+	}
+}
+
+// poweredOff reports whether the VM is powered off, so powering it on boots
+// it. Powering on a running VM does nothing and a suspended VM resumes
+// where it left off, so neither uses a one-time boot device. A VM whose
+// state can't be read is assumed not to boot, keeping the device pending.
+func (s *Server) poweredOff(ctx context.Context, vc vsphere.VMClient) bool {
+	state, err := vc.GetVMPowerState(ctx, s.vm)
+	if err != nil {
+		s.log.Warnf("Failed to get power state before power up, keeping any one-time boot device: %v", err)
+	}
+	return err == nil && state == "poweredOff"
+}
+
+// consumeOneTimeBoot clears a one-time boot override once the VM has been
+// powered on with it. vSphere reads the boot order when the VM powers on or
+// resets, so the override still applies to the boot in progress and the
+// following boot uses the default devices. Power-ons from outside the BMC
+// are only seen through ObservePowerOn, and guest reboots not at all.
+func (s *Server) consumeOneTimeBoot(ctx context.Context, vc vsphere.VMClient) {
+	if !s.oneTimeBoot.CompareAndSwap(true, false) {
+		return
+	}
+	if err := vc.ClearBootOrder(ctx, s.vm); err != nil {
+		s.oneTimeBoot.Store(true) // Try again on the next power-on
+		s.log.Errorf("Failed to clear one-time boot device: %v", err)
+		return
+	}
+	if err := s.db.SetOneTimeBoot(s.key, false); err != nil {
+		s.log.Errorf("Failed to record one-time boot override: %v", err)
+	}
+	s.log.WithField(syslog.EventField, "boot_device").Info("One-time boot device used, boot order cleared")
+}
+
+// ObservePowerOn consumes a pending one-time boot device after the VM was
+// seen powered off at offAt and found powered on since, without this BMC,
+// e.g. from vCenter. A device set after offAt may have been set once the
+// VM was already on, for the boot after, so it is kept.
+func (s *Server) ObservePowerOn(ctx context.Context, offAt time.Time) {
+	if !s.oneTimeBoot.Load() || s.oneTimeSetAt.Load() > offAt.UnixNano() {
+		return
+	}
+	s.log.Info("VM was powered on outside this BMC")
+	s.consumeOneTimeBoot(ctx, s.vsClient)
+}
+
+// setBootDevice applies an IPMI boot device to the VM, using the configured
+// boot order for the device when there is one
+func (s *Server) setBootDevice(ctx context.Context, vc vsphere.VMClient, device goipmi.BootDevice) error {
+	if order, ok := s.cfg.BootOrder[device.String()]; ok {
+		return vc.SetBootOrder(ctx, s.vm, order)
+	}
+
 	// Map IPMI boot device to vSphere boot device
 	var bootDevice vsphere.BootDevice
-	switch goipmi.BootDevice(req.Data[1]) { // Mask out persistent/EFI bits
-	case goipmi.BootDeviceNone: // No override
-		return &goipmi.SetSystemBootOptionsResponse{CompletionCode: goipmi.CommandCompleted}
+	switch device {
 	case goipmi.BootDeviceDisk:
 		bootDevice = vsphere.BootDeviceHDD
 	case goipmi.BootDeviceCdrom:
@@ -141,68 +665,156 @@ func (s *Server) handleSetSystemBootOptions(m *goipmi.Message) goipmi.Response {
 	case goipmi.BootDeviceFloppy:
 		bootDevice = vsphere.BootDeviceFloppy
 	default:
-		s.log.Warnf("Unsupported boot device: %v", req.Data[1])
-		return goipmi.ErrInvalidObjCommand
+		return errUnsupportedBootDevice
 	}
 
-	// Set the boot device
-	ctx := context.Background()
-	if err := s.vsClient.SetNextBoot(ctx, s.vm, bootDevice); err != nil {
-		s.log.Errorf("Failed to set boot device: %v", err)
-		return goipmi.ErrUnspecified
+	err := vc.SetNextBoot(ctx, s.vm, bootDevice, s.pxeNetwork(ctx))
+	if errors.Is(err, vsphere.ErrNoBootDevice) && device == goipmi.BootDeviceFloppy && s.cfg.FloppyFallback != "" {
+		s.log.Infof("VM has no floppy drive, booting from %s instead", s.cfg.FloppyFallback)
+		return s.setBootDevice(ctx, vc, bootDevices[s.cfg.FloppyFallback])
 	}
+	return err
+}
 
-	return &goipmi.SetSystemBootOptionsResponse{CompletionCode: goipmi.CommandCompleted}
+// pxeNetwork returns the NIC or network PXE boots from, preferring the
+// VM's custom attribute over the global setting
+func (s *Server) pxeNetwork(ctx context.Context) string {
+	if s.cfg.PXENetworkAttribute == "" {
+		return s.cfg.PXENetwork
+	}
+	network, err := s.vsClient.GetCustomAttribute(ctx, s.vm, s.cfg.PXENetworkAttribute)
+	if err != nil {
+		s.log.Warnf("Failed to read PXE network attribute, using default: %v", err)
+		return s.cfg.PXENetwork
+	}
+	if network == "" {
+		return s.cfg.PXENetwork
+	}
+	return network
+}
+
+// applyDefaultBootDevice sets the configured default boot device unless the
+// VM already has a boot order. Once applied the VM has a boot order, so
+// restarts don't apply it again.
+func (s *Server) applyDefaultBootDevice(ctx context.Context) {
+	if s.cfg.DefaultBootDevice == "" {
+		return
+	}
+
+	hasOverride, err := s.vsClient.HasBootOrder(ctx, s.vm)
+	if err != nil {
+		s.log.Errorf("Failed to check boot order: %v", err)
+		return
+	}
+	if hasOverride {
+		s.log.Debug("VM already has a boot order, not applying default boot device")
+		return
+	}
+
+	if err := s.setBootDevice(ctx, s.vsClient, bootDevices[s.cfg.DefaultBootDevice]); err != nil {
+		s.log.Errorf("Failed to apply default boot device %s: %v", s.cfg.DefaultBootDevice, err)
+		return
+	}
+	s.log.Infof("Applied default boot device %s", s.cfg.DefaultBootDevice)
 }
 
 // Start starts the IPMI server
-// configureIP configures the IP address on the specified network interface
+// configureIP configures the IP address on the specified network interface.
+// When addresses are managed externally, it only checks the address exists.
 func (s *Server) configureIP() error {
-	// Check if IP already exists
-	checkCmd := exec.Command("ip", "addr", "show", "dev", s.nic)
-	checkOutput, err := checkCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to check IP configuration on %s: %v - %s", 
-			s.nic, err, string(checkOutput))
+	if !s.cfg.ManagesIPs() {
+		return s.checkIPPresent()
+	}
+	if err := s.probeIP(); err != nil {
+		return err
+	}
+
+	err := s.netcfg.AddAddress(s.nic, s.ipNet())
+	switch {
+	case errors.Is(err, netconfig.ErrExists):
+		s.log.Infof("IP %s already configured on interface %s, skipping configuration", s.ip, s.nic)
+		return nil
+	case errors.Is(err, netconfig.ErrPermission):
+		return fmt.Errorf("not permitted to configure IP %s on %s, CAP_NET_ADMIN is required: %w", s.ip, s.nic, err)
+	case err != nil:
+		return fmt.Errorf("failed to configure IP %s on %s: %w", s.ip, s.nic, err)
 	}
 
-	// Check if our IP is already in the output
-	if strings.Contains(string(checkOutput), s.ip.String()) {
-		s.log.Infof("IP %s already configured on interface %s, skipping configuration", 
-			s.ip.String(), s.nic)
+	s.log.Infof("Configured IP %s on interface %s", s.ipNet(), s.nic)
+	return nil
+}
+
+// probeIP returns netconfig.ErrInUse if another host answers for the
+// address, so a BMC doesn't knock a real host off the network. Addresses
+// already on this host, e.g. left behind by a crash, aren't probed; a
+// probe that fails is logged and the address added anyway.
+func (s *Server) probeIP() error {
+	if !s.cfg.ConflictProbe.Enabled || localIP(s.ip) {
+		return nil
+	}
+	timeout := time.Duration(s.cfg.ConflictProbe.TimeoutMs) * time.Millisecond
+	inUse, err := s.prober.Probe(s.nic, s.ip, timeout)
+	if err != nil {
+		s.log.Warnf("Failed to probe IP %s for conflicts, adding it anyway: %v", s.ip, err)
 		return nil
 	}
+	if inUse {
+		s.log.WithField(syslog.EventField, "ip_conflict").
+			Errorf("IP %s is already used by another host on %s, not starting the BMC", s.ip, s.nic)
+		return fmt.Errorf("%w: %s", netconfig.ErrInUse, s.ip)
+	}
+	return nil
+}
 
-	// Use ip command to add IP address
-	cmd := exec.Command("ip", "addr", "add", 
-		fmt.Sprintf("%s/%s", s.ip.String(), s.netmask.String()), 
-		"dev", s.nic)
-	
-	output, err := cmd.CombinedOutput()
+// localIP reports whether ip is configured on an interface of this host
+func localIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return fmt.Errorf("failed to configure IP %s on %s: %v - %s", 
-			s.ip.String(), s.nic, err, string(output))
+		return false
 	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
 
-	s.log.Infof("Configured IP %s with netmask %s on interface %s", 
-		s.ip.String(), s.netmask.String(), s.nic)
-	return nil
+// ipNet returns the address with its mask, e.g. 192.168.1.10/24
+func (s *Server) ipNet() *net.IPNet {
+	return &net.IPNet{IP: s.ip, Mask: net.IPMask(s.netmask)}
 }
 
-// cleanupIP removes the IP address from the network interface
+// checkIPPresent returns an error unless the address is configured on
+// some interface, so it can be listened on
+func (s *Server) checkIPPresent() error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list interface addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(s.ip) {
+			s.log.Infof("Using externally managed IP %s", s.ip)
+			return nil
+		}
+	}
+	return fmt.Errorf("IP %s is not configured on any interface and is not managed by the BMC", s.ip)
+}
+
+// cleanupIP removes the IP address from the network interface, unless
+// addresses are managed externally
 func (s *Server) cleanupIP() error {
-	if s.ip == nil || s.nic == "" {
+	if !s.cfg.ManagesIPs() || s.ip == nil || s.nic == "" {
 		return nil
 	}
 
-	cmd := exec.Command("ip", "addr", "del", 
-		fmt.Sprintf("%s/%s", s.ip.String(), s.netmask.String()), 
-		"dev", s.nic)
-	
-	output, err := cmd.CombinedOutput()
+	err := s.netcfg.RemoveAddress(s.nic, s.ipNet())
+	if errors.Is(err, netconfig.ErrNotFound) {
+		s.log.Infof("IP %s already removed from interface %s", s.ip, s.nic)
+		return nil
+	}
 	if err != nil {
-		s.log.Errorf("Failed to remove IP %s from %s: %v - %s", 
-			s.ip.String(), s.nic, err, string(output))
+		s.log.Errorf("Failed to remove IP %s from %s: %v", s.ip, s.nic, err)
 		return err
 	}
 
@@ -210,45 +822,227 @@ func (s *Server) cleanupIP() error {
 	return nil
 }
 
+// newSimulator creates the simulator and returns the func registering its
+// handlers. The simulator can't safely parse every packet, so it stays on
+// loopback behind listeners that drop malformed packets first.
+func (s *Server) newSimulator() func(goipmi.NetworkFunction, goipmi.Command, goipmi.Handler) {
+	s.ipmiServer = goipmi.NewSimulator(net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	return func(netfn goipmi.NetworkFunction, command goipmi.Command, handler goipmi.Handler) {
+		s.ipmiServer.SetHandler(netfn, command, s.guard(handler))
+	}
+}
+
+// handleSessions registers the handlers setting up sessions and managing
+// the configured user. The session tag must be set first.
+func (s *Server) handleSessions(handle func(goipmi.NetworkFunction, goipmi.Command, goipmi.Handler)) {
+	// Replace built-in handlers that don't validate their requests
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandSetSessionPrivilegeLevel, s.authorize(s.handleSetSessionPrivilege))
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandCloseSession, s.handleCloseSession)
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandGetUserName, s.handleGetUserName)
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandSetUserName, s.authorize(s.handleSetUserName))
+
+	// Register handlers listing and changing the configured user
+	handle(goipmi.NetworkFunctionApp, CommandGetUserAccess, s.authorize(s.handleGetUserAccess))
+	handle(goipmi.NetworkFunctionApp, CommandSetUserPassword, s.authorize(s.handleSetUserPassword))
+
+	// Check credentials when sessions are set up
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandGetSessionChallenge, s.handleGetSessionChallenge)
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandActivateSession, s.handleActivateSession)
+
+	// Register handler for session info, which some clients poll as a keepalive
+	handle(goipmi.NetworkFunctionApp, CommandGetSessionInfo, s.authorize(s.handleGetSessionInfo))
+
+	// Register handler for cipher suite discovery, sent before a session exists
+	handle(goipmi.NetworkFunctionApp, CommandGetChannelCipherSuites, s.handleGetChannelCipherSuites)
+}
+
+// Start starts the IPMI simulator on loopback and, unless the server
+// starts in standby, activates it. The manager BMC always activates.
 func (s *Server) Start(ctx context.Context) error {
-	// Configure IP address on the interface
-	if err := s.configureIP(); err != nil {
-		return fmt.Errorf("failed to configure IP: %v", err)
+	if s.vm == nil {
+		return s.startManager(ctx)
 	}
 
-	addr := net.UDPAddr{
-		Port: 623, // Standard IPMI port
-		IP:   s.ip,
+	// Identify the VM by UUID too, so its log can be followed across renames
+	var uuid string
+	if id, err := s.vsClient.GetVMIdentity(ctx, s.vm); err != nil {
+		s.log.Warnf("Failed to get VM UUID: %v", err)
+	} else {
+		uuid = id.UUID
+	}
+	s.uuid = uuid
+	if uuid != "" {
+		s.log = s.log.WithField("vm_uuid", uuid)
+	}
+
+	// A one-time boot override set before a restart is still cleared
+	// after the boot it was for
+	oneTime, err := s.db.GetOneTimeBoot(s.key)
+	if err != nil {
+		s.log.Warnf("Failed to read one-time boot override: %v", err)
 	}
+	s.oneTimeBoot.Store(oneTime)
 
-	// Create new IPMI simulator
-	s.ipmiServer = goipmi.NewSimulator(addr)
+	// Tag session IDs with the VM so captures can be correlated
+	handle := s.newSimulator()
+	s.tag = s.sessionTag(uuid)
+	s.handleSessions(handle)
+
+	// Register handlers for the GUID, derived once from the VM's instance UUID
+	s.guid = s.vmGUID(ctx, uuid)
+	handle(goipmi.NetworkFunctionApp, CommandGetDeviceGUID, s.handleGetGUID)
+	handle(goipmi.NetworkFunctionApp, CommandGetSystemGUID, s.handleGetGUID)
 
 	// Register handlers for chassis operations
-	s.ipmiServer.SetHandler(goipmi.NetworkFunctionChassis, goipmi.CommandChassisControl, s.handleChassisControl)
-	s.ipmiServer.SetHandler(goipmi.NetworkFunctionChassis, goipmi.CommandChassisStatus, s.handleGetChassisStatus)
-	s.ipmiServer.SetHandler(goipmi.NetworkFunctionChassis, goipmi.CommandSetSystemBootOptions, s.handleSetSystemBootOptions)
+	handle(goipmi.NetworkFunctionChassis, goipmi.CommandChassisControl, s.authorize(s.limit(s.connected(s.handleChassisControl))))
+	handle(goipmi.NetworkFunctionChassis, goipmi.CommandChassisStatus, s.authorize(s.limit(s.handleGetChassisStatus)))
+	handle(goipmi.NetworkFunctionChassis, goipmi.CommandSetSystemBootOptions, s.authorize(s.limit(s.connected(s.handleSetSystemBootOptions))))
+	handle(goipmi.NetworkFunctionChassis, goipmi.CommandGetSystemBootOptions, s.authorize(s.limit(s.handleGetSystemBootOptions)))
+	handle(goipmi.NetworkFunctionChassis, CommandChassisIdentify, s.authorize(s.limit(s.handleChassisIdentify)))
+	handle(goipmi.NetworkFunctionChassis, CommandSetPowerRestorePolicy, s.authorize(s.handleSetPowerRestorePolicy))
+
+	// Register handlers for the watchdog timer, which guests running a
+	// watchdog driver reset periodically
+	handle(goipmi.NetworkFunctionApp, CommandSetWatchdogTimer, s.authorize(s.handleSetWatchdogTimer))
+	handle(goipmi.NetworkFunctionApp, CommandGetWatchdogTimer, s.authorize(s.handleGetWatchdogTimer))
+	handle(goipmi.NetworkFunctionApp, CommandResetWatchdogTimer, s.authorize(s.handleResetWatchdogTimer))
+
+	// Register handler for device ID, which reports the VM's hardware sizing.
+	// Clients use it as a keepalive, so it isn't limited as a whole.
+	handle(goipmi.NetworkFunctionApp, goipmi.CommandGetDeviceID, s.handleGetDeviceID)
+
+	// Register handlers for system info parameters
+	handle(goipmi.NetworkFunctionApp, CommandGetSystemInfoParameters, s.authorize(s.limit(s.handleGetSystemInfoParameters)))
+	handle(goipmi.NetworkFunctionApp, CommandSetSystemInfoParameters, s.authorize(s.limit(s.handleSetSystemInfoParameters)))
+
+	// Register handlers for the SEL
+	s.handleSEL()
+
+	// Register handlers for the sensors and the repository describing them
+	records := slices.Clone(sensorRecords)
+	s.synthetic = make(map[uint8]sdr.Analog, len(s.cfg.SyntheticSensors))
+	for _, sensor := range syntheticSensors(s.cfg.SyntheticSensors) {
+		s.synthetic[sensor.Number] = sensor
+		records = append(records, sensor)
+	}
+	s.sdrRepo = sdr.New(s.clock.Now(), records...)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSDRRepositoryInfo, s.handleGetSDRRepositoryInfo)
+	s.handleDirect(NetworkFunctionStorage, CommandReserveSDRRepository, s.handleReserveSDRRepository)
+	s.handleDirect(NetworkFunctionStorage, CommandGetSDR, s.handleGetSDR)
+	s.handleDirect(NetworkFunctionSensor, CommandGetSensorReading, s.limitDirect(s.handleGetSensorReading))
+	s.handleDirect(NetworkFunctionSensor, CommandGetSensorThresholds, s.handleGetSensorThresholds)
+
+	// Register handlers for the FRU, built from the VM's identity
+	s.handleDirect(NetworkFunctionStorage, CommandGetFRUInventoryAreaInfo, s.limitDirect(s.handleGetFRUInventoryAreaInfo))
+	s.handleDirect(NetworkFunctionStorage, CommandReadFRUData, s.limitDirect(s.handleReadFRUData))
 
 	// Start the simulator
 	if err := s.ipmiServer.Run(); err != nil {
 		return fmt.Errorf("failed to start IPMI simulator: %v", err)
 	}
 
-	s.log.Infof("IPMI simulator listening on %s:623", s.ip)
+	if s.cfg.Startup == config.StartupStandby {
+		s.log.Infof("IPMI simulator ready in standby, not claiming %s until activated", s.ip)
+		return nil
+	}
+	return s.Activate(ctx)
+}
+
+// Activate claims the BMC's address and starts listening on it. Activating
+// an active server does nothing.
+func (s *Server) Activate(ctx context.Context) error {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if s.active {
+		return nil
+	}
+
+	// Configure IP address on the interface
+	if err := s.configureIP(); err != nil {
+		return fmt.Errorf("failed to configure IP: %w", err)
+	}
+
+	// Start the UDP listener unless only TCP was requested
+	if s.cfg.Transport != config.TransportTCP {
+		front, err := newUDPFront(&net.UDPAddr{IP: s.ip, Port: s.port}, s.ipmiServer.LocalAddr(), s.answer, s.log)
+		if err != nil {
+			_ = s.cleanupIP()
+			return fmt.Errorf("failed to start IPMI UDP listener: %v", err)
+		}
+		s.udpFront = front
+	}
+
+	// Start the TCP bridge if requested
+	if s.cfg.Transport == config.TransportTCP || s.cfg.Transport == config.TransportBoth {
+		// Relayed chassis commands wait for their vCenter task, which can
+		// take as long as stopping the BMC waits for one
+		timeout := max(time.Duration(s.cfg.StopTimeout)*time.Second, minRelayTimeout)
+		bridge, err := newTCPBridge(&net.TCPAddr{IP: s.ip, Port: s.port}, s.ipmiServer.LocalAddr(), s.answer, timeout, s.log)
+		if err != nil {
+			if s.udpFront != nil {
+				s.udpFront.Stop()
+				s.udpFront = nil
+			}
+			_ = s.cleanupIP()
+			return fmt.Errorf("failed to start IPMI TCP bridge: %v", err)
+		}
+		s.tcpBridge = bridge
+	}
+
+	s.active = true
+	s.log.Infof("IPMI simulator listening on %s (%s), session tag 0x%04x", s.Addr(), s.cfg.Transport, s.tag)
+
+	if s.vm != nil {
+		s.applyDefaultBootDevice(ctx)
+	}
 	return nil
 }
 
+// Active reports whether the server holds its address
+func (s *Server) Active() bool {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	return s.active
+}
+
+
+// Stop stops the IPMI server. Commands in flight, such as a power action
+// waiting on its vCenter task, are waited for until ctx is done before the
+// listeners and the address go away; commands arriving meanwhile are
+// rejected as busy.
+func (s *Server) Stop(ctx context.Context) error {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+
+	s.stopWatchdog()
+	s.cancel()
+	if left, waited := s.inflight.drain(ctx); left > 0 {
+		s.log.Warnf("Stopping with %d commands still in flight", left)
+	} else if waited {
+		// The simulator sends its response after the handler returns, so
+		// give it time to be relayed before the listeners close
+		time.Sleep(replyGrace)
+	}
+
+	// Stop the listeners before the simulator they relay to
+	if s.udpFront != nil {
+		s.udpFront.Stop()
+	}
+	if s.tcpBridge != nil {
+		s.tcpBridge.Stop()
+	}
 
-// Stop stops the IPMI server
-func (s *Server) Stop() error {
 	// Stop the IPMI simulator
 	if s.ipmiServer != nil {
 		s.ipmiServer.Stop()
 	}
 
-	// Clean up the IP configuration
-	if err := s.cleanupIP(); err != nil {
-		return fmt.Errorf("failed to cleanup IP configuration: %v", err)
+	// Clean up the IP configuration, unless it was never claimed
+	if s.active {
+		if err := s.cleanupIP(); err != nil {
+			return fmt.Errorf("failed to cleanup IP configuration: %v", err)
+		}
+		s.active = false
 	}
 
 	s.log.Info("IPMI server stopped")
@@ -0,0 +1,803 @@
+package ipmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/clock"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/netconfig"
+	"github.com/vbmc-vsphere/sel"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vbmc-vsphere/vsphere/mock"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTestServer returns a server for a VM backed by a fake vSphere client
+// and driven by a fake clock. It isn't started.
+func newTestServer(t testing.TB) (*Server, *mock.Client, *clock.Fake) {
+	t.Helper()
+	db, err := config.NewIPDB(filepath.Join(t.TempDir(), "ipdb.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	vm := object.NewVirtualMachine(nil, types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-42"})
+	vm.InventoryPath = "/DC0/vm/test-vm"
+	vc := mock.New()
+	cfg := config.NewConfig().Server
+	s := NewServer(vm, vc, net.IPv4(127, 0, 0, 1), net.IPv4(255, 0, 0, 0), cfg, NewLimiter(cfg.MaxInflightCommands), db)
+
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.clock = fake
+	s.eventLog = sel.New(cfg.SELCapacity, fake)
+	return s, vc, fake
+}
+
+// startTestServer starts s on a free loopback port and returns a client
+// with an admin session open to it. The server is stopped when the test
+// ends.
+func startTestServer(t *testing.T, s *Server) *goipmi.Client {
+	t.Helper()
+	s.SetConfigurator(netconfig.DryRun{Log: s.log})
+	s.SetProber(netconfig.DryRun{Log: s.log})
+	s.SetCredentials("admin", "password")
+	s.port = 0
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+
+	client := newTestClient(t, s, "password")
+	if err := client.Open(); err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// newTestClient returns a client of a started server logging in as admin
+// with password. No session is opened yet.
+func newTestClient(t *testing.T, s *Server, password string) *goipmi.Client {
+	t.Helper()
+	client, err := goipmi.NewClient(&goipmi.Connection{
+		Hostname:  "127.0.0.1",
+		Port:      s.udpFront.conn.LocalAddr().(*net.UDPAddr).Port,
+		Username:  "admin",
+		Password:  password,
+		Interface: "lan",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// sendRaw sends an unauthenticated request outside any session to a
+// started server and returns the completion code and response data
+func sendRaw(t *testing.T, s *Server, netfn, command uint8, data ...byte) []byte {
+	t.Helper()
+	conn, err := net.DialUDP("udp4", nil, s.udpFront.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(testPacket(0, netfn, command, data...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxFrameSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no response to command 0x%02x: %v", command, err)
+	}
+	offset := rmcpHeaderSize + sessionHeaderSize + ipmiHeaderSize
+	if n <= offset {
+		t.Fatalf("short response to command 0x%02x: % x", command, buf[:n])
+	}
+	return buf[offset : n-1] // Without the trailing checksum
+}
+
+// rawResponse holds a response's completion code and data
+type rawResponse []byte
+
+// UnmarshalBinary keeps the response as it is
+func (r *rawResponse) UnmarshalBinary(buf []byte) error {
+	*r = append(rawResponse{}, buf...)
+	return nil
+}
+
+// Code returns the completion code
+func (r *rawResponse) Code() uint8 {
+	return (*r)[0]
+}
+
+// send sends a request in client's session and returns the response data
+// after the completion code, or the completion code as the error
+func send(client *goipmi.Client, netfn, command uint8, data ...byte) ([]byte, error) {
+	req := &goipmi.Request{
+		NetworkFunction: goipmi.NetworkFunction(netfn),
+		Command:         goipmi.Command(command),
+		Data:            append([]byte{}, data...),
+	}
+	var resp rawResponse
+	if err := client.Send(req, &resp); err != nil {
+		return nil, err
+	}
+	return resp[1:], nil
+}
+
+// waitFor fails the test unless cond becomes true within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// called reports how many times method was called on vc
+func called(vc *mock.Client, method string) int {
+	n := 0
+	for _, c := range vc.Calls() {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPowerCycleHonorsDelay(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	s.cfg.PowerCycleDelay = 5
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+
+	if err := s.powerCycle(context.Background(), vc); err != nil {
+		t.Fatalf("powerCycle: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOff" {
+		t.Fatalf("VM is %s after the power cycle returned, want poweredOff", state)
+	}
+
+	waitFor(t, "the power cycle delay", func() bool { return fake.Waiters() == 1 })
+	fake.Advance(4 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if n := called(vc, "PowerOnVM"); n != 0 {
+		t.Fatalf("VM powered on %d times before the delay passed", n)
+	}
+
+	fake.Advance(time.Second)
+	waitFor(t, "the power-on", func() bool { return vc.VM(s.vm).PowerState == "poweredOn" })
+}
+
+func TestPowerCycleConsumesOneTimeBoot(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected", BootOrder: []string{"cdrom"}})
+	s.oneTimeBoot.Store(true)
+
+	if err := s.powerCycle(context.Background(), vc); err != nil {
+		t.Fatalf("powerCycle: %v", err)
+	}
+	waitFor(t, "the power cycle delay", func() bool { return fake.Waiters() == 1 })
+	if vc.VM(s.vm).BootOrder == nil {
+		t.Fatal("boot order cleared before the VM powered on")
+	}
+
+	fake.Advance(time.Duration(s.cfg.PowerCycleDelay) * time.Second)
+	waitFor(t, "the boot order to be cleared", func() bool { return vc.VM(s.vm).BootOrder == nil })
+}
+
+func TestStopEndsPowerCycleDelay(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	s.cfg.PowerCycleDelay = 60
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+
+	if err := s.powerCycle(context.Background(), vc); err != nil {
+		t.Fatalf("powerCycle: %v", err)
+	}
+	waitFor(t, "the power cycle delay", func() bool { return fake.Waiters() == 1 })
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOn" {
+		t.Errorf("VM is %s after Stop, want poweredOn", state)
+	}
+}
+
+func TestChassisControlPowersVM(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOn" {
+		t.Errorf("VM is %s after power up, want poweredOn", state)
+	}
+	if status, err := client.GetPowerStatus(); err != nil || status != goipmi.StatusPowerOnString {
+		t.Errorf("power status is %q (%v), want %q", status, err, goipmi.StatusPowerOnString)
+	}
+
+	if err := client.Control(goipmi.ControlPowerDown); err != nil {
+		t.Fatalf("power down: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOff" {
+		t.Errorf("VM is %s after power down, want poweredOff", state)
+	}
+	if status, err := client.GetPowerStatus(); err != nil || status != goipmi.StatusPowerOffString {
+		t.Errorf("power status is %q (%v), want %q", status, err, goipmi.StatusPowerOffString)
+	}
+}
+
+func TestChassisControlFailure(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	vc.SetError("PowerOnVM", errors.New("host is in maintenance mode"))
+
+	err := client.Control(goipmi.ControlPowerUp)
+	if err != goipmi.ErrUnspecified {
+		t.Errorf("power up returned %v, want %v", err, goipmi.ErrUnspecified)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOff" {
+		t.Errorf("VM is %s after a failed power up, want poweredOff", state)
+	}
+}
+
+func TestChassisControlBusyDuringTask(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	vc.SetError("PowerOnVM", fmt.Errorf("%w: cloning", vsphere.ErrTaskInProgress))
+
+	err := client.Control(goipmi.ControlPowerUp)
+	if err != goipmi.ErrNodeBusy {
+		t.Errorf("power up during a task returned %v, want %v", err, goipmi.ErrNodeBusy)
+	}
+}
+
+func TestChassisControlCanceled(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	vc.SetError("PowerOnVM", fmt.Errorf("%w: by the admin API", vsphere.ErrTaskCanceled))
+
+	err := client.Control(goipmi.ControlPowerUp)
+	if err != goipmi.ErrNoResponse {
+		t.Errorf("cancelled power up returned %v, want %v", err, goipmi.ErrNoResponse)
+	}
+}
+
+func TestChassisControlTimeoutWhileUnreachable(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	vc.SetError("PowerOnVM", vsphere.ErrUnreachable)
+
+	err := client.Control(goipmi.ControlPowerUp)
+	if err != goipmi.ErrCommandTimeout {
+		t.Errorf("power up with vCenter unreachable returned %v, want %v", err, goipmi.ErrCommandTimeout)
+	}
+}
+
+func TestDiagnosticInterruptSendsNMI(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		vm   mock.VM
+		fail error
+		want error
+	}{
+		// The NMI goes through the hypervisor, so it doesn't need VMware Tools
+		{"tools running", mock.VM{PowerState: "poweredOn", ConnectionState: "connected", ToolsRunning: true}, nil, nil},
+		{"tools absent", mock.VM{PowerState: "poweredOn", ConnectionState: "connected"}, nil, nil},
+		{"powered off", mock.VM{PowerState: "poweredOff", ConnectionState: "connected"}, nil, goipmi.ErrInvalidState},
+		{"unsupported by the host", mock.VM{PowerState: "poweredOn", ConnectionState: "connected"}, vsphere.ErrNMIUnavailable, goipmi.ErrInvalidState},
+	} {
+		s, vc, _ := newTestServer(t)
+		vc.SetVM(s.vm, tc.vm)
+		if tc.fail != nil {
+			vc.SetError("SendNMI", tc.fail)
+		}
+		client := startTestServer(t, s)
+
+		if err := client.Control(goipmi.ControlPowerPulseDiag); err != tc.want {
+			t.Errorf("%s: diagnostic interrupt returned %v, want %v", tc.name, err, tc.want)
+		}
+		if n := called(vc, "SendNMI"); n != 1 {
+			t.Errorf("%s: NMI sent %d times, want 1", tc.name, n)
+		}
+		if state := vc.VM(s.vm).PowerState; state != tc.vm.PowerState {
+			t.Errorf("%s: VM is %s after a diagnostic interrupt, want %s", tc.name, state, tc.vm.PowerState)
+		}
+	}
+}
+
+func TestChassisControlBusyUntilConnected(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "inaccessible"})
+	client := startTestServer(t, s)
+
+	if err := client.Control(goipmi.ControlPowerUp); err != goipmi.ErrNodeBusy {
+		t.Errorf("power up of a VM still being cloned returned %v, want %v", err, goipmi.ErrNodeBusy)
+	}
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != goipmi.ErrNodeBusy {
+		t.Errorf("setting the boot device of a VM still being cloned returned %v, want %v", err, goipmi.ErrNodeBusy)
+	}
+	if vm := vc.VM(s.vm); vm.PowerState != "poweredOff" || vm.BootOrder != nil {
+		t.Errorf("VM changed to %+v before it was connected", vm)
+	}
+
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "connected"})
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Errorf("power up once connected: %v", err)
+	}
+}
+
+// getBootFlags reads the boot flags parameter back from a server
+func getBootFlags(t *testing.T, client *goipmi.Client) (flags uint8, device goipmi.BootDevice) {
+	t.Helper()
+	resp, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandGetSystemBootOptions), goipmi.BootParamBootFlags, 0, 0)
+	if err != nil {
+		t.Fatalf("getting boot flags: %v", err)
+	}
+	if len(resp) < 4 || resp[1] != goipmi.BootParamBootFlags {
+		t.Fatalf("bad boot flags response % x", resp)
+	}
+	return resp[2], goipmi.BootDevice(resp[3] & bootDeviceMask)
+}
+
+func TestBootDeviceReadsBack(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+
+	if flags, _ := getBootFlags(t, client); flags&bootFlagsValid != 0 {
+		t.Errorf("VM without a boot order reports flags 0x%02x, want no override", flags)
+	}
+
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatalf("setting the boot device: %v", err)
+	}
+	flags, device := getBootFlags(t, client)
+	if device != goipmi.BootDevicePxe || flags&bootFlagsValid == 0 || flags&bootFlagsPersistent != 0 {
+		t.Errorf("read back device %v with flags 0x%02x, want a one-time PXE boot", device, flags)
+	}
+
+	// A persistent EFI boot from disk
+	_, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandSetSystemBootOptions),
+		goipmi.BootParamBootFlags, bootFlagsValid|bootFlagsPersistent|bootFlagsEFI, uint8(goipmi.BootDeviceDisk), 0, 0, 0)
+	if err != nil {
+		t.Fatalf("setting a persistent boot device: %v", err)
+	}
+	flags, device = getBootFlags(t, client)
+	if device != goipmi.BootDeviceDisk || flags&bootFlagsPersistent == 0 || flags&bootFlagsEFI == 0 {
+		t.Errorf("read back device %v with flags 0x%02x, want a persistent EFI disk boot", device, flags)
+	}
+	if fw := vc.VM(s.vm).Firmware; fw != vsphere.FirmwareEFI {
+		t.Errorf("VM firmware is %q, want %q", fw, vsphere.FirmwareEFI)
+	}
+}
+
+// chassisStatus returns the current power state and last power event bytes
+// of the chassis status
+func chassisStatus(t *testing.T, client *goipmi.Client) (uint8, uint8) {
+	t.Helper()
+	resp, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus))
+	if err != nil || len(resp) < 3 {
+		t.Fatalf("chassis status % x: %v", resp, err)
+	}
+	return resp[0], resp[1]
+}
+
+func TestPowerDownByCommandReported(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+	if _, event := chassisStatus(t, client); event&lastPowerByCommand != 0 {
+		t.Errorf("last power event 0x%02x before any command, want it clear", event)
+	}
+
+	if err := client.Control(goipmi.ControlPowerDown); err != nil {
+		t.Fatalf("power down: %v", err)
+	}
+	if power, event := chassisStatus(t, client); power&goipmi.SystemPower != 0 || event != lastPowerByCommand {
+		t.Errorf("after a power down via IPMI, power state 0x%02x and last power event 0x%02x, want off by command", power, event)
+	}
+
+	// Powered on in vCenter since
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	if _, event := chassisStatus(t, client); event&lastPowerByCommand != 0 {
+		t.Errorf("last power event 0x%02x after a power on in vCenter, want it clear", event)
+	}
+}
+
+func TestBootFlagsDecoding(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		flags      uint8 // First byte of the boot flags
+		selector   uint8 // Second byte, with the boot device selector
+		device     goipmi.BootDevice
+		persistent bool
+	}{
+		{"one-time PXE", bootFlagsValid, uint8(goipmi.BootDevicePxe), goipmi.BootDevicePxe, false},
+		{"persistent PXE", bootFlagsValid | bootFlagsPersistent, uint8(goipmi.BootDevicePxe), goipmi.BootDevicePxe, true},
+		{"PXE with CMOS clear", bootFlagsValid, 0x80 | uint8(goipmi.BootDevicePxe), goipmi.BootDevicePxe, false},
+		{"disk with screen blank and reset lock", bootFlagsValid, uint8(goipmi.BootDeviceDisk) | 0x03, goipmi.BootDeviceDisk, false},
+	} {
+		s, _, _ := newTestServer(t)
+		client := startTestServer(t, s)
+		_, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandSetSystemBootOptions),
+			goipmi.BootParamBootFlags, tc.flags, tc.selector, 0, 0, 0)
+		if err != nil {
+			t.Errorf("%s: setting flags 0x%02x 0x%02x: %v", tc.name, tc.flags, tc.selector, err)
+			continue
+		}
+		flags, device := getBootFlags(t, client)
+		if device != tc.device || (flags&bootFlagsPersistent != 0) != tc.persistent {
+			t.Errorf("%s: read back device %v with flags 0x%02x, want %v persistent %v", tc.name, device, flags, tc.device, tc.persistent)
+		}
+	}
+}
+
+func TestOneTimeBootSurvivesRestart(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatalf("setting the boot device: %v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	restarted := NewServer(s.vm, vc, s.ip, s.netmask, s.cfg, NewLimiter(s.cfg.MaxInflightCommands), s.db)
+	client = startTestServer(t, restarted)
+	if flags, _ := getBootFlags(t, client); flags&bootFlagsPersistent != 0 {
+		t.Errorf("boot flags are 0x%02x after a restart, want a one-time boot", flags)
+	}
+
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	waitFor(t, "the boot order to be cleared", func() bool { return vc.VM(s.vm).BootOrder == nil })
+	if oneTime, err := s.db.GetOneTimeBoot(s.key); err != nil || oneTime {
+		t.Errorf("one-time boot still recorded (%v) after the VM booted", err)
+	}
+}
+
+func TestPowerUpOnRunningVMKeepsOneTimeBoot(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatalf("setting the boot device: %v", err)
+	}
+
+	// Nothing boots, so the override stays for the next boot
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	if order := vc.VM(s.vm).BootOrder; len(order) == 0 {
+		t.Fatal("one-time boot device cleared by a power up of a running VM")
+	}
+	if oneTime, err := s.db.GetOneTimeBoot(s.key); err != nil || !oneTime {
+		t.Errorf("one-time boot no longer recorded (%v) after a power up of a running VM", err)
+	}
+
+	if err := client.Control(goipmi.ControlPowerDown); err != nil {
+		t.Fatalf("power down: %v", err)
+	}
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("power up: %v", err)
+	}
+	if order := vc.VM(s.vm).BootOrder; order != nil {
+		t.Errorf("boot order is %v after the VM booted, want the one-time device cleared", order)
+	}
+}
+
+func TestObservePowerOnKeepsLaterOneTimeBoot(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	client := startTestServer(t, s)
+	offAt := fake.Now()
+	fake.Advance(time.Second)
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatalf("setting the boot device: %v", err)
+	}
+
+	// Set after the VM was seen off, possibly for the boot after this one
+	s.ObservePowerOn(context.Background(), offAt)
+	if order := vc.VM(s.vm).BootOrder; len(order) == 0 {
+		t.Fatal("one-time boot device set after the VM was seen off was cleared")
+	}
+
+	s.ObservePowerOn(context.Background(), fake.Now())
+	if order := vc.VM(s.vm).BootOrder; order != nil {
+		t.Errorf("boot order is %v after a power-on seen by reconcile, want the one-time device cleared", order)
+	}
+}
+
+// fakeNetwork is a network where some addresses are taken by other hosts.
+// It records the addresses added to and removed from the NIC.
+type fakeNetwork struct {
+	mu       sync.Mutex
+	occupied map[string]bool
+	probed   []string
+	added    []string
+	removed  []string
+}
+
+// Probe reports whether another host holds ip
+func (n *fakeNetwork) Probe(nic string, ip net.IP, timeout time.Duration) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.probed = append(n.probed, ip.String())
+	return n.occupied[ip.String()], nil
+}
+
+// AddAddress records the address as added
+func (n *fakeNetwork) AddAddress(nic string, addr *net.IPNet) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.added = append(n.added, addr.IP.String())
+	return nil
+}
+
+// RemoveAddress records the address as removed
+func (n *fakeNetwork) RemoveAddress(nic string, addr *net.IPNet) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removed = append(n.removed, addr.IP.String())
+	return nil
+}
+
+func TestConflictProbeRefusesOccupiedIPs(t *testing.T) {
+	network := &fakeNetwork{occupied: map[string]bool{"127.0.0.11": true, "127.0.0.13": true}}
+	var started []string
+	for i := 10; i <= 13; i++ {
+		s, _, _ := newTestServer(t)
+		s.ip = net.IPv4(127, 0, 0, byte(i))
+		s.cfg.ManageIPs = true
+		s.SetConfigurator(network)
+		s.SetProber(network)
+		s.port = 0
+
+		err := s.Start(context.Background())
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+		if err == nil {
+			started = append(started, s.ip.String())
+		} else if !errors.Is(err, netconfig.ErrInUse) {
+			t.Errorf("starting on %s: %v", s.ip, err)
+		}
+	}
+
+	want := []string{"127.0.0.10", "127.0.0.12"}
+	if !slices.Equal(started, want) {
+		t.Errorf("BMCs started on %v, want the free addresses %v", started, want)
+	}
+	if !slices.Equal(network.added, want) {
+		t.Errorf("added %v to the NIC, want only the free addresses %v", network.added, want)
+	}
+}
+
+func TestConflictProbeDisabled(t *testing.T) {
+	network := &fakeNetwork{occupied: map[string]bool{"127.0.0.11": true}}
+	s, _, _ := newTestServer(t)
+	s.ip = net.IPv4(127, 0, 0, 11)
+	s.cfg.ManageIPs = true
+	s.cfg.ConflictProbe.Enabled = false
+	s.SetConfigurator(network)
+	s.SetProber(network)
+	s.port = 0
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start with the conflict probe disabled: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+	if len(network.probed) != 0 {
+		t.Errorf("probed %v with the conflict probe disabled", network.probed)
+	}
+}
+
+func TestServersShareIPOnDifferentPorts(t *testing.T) {
+	var ports []int
+	for range 2 {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, conn.LocalAddr().(*net.UDPAddr).Port)
+		conn.Close()
+	}
+
+	for _, port := range ports {
+		base, vc, _ := newTestServer(t)
+		cfg := base.cfg
+		cfg.IPMIPort = port
+		s := NewServer(base.vm, vc, base.ip, base.netmask, cfg, NewLimiter(cfg.MaxInflightCommands), base.db)
+		s.SetConfigurator(netconfig.DryRun{Log: s.log})
+		s.SetProber(netconfig.DryRun{Log: s.log})
+		s.SetCredentials("admin", "password")
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatalf("starting on port %d: %v", port, err)
+		}
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+	}
+
+	for _, port := range ports {
+		client, err := goipmi.NewClient(&goipmi.Connection{
+			Hostname:  "127.0.0.1",
+			Port:      port,
+			Username:  "admin",
+			Password:  "password",
+			Interface: "lan",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Open(); err != nil {
+			t.Errorf("opening a session on port %d: %v", port, err)
+			continue
+		}
+		if _, err := client.DeviceID(); err != nil {
+			t.Errorf("Get Device ID on port %d: %v", port, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestUnmanagedIPsLeftAlone(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ip      net.IP
+		present bool
+	}{
+		{"address present", net.IPv4(127, 0, 0, 1), true},
+		{"address missing", net.IPv4(192, 0, 2, 1), false},
+	} {
+		network := &fakeNetwork{}
+		s, _, _ := newTestServer(t)
+		s.ip = tc.ip
+		s.cfg.ManageIPs = false
+		s.SetConfigurator(network)
+		s.SetProber(network)
+		s.port = 0
+
+		err := s.Start(context.Background())
+		if (err == nil) != tc.present {
+			t.Errorf("%s: Start returned %v", tc.name, err)
+		}
+		if err == nil {
+			if err := s.Stop(context.Background()); err != nil {
+				t.Errorf("%s: Stop: %v", tc.name, err)
+			}
+		}
+		if len(network.probed) != 0 || len(network.added) != 0 || len(network.removed) != 0 {
+			t.Errorf("%s: probed %v, added %v and removed %v with manage_ips disabled",
+				tc.name, network.probed, network.added, network.removed)
+		}
+	}
+}
+
+func TestStandbyClaimsAddressOnActivate(t *testing.T) {
+	network := &fakeNetwork{}
+	s, _, _ := newTestServer(t)
+	s.cfg.Startup = config.StartupStandby
+	s.cfg.ManageIPs = true
+	s.SetConfigurator(network)
+	s.SetProber(network)
+	s.SetCredentials("admin", "password")
+	s.port = 0
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+	if s.Active() || s.udpFront != nil || len(network.added) != 0 {
+		t.Fatalf("standby BMC is active %v, listening %v, added %v", s.Active(), s.udpFront != nil, network.added)
+	}
+
+	if err := s.Activate(context.Background()); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if !s.Active() || len(network.added) != 1 || network.added[0] != "127.0.0.1" {
+		t.Fatalf("activated BMC is active %v, added %v", s.Active(), network.added)
+	}
+	client := newTestClient(t, s, "password")
+	if err := client.Open(); err != nil {
+		t.Fatalf("opening a session after activating: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.DeviceID(); err != nil {
+		t.Errorf("device ID after activating: %v", err)
+	}
+
+	// Activating again claims nothing more
+	if err := s.Activate(context.Background()); err != nil || len(network.added) != 1 {
+		t.Errorf("second Activate returned %v and added %v", err, network.added)
+	}
+}
+
+func TestFloppyFallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fallback string
+		want     []string // Boot order afterwards
+		err      error
+	}{
+		{"fallback to cdrom", "cdrom", []string{string(vsphere.BootDeviceCDROM)}, nil},
+		{"no fallback", "", nil, goipmi.ErrInvalidObjCommand},
+	} {
+		s, vc, _ := newTestServer(t)
+		s.cfg.FloppyFallback = tc.fallback
+		vc.SetVM(s.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "connected", NoFloppy: true})
+		client := startTestServer(t, s)
+
+		if err := client.SetBootDevice(goipmi.BootDeviceFloppy); err != tc.err {
+			t.Errorf("%s: setting floppy on a VM without one returned %v, want %v", tc.name, err, tc.err)
+		}
+		if order := vc.VM(s.vm).BootOrder; !slices.Equal(order, tc.want) {
+			t.Errorf("%s: boot order is %v, want %v", tc.name, order, tc.want)
+		}
+	}
+}
+
+// failingNetwork is a NIC whose address changes fail with fixed errors
+type failingNetwork struct {
+	addErr, removeErr error
+}
+
+// AddAddress returns the add error
+func (n failingNetwork) AddAddress(nic string, addr *net.IPNet) error {
+	return n.addErr
+}
+
+// RemoveAddress returns the remove error
+func (n failingNetwork) RemoveAddress(nic string, addr *net.IPNet) error {
+	return n.removeErr
+}
+
+func TestConfiguratorErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		network failingNetwork
+		wantErr error  // Wrapped by the Start error
+		wantMsg string // In the Start error, empty if Start succeeds
+	}{
+		{"address already present", failingNetwork{addErr: netconfig.ErrExists, removeErr: netconfig.ErrNotFound}, nil, ""},
+		{"permission denied", failingNetwork{addErr: netconfig.ErrPermission}, netconfig.ErrPermission, "CAP_NET_ADMIN"},
+		{"other failure", failingNetwork{addErr: errors.New("no such device")}, nil, "no such device"},
+	} {
+		s, _, _ := newTestServer(t)
+		s.cfg.ManageIPs = true
+		s.SetConfigurator(tc.network)
+		s.SetProber(&fakeNetwork{})
+		s.port = 0
+
+		err := s.Start(context.Background())
+		if tc.wantMsg == "" {
+			if err != nil {
+				t.Errorf("%s: Start returned %v, want success", tc.name, err)
+			} else if err := s.Stop(context.Background()); err != nil {
+				t.Errorf("%s: Stop returned %v with the address already gone", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantMsg) || (tc.wantErr != nil && !errors.Is(err, tc.wantErr)) {
+			t.Errorf("%s: Start returned %v, want an error with %q", tc.name, err, tc.wantMsg)
+		}
+		if s.Active() {
+			t.Errorf("%s: server active after a failed start", tc.name)
+		}
+		_ = s.Stop(context.Background())
+	}
+}
@@ -0,0 +1,167 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+// challenge asks a server for a session challenge and returns the
+// temporary session ID it hands out
+func challenge(t *testing.T, s *Server) uint32 {
+	t.Helper()
+	data := make([]byte, 17)
+	data[0] = AuthTypeMD5
+	copy(data[1:], "admin")
+	resp := sendRaw(t, s, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandGetSessionChallenge), data...)
+	if resp[0] != uint8(goipmi.CommandCompleted) || len(resp) < 5 {
+		t.Fatalf("session challenge failed: % x", resp)
+	}
+	return binary.LittleEndian.Uint32(resp[1:])
+}
+
+func TestSessionTagStablePerVM(t *testing.T) {
+	const uuid = "4211c2e4-5d3c-8a1b-9f0e-6b7a8c9d0e1f"
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "connected", UUID: uuid})
+	startTestServer(t, s)
+
+	tag := s.sessionTag(uuid)
+	first, second := challenge(t, s), challenge(t, s)
+	if first>>16 != uint32(tag) || second>>16 != uint32(tag) {
+		t.Errorf("session IDs 0x%08x and 0x%08x don't carry tag 0x%04x", first, second, tag)
+	}
+	if first == second {
+		t.Errorf("two sessions got the same ID 0x%08x", first)
+	}
+
+	// A restarted BMC for the same VM keeps the tag
+	restarted, vc2, _ := newTestServer(t)
+	vc2.SetVM(restarted.vm, mock.VM{PowerState: "poweredOff", ConnectionState: "connected", UUID: uuid})
+	startTestServer(t, restarted)
+	if id := challenge(t, restarted); id>>16 != uint32(tag) {
+		t.Errorf("restarted BMC handed out session ID 0x%08x, want tag 0x%04x", id, tag)
+	}
+
+	if other := s.sessionTag("4211c2e4-0000-0000-0000-000000000000"); other == tag {
+		t.Errorf("VMs with different UUIDs share tag 0x%04x", tag)
+	}
+}
+
+func TestSessionRejectsOtherPassword(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	startTestServer(t, s)
+
+	client := newTestClient(t, s, "default")
+	if err := client.Open(); err == nil {
+		client.Close()
+		t.Error("session opened with a password other than the BMC's")
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	s, _, fake := newTestServer(t)
+	s.cfg.MaxSessions = 2
+	startTestServer(t, s)
+
+	second := newTestClient(t, s, "password")
+	if err := second.Open(); err != nil {
+		t.Fatalf("second session: %v", err)
+	}
+	third := newTestClient(t, s, "password")
+	if err := third.Open(); err != goipmi.CompletionCode(CompletionCodeNoSessionSlot) {
+		t.Fatalf("session beyond the limit returned %v, want 0x%02x", err, CompletionCodeNoSessionSlot)
+	}
+
+	// Closing a session frees its slot
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := third.Open(); err != nil {
+		t.Fatalf("session after another closed: %v", err)
+	}
+
+	// So do sessions going idle
+	fake.Advance(sessionIdleTimeout + time.Second)
+	fourth := newTestClient(t, s, "password")
+	if err := fourth.Open(); err != nil {
+		t.Fatalf("session after the others went idle: %v", err)
+	}
+	_ = third.Close()
+	_ = fourth.Close()
+}
+
+func TestPingsKeepSessionAlive(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+
+	// Alternate the keepalives clients send, well past the idle timeout
+	pings := []struct {
+		command uint8
+		data    []byte
+	}{
+		{uint8(goipmi.CommandGetDeviceID), nil},
+		{CommandGetSessionInfo, []byte{0x00}}, // The current session
+	}
+	for i := 0; i < 6; i++ {
+		fake.Advance(sessionIdleTimeout / 2)
+		ping := pings[i%2]
+		if _, err := send(client, uint8(goipmi.NetworkFunctionApp), ping.command, ping.data...); err != nil {
+			t.Fatalf("ping %d after %v: %v", i, time.Duration(i+1)*sessionIdleTimeout/2, err)
+		}
+	}
+	if n := s.Sessions(); n != 1 || !sessionWorks(client) {
+		t.Errorf("pinged session gone after %v (%d active), want it kept alive", 3*sessionIdleTimeout, n)
+	}
+}
+
+// sessionWorks reports whether a client's session can still send commands
+// that need authentication
+func sessionWorks(client *goipmi.Client) bool {
+	_, err := send(client, uint8(goipmi.NetworkFunctionChassis), uint8(goipmi.CommandChassisStatus))
+	return err == nil
+}
+
+func TestRotateCredentials(t *testing.T) {
+	for _, invalidate := range []bool{false, true} {
+		s, vc, _ := newTestServer(t)
+		vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+		client := startTestServer(t, s)
+		other, otherVC, _ := newTestServer(t)
+		otherVC.SetVM(other.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+		otherClient := startTestServer(t, other)
+
+		if closed := s.RotateCredentials("admin", "rotated", invalidate); invalidate && closed != 1 || !invalidate && closed != 0 {
+			t.Errorf("rotation with invalidate %v closed %d sessions", invalidate, closed)
+		}
+		if works := sessionWorks(client); works == invalidate {
+			t.Errorf("with invalidate %v the open session still works: %v", invalidate, works)
+		}
+		if old := newTestClient(t, s, "password"); old.Open() == nil {
+			_ = old.Close()
+			t.Errorf("with invalidate %v a session opened with the old password", invalidate)
+		}
+		rotated := newTestClient(t, s, "rotated")
+		if err := rotated.Open(); err != nil {
+			t.Fatalf("with invalidate %v a session with the new password: %v", invalidate, err)
+		}
+		if !sessionWorks(rotated) {
+			t.Errorf("with invalidate %v the new session can't send commands", invalidate)
+		}
+		_ = rotated.Close()
+
+		// Other BMCs keep their credentials and sessions
+		if !sessionWorks(otherClient) {
+			t.Errorf("with invalidate %v another BMC's session stopped working", invalidate)
+		}
+		if fresh := newTestClient(t, other, "password"); fresh.Open() != nil {
+			t.Errorf("with invalidate %v another BMC refused its own password", invalidate)
+		} else {
+			_ = fresh.Close()
+		}
+	}
+}
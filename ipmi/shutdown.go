@@ -0,0 +1,123 @@
+package ipmi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/syslog"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// guestShutdownConfig returns the guest shutdown settings for the VM,
+// applying the per-VM override attribute when one is configured
+func (s *Server) guestShutdownConfig(ctx context.Context) config.GuestShutdownConfig {
+	cfg := s.cfg.GuestShutdown
+	if cfg.OverrideAttribute == "" {
+		return cfg
+	}
+
+	value, err := s.vsClient.GetCustomAttribute(ctx, s.vm, cfg.OverrideAttribute)
+	if err != nil {
+		s.log.Warnf("Failed to read guest shutdown override, using defaults: %v", err)
+		return cfg
+	}
+	override, err := cfg.WithOverride(value)
+	if err != nil {
+		s.log.Warnf("Ignoring guest shutdown override %q: %v", value, err)
+		return cfg
+	}
+	return override
+}
+
+// softOff asks the guest to shut down and returns once the request is
+// accepted. A background poll waits for the VM to power off, hard powering
+// it off on timeout if configured.
+func (s *Server) softOff(ctx context.Context, vc vsphere.VMClient, cfg config.GuestShutdownConfig) error {
+	if !s.shuttingDown.CompareAndSwap(false, true) {
+		s.log.Info("Guest shutdown already in progress")
+		return nil
+	}
+
+	if err := vc.ShutdownGuestVM(ctx, s.vm); err != nil {
+		s.shuttingDown.Store(false)
+		return err
+	}
+
+	go func() {
+		defer s.shuttingDown.Store(false)
+		s.waitGuestShutdown(vc, cfg)
+	}()
+	return nil
+}
+
+// gracefulPowerDown handles a power down as a guest shutdown that is
+// forced after the graceful shutdown timeout. VMs without VMware Tools
+// running are powered off at once.
+func (s *Server) gracefulPowerDown(ctx context.Context, vc vsphere.VMClient) error {
+	cfg := s.guestShutdownConfig(ctx)
+	cfg.TimeoutSeconds = s.cfg.GracefulShutdown
+	cfg.ForceOnTimeout = true
+
+	err := s.softOff(ctx, vc, cfg)
+	if !errors.Is(err, vsphere.ErrToolsUnavailable) {
+		return err
+	}
+	s.log.WithField(syslog.EventField, "forced_off").Warn("VMware Tools not running, powering off without a guest shutdown")
+	return vc.PowerOffVM(ctx, s.vm)
+}
+
+// guestRebootTimeout bounds how long VMware Tools may take to accept a
+// guest reboot before the VM is hard reset instead
+const guestRebootTimeout = 30 * time.Second
+
+// reset handles a hard reset, first asking the guest to reboot when
+// prefer_guest_reboot is enabled. A guest reboot that can't be requested,
+// e.g. without VMware Tools running, falls back to a hard reset.
+func (s *Server) reset(ctx context.Context, vc vsphere.VMClient) error {
+	if s.cfg.PreferGuestReboot {
+		rebootCtx, cancel := context.WithTimeout(ctx, guestRebootTimeout)
+		err := vc.RebootGuestVM(rebootCtx, s.vm)
+		cancel()
+		if err == nil {
+			s.log.WithField(syslog.EventField, "guest_reboot").Info("Guest reboot requested")
+			return nil
+		}
+		s.log.WithField(syslog.EventField, "forced_reset").Warnf("Guest reboot failed, hard resetting: %v", err)
+	}
+	return vc.ResetVM(ctx, s.vm)
+}
+
+// waitGuestShutdown polls the power state until the VM is off or the
+// timeout expires
+func (s *Server) waitGuestShutdown(vc vsphere.VMClient, cfg config.GuestShutdownConfig) {
+	ctx := context.Background()
+	interval := time.Duration(cfg.PollIntervalSeconds) * time.Second
+	deadline := s.clock.Now().Add(time.Duration(cfg.TimeoutSeconds) * time.Second)
+
+	for s.clock.Now().Before(deadline) {
+		<-s.clock.After(interval)
+		state, err := vc.GetVMPowerState(ctx, s.vm)
+		if err != nil {
+			s.log.Warnf("Failed to poll power state during guest shutdown: %v", err)
+			continue
+		}
+		if state == "poweredOff" {
+			s.log.WithField(syslog.EventField, "guest_shutdown").Info("Guest shut down gracefully")
+			return
+		}
+	}
+
+	if !cfg.ForceOnTimeout {
+		s.log.WithField(syslog.EventField, "guest_shutdown_timeout").
+			Warnf("Guest did not shut down within %ds, leaving it running", cfg.TimeoutSeconds)
+		return
+	}
+	if err := vc.PowerOffVM(ctx, s.vm); err != nil {
+		s.log.Errorf("Failed to force off VM after guest shutdown timeout: %v", err)
+		return
+	}
+	s.log.WithField(syslog.EventField, "forced_off").
+		Warnf("Guest did not shut down within %ds, forced power off", cfg.TimeoutSeconds)
+}
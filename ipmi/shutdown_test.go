@@ -0,0 +1,197 @@
+package ipmi
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/clock"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/vsphere/mock"
+	"github.com/vmware/govmomi/object"
+)
+
+// stuckGuest is a VM whose guest accepts shutdown requests but never
+// powers off
+var stuckGuest = mock.VM{PowerState: "poweredOn", ConnectionState: "connected", ToolsRunning: true, IgnoresShutdown: true}
+
+// advancePolls advances the fake clock through n power state polls
+func advancePolls(t *testing.T, fake *clock.Fake, interval time.Duration, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		waitFor(t, "the next power state poll", func() bool { return fake.Waiters() == 1 })
+		fake.Advance(interval)
+	}
+}
+
+func TestGuestShutdownTimeoutForcesOff(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, stuckGuest)
+	cfg := config.GuestShutdownConfig{PollIntervalSeconds: 10, TimeoutSeconds: 30, ForceOnTimeout: true}
+
+	if err := s.softOff(context.Background(), vc, cfg); err != nil {
+		t.Fatalf("softOff: %v", err)
+	}
+	advancePolls(t, fake, 10*time.Second, 2)
+	time.Sleep(10 * time.Millisecond)
+	if n := called(vc, "PowerOffVM"); n != 0 {
+		t.Fatalf("VM forced off %d times before the timeout", n)
+	}
+
+	advancePolls(t, fake, 10*time.Second, 1)
+	waitFor(t, "the forced power off", func() bool { return vc.VM(s.vm).PowerState == "poweredOff" })
+	waitFor(t, "the shutdown to end", func() bool { return !s.shuttingDown.Load() })
+}
+
+func TestGuestShutdownTimeoutLeavesVMRunning(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, stuckGuest)
+	cfg := config.GuestShutdownConfig{PollIntervalSeconds: 10, TimeoutSeconds: 30}
+
+	if err := s.softOff(context.Background(), vc, cfg); err != nil {
+		t.Fatalf("softOff: %v", err)
+	}
+	advancePolls(t, fake, 10*time.Second, 3)
+	waitFor(t, "the shutdown to end", func() bool { return !s.shuttingDown.Load() })
+
+	if n := called(vc, "PowerOffVM"); n != 0 {
+		t.Errorf("VM forced off %d times without force_on_timeout", n)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOn" {
+		t.Errorf("VM is %s after the timeout, want poweredOn", state)
+	}
+}
+
+func TestGuestShutdownOverrideAttribute(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	s.cfg.GuestShutdown = config.GuestShutdownConfig{PollIntervalSeconds: 5, TimeoutSeconds: 300, ForceOnTimeout: true, OverrideAttribute: "vbmc.shutdown"}
+	vm := stuckGuest
+	vm.CustomAttributes = map[string]string{"vbmc.shutdown": "timeout=60,force=false"}
+	vc.SetVM(s.vm, vm)
+
+	cfg := s.guestShutdownConfig(context.Background())
+	if cfg.TimeoutSeconds != 60 || cfg.ForceOnTimeout || cfg.PollIntervalSeconds != 5 {
+		t.Errorf("overridden config is %+v, want a 60s timeout without force and the 5s poll", cfg)
+	}
+}
+
+// events records what happened, in order
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, event)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.list...)
+}
+
+// slowPowerOffClient is a mock client whose power offs wait to be released
+type slowPowerOffClient struct {
+	*mock.Client
+	events  *events
+	started chan struct{}
+	release chan struct{}
+}
+
+// PowerOffVM signals started and powers the VM off once released
+func (c *slowPowerOffClient) PowerOffVM(ctx context.Context, vm *object.VirtualMachine) error {
+	close(c.started)
+	<-c.release
+	defer c.events.add("PowerOffVM done")
+	return c.Client.PowerOffVM(ctx, vm)
+}
+
+// recordingConfigurator records address removals
+type recordingConfigurator struct {
+	events *events
+}
+
+func (r recordingConfigurator) AddAddress(nic string, addr *net.IPNet) error {
+	return nil
+}
+
+func (r recordingConfigurator) RemoveAddress(nic string, addr *net.IPNet) error {
+	r.events.add("RemoveAddress")
+	return nil
+}
+
+func TestStopWaitsForPowerOffBeforeIPCleanup(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	log := &events{}
+	slow := &slowPowerOffClient{Client: vc, events: log, started: make(chan struct{}), release: make(chan struct{})}
+	s.vsClient = slow
+	client := startTestServer(t, s)
+	s.SetConfigurator(recordingConfigurator{events: log})
+
+	go func() { _ = client.Control(goipmi.ControlPowerDown) }()
+	<-slow.started
+	stopped := make(chan error)
+	go func() { stopped <- s.Stop(context.Background()) }()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := log.get(); len(got) != 0 {
+		t.Fatalf("%v before the power off was released, want Stop to wait for it", got)
+	}
+	close(slow.release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got, want := log.get(), []string{"PowerOffVM done", "RemoveAddress"}; !slices.Equal(got, want) {
+		t.Errorf("Stop did %v, want the power off to finish before the IP is removed", got)
+	}
+}
+
+func TestResetPrefersGuestReboot(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tools  bool
+		resets int
+	}{
+		{"tools running", true, 0},
+		{"tools absent", false, 1},
+	} {
+		s, vc, _ := newTestServer(t)
+		s.cfg.PreferGuestReboot = true
+		vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected", ToolsRunning: tc.tools})
+		client := startTestServer(t, s)
+
+		if err := client.Control(goipmi.ControlPowerHardReset); err != nil {
+			t.Fatalf("%s: reset: %v", tc.name, err)
+		}
+		if n := called(vc, "RebootGuestVM"); n != 1 {
+			t.Errorf("%s: guest reboot requested %d times, want 1", tc.name, n)
+		}
+		if n := called(vc, "ResetVM"); n != tc.resets {
+			t.Errorf("%s: VM hard reset %d times, want %d", tc.name, n, tc.resets)
+		}
+	}
+}
+
+func TestResetWithoutGuestReboot(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected", ToolsRunning: true})
+	client := startTestServer(t, s)
+
+	if err := client.Control(goipmi.ControlPowerHardReset); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if n := called(vc, "RebootGuestVM"); n != 0 {
+		t.Errorf("guest reboot requested %d times without prefer_guest_reboot", n)
+	}
+	if n := called(vc, "ResetVM"); n != 1 {
+		t.Errorf("VM hard reset %d times, want 1", n)
+	}
+}
@@ -0,0 +1,33 @@
+package ipmi
+
+import (
+	"context"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/syslog"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// ControlSuspend is the OEM chassis control code that suspends the VM. The
+// spec reserves codes above 5; power up resumes a suspended VM.
+const ControlSuspend = goipmi.ChassisControl(0x0e)
+
+// chassisPowerSuspended is set in the current power state byte of the
+// chassis status while the VM is suspended. The spec reserves the bit, so
+// clients that don't know it read a suspended VM as powered off.
+const chassisPowerSuspended = 0x80
+
+// suspend suspends the VM for the OEM chassis control code, which is only
+// accepted when enable_suspend is set
+func (s *Server) suspend(ctx context.Context, vc vsphere.VMClient) goipmi.Response {
+	if !s.cfg.EnableSuspend {
+		s.log.Warn("Suspend command received, but suspend is not enabled")
+		return goipmi.ErrInvalidCommand
+	}
+	s.log.WithField(syslog.EventField, "suspend").Info("Suspend command received")
+	if err := vc.SuspendVM(ctx, s.vm); err != nil {
+		s.log.Errorf("Failed to suspend VM: %v", err)
+		return s.errorCode(err)
+	}
+	return goipmi.CommandCompleted
+}
@@ -0,0 +1,73 @@
+package ipmi
+
+import (
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+func TestSuspendThenResume(t *testing.T) {
+	s, vc, _ := newTestServer(t)
+	s.cfg.EnableSuspend = true
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+	if err := client.SetBootDevice(goipmi.BootDevicePxe); err != nil {
+		t.Fatalf("setting the boot device: %v", err)
+	}
+
+	if err := client.Control(ControlSuspend); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "suspended" {
+		t.Fatalf("VM is %s after suspend, want suspended", state)
+	}
+	if power, _ := chassisStatus(t, client); power&chassisPowerSuspended == 0 || power&goipmi.SystemPower != 0 {
+		t.Errorf("suspended VM reports power state 0x%02x, want suspended and off", power)
+	}
+	if err := client.Control(ControlSuspend); err != nil {
+		t.Errorf("suspending a suspended VM: %v", err)
+	}
+
+	// Power up resumes the VM, which doesn't boot, so the one-time device
+	// stays for the next boot
+	if err := client.Control(goipmi.ControlPowerUp); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if state := vc.VM(s.vm).PowerState; state != "poweredOn" {
+		t.Fatalf("VM is %s after power up, want poweredOn", state)
+	}
+	if power, _ := chassisStatus(t, client); power&chassisPowerSuspended != 0 || power&goipmi.SystemPower == 0 {
+		t.Errorf("resumed VM reports power state 0x%02x, want on", power)
+	}
+	if order := vc.VM(s.vm).BootOrder; len(order) == 0 {
+		t.Error("one-time boot device cleared by a resume")
+	}
+	if oneTime, err := s.db.GetOneTimeBoot(s.key); err != nil || !oneTime {
+		t.Errorf("one-time boot no longer recorded (%v) after a resume", err)
+	}
+}
+
+func TestSuspendRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		state   string
+		want    error
+	}{
+		{"not enabled", false, "poweredOn", goipmi.ErrInvalidCommand},
+		{"powered off", true, "poweredOff", goipmi.ErrInvalidState},
+	} {
+		s, vc, _ := newTestServer(t)
+		s.cfg.EnableSuspend = tc.enabled
+		vc.SetVM(s.vm, mock.VM{PowerState: tc.state, ConnectionState: "connected"})
+		client := startTestServer(t, s)
+
+		if err := client.Control(ControlSuspend); err != tc.want {
+			t.Errorf("%s: suspend returned %v, want %v", tc.name, err, tc.want)
+		}
+		if state := vc.VM(s.vm).PowerState; state != tc.state {
+			t.Errorf("%s: VM is %s after a refused suspend, want %s", tc.name, state, tc.state)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"unicode/utf8"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere"
+)
+
+// System Info parameter selectors. Parameters 0xC0-0xFF are reserved for OEM use.
+const (
+	SystemInfoParamSetInProgress = 0x00
+	SystemInfoParamVMAnnotation  = 0xc0 // VM notes field from vCenter
+	SystemInfoParamAssetTag      = 0xc1 // Asset tag, persisted in the IP database
+	SystemInfoParamVMSizing      = 0xc2 // vCPU count and memory in MB
+	SystemInfoParamFactoryReset  = 0xc3 // Write-only, resets the BMC
+)
+
+const (
+	systemInfoRevision     = 0x11 // Parameter revision reported to clients
+	systemInfoEncodingUTF8 = 0x01
+	systemInfoFirstBlock   = 14  // String bytes carried in set selector 0
+	systemInfoBlockSize    = 16  // String bytes carried in each following set
+	systemInfoMaxString    = 255 // String length is a single byte
+)
+
+// systemInfoResponse is the Get System Info Parameters response
+type systemInfoResponse struct {
+	goipmi.CompletionCode
+	Revision uint8
+	Data     []byte
+}
+
+// MarshalBinary encodes the response
+func (r *systemInfoResponse) MarshalBinary() ([]byte, error) {
+	return append([]byte{byte(r.CompletionCode), r.Revision}, r.Data...), nil
+}
+
+// handleGetSystemInfoParameters handles IPMI get system info parameters commands
+func (s *Server) handleGetSystemInfoParameters(m *goipmi.Message) goipmi.Response {
+	s.log.Debug("Getting system info parameters")
+
+	if len(m.Data) < 4 {
+		return goipmi.ErrShortPacket
+	}
+
+	// Bit 7 of the first byte requests the parameter revision only
+	if m.Data[0]&0x80 != 0 {
+		return &systemInfoResponse{CompletionCode: goipmi.CommandCompleted, Revision: systemInfoRevision}
+	}
+
+	param, set := m.Data[1], m.Data[2]
+	ctx := context.Background()
+	switch param {
+	case SystemInfoParamVMAnnotation:
+		annotation, err := s.vsClient.GetVMAnnotation(ctx, s.vm)
+		if err != nil {
+			s.log.Errorf("Failed to get VM annotation: %v", err)
+			return s.errorCode(err)
+		}
+		return systemInfoString(annotation, set)
+	case SystemInfoParamAssetTag:
+		tag, err := s.db.GetAssetTag(s.key)
+		if err != nil {
+			s.log.Errorf("Failed to get asset tag: %v", err)
+			return goipmi.ErrUnspecified
+		}
+		return systemInfoString(tag, set)
+	case SystemInfoParamVMSizing:
+		hw, err := s.vsClient.GetVMHardware(ctx, s.vm)
+		if err != nil {
+			s.log.Errorf("Failed to get VM hardware: %v", err)
+			return s.errorCode(err)
+		}
+		data := make([]byte, 6)
+		binary.LittleEndian.PutUint16(data[0:], uint16(hw.NumCPU))
+		binary.LittleEndian.PutUint32(data[2:], uint32(hw.MemoryMB))
+		return &systemInfoResponse{CompletionCode: goipmi.CommandCompleted, Revision: systemInfoRevision, Data: data}
+	default:
+		return goipmi.CompletionCode(CompletionCodeParamUnsupported)
+	}
+}
+
+// handleSetSystemInfoParameters handles IPMI set system info parameters commands
+func (s *Server) handleSetSystemInfoParameters(m *goipmi.Message) goipmi.Response {
+	s.log.Debug("Setting system info parameters")
+
+	if len(m.Data) < 2 {
+		return goipmi.ErrShortPacket
+	}
+
+	switch m.Data[0] {
+	case SystemInfoParamSetInProgress:
+		return goipmi.CommandCompleted // Writes are applied as soon as they complete
+	case SystemInfoParamAssetTag:
+		tag, complete, code := s.assetTagWriter.write(m.Data[1:])
+		if code != goipmi.CommandCompleted || !complete {
+			return code
+		}
+
+		ctx := context.Background()
+		if err := s.db.SetAssetTag(s.key, tag); err != nil {
+			s.log.Errorf("Failed to store asset tag: %v", err)
+			return goipmi.ErrUnspecified
+		}
+		s.log.Infof("Asset tag set to %q", tag)
+
+		if s.cfg.AssetTagAttribute != "" {
+			if err := s.client(m).SetCustomAttribute(ctx, s.vm, s.cfg.AssetTagAttribute, tag); err != nil {
+				s.log.Errorf("Failed to sync asset tag to vSphere: %v", err)
+				return s.errorCode(err)
+			}
+		}
+		return goipmi.CommandCompleted
+	case SystemInfoParamFactoryReset:
+		return s.handleFactoryReset(m)
+	case SystemInfoParamVMSizing:
+		if !s.cfg.AllowResize {
+			return goipmi.CompletionCode(CompletionCodeParamReadOnly)
+		}
+		if len(m.Data) < 7 {
+			return goipmi.ErrShortPacket
+		}
+		hw := vsphere.VMHardware{
+			NumCPU:   int(binary.LittleEndian.Uint16(m.Data[1:])),
+			MemoryMB: int(binary.LittleEndian.Uint32(m.Data[3:])),
+		}
+
+		if err := s.client(m).SetVMHardware(context.Background(), s.vm, hw); err != nil {
+			s.log.Errorf("Failed to resize VM to %d vCPUs and %d MB: %v", hw.NumCPU, hw.MemoryMB, err)
+			return s.errorCode(err)
+		}
+		s.hardware.Store(nil) // Get Device ID reads the new sizing
+		s.log.Infof("VM resized to %d vCPUs and %d MB", hw.NumCPU, hw.MemoryMB)
+		return goipmi.CommandCompleted
+	default:
+		return goipmi.CompletionCode(CompletionCodeParamUnsupported)
+	}
+}
+
+// stringWriter reassembles a string parameter written over several sets
+type stringWriter struct {
+	mu     sync.Mutex
+	data   []byte
+	length int
+}
+
+// write applies one set of a string parameter, returning the string once
+// all of its bytes have been written
+func (w *stringWriter) write(data []byte) (string, bool, goipmi.CompletionCode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	set := data[0]
+	if set == 0 {
+		if len(data) < 3 {
+			return "", false, goipmi.ErrShortPacket
+		}
+		if data[1] != systemInfoEncodingUTF8 && data[1] != 0x00 { // UTF-8 or ASCII+Latin1
+			return "", false, goipmi.ErrInvalidPacket
+		}
+		w.length = int(data[2])
+		w.data = make([]byte, w.length)
+		copy(w.data, data[3:])
+		return w.complete(systemInfoFirstBlock)
+	}
+
+	if w.data == nil {
+		return "", false, goipmi.ErrInvalidState // Set 0 must come first
+	}
+	start := systemInfoFirstBlock + int(set-1)*systemInfoBlockSize
+	if start >= w.length {
+		return "", false, goipmi.ErrParamRange
+	}
+	copy(w.data[start:], data[1:])
+	return w.complete(start + systemInfoBlockSize)
+}
+
+// reset discards a partially written string
+func (w *stringWriter) reset() {
+	w.mu.Lock()
+	w.data = nil
+	w.mu.Unlock()
+}
+
+// complete returns the string if written covers all of it
+func (w *stringWriter) complete(written int) (string, bool, goipmi.CompletionCode) {
+	if written < w.length {
+		return "", false, goipmi.CommandCompleted
+	}
+	value := string(w.data)
+	w.data = nil
+	return value, true, goipmi.CommandCompleted
+}
+
+// systemInfoString encodes one set (block) of a string parameter. Set 0
+// carries the encoding, total length and the first 14 bytes; every
+// following set carries the next 16 bytes.
+func systemInfoString(value string, set uint8) goipmi.Response {
+	str := truncateUTF8(value, systemInfoMaxString)
+
+	var data []byte
+	if set == 0 {
+		data = []byte{set, systemInfoEncodingUTF8, byte(len(str))}
+		data = append(data, padBlock(str, 0, systemInfoFirstBlock)...)
+	} else {
+		start := systemInfoFirstBlock + int(set-1)*systemInfoBlockSize
+		if start >= len(str) {
+			return goipmi.ErrParamRange
+		}
+		data = append([]byte{set}, padBlock(str, start, systemInfoBlockSize)...)
+	}
+
+	return &systemInfoResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		Revision:       systemInfoRevision,
+		Data:           data,
+	}
+}
+
+// padBlock returns size bytes of str starting at start, zero padded
+func padBlock(str string, start, size int) []byte {
+	block := make([]byte, size)
+	if start < len(str) {
+		copy(block, str[start:])
+	}
+	return block
+}
+
+// truncateUTF8 shortens s to at most max bytes without splitting a rune
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
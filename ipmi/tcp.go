@@ -0,0 +1,169 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxFrameSize is the largest RMCP message accepted, matching the
+// simulator's UDP receive buffer
+const maxFrameSize = 1024
+
+// minRelayTimeout is the shortest the bridge waits for the simulator to
+// answer, for BMCs configured not to wait for commands when stopping
+const minRelayTimeout = 5 * time.Second
+
+// tcpBridge accepts RMCP messages framed over TCP and relays them to the
+// UDP simulator. Each frame is a 2-byte big-endian length followed by the
+// raw RMCP datagram; responses are framed the same way. Commands the
+// simulator can't route are answered directly.
+type tcpBridge struct {
+	listener net.Listener
+	target   *net.UDPAddr
+	answer   answerFunc
+	timeout  time.Duration // How long to wait for the simulator to answer
+	log      *logrus.Entry
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// newTCPBridge starts listening on addr and relays frames to target,
+// waiting up to timeout for each answer
+func newTCPBridge(addr *net.TCPAddr, target *net.UDPAddr, answer answerFunc, timeout time.Duration, log *logrus.Entry) (*tcpBridge, error) {
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on tcp %s: %v", addr, err)
+	}
+
+	b := &tcpBridge{
+		listener: listener,
+		target:   target,
+		answer:   answer,
+		timeout:  timeout,
+		log:      log,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	b.wg.Add(1)
+	go b.serve()
+
+	return b, nil
+}
+
+// serve accepts connections until the listener is closed
+func (b *tcpBridge) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+
+		b.mu.Lock()
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handleConn(conn)
+
+			b.mu.Lock()
+			delete(b.conns, conn)
+			b.mu.Unlock()
+		}()
+	}
+}
+
+// handleConn relays frames from a single TCP client to the simulator
+func (b *tcpBridge) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 2)
+	buf := make([]byte, maxFrameSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return // client closed
+		}
+
+		size := int(binary.BigEndian.Uint16(header))
+		if size == 0 || size > maxFrameSize {
+			b.log.Warnf("Dropping TCP client %s: invalid frame size %d", conn.RemoteAddr(), size)
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			return
+		}
+		if reason := checkPacket(buf[:size]); reason != "" {
+			countMalformed(reason)
+			b.log.Debugf("Dropping malformed frame from TCP client %s: %s", conn.RemoteAddr(), reason)
+			continue
+		}
+
+		var resp []byte
+		respond, ok := b.answer(buf[:size])
+		if ok && respond != nil {
+			resp = respond()
+		} else if !ok {
+			var err error
+			resp, err = b.relay(buf[:size])
+			if err != nil {
+				// The simulator silently drops messages it can't parse, so
+				// a missing response is not fatal for the connection
+				b.log.Debugf("No response from simulator for TCP client %s: %v", conn.RemoteAddr(), err)
+				continue
+			}
+		}
+		if resp == nil {
+			continue
+		}
+
+		binary.BigEndian.PutUint16(header, uint16(len(resp)))
+		if _, err := conn.Write(append(header, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// relay sends a frame to the simulator and returns its response. Each frame
+// is relayed from a socket of its own, so a response arriving after the
+// timeout is dropped with the socket rather than read as the answer to the
+// next frame.
+func (b *tcpBridge) relay(frame []byte) ([]byte, error) {
+	udpConn, err := net.DialUDP("udp4", nil, b.target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to simulator: %v", err)
+	}
+	defer udpConn.Close()
+
+	if _, err := udpConn.Write(frame); err != nil {
+		return nil, fmt.Errorf("failed to relay frame: %v", err)
+	}
+	_ = udpConn.SetReadDeadline(time.Now().Add(b.timeout))
+	resp := make([]byte, maxFrameSize)
+	n, err := udpConn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+// Stop closes the listener and all client connections
+func (b *tcpBridge) Stop() {
+	_ = b.listener.Close()
+
+	b.mu.Lock()
+	for conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
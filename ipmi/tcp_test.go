@@ -0,0 +1,90 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testPacket builds an unauthenticated IPMI v1.5 request
+func testPacket(sequence uint32, netfn, command uint8, data ...byte) []byte {
+	buf := []byte{rmcpVersion1, 0x00, 0xff, rmcpClassIPMI, AuthTypeNone}
+	buf = binary.LittleEndian.AppendUint32(buf, sequence)
+	buf = binary.LittleEndian.AppendUint32(buf, 0) // Session ID
+	header := []byte{uint8(ipmiHeaderSize + len(data)), 0x20, netfn << 2, 0, 0x81, 0, command}
+	header[3] = checksum(header[1], header[2])
+	buf = append(buf, header...)
+	buf = append(buf, data...)
+	return append(buf, checksum(append(header[4:7:7], data...)...))
+}
+
+// packetSequence returns the session sequence number of a packet
+func packetSequence(buf []byte) uint32 {
+	return binary.LittleEndian.Uint32(buf[rmcpHeaderSize+1:])
+}
+
+func TestTCPBridgeDropsLateResponses(t *testing.T) {
+	const relayTimeout = 50 * time.Millisecond
+
+	// The simulator answers the first request only after the bridge has
+	// given up on it
+	sim, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	go func() {
+		buf := make([]byte, maxFrameSize)
+		for first := true; ; first = false {
+			n, client, err := sim.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if first {
+				time.Sleep(2 * relayTimeout)
+			}
+			_, _ = sim.WriteToUDP(buf[:n], client)
+		}
+	}()
+
+	bridge, err := newTCPBridge(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, sim.LocalAddr().(*net.UDPAddr),
+		func([]byte) (func() []byte, bool) { return nil, false }, relayTimeout, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Stop()
+
+	conn, err := net.Dial("tcp", bridge.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(sequence uint32) {
+		frame := testPacket(sequence, 0x06, 0x01)
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(frame))), frame...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(1)
+	time.Sleep(4 * relayTimeout) // The late response has arrived by now
+	send(2)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	if seq := packetSequence(resp); seq != 2 {
+		t.Errorf("got response to request %d, want 2", seq)
+	}
+}
@@ -0,0 +1,175 @@
+package ipmi
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// udpClientIdle is how long a client may be silent before its relay is closed
+const udpClientIdle = 60 * time.Second
+
+// maxUDPClients bounds the clients relayed at once by a single server
+const maxUDPClients = 64
+
+// maxUDPAnswers bounds the packets a single server answers directly at once
+const maxUDPAnswers = 16
+
+// udpFront listens on the BMC address and relays well-formed RMCP packets
+// to the simulator on loopback. Packets the simulator can't safely parse
+// are dropped and counted, so a malformed packet can't stop the listener.
+// Commands the simulator can't route are answered directly, off the read
+// loop so a slow vCenter can't hold up other packets.
+type udpFront struct {
+	conn    *net.UDPConn
+	target  *net.UDPAddr
+	answer  answerFunc
+	log     *logrus.Entry
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	clients map[string]*net.UDPConn // Relay connection by client address
+	answers chan struct{}           // Holds a token per packet being answered
+}
+
+// newUDPFront starts listening on addr and relays packets to target
+func newUDPFront(addr *net.UDPAddr, target *net.UDPAddr, answer answerFunc, log *logrus.Entry) (*udpFront, error) {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %v", addr, err)
+	}
+
+	f := &udpFront{
+		conn:    conn,
+		target:  target,
+		answer:  answer,
+		log:     log,
+		clients: make(map[string]*net.UDPConn),
+		answers: make(chan struct{}, maxUDPAnswers),
+	}
+
+	f.wg.Add(1)
+	go f.serve()
+
+	return f, nil
+}
+
+// serve reads packets until the listener is closed
+func (f *udpFront) serve() {
+	defer f.wg.Done()
+	buf := make([]byte, maxFrameSize)
+	for {
+		n, client, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // listener closed
+		}
+
+		if reason := checkPacket(buf[:n]); reason != "" {
+			countMalformed(reason)
+			f.log.Debugf("Dropping malformed packet from %s: %s", client, reason)
+			continue
+		}
+
+		if respond, ok := f.answer(buf[:n]); ok {
+			if respond != nil {
+				f.respond(client, respond)
+			}
+			continue
+		}
+
+		relay, err := f.relay(client)
+		if err != nil {
+			f.log.Debugf("Dropping packet from %s: %v", client, err)
+			continue
+		}
+		if _, err := relay.Write(buf[:n]); err != nil {
+			f.log.Errorf("Failed to relay packet to simulator: %v", err)
+		}
+	}
+}
+
+// respond sends a client the response built by respond, in the background.
+// Packets beyond maxUDPAnswers at once are dropped; clients retry them.
+func (f *udpFront) respond(client *net.UDPAddr, respond func() []byte) {
+	select {
+	case f.answers <- struct{}{}:
+	default:
+		f.log.Debugf("Dropping packet from %s: too many packets being answered", client)
+		return
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer func() { <-f.answers }()
+		if resp := respond(); resp != nil {
+			if _, err := f.conn.WriteToUDP(resp, client); err != nil {
+				f.log.Debugf("Failed to answer %s: %v", client, err)
+			}
+		}
+	}()
+}
+
+// relay returns the connection relaying a client's packets, creating it
+// on first use
+func (f *udpFront) relay(client *net.UDPAddr) (*net.UDPConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := client.String()
+	if relay, ok := f.clients[key]; ok {
+		return relay, nil
+	}
+	if len(f.clients) >= maxUDPClients {
+		return nil, fmt.Errorf("too many clients")
+	}
+
+	relay, err := net.DialUDP("udp4", nil, f.target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to simulator: %v", err)
+	}
+	f.clients[key] = relay
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.reply(client, relay)
+
+		f.mu.Lock()
+		delete(f.clients, key)
+		f.mu.Unlock()
+		_ = relay.Close()
+	}()
+	return relay, nil
+}
+
+// reply sends the simulator's responses back to the client from the BMC
+// address until the client goes idle
+func (f *udpFront) reply(client *net.UDPAddr, relay *net.UDPConn) {
+	buf := make([]byte, maxFrameSize)
+	for {
+		_ = relay.SetReadDeadline(time.Now().Add(udpClientIdle))
+		n, err := relay.Read(buf)
+		if err != nil {
+			return // idle or closed
+		}
+		if _, err := f.conn.WriteToUDP(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+// Stop closes the listener and all relay connections
+func (f *udpFront) Stop() {
+	_ = f.conn.Close()
+
+	f.mu.Lock()
+	for _, relay := range f.clients {
+		_ = relay.Close()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
@@ -0,0 +1,140 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/subtle"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// IPMI user commands the simulator doesn't implement
+const (
+	CommandGetUserAccess   = 0x44
+	CommandSetUserPassword = 0x47
+)
+
+// configuredUserID is the user ID of the configured user, as reported by
+// Get Session Info
+const configuredUserID = 0x01
+
+// Set User Password operations, in the low two bits of the second byte
+const (
+	passwordDisable = 0x00
+	passwordEnable  = 0x01
+	passwordSet     = 0x02
+	passwordTest    = 0x03
+)
+
+// CompletionCodePasswordTestFailed is returned when a tested password
+// doesn't match
+const CompletionCodePasswordTestFailed = 0x80
+
+// userAccessResponse is the Get User Access response
+type userAccessResponse struct {
+	goipmi.CompletionCode
+	MaxUsers     uint8
+	EnabledUsers uint8
+	FixedNames   uint8
+	Access       uint8 // IPMI messaging bit and privilege limit
+}
+
+// userEnabled reports whether the configured user can set up sessions
+func (s *Server) userEnabled() bool {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	return !s.userDisabled
+}
+
+// setUserEnabled enables or disables the configured user. Open sessions
+// are kept, so an administrator can enable the user again.
+func (s *Server) setUserEnabled(enabled bool) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	s.userDisabled = !enabled
+}
+
+// handleGetUserAccess reports the configured user with Administrator
+// access, or none while it is disabled, and every other user ID as
+// unused. Only the configured user can be named, so no user ID is
+// reported as having a fixed name.
+func (s *Server) handleGetUserAccess(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 2 {
+		return goipmi.ErrShortPacket
+	}
+	id := m.Data[1] & 0x3f
+	if id == 0 || id >= maxUsers {
+		return goipmi.ErrParamRange
+	}
+
+	enabled := s.userEnabled()
+	var count uint8
+	access := uint8(0x0f) // No access
+	if enabled {
+		count = 1
+		if id == configuredUserID {
+			access = 0x10 | PrivLevelAdmin // IPMI messaging enabled
+		}
+	}
+	return &userAccessResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		MaxUsers:       maxUsers - 1,
+		EnabledUsers:   count,
+		Access:         access,
+	}
+}
+
+// handleSetUserPassword enables, disables, sets and tests the password of
+// the configured user. A new password applies to new sessions; open ones
+// keep theirs, as with a credential rotation through the admin API.
+func (s *Server) handleSetUserPassword(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 2 {
+		return goipmi.ErrShortPacket
+	}
+	if s.privilege(m.SessionID) < PrivLevelAdmin {
+		return goipmi.ErrPrivLevel
+	}
+	id := m.Data[0] & 0x3f
+	if id == 0 || id >= maxUsers {
+		return goipmi.ErrParamRange
+	}
+
+	switch m.Data[1] & 0x03 {
+	case passwordEnable, passwordDisable:
+		if id != configuredUserID {
+			return goipmi.ErrInvalidCommand
+		}
+		enable := m.Data[1]&0x03 == passwordEnable
+		s.setUserEnabled(enable)
+		s.log.Infof("User %d enabled %v through IPMI", id, enable)
+		return goipmi.CommandCompleted
+	case passwordSet:
+		password := m.Data[2:]
+		if len(password) != 16 && len(password) != 20 {
+			return goipmi.CompletionCode(CompletionCodeInvalidLength)
+		}
+		password = bytes.TrimRight(password, "\000")
+		if len(password) > 16 {
+			return goipmi.ErrParamRange // Longer than the RMCP password
+		}
+		if id != configuredUserID {
+			return goipmi.ErrInvalidCommand
+		}
+		name, _ := s.credentials()
+		s.RotateCredentials(name, string(password), false)
+		s.log.Infof("Password of user %d changed through IPMI", id)
+		return goipmi.CommandCompleted
+	case passwordTest:
+		password := m.Data[2:]
+		if len(password) != 16 && len(password) != 20 {
+			return goipmi.CompletionCode(CompletionCodeInvalidLength)
+		}
+		_, current := s.credentials()
+		var padded [20]byte
+		copy(padded[:], current[:])
+		if id != configuredUserID || subtle.ConstantTimeCompare(password, padded[:len(password)]) != 1 {
+			return goipmi.CompletionCode(CompletionCodePasswordTestFailed)
+		}
+		return goipmi.CommandCompleted
+	}
+	return goipmi.ErrInvalidCommand
+}
@@ -0,0 +1,116 @@
+package ipmi
+
+import (
+	"net"
+	"testing"
+
+	goipmi "github.com/ooneko/goipmi"
+)
+
+// padded returns s NUL-padded to n bytes
+func padded(s string, n int) []byte {
+	buf := make([]byte, n)
+	copy(buf, s)
+	return buf
+}
+
+// userAccess returns the enabled user count and the access byte Get User
+// Access reports for a user ID
+func userAccess(t *testing.T, client *goipmi.Client, id uint8) (uint8, uint8) {
+	t.Helper()
+	resp, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandGetUserAccess, 0x0e, id)
+	if err != nil {
+		t.Fatalf("Get User Access of user %d: %v", id, err)
+	}
+	return resp[1], resp[3]
+}
+
+// setUserPassword sends Set User Password with a 16-byte password
+func setUserPassword(client *goipmi.Client, id, operation uint8, password string) error {
+	_, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandSetUserPassword,
+		append([]byte{id, operation}, padded(password, 16)...)...)
+	return err
+}
+
+// canLogIn reports whether a session can be opened with a user and password
+func canLogIn(t *testing.T, s *Server, user, password string) bool {
+	t.Helper()
+	client, err := goipmi.NewClient(&goipmi.Connection{
+		Hostname:  "127.0.0.1",
+		Port:      s.udpFront.conn.LocalAddr().(*net.UDPAddr).Port,
+		Username:  user,
+		Password:  password,
+		Interface: "lan",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		return false
+	}
+	_ = client.Close()
+	return true
+}
+
+func TestUserRoundTrip(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	client := startTestServer(t, s)
+	app := uint8(goipmi.NetworkFunctionApp)
+
+	if _, err := send(client, app, uint8(goipmi.CommandSetUserName), append([]byte{configuredUserID}, padded("ops", 16)...)...); err != nil {
+		t.Fatalf("Set User Name: %v", err)
+	}
+	if err := setUserPassword(client, configuredUserID, passwordSet, "s3cret"); err != nil {
+		t.Fatalf("setting the password: %v", err)
+	}
+
+	name, err := client.GetUserName(configuredUserID)
+	if err != nil || name.Username != "ops" {
+		t.Errorf("Get User Name returned %+v (%v), want ops", name, err)
+	}
+	if count, access := userAccess(t, client, configuredUserID); count != 1 || access != 0x10|PrivLevelAdmin {
+		t.Errorf("Get User Access reports %d enabled users and access 0x%02x, want 1 and 0x14", count, access)
+	}
+	if err := setUserPassword(client, configuredUserID, passwordTest, "s3cret"); err != nil {
+		t.Errorf("testing the new password: %v", err)
+	}
+	if err := setUserPassword(client, configuredUserID, passwordTest, "password"); err != goipmi.CompletionCode(CompletionCodePasswordTestFailed) {
+		t.Errorf("testing the old password returned %v, want a failed test", err)
+	}
+	if !canLogIn(t, s, "ops", "s3cret") || canLogIn(t, s, "admin", "password") {
+		t.Error("sessions aren't set up with the new credentials only")
+	}
+
+	if err := setUserPassword(client, configuredUserID, passwordDisable, ""); err != nil {
+		t.Fatalf("disabling the user: %v", err)
+	}
+	if count, access := userAccess(t, client, configuredUserID); count != 0 || access != 0x0f {
+		t.Errorf("Get User Access of the disabled user reports %d enabled users and access 0x%02x, want 0 and no access", count, access)
+	}
+	if canLogIn(t, s, "ops", "s3cret") {
+		t.Error("disabled user set up a session")
+	}
+
+	// The open session outlives the change and can enable the user again
+	if err := setUserPassword(client, configuredUserID, passwordEnable, ""); err != nil {
+		t.Fatalf("enabling the user: %v", err)
+	}
+	if !canLogIn(t, s, "ops", "s3cret") {
+		t.Error("enabled user can't set up a session")
+	}
+}
+
+func TestOtherUsersCantBeAdded(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	client := startTestServer(t, s)
+
+	if _, err := send(client, uint8(goipmi.NetworkFunctionApp), uint8(goipmi.CommandSetUserName), append([]byte{0x02}, padded("ops", 16)...)...); err != goipmi.ErrInvalidCommand {
+		t.Errorf("naming user 2 returned %v, want invalid command", err)
+	}
+	if err := setUserPassword(client, 0x02, passwordSet, "s3cret"); err != goipmi.ErrInvalidCommand {
+		t.Errorf("setting the password of user 2 returned %v, want invalid command", err)
+	}
+	if name, err := client.GetUserName(0x02); err != nil || name.Username != "" {
+		t.Errorf("Get User Name of user 2 returned %+v (%v), want no name", name, err)
+	}
+}
@@ -0,0 +1,254 @@
+package ipmi
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/sel"
+	"github.com/vbmc-vsphere/syslog"
+)
+
+// IPMI watchdog timer commands, in the App network function
+const (
+	CommandResetWatchdogTimer = 0x22
+	CommandSetWatchdogTimer   = 0x24
+	CommandGetWatchdogTimer   = 0x25
+)
+
+// CompletionCodeWatchdogUninitialized is returned by Reset Watchdog Timer
+// before the timer has been set
+const CompletionCodeWatchdogUninitialized = 0x80
+
+// Bits of the timer use byte
+const (
+	watchdogDontLog  = 0x80 // Expiry isn't logged to the SEL
+	watchdogDontStop = 0x40 // Set leaves a running timer running; Get reports the timer as running
+	watchdogUseMask  = 0x07
+)
+
+// Timeout actions, in the low bits of the timer actions byte. They double
+// as the watchdog 2 sensor's event offsets.
+const (
+	watchdogActionNone       = 0x00
+	watchdogActionHardReset  = 0x01
+	watchdogActionPowerDown  = 0x02
+	watchdogActionPowerCycle = 0x03
+	watchdogActionMask       = 0x07
+)
+
+// watchdogExpiredMask covers the timer use expiration flags, one bit per
+// timer use from BIOS FRB2 (1) to OEM (5)
+const watchdogExpiredMask = 0x3e
+
+// watchdogTick is the unit of the countdown
+const watchdogTick = 100 * time.Millisecond
+
+// watchdog is the state of the BMC's watchdog timer
+type watchdog struct {
+	mu         sync.Mutex
+	set        bool          // Set Watchdog Timer has been called, so the timer can be started
+	use        uint8         // Timer use byte as set, without the running bit
+	actions    uint8         // Timeout action and pre-timeout interrupt
+	pretimeout uint8         // Pre-timeout interval in seconds, reported only
+	expired    uint8         // Timer use expiration flags
+	countdown  uint16        // Initial countdown in 100ms ticks
+	present    uint16        // Countdown left when the timer stopped
+	deadline   time.Time     // Expiry of the running timer
+	stop       chan struct{} // Closed to cancel the running timer, nil while stopped
+}
+
+// watchdogResponse is the Get Watchdog Timer response
+type watchdogResponse struct {
+	goipmi.CompletionCode
+	Use        uint8
+	Actions    uint8
+	Pretimeout uint8
+	Expired    uint8
+	Countdown  uint16
+	Present    uint16
+}
+
+// MarshalBinary encodes the response
+func (r *watchdogResponse) MarshalBinary() ([]byte, error) {
+	buf := []byte{byte(r.CompletionCode), r.Use, r.Actions, r.Pretimeout, r.Expired, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(buf[5:], r.Countdown)
+	binary.LittleEndian.PutUint16(buf[7:], r.Present)
+	return buf, nil
+}
+
+// handleSetWatchdogTimer handles IPMI set watchdog timer commands. The
+// timer is stopped unless the request asks for a running timer to keep
+// running, in which case it restarts with the new countdown.
+func (s *Server) handleSetWatchdogTimer(m *goipmi.Message) goipmi.Response {
+	if len(m.Data) < 6 {
+		return goipmi.ErrShortPacket
+	}
+	use, actions, pretimeout := m.Data[0], m.Data[1], m.Data[2]
+	countdown := binary.LittleEndian.Uint16(m.Data[4:])
+	if actions&watchdogActionMask > watchdogActionPowerCycle {
+		return goipmi.ErrInvalidPacket
+	}
+	if uint32(pretimeout)*10 > uint32(countdown) {
+		return goipmi.ErrInvalidPacket // The pre-timeout must not outlast the countdown
+	}
+
+	w := &s.watchdog
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.set = true
+	w.use = use &^ watchdogDontStop
+	w.actions = actions
+	w.pretimeout = pretimeout
+	w.expired &^= m.Data[3] & watchdogExpiredMask
+	w.countdown = countdown
+	w.present = countdown
+
+	if use&watchdogDontStop != 0 && w.stop != nil {
+		s.armWatchdog()
+		s.log.Infof("Watchdog timer restarted with %s countdown", time.Duration(countdown)*watchdogTick)
+		return goipmi.CommandCompleted
+	}
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+		s.log.Info("Watchdog timer stopped")
+	}
+	return goipmi.CommandCompleted
+}
+
+// handleGetWatchdogTimer handles IPMI get watchdog timer commands
+func (s *Server) handleGetWatchdogTimer(m *goipmi.Message) goipmi.Response {
+	w := &s.watchdog
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	resp := &watchdogResponse{
+		CompletionCode: goipmi.CommandCompleted,
+		Use:            w.use,
+		Actions:        w.actions,
+		Pretimeout:     w.pretimeout,
+		Expired:        w.expired,
+		Countdown:      w.countdown,
+		Present:        w.present,
+	}
+	if w.stop != nil {
+		resp.Use |= watchdogDontStop
+		left := w.deadline.Sub(s.clock.Now())
+		resp.Present = uint16(max(0, (left+watchdogTick-1)/watchdogTick))
+	}
+	return resp
+}
+
+// handleResetWatchdogTimer handles IPMI reset watchdog timer commands,
+// starting the countdown over from its initial value
+func (s *Server) handleResetWatchdogTimer(m *goipmi.Message) goipmi.Response {
+	w := &s.watchdog
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.set {
+		return goipmi.CompletionCode(CompletionCodeWatchdogUninitialized)
+	}
+	if w.stop == nil {
+		s.log.Infof("Watchdog timer started with %s countdown", time.Duration(w.countdown)*watchdogTick)
+	}
+	s.armWatchdog()
+	return goipmi.CommandCompleted
+}
+
+// armWatchdog starts the countdown from its initial value, canceling the
+// running one. The caller holds the watchdog lock.
+func (s *Server) armWatchdog() {
+	w := &s.watchdog
+	if w.stop != nil {
+		close(w.stop)
+	}
+	stop := make(chan struct{})
+	w.stop = stop
+	d := time.Duration(w.countdown) * watchdogTick
+	w.deadline = s.clock.Now().Add(d)
+	go s.runWatchdog(stop, d)
+}
+
+// runWatchdog waits for the countdown to elapse and takes the timeout
+// action, unless the timer is stopped or re-armed first
+func (s *Server) runWatchdog(stop chan struct{}, d time.Duration) {
+	select {
+	case <-stop:
+		return
+	case <-s.clock.After(d):
+	}
+
+	// The timer may have been stopped or re-armed as it expired; only the
+	// countdown that is still current acts
+	w := &s.watchdog
+	w.mu.Lock()
+	if w.stop != stop {
+		w.mu.Unlock()
+		return
+	}
+	w.stop = nil
+	w.present = 0
+	use, actions := w.use, w.actions
+	w.expired |= 1 << (use & watchdogUseMask) & watchdogExpiredMask
+	w.mu.Unlock()
+
+	s.watchdogTimeout(use, actions)
+}
+
+// stopWatchdog stops the running timer, so the VM isn't acted on after
+// the BMC stops
+func (s *Server) stopWatchdog() {
+	w := &s.watchdog
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// watchdogTimeout takes the timeout action of an expired timer. The guest
+// is presumed hung, so it isn't asked to reboot or shut down first.
+func (s *Server) watchdogTimeout(use, actions uint8) {
+	if !s.inflight.begin() {
+		return // Expired as the server stops
+	}
+	defer s.inflight.end()
+
+	ctx := context.Background()
+	log := s.log.WithField(syslog.EventField, "watchdog_expired")
+	action := actions & watchdogActionMask
+
+	var err error
+	switch action {
+	case watchdogActionHardReset:
+		log.Warn("Watchdog timer expired, resetting VM")
+		if err = s.vsClient.ResetVM(ctx, s.vm); err == nil {
+			s.consumeOneTimeBoot(ctx, s.vsClient)
+		}
+	case watchdogActionPowerDown:
+		log.Warn("Watchdog timer expired, powering off VM")
+		err = s.vsClient.PowerOffVM(ctx, s.vm)
+	case watchdogActionPowerCycle:
+		log.Warn("Watchdog timer expired, power cycling VM")
+		err = s.powerCycle(ctx, s.vsClient)
+	default:
+		log.Warn("Watchdog timer expired, no timeout action set")
+	}
+	if err != nil {
+		s.log.Errorf("Failed to take watchdog timeout action: %v", err)
+	}
+
+	if use&watchdogDontLog == 0 {
+		// Event data 2 holds the pre-timeout interrupt and the timer use
+		s.logEvent(sel.Event{
+			SensorType:   sel.SensorTypeWatchdog2,
+			SensorNumber: sensorWatchdog,
+			EventType:    sel.EventTypeSensorSpecific,
+			Data:         [3]uint8{0xc0 | action, actions&0x70 | use&watchdogUseMask, 0xff},
+		})
+	}
+}
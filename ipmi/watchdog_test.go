@@ -0,0 +1,102 @@
+package ipmi
+
+import (
+	"testing"
+	"time"
+
+	goipmi "github.com/ooneko/goipmi"
+	"github.com/vbmc-vsphere/vsphere/mock"
+)
+
+// watchdogUseOSLoad is the OS load timer use, whose expiration flag is 0x10
+const watchdogUseOSLoad = 0x04
+
+// setWatchdog sets the watchdog timer of a server to take action after
+// countdown, without starting it
+func setWatchdog(t *testing.T, client *goipmi.Client, action uint8, countdown time.Duration) {
+	t.Helper()
+	ticks := uint16(countdown / watchdogTick)
+	_, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandSetWatchdogTimer,
+		watchdogUseOSLoad, action, 0, watchdogExpiredMask, uint8(ticks), uint8(ticks>>8))
+	if err != nil {
+		t.Fatalf("setting the watchdog timer: %v", err)
+	}
+}
+
+// resetWatchdog starts the watchdog timer of a server over
+func resetWatchdog(t *testing.T, client *goipmi.Client) {
+	t.Helper()
+	if _, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandResetWatchdogTimer); err != nil {
+		t.Fatalf("resetting the watchdog timer: %v", err)
+	}
+}
+
+func TestWatchdogExpiryActsOnce(t *testing.T) {
+	for _, tc := range []struct {
+		action uint8
+		method string
+	}{
+		{watchdogActionHardReset, "ResetVM"},
+		{watchdogActionPowerDown, "PowerOffVM"},
+	} {
+		s, vc, fake := newTestServer(t)
+		vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+		client := startTestServer(t, s)
+
+		setWatchdog(t, client, tc.action, 2*time.Second)
+		resetWatchdog(t, client)
+		waitFor(t, "the watchdog countdown", func() bool { return fake.Waiters() == 1 })
+		fake.Advance(time.Second)
+		time.Sleep(10 * time.Millisecond)
+		if n := called(vc, tc.method); n != 0 {
+			t.Fatalf("%s called %d times before the watchdog expired", tc.method, n)
+		}
+
+		fake.Advance(time.Second)
+		waitFor(t, tc.method, func() bool { return called(vc, tc.method) == 1 })
+		fake.Advance(30 * time.Second) // Within the session idle timeout
+		time.Sleep(10 * time.Millisecond)
+		if n := called(vc, tc.method); n != 1 {
+			t.Errorf("%s called %d times by one expiry, want 1", tc.method, n)
+		}
+
+		resp, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandGetWatchdogTimer)
+		if err != nil {
+			t.Fatalf("getting the watchdog timer: %v", err)
+		}
+		if resp[0]&watchdogDontStop != 0 || resp[3] != 1<<watchdogUseOSLoad {
+			t.Errorf("watchdog after expiry has use 0x%02x and expiration flags 0x%02x, want stopped with the OS load flag", resp[0], resp[3])
+		}
+	}
+}
+
+func TestWatchdogRearmAndStop(t *testing.T) {
+	s, vc, fake := newTestServer(t)
+	vc.SetVM(s.vm, mock.VM{PowerState: "poweredOn", ConnectionState: "connected"})
+	client := startTestServer(t, s)
+
+	if _, err := send(client, uint8(goipmi.NetworkFunctionApp), CommandResetWatchdogTimer); err != goipmi.CompletionCode(CompletionCodeWatchdogUninitialized) {
+		t.Errorf("reset before set returned %v, want 0x%02x", err, CompletionCodeWatchdogUninitialized)
+	}
+
+	// Re-arming halfway through restarts the countdown
+	setWatchdog(t, client, watchdogActionHardReset, 2*time.Second)
+	resetWatchdog(t, client)
+	waitFor(t, "the watchdog countdown", func() bool { return fake.Waiters() == 1 })
+	fake.Advance(time.Second)
+	resetWatchdog(t, client)
+	waitFor(t, "the new countdown", func() bool { return fake.Waiters() == 2 })
+	fake.Advance(1500 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if n := called(vc, "ResetVM"); n != 0 {
+		t.Fatalf("VM reset %d times by a re-armed timer before its new countdown ended", n)
+	}
+
+	// Setting the timer again without don't-stop stops it
+	setWatchdog(t, client, watchdogActionHardReset, 2*time.Second)
+	fake.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if n := called(vc, "ResetVM"); n != 0 {
+		t.Errorf("VM reset %d times by a stopped timer", n)
+	}
+}
@@ -1,34 +1,83 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"math/big"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vbmc-vsphere/admin"
 	"github.com/vbmc-vsphere/config"
 	"github.com/vbmc-vsphere/ipmi"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/netconfig"
+	"github.com/vbmc-vsphere/syslog"
 	"github.com/vbmc-vsphere/vsphere"
 )
 
-// ipRange calculates the number of IP addresses between start and end
-func ipRange(start, end net.IP) int64 {
-	var i int64
-	for i = 1; ; i++ {
-		if start.Equal(end) {
-			break
+// maxIPCount caps the number of available IPs reported for a range, far
+// beyond the BMCs one host can run, so IPv6 ranges fit an int64
+const maxIPCount = 1 << 24
+
+// ipRange calculates the number of IP addresses between start and end,
+// inclusive. IPv6 ranges can hold more addresses than an int64, so the
+// count is computed rather than enumerated.
+func ipRange(start, end net.IP) (*big.Int, error) {
+	if start == nil || end == nil || len(start) != len(end) {
+		return nil, fmt.Errorf("%s and %s are not addresses of the same family", start, end)
+	}
+	if bytes.Compare(end, start) < 0 {
+		return nil, fmt.Errorf("reversed range %s-%s", start, end)
+	}
+	count := new(big.Int).Sub(new(big.Int).SetBytes(end), new(big.Int).SetBytes(start))
+	return count.Add(count, big.NewInt(1)), nil
+}
+
+// availableIPs returns the number of IPs in a range less the exclusions
+// within it, capped at maxIPCount
+func availableIPs(r config.IPRange) (int64, error) {
+	count, err := ipRange(config.ParseIP(r.Start), config.ParseIP(r.End))
+	if err != nil {
+		return 0, err
+	}
+	start, end := config.ParseIP(r.Start), config.ParseIP(r.End)
+	for _, span := range r.ExcludedSpans() {
+		// Exclusions of server.ip_range can lie outside a vCenter's sub-range
+		lo, hi := span[0], span[1]
+		if len(lo) != len(start) || bytes.Compare(hi, start) < 0 || bytes.Compare(lo, end) > 0 {
+			continue
+		}
+		if bytes.Compare(lo, start) < 0 {
+			lo = start
+		}
+		if bytes.Compare(hi, end) > 0 {
+			hi = end
 		}
-		incrementIP(start)
+		excluded, err := ipRange(lo, hi)
+		if err != nil {
+			return 0, fmt.Errorf("invalid exclusion: %v", err)
+		}
+		count.Sub(count, excluded)
+	}
+	if count.Cmp(big.NewInt(maxIPCount)) > 0 {
+		return maxIPCount, nil
 	}
-	return i
+	return count.Int64(), nil
 }
 
-// incrementIP increments an IP address by 1
+// incrementIP increments an IPv4 or IPv6 address by 1
 func incrementIP(ip net.IP) {
 	for i := len(ip) - 1; i >= 0; i-- {
 		ip[i]++
@@ -38,137 +87,502 @@ func incrementIP(ip net.IP) {
 	}
 }
 
+// selfPing pings a sample of the started BMCs on their assigned IPs and
+// reports any that don't answer, which points at an IP conflict or routing issue
+func selfPing(log *logrus.Logger, servers []*ipmi.Server, cfg config.SelfPingConfig) {
+	sample := servers
+	if cfg.Sample > 0 && cfg.Sample < len(servers) {
+		sample = make([]*ipmi.Server, cfg.Sample)
+		for i, j := range rand.Perm(len(servers))[:cfg.Sample] {
+			sample[i] = servers[j]
+		}
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var unreachable []string
+	for _, server := range sample {
+		wg.Add(1)
+		go func(s *ipmi.Server) {
+			defer wg.Done()
+			if err := s.Ping(timeout); err != nil {
+				log.Warnf("BMC on %s did not answer presence ping: %v", s.Addr(), err)
+				mu.Lock()
+				unreachable = append(unreachable, s.Addr())
+				mu.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	if len(unreachable) > 0 {
+		log.Errorf("%d of %d sampled BMCs unreachable, check for IP conflicts or routing issues: %v",
+			len(unreachable), len(sample), unreachable)
+		return
+	}
+	log.Infof("All %d sampled BMCs answered presence ping", len(sample))
+}
+
+// startManager starts the manager BMC on its dedicated address, with the
+// default IPMI credentials
+func startManager(ctx context.Context, log *logrus.Logger, cfg *config.Config, netmask net.IP) (*ipmi.Server, error) {
+	manager := ipmi.NewManager(config.ParseIP(cfg.Server.Manager.IP), netmask, cfg.Server)
+	if cfg.DryRun.Enabled {
+		dryRun := netconfig.DryRun{Log: log.WithField("bmc", "manager")}
+		manager.SetConfigurator(dryRun)
+		manager.SetProber(dryRun)
+	}
+	manager.SetCredentials(cfg.IPMI.DefaultUser, cfg.IPMI.DefaultPassword)
+	if err := manager.Start(ctx); err != nil {
+		return nil, err
+	}
+	log.Infof("Started manager BMC on %s", manager.Addr())
+	return manager, nil
+}
+
+// powerOnDiscovered powers on every managed VM that is currently powered
+// off, waiting delay between power-ons so the VMs don't all boot at once
+func powerOnDiscovered(ctx context.Context, log *logrus.Logger, vsClient *vsphere.Client, vms []*object.VirtualMachine, delay time.Duration) {
+	states, err := vsClient.GetPowerStates(ctx, vms)
+	if err != nil {
+		log.Errorf("Failed to get VM power states: %v", err)
+		return
+	}
+
+	poweredOn := 0
+	for _, vm := range vms {
+		if states[vm.Reference().Value] != "poweredOff" {
+			continue
+		}
+		if poweredOn > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+		poweredOn++
+		log.Warnf("Powering on discovered VM %s (server.power_on_discovered is enabled)", vm.Name())
+		if err := vsClient.PowerOnVM(ctx, vm); err != nil {
+			log.Errorf("Failed to power on VM %s: %v", vm.Name(), err)
+		}
+	}
+}
+
+// restorePower applies each VM's power restore policy, as set through IPMI
+// or else as configured, and records the VMs' power states as their last
+// known states. always-off leaves VMs as found; always-on powers on VMs
+// found powered off; restore-previous powers on those that were on when
+// last seen.
+func restorePower(ctx context.Context, log *logrus.Logger, t *target, ipdb *config.IPDB, policy string, vms []*object.VirtualMachine) {
+	states, err := t.vsClient.GetPowerStates(ctx, vms)
+	if err != nil {
+		log.Errorf("Failed to get VM power states, not applying the power restore policy: %v", err)
+		return
+	}
+
+	keyed := make(map[string]string, len(vms))
+	for _, vm := range vms {
+		vmID := t.key(vm)
+		state := states[vsphere.VMKey(vm)]
+		if state != "" {
+			keyed[vmID] = state
+		}
+		if state != "poweredOff" {
+			continue
+		}
+		vmPolicy := policy
+		if p, ok, err := ipdb.GetRestorePolicy(vmID); err == nil && ok {
+			vmPolicy = p
+		}
+		switch vmPolicy {
+		case config.PowerRestoreAlwaysOn:
+		case config.PowerRestorePrevious:
+			previous, err := ipdb.GetPowerState(vmID)
+			if err != nil {
+				log.Errorf("Failed to get last known power state of VM %s: %v", vm.Name(), err)
+				continue
+			}
+			if previous != "poweredOn" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		log.Infof("Powering on VM %s per power restore policy %s", vm.Name(), vmPolicy)
+		if err := t.vsClient.PowerOnVM(ctx, vm); err != nil {
+			log.Errorf("Failed to power on VM %s: %v", vm.Name(), err)
+			continue
+		}
+		keyed[vmID] = "poweredOn"
+	}
+
+	if err := ipdb.SetPowerStates(keyed); err != nil {
+		log.Errorf("Failed to record VM power states: %v", err)
+	}
+}
+
+// activate claims the addresses of BMCs started in standby, e.g. when this
+// node takes over from a failed peer. It returns the first failure after
+// trying every BMC.
+func activate(ctx context.Context, log *logrus.Logger, servers []*ipmi.Server, pingCfg config.SelfPingConfig) error {
+	log.Infof("Activating %d BMCs", len(servers))
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Activate(ctx); err != nil {
+			log.Errorf("Failed to activate BMC on %s: %v", server.Addr(), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to activate BMC on %s: %v", server.Addr(), err)
+			}
+		}
+	}
+	if pingCfg.Enabled {
+		selfPing(log, servers, pingCfg)
+	}
+	return firstErr
+}
+
+// selectVMs keeps the VMs in the requested power state, if any, sorted so IP
+// assignment and the max_vms cap are deterministic
+func selectVMs(ctx context.Context, log *logrus.Logger, vsClient *vsphere.Client, powerState string, vms []*object.VirtualMachine) ([]*object.VirtualMachine, error) {
+	if powerState != "" {
+		states, err := vsClient.GetPowerStates(ctx, vms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get VM power states: %v", err)
+		}
+		filtered := vms[:0]
+		for _, vm := range vms {
+			if states[vm.Reference().Value] == powerState {
+				filtered = append(filtered, vm)
+			}
+		}
+		log.Infof("Managing %d of %d VMs in power state %s", len(filtered), len(vms), powerState)
+		vms = filtered
+	}
+
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].Name() != vms[j].Name() {
+			return vms[i].Name() < vms[j].Name()
+		}
+		return vms[i].Reference().Value < vms[j].Reference().Value
+	})
+	return vms, nil
+}
+
+// duplicateUUIDs returns the reference values of VMs sharing their instance
+// UUID with another VM, e.g. after a bad clone or import, logging each
+// conflict
+func duplicateUUIDs(ctx context.Context, log *logrus.Logger, vsClient *vsphere.Client, vms []*object.VirtualMachine) map[string]bool {
+	uuids, err := vsClient.GetInstanceUUIDs(ctx, vms)
+	if err != nil {
+		log.Errorf("Failed to check VM instance UUIDs: %v", err)
+		return nil
+	}
+
+	byUUID := make(map[string][]*object.VirtualMachine)
+	for _, vm := range vms {
+		if uuid := uuids[vm.Reference().Value]; uuid != "" {
+			byUUID[uuid] = append(byUUID[uuid], vm)
+		}
+	}
+
+	duplicates := make(map[string]bool)
+	for uuid, shared := range byUUID {
+		if len(shared) < 2 {
+			continue
+		}
+		names := make([]string, len(shared))
+		for i, vm := range shared {
+			names[i] = fmt.Sprintf("%s (%s)", vm.Name(), vm.Reference().Value)
+			duplicates[vm.Reference().Value] = true
+		}
+		log.WithField(syslog.EventField, "duplicate_instance_uuid").
+			Errorf("VMs %s share instance UUID %s; their BMCs are keyed by managed object ID and report distinct GUIDs, but the VMs should be given unique UUIDs",
+				strings.Join(names, ", "), uuid)
+	}
+	return duplicates
+}
+
 func main() {
+	// Subcommands run without starting any servers
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "db":
+			os.Exit(runDB(os.Args[2:]))
+		case "power":
+			os.Exit(runPower(os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags
 	configFile := flag.String("config", "config.json", "Path to configuration file")
+	dryRunFlag := flag.Bool("dry-run", false, "Run against a simulated vCenter without changing interface addresses")
 	flag.Parse()
 
 	// Load configuration
-	cfg, err := config.LoadFromFile(*configFile)
+	load := config.LoadFromFile
+	if *dryRunFlag {
+		load = config.LoadDryRunFromFile
+	}
+	cfg, err := load(*configFile)
 	if err != nil {
 		fmt.Printf("Error loading configuration: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Servers log through the standard logger, so it is set up the same way
 	log := logrus.New()
-	log.SetLevel(cfg.GetLogLevel())
-	log.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
+	for _, logger := range []*logrus.Logger{log, logrus.StandardLogger()} {
+		logger.SetLevel(cfg.GetLogLevel())
+		logger.SetFormatter(cfg.GetLogFormatter())
+	}
+	// Forward power and boot events to syslog. Servers log through the
+	// standard logger, so the hook is added there.
+	if cfg.Syslog.Address != "" {
+		hook, err := syslog.NewHook(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.Facility, cfg.Syslog.Severity)
+		if err != nil {
+			log.Fatalf("Failed to set up syslog: %v", err)
+		}
+		logrus.AddHook(hook)
+		log.Infof("Forwarding events to syslog server %s (%s)", cfg.Syslog.Address, cfg.Syslog.Network)
+	}
+
+	// Serve the admin API. Per-VM log streams follow the standard logger.
+	var adminServer *admin.Server
+	if cfg.Admin.Listen != "" {
+		hub := admin.NewLogHub()
+		logrus.AddHook(hub)
+		adminServer = admin.NewServer(cfg.Admin.Listen, hub)
+		if err := adminServer.Start(); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+	}
+
 	log.Info("Starting vBMC-vSphere service")
 	log.Infof("Using config file: %s", *configFile)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Create vSphere client
+	// Point the vCenter settings at a simulator in a dry run
+	dbPath := ipdbPath
+	if cfg.DryRun.Enabled {
+		sim, err := startDryRun(ctx, log, cfg)
+		if err != nil {
+			log.Fatalf("Failed to start dry run: %v", err)
+		}
+		defer sim.stop()
+		dbPath = sim.ipdbPath()
+	}
+
+	// Create vSphere clients, one per vCenter
 	log.Info("Connecting to vSphere...")
-	vsClient, err := vsphere.NewClient(ctx, cfg.VCenter.IP, cfg.VCenter.User, cfg.VCenter.Password, cfg.VCenter.Datacenter)
-	if err != nil {
-		log.Fatalf("Failed to create vSphere client: %v", err)
+	var targets []*target
+	for _, v := range cfg.VCenterTargets() {
+		t, err := connectTarget(ctx, log, v)
+		if err != nil {
+			log.Fatalf("Failed to connect to vCenter %s: %v", v.IP, err)
+		}
+		targets = append(targets, t)
 	}
 
-	// Get list of VMs
-	log.Infof("Retrieving VMs from folder: %s", cfg.VCenter.Folder)
-	vms, err := vsClient.GetVMs(ctx, cfg.VCenter.Folder)
-	log.Infof("Found %d VMs", len(vms))
+	// Get list of VMs across the vCenters, in the requested power states
+	vms, err := listVMs(ctx, log, targets)
 	if err != nil {
 		log.Fatalf("Failed to get VMs: %v", err)
 	}
+	if len(targets) > 1 {
+		log.Infof("Managing %d VMs across %d vCenters", len(vms), len(targets))
+	}
+
+	// Enforce the managed VM cap
+	vms = capVMs(log, vms, cfg.Server.MaxVMs)
 
-	// Create IP address pool
-	startIP := net.ParseIP(cfg.Server.IPRange.Start).To4()
-	endIP := net.ParseIP(cfg.Server.IPRange.End).To4()
+	// Detect VMs sharing an instance UUID so their BMCs can't be confused
+	duplicates := duplicateKeys(ctx, log, vms)
 
-	// Calculate number of available IPs
-	ipCount := ipRange(startIP, endIP)
-	if ipCount < int64(len(vms)) {
-		log.Fatalf("Not enough IP addresses in range for all VMs. Need %d, have %d", len(vms), ipCount)
+	// Check the range has an address for every VM
+	if cfg.Server.AllocationMode == config.AllocationPortPerVM {
+		portCount := cfg.Server.PortRange.End - cfg.Server.PortRange.Start + 1
+		if portCount < len(vms) {
+			log.Fatalf("Not enough ports in range for all VMs. Need %d, have %d", len(vms), portCount)
+		}
+	} else if cfg.VCenterTargets()[0].IPRange == nil {
+		// Calculate number of available IPs, leaving out excluded addresses
+		ipCount, err := availableIPs(cfg.Server.IPRange)
+		if err != nil {
+			log.Fatalf("Invalid server.ip_range: %v", err)
+		}
+		if ipCount < int64(len(vms)) {
+			log.Fatalf("Not enough IP addresses in range for all VMs. Need %d, have %d", len(vms), ipCount)
+		}
+	} else {
+		// Each vCenter draws from its own sub-range
+		for t, group := range byTarget(vms) {
+			ipCount, err := availableIPs(cfg.Server.RangeFor(t.cfg))
+			if err != nil {
+				log.Fatalf("Invalid ip_range of vCenter %s: %v", t.label(), err)
+			}
+			if ipCount < int64(len(group)) {
+				log.Fatalf("Not enough IP addresses in range of vCenter %s for all its VMs. Need %d, have %d", t.label(), len(group), ipCount)
+			}
+		}
 	}
 
 	// Create IPMI servers for each VM
 	var wg sync.WaitGroup
-	servers := make([]*ipmi.Server, len(vms))
+	limiter := ipmi.NewLimiter(cfg.Server.MaxInflightCommands)
 
-	// Parse netmask
-	netmask := net.ParseIP(cfg.Server.Network.Netmask)
-	if netmask == nil {
-		log.Fatalf("Failed to parse netmask: %s", cfg.Server.Network.Netmask)
+	// Parse netmask, given as a netmask or prefix length
+	mask, err := cfg.Server.Network.Mask(cfg.Server.IPv6())
+	if err != nil {
+		log.Fatalf("Failed to parse netmask: %v", err)
 	}
+	netmask := net.IP(mask)
 
 	// Initialize IP database
-	ipdb, err := config.NewIPDB("/var/lib/vbmc-vsphere/ipdb.json")
+	ipdb, err := config.NewIPDB(dbPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize IP database: %v", err)
 	}
 
 	// Create a map of existing VMs for cleanup
 	existingVMs := make(map[string]bool)
-	for _, vm := range vms {
-		existingVMs[vm.Reference().Value] = true
+	for _, v := range vms {
+		existingVMs[v.key()] = true
 	}
 
-	// Cleanup stale entries
-	if err := ipdb.Cleanup(existingVMs); err != nil {
+	// Free the IPs of VMs that are gone, at once or once their lease expires
+	if cfg.Server.IPLeaseSeconds > 0 {
+		seen := make([]string, 0, len(existingVMs))
+		for vmID := range existingVMs {
+			seen = append(seen, vmID)
+		}
+		if err := ipdb.MarkSeen(seen); err != nil {
+			log.Errorf("Failed to renew IP leases: %v", err)
+		}
+		expired, err := ipdb.PruneExpired(time.Duration(cfg.Server.IPLeaseSeconds) * time.Second)
+		if err != nil {
+			log.Errorf("Failed to prune expired IP leases: %v", err)
+		}
+		if len(expired) > 0 {
+			log.Infof("Freed the IPs of %d VMs whose leases expired: %v", len(expired), expired)
+		}
+	} else if err := ipdb.Cleanup(existingVMs); err != nil {
 		log.Errorf("Failed to cleanup IP database: %v", err)
 	}
 
-	// Get currently assigned IPs
+	// Get currently assigned IPs and ports
 	usedIPs, err := ipdb.GetAssignedIPs()
 	if err != nil {
 		log.Errorf("Failed to get assigned IPs: %v", err)
 		return
 	}
+	usedPorts, err := ipdb.GetAssignedPorts()
+	if err != nil {
+		log.Errorf("Failed to get assigned ports: %v", err)
+		return
+	}
 
-	currentIP := make(net.IP, len(startIP))
-	copy(currentIP, startIP)
-
-	for i, vm := range vms {
-		vmID := vm.Reference().Value
-		// Check if VM already has an assigned IP
-		assignedIP, exists, err := ipdb.GetIP(vmID)
-if err != nil {
-			log.Errorf("Failed to get IP for VM %s: %v", vmID, err)
-			return
-		}
-if exists {
-			log.Debugf("Using previously assigned IP %s for VM %s", assignedIP, vm.Name())
-			currentIP = net.ParseIP(assignedIP)
-		} else {
-			// Find next available IP
-			ipStr := currentIP.String()
-			for usedIPs[ipStr] {
-				incrementIP(currentIP)
-				ipStr = currentIP.String()
-				if currentIP.Equal(endIP) {
-					log.Fatalf("No more available IPs in range")
-				}
-			}
-			usedIPs[ipStr] = true
+	bmcs := &fleet{
+		cfg:       cfg,
+		log:       log,
+		targets:   targets,
+		limiter:   limiter,
+		ipdb:      ipdb,
+		netmask:   netmask,
+		servers:   make(map[string]*ipmi.Server, len(vms)),
+		owners:    make(map[string]*target, len(vms)),
+		usedIPs:   usedIPs,
+		usedPorts: usedPorts,
+		conflicts: make(map[string]bool),
+	}
 
-			// Save the IP assignment
-			if err := ipdb.AssignIP(vmID, ipStr); err != nil {
-				log.Errorf("Failed to save IP assignment for VM %s: %v", vm.Name(), err)
-			}
+	for _, v := range vms {
+		vm := v.vm
+		ip, port, err := bmcs.allocate(v.target, vm)
+		if err != nil {
+			log.Fatalf("Failed to allocate an address for VM %s: %v", vm.Name(), err)
 		}
 
-		server := ipmi.NewServer(vm, vsClient, currentIP, netmask, cfg.Server.NIC)
-		servers[i] = server
-
 		wg.Add(1)
-		go func(s *ipmi.Server) {
+		go func(v targetVM, ip net.IP, port int) {
 			defer wg.Done()
-			if err := s.Start(ctx); err != nil {
-				log.Errorf("Failed to start IPMI server: %v", err)
+			if err := bmcs.launch(ctx, v.target, v.vm, ip, port, duplicates[v.key()]); err != nil {
+				log.Errorf("Failed to start virtual BMC for VM %s: %v", v.vm.Name(), err)
 			}
-		}(server)
+		}(v, ip, port)
+	}
+
+	// Apply the power restore policy to VMs left powered off while the
+	// service was down, then power on newly managed VMs that are off, if
+	// explicitly enabled
+	for t, group := range byTarget(vms) {
+		restorePower(ctx, log, t, ipdb, cfg.Server.PowerRestorePolicy, group)
+		if cfg.Server.PowerOnDiscovered {
+			powerOnDiscovered(ctx, log, t.vsClient, group, time.Duration(cfg.Server.PowerOnDelay)*time.Second)
+		}
+	}
 
-		vmName := vm.Name()
-		log.Infof("Started virtual BMC for VM %s on IP %s", vmName, currentIP)
+	// Verify the BMCs answer on their assigned IPs once they have all started.
+	// Standby BMCs are checked once activated.
+	if cfg.Server.SelfPing.Enabled && cfg.Server.Startup == config.StartupEager {
+		wg.Wait()
+		selfPing(log, bmcs.list(), cfg.Server.SelfPing)
+	}
 
-		// Increment IP for next VM
-		incrementIP(currentIP)
+	// Watch for the NIC's own addresses changing underneath the BMCs
+	if cfg.Server.NICWatch.Enabled {
+		wg.Wait()
+		if err := ipmi.WatchNIC(ctx, cfg.Server.NIC, bmcs.list, cfg.Server.NICWatch); err != nil {
+			log.Errorf("Failed to watch interface %s: %v", cfg.Server.NIC, err)
+		}
+	}
+
+	// Summarize the fleet on the manager BMC
+	var manager *ipmi.Server
+	if cfg.Server.Manager.Enabled {
+		if manager, err = startManager(ctx, log, cfg, netmask); err != nil {
+			log.Errorf("Failed to start manager BMC: %v", err)
+		}
+	}
+
+	// Serve BMC lookups once every server has read its VM's UUID
+	if adminServer != nil {
+		wg.Wait()
+		adminServer.SetInventory(&bmcInventory{bmcs: bmcs, log: log})
+		adminServer.SetActivator(bmcs.activate)
+		adminServer.SetCanceller(bmcs.cancelTask)
+		adminServer.SetCredentialRotator(bmcs.rotateCredentials)
+		adminServer.SetReconcilePauser(bmcs.pauseReconcile)
+		adminServer.SetCacheInvalidator(bmcs.invalidateCache)
+		adminServer.SetStatus(bmcs.status)
+		adminServer.SetHealthCheck(healthCheck(targets))
+	}
+
+	// Serve Prometheus metrics
+	var metricsServer *metrics.Server
+	if cfg.Server.MetricsAddr != "" {
+		metrics.RegisterSessions(func() int {
+			total := 0
+			for _, server := range bmcs.list() {
+				total += server.Sessions()
+			}
+			return total
+		})
+		metricsServer = metrics.NewServer(cfg.Server.MetricsAddr)
+		if err := metricsServer.Start(); err != nil {
+			log.Fatalf("Failed to start metrics server: %v", err)
+		}
+	}
+
+	// Start and stop BMCs as VMs are created and deleted
+	if cfg.Server.ReconcileInterval > 0 {
+		wg.Wait()
+		bmcs.reconcile(ctx, time.Duration(cfg.Server.ReconcileInterval)*time.Second)
+		log.Infof("Reconciling BMCs with the VMs every %d seconds", cfg.Server.ReconcileInterval)
 	}
 
 	// Handle shutdown gracefully
@@ -179,11 +593,55 @@ if exists {
 	log.Info("Shutting down...")
 	cancel()
 
-	// Stop all servers
-	for _, server := range servers {
-		server.Stop()
+	if adminServer != nil {
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := adminServer.Stop(stopCtx); err != nil {
+			log.Errorf("Failed to stop admin API: %v", err)
+		}
+		stopCancel()
+	}
+	if metricsServer != nil {
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metricsServer.Stop(stopCtx); err != nil {
+			log.Errorf("Failed to stop metrics server: %v", err)
+		}
+		stopCancel()
 	}
 
+	// Stop all servers, collecting cleanup failures for the shutdown report.
+	// Commands in flight are given the stop timeout to finish, unless a
+	// second signal asks not to wait.
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.StopTimeout)*time.Second)
+	go func() {
+		select {
+		case <-sigChan:
+			log.Warn("Second signal received, not waiting for commands in flight")
+			stopCancel()
+		case <-stopCtx.Done():
+		}
+	}()
+	stopped, failedIPs := bmcs.stop(stopCtx)
+	if manager != nil {
+		if err := manager.Stop(stopCtx); err != nil {
+			log.Errorf("Failed to stop manager BMC: %v", err)
+			failedIPs = append(failedIPs, cfg.Server.Manager.IP)
+		}
+	}
+	stopCancel()
+
 	wg.Wait()
+	log.WithFields(logrus.Fields{
+		"stopped":           stopped,
+		"cleanup_failed":    len(failedIPs),
+		"cleanup_failed_ip": failedIPs,
+		"rejected_busy":     limiter.Rejected(),
+		"malformed_packets": ipmi.MalformedPackets(),
+	}).Info("Shutdown report")
+
+	// Exit non-zero so an orchestrator notices addresses left on the NIC
+	if len(failedIPs) > 0 {
+		log.Errorf("Shutdown finished with %d cleanup failures", len(failedIPs))
+		os.Exit(1)
+	}
 	log.Info("Shutdown complete")
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/vbmc-vsphere/config"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestIPRange(t *testing.T) {
+	for _, tc := range []struct {
+		start, end string
+		count      int64
+		valid      bool
+	}{
+		{"127.0.0.10", "127.0.0.20", 11, true},
+		{"127.0.0.10", "127.0.0.10", 1, true},
+		{"127.0.0.255", "127.0.1.0", 2, true},
+		{"127.0.0.20", "127.0.0.10", 0, false},
+		{"127.0.0.10", "::1", 0, false},
+	} {
+		count, err := ipRange(config.ParseIP(tc.start), config.ParseIP(tc.end))
+		if (err == nil) != tc.valid {
+			t.Errorf("%s-%s: got error %v, want valid %v", tc.start, tc.end, err, tc.valid)
+			continue
+		}
+		if tc.valid && count.Int64() != tc.count {
+			t.Errorf("%s-%s holds %v addresses, want %d", tc.start, tc.end, count, tc.count)
+		}
+	}
+}
+
+func TestAvailableIPs(t *testing.T) {
+	for _, tc := range []struct {
+		r    config.IPRange
+		want int64
+	}{
+		{config.IPRange{Start: "127.0.0.10", End: "127.0.0.10"}, 1},
+		{config.IPRange{Start: "127.0.0.10", End: "127.0.0.20", Exclude: []string{"127.0.0.12", "127.0.0.15-127.0.0.17"}}, 7},
+		// Exclusions of server.ip_range are clipped to a vCenter's sub-range
+		{config.IPRange{Start: "127.0.0.10", End: "127.0.0.14", Exclude: []string{"127.0.0.1-127.0.0.11", "127.0.0.30"}}, 3},
+		{config.IPRange{Start: "2001:db8::", End: "2001:db8::ffff:ffff:ffff:ffff"}, maxIPCount},
+	} {
+		got, err := availableIPs(tc.r)
+		if err != nil || got != tc.want {
+			t.Errorf("%+v: %d available (%v), want %d", tc.r, got, err, tc.want)
+		}
+	}
+
+	if _, err := availableIPs(config.IPRange{Start: "127.0.0.20", End: "127.0.0.10"}); err == nil {
+		t.Error("reversed range accepted")
+	}
+}
+
+func TestRangeLargerThanFleet(t *testing.T) {
+	vc := newTestTarget(t, 2)
+	f := newTestFleet(t, vc)
+	if n, err := availableIPs(f.cfg.Server.IPRange); err != nil || n != 11 {
+		t.Fatalf("test range has %d addresses (%v), want 11", n, err)
+	}
+
+	f.reconcileOnce(context.Background())
+	var ips []string
+	for _, server := range f.list() {
+		ips = append(ips, server.IP().String())
+	}
+	slices.Sort(ips)
+	if want := []string{"127.0.0.10", "127.0.0.11"}; !slices.Equal(ips, want) {
+		t.Errorf("BMCs got %v, want the first addresses of the range %v", ips, want)
+	}
+}
+
+func TestRestorePowerPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   []string // Power states of the four VMs afterwards
+	}{
+		{config.PowerRestoreAlwaysOff, []string{"poweredOff", "poweredOn", "poweredOff", "poweredOn"}},
+		{config.PowerRestoreAlwaysOn, []string{"poweredOn", "poweredOn", "poweredOn", "poweredOn"}},
+		{config.PowerRestorePrevious, []string{"poweredOff", "poweredOn", "poweredOn", "poweredOn"}},
+	} {
+		vc := newTestTarget(t, 4)
+		vms := targetVMList(t, vc)
+		ctx := context.Background()
+		db, err := config.NewIPDB(filepath.Join(t.TempDir(), "ipdb.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		// VMs 0 and 2 are off, and 2 was on when last seen. VM 3 is off
+		// with always-on set over IPMI.
+		for _, i := range []int{0, 2, 3} {
+			if err := vc.vsClient.PowerOffVM(ctx, vms[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.SetPowerStates(map[string]string{vc.key(vms[0]): "poweredOff", vc.key(vms[2]): "poweredOn"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetRestorePolicy(vc.key(vms[3]), config.PowerRestoreAlwaysOn); err != nil {
+			t.Fatal(err)
+		}
+
+		restorePower(ctx, testLogger(), vc, db, tc.policy, vms)
+
+		for i, vm := range vms {
+			if state := powerState(t, vc, vm); state != tc.want[i] {
+				t.Errorf("%s: VM %d is %s, want %s", tc.policy, i, state, tc.want[i])
+			}
+			if recorded, _ := db.GetPowerState(vc.key(vm)); recorded != tc.want[i] {
+				t.Errorf("%s: VM %d recorded as %q, want %s", tc.policy, i, recorded, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestDuplicateUUIDs(t *testing.T) {
+	vc := newTestTarget(t, 3)
+	vms := targetVMList(t, vc)
+	ctx := context.Background()
+
+	// Give the second VM the first one's UUID, as a bad clone would
+	uuids, err := vc.vsClient.GetInstanceUUIDs(ctx, vms[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := uuids[vms[0].Reference().Value]
+	task, err := vms[1].Reconfigure(ctx, types.VirtualMachineConfigSpec{InstanceUuid: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates := duplicateUUIDs(ctx, testLogger(), vc.vsClient, vms)
+	if len(duplicates) != 2 || !duplicates[vms[0].Reference().Value] || !duplicates[vms[1].Reference().Value] {
+		t.Errorf("duplicates are %v, want %s and %s sharing %s", duplicates, vms[0].Reference().Value, vms[1].Reference().Value, shared)
+	}
+}
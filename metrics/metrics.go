@@ -0,0 +1,182 @@
+// Package metrics exports BMC activity in the Prometheus format
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Registry holds the service's metrics. It is separate from the default
+// registry so only BMC metrics are exported.
+var Registry = prometheus.NewRegistry()
+
+var (
+	commands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vbmc_ipmi_commands_total",
+		Help: "IPMI commands handled, by network function and command.",
+	}, []string{"netfn", "command"})
+
+	powerActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vbmc_power_actions_total",
+		Help: "Chassis control actions, by action and result.",
+	}, []string{"action", "result"})
+
+	powerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vbmc_vm_powered_on",
+		Help: "Whether each managed VM is powered on, refreshed by the reconcile loop.",
+	}, []string{"vm"})
+
+	unmanagedVMs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vbmc_unmanaged_vms",
+		Help: "VMs found but left without a BMC because server.max_vms was reached.",
+	})
+
+	reconcilePaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "vbmc_reconcile_paused",
+		Help: "Whether the reconcile loop is paused through the admin API.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(commands, powerActions, powerState, unmanagedVMs, reconcilePaused)
+}
+
+// CountCommand counts an IPMI command
+func CountCommand(netfn, command uint8) {
+	commands.WithLabelValues(fmt.Sprintf("0x%02x", netfn), fmt.Sprintf("0x%02x", command)).Inc()
+}
+
+// CountPowerAction counts a chassis control action, with result "success"
+// or "failure"
+func CountPowerAction(action string, ok bool) {
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+	powerActions.WithLabelValues(action, result).Inc()
+}
+
+// SetPowerState records whether a VM is powered on
+func SetPowerState(vm string, on bool) {
+	value := 0.0
+	if on {
+		value = 1
+	}
+	powerState.WithLabelValues(vm).Set(value)
+}
+
+// ForgetVM drops the power state of a VM that is no longer managed
+func ForgetVM(vm string) {
+	powerState.DeleteLabelValues(vm)
+}
+
+// SetUnmanagedVMs records how many VMs were left without a BMC by the
+// max_vms cap
+func SetUnmanagedVMs(n int) {
+	unmanagedVMs.Set(float64(n))
+}
+
+// SetReconcilePaused records whether the reconcile loop is paused
+func SetReconcilePaused(paused bool) {
+	value := 0.0
+	if paused {
+		value = 1
+	}
+	reconcilePaused.Set(value)
+}
+
+// Summary is the state of the whole fleet as recorded in the metrics
+type Summary struct {
+	ManagedVMs    int // VMs whose power state is recorded
+	PoweredOnVMs  int
+	FailedActions int // Chassis control actions that failed since startup
+}
+
+// Summarize reads the fleet's summary from the metrics registry. Power
+// states are refreshed by the reconcile loop, so they lag behind by up to
+// one reconcile interval.
+func Summarize() Summary {
+	var s Summary
+	families, _ := Registry.Gather() // Whatever was gathered is still valid
+	for _, family := range families {
+		switch family.GetName() {
+		case "vbmc_vm_powered_on":
+			for _, metric := range family.GetMetric() {
+				s.ManagedVMs++
+				if metric.GetGauge().GetValue() > 0 {
+					s.PoweredOnVMs++
+				}
+			}
+		case "vbmc_power_actions_total":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "result" && label.GetValue() == "failure" {
+						s.FailedActions += int(metric.GetCounter().GetValue())
+					}
+				}
+			}
+		}
+	}
+	return s
+}
+
+// RegisterSessions exports the number of active IPMI sessions, read from
+// sessions at each scrape
+func RegisterSessions(sessions func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "vbmc_active_sessions",
+		Help: "Active IPMI sessions across all BMCs.",
+	}, func() float64 { return float64(sessions()) }))
+}
+
+// Server serves the metrics over HTTP
+type Server struct {
+	http *http.Server
+	log  *logrus.Entry
+}
+
+// NewServer creates a metrics server for addr
+func NewServer(addr string) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	return &Server{
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		log: logrus.WithField("component", "metrics"),
+	}
+}
+
+// Start listens on the metrics address and serves scrapes in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.http.Addr, err)
+	}
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("Metrics server stopped: %v", err)
+		}
+	}()
+	s.log.Infof("Serving metrics on %s/metrics", listener.Addr())
+	return nil
+}
+
+// Stop closes the listener and open connections
+func (s *Server) Stop(ctx context.Context) error {
+	if err := s.http.Shutdown(ctx); err != nil {
+		return s.http.Close()
+	}
+	return nil
+}
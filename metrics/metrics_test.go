@@ -0,0 +1,18 @@
+package metrics
+
+import "testing"
+
+func TestSummarize(t *testing.T) {
+	SetPowerState("web-01", true)
+	SetPowerState("web-02", false)
+	SetPowerState("db-01", true)
+	ForgetVM("db-01")
+	CountPowerAction("power_up", true)
+	CountPowerAction("power_up", false)
+	CountPowerAction("hard_reset", false)
+
+	want := Summary{ManagedVMs: 2, PoweredOnVMs: 1, FailedActions: 2}
+	if got := Summarize(); got != want {
+		t.Errorf("summary is %+v, want %+v", got, want)
+	}
+}
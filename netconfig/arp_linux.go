@@ -0,0 +1,127 @@
+//go:build linux
+
+package netconfig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ARP operations
+const (
+	arpRequest = 1
+	arpReply   = 2
+)
+
+// arpPacketLength is the length of an Ethernet IPv4 ARP packet
+const arpPacketLength = 28
+
+// ARP probes IPv4 addresses with ARP requests from the unspecified address,
+// per RFC 5227, so the probe itself doesn't claim the address. IPv6
+// addresses aren't probed and are reported free.
+type ARP struct{}
+
+var _ Prober = ARP{}
+
+// Probe broadcasts an ARP request for ip on nic and reports whether any
+// host answers for it within timeout
+func (ARP) Probe(nic string, ip net.IP, timeout time.Duration) (bool, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false, nil
+	}
+	iface, err := net.InterfaceByName(nic)
+	if err != nil {
+		return false, fmt.Errorf("failed to find interface %s: %v", nic, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return false, nil // No Ethernet neighbors to conflict with, e.g. loopback
+	}
+
+	proto := htons(syscall.ETH_P_ARP)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, int(proto))
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return false, fmt.Errorf("%w: opening an ARP socket requires CAP_NET_RAW: %v", ErrPermission, err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open ARP socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		return false, fmt.Errorf("failed to bind ARP socket to %s: %v", nic, err)
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return false, fmt.Errorf("failed to set ARP read timeout: %v", err)
+	}
+
+	broadcast := &syscall.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	if err := syscall.Sendto(fd, arpProbe(iface.HardwareAddr, ip4), 0, broadcast); err != nil {
+		return false, fmt.Errorf("failed to send ARP probe: %v", err)
+	}
+
+	// Other traffic can arrive before the deadline, so read until an
+	// answer or the timeout
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 128)
+	for time.Now().Before(deadline) {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EAGAIN) {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read ARP reply: %v", err)
+		}
+		if answers(buf[:n], iface.HardwareAddr, ip4) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// arpProbe encodes an ARP request for ip from hw with a zero sender address
+func arpProbe(hw net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, arpPacketLength)
+	binary.BigEndian.PutUint16(b[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(b[2:4], syscall.ETH_P_IP)
+	b[4] = 6 // Hardware address length
+	b[5] = 4 // Protocol address length
+	binary.BigEndian.PutUint16(b[6:8], arpRequest)
+	copy(b[8:14], hw)
+	// Sender protocol address and target hardware address stay zero
+	copy(b[24:28], ip)
+	return b
+}
+
+// answers reports whether an ARP packet was sent by another host using ip
+// as its own address, in a reply or a request of its own
+func answers(b []byte, hw net.HardwareAddr, ip net.IP) bool {
+	if len(b) < arpPacketLength || b[4] != 6 || b[5] != 4 {
+		return false
+	}
+	op := binary.BigEndian.Uint16(b[6:8])
+	if op != arpReply && op != arpRequest {
+		return false
+	}
+	return !bytes.Equal(b[8:14], hw) && net.IP(b[14:18]).Equal(ip)
+}
+
+// htons converts a short to network byte order, as AF_PACKET expects
+// protocols
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package netconfig
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ARP is only supported on Linux
+type ARP struct{}
+
+var _ Prober = ARP{}
+
+// Probe fails, as ARP probing is only available on Linux
+func (ARP) Probe(nic string, ip net.IP, timeout time.Duration) (bool, error) {
+	return false, fmt.Errorf("ARP probing is only supported on Linux")
+}
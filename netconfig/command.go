@@ -0,0 +1,51 @@
+package netconfig
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// Command configures addresses by running the ip command from iproute2
+type Command struct{}
+
+var _ Configurator = Command{}
+
+// AddAddress adds addr to nic. IPv6 addresses skip duplicate address
+// detection, which would leave them unusable for listening until it
+// completes.
+func (Command) AddAddress(nic string, addr *net.IPNet) error {
+	args := []string{"addr", "add", addr.String(), "dev", nic}
+	if addr.IP.To4() == nil {
+		args = append(args, "nodad")
+	}
+	return run(args...)
+}
+
+// RemoveAddress removes addr from nic
+func (Command) RemoveAddress(nic string, addr *net.IPNet) error {
+	return run("addr", "del", addr.String(), "dev", nic)
+}
+
+// run runs ip with args, telling the errors callers act on apart by the
+// message ip prints
+func run(args ...string) error {
+	output, err := exec.Command("ip", args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	// Older versions print the errno, newer ones the kernel's extended ack
+	msg := strings.TrimSpace(string(output))
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "file exists"), strings.Contains(lower, "already assigned"):
+		return fmt.Errorf("%w: %s", ErrExists, msg)
+	case strings.Contains(lower, "cannot assign requested address"), strings.Contains(lower, "address not found"):
+		return fmt.Errorf("%w: %s", ErrNotFound, msg)
+	case strings.Contains(lower, "operation not permitted"):
+		return fmt.Errorf("%w: %s", ErrPermission, msg)
+	}
+	return fmt.Errorf("ip %s: %v - %s", strings.Join(args, " "), err, msg)
+}
@@ -0,0 +1,37 @@
+package netconfig
+
+import (
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DryRun logs the addresses it would add and remove without touching the
+// interface
+type DryRun struct {
+	Log *logrus.Entry
+}
+
+var (
+	_ Configurator = DryRun{}
+	_ Prober       = DryRun{}
+)
+
+// AddAddress logs that addr would be added to nic
+func (d DryRun) AddAddress(nic string, addr *net.IPNet) error {
+	d.Log.Infof("Dry run: would add IP %s to interface %s", addr, nic)
+	return nil
+}
+
+// RemoveAddress logs that addr would be removed from nic
+func (d DryRun) RemoveAddress(nic string, addr *net.IPNet) error {
+	d.Log.Infof("Dry run: would remove IP %s from interface %s", addr, nic)
+	return nil
+}
+
+// Probe logs that ip would be probed on nic and reports it free
+func (d DryRun) Probe(nic string, ip net.IP, timeout time.Duration) (bool, error) {
+	d.Log.Infof("Dry run: would probe IP %s on interface %s for conflicts", ip, nic)
+	return false, nil
+}
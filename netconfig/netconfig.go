@@ -0,0 +1,31 @@
+// Package netconfig adds and removes addresses on network interfaces
+package netconfig
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Errors returned by configurators, wrapped with the underlying cause
+var (
+	ErrExists     = errors.New("address already exists")
+	ErrNotFound   = errors.New("address not found")
+	ErrPermission = errors.New("permission denied")
+	ErrInUse      = errors.New("address in use by another host")
+)
+
+// Configurator adds and removes interface addresses. Adding an address
+// that is already present fails with ErrExists and removing one that isn't
+// fails with ErrNotFound, so callers can treat both as done.
+type Configurator interface {
+	AddAddress(nic string, addr *net.IPNet) error
+	RemoveAddress(nic string, addr *net.IPNet) error
+}
+
+// Prober checks whether an address is already in use on the network
+// before it is added, reporting true if another host answered for it
+// within timeout
+type Prober interface {
+	Probe(nic string, ip net.IP, timeout time.Duration) (bool, error)
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+package netconfig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ifaFlagNoDAD is IFA_F_NODAD, skipping IPv6 duplicate address detection
+const ifaFlagNoDAD = 0x02
+
+// netlinkTimeout bounds the wait for the kernel's acknowledgement
+const netlinkTimeout = 5 * time.Second
+
+// netlinkSeq numbers requests so their acknowledgements can be matched
+var netlinkSeq atomic.Uint32
+
+// Netlink configures addresses through rtnetlink, so it works without
+// iproute2 installed
+type Netlink struct{}
+
+var _ Configurator = Netlink{}
+
+// AddAddress adds addr to nic. IPv6 addresses skip duplicate address
+// detection, which would leave them unusable for listening until it
+// completes.
+func (Netlink) AddAddress(nic string, addr *net.IPNet) error {
+	return addrRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, nic, addr)
+}
+
+// RemoveAddress removes addr from nic
+func (Netlink) RemoveAddress(nic string, addr *net.IPNet) error {
+	return addrRequest(syscall.RTM_DELADDR, 0, nic, addr)
+}
+
+// addrRequest sends an address request for nic and waits for the kernel
+// to acknowledge it
+func addrRequest(msgType, flags uint16, nic string, addr *net.IPNet) error {
+	iface, err := net.InterfaceByName(nic)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %v", nic, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	tv := syscall.NsecToTimeval(netlinkTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("failed to set netlink read timeout: %v", err)
+	}
+
+	seq := netlinkSeq.Add(1)
+	msg := addrMessage(msgType, flags, seq, iface.Index, addr)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to send netlink request: %v", err)
+	}
+	return readAck(fd, seq)
+}
+
+// addrMessage encodes an RTM_NEWADDR or RTM_DELADDR request: the netlink
+// header, struct ifaddrmsg and the IFA_LOCAL and IFA_ADDRESS attributes
+func addrMessage(msgType, flags uint16, seq uint32, index int, addr *net.IPNet) []byte {
+	family, ip := uint8(syscall.AF_INET), addr.IP.To4()
+	var ifaFlags uint8
+	if ip == nil {
+		family, ip = syscall.AF_INET6, addr.IP.To16()
+		ifaFlags = ifaFlagNoDAD
+	}
+	scope := uint8(syscall.RT_SCOPE_UNIVERSE)
+	if ip.IsLoopback() {
+		scope = syscall.RT_SCOPE_HOST
+	}
+	ones, _ := addr.Mask.Size()
+
+	attrLen := syscall.SizeofRtAttr + len(ip) // Addresses are 4-byte aligned already
+	b := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofIfAddrmsg+2*attrLen)
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], msgType)
+	binary.NativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	binary.NativeEndian.PutUint32(b[8:12], seq)
+
+	ifa := b[syscall.NLMSG_HDRLEN:]
+	ifa[0] = family
+	ifa[1] = uint8(ones)
+	ifa[2] = ifaFlags
+	ifa[3] = scope
+	binary.NativeEndian.PutUint32(ifa[4:8], uint32(index))
+
+	attrs := ifa[syscall.SizeofIfAddrmsg:]
+	for i, attrType := range []uint16{syscall.IFA_LOCAL, syscall.IFA_ADDRESS} {
+		attr := attrs[i*attrLen:]
+		binary.NativeEndian.PutUint16(attr[0:2], uint16(attrLen))
+		binary.NativeEndian.PutUint16(attr[2:4], attrType)
+		copy(attr[syscall.SizeofRtAttr:], ip)
+	}
+	return b
+}
+
+// readAck reads until the acknowledgement of request seq, returning the
+// error it reports
+func readAck(fd int, seq uint32) error {
+	buf := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read netlink acknowledgement: %v", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to parse netlink acknowledgement: %v", err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != seq || msg.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return fmt.Errorf("short netlink acknowledgement")
+			}
+			errno := -int32(binary.NativeEndian.Uint32(msg.Data[0:4]))
+			if errno == 0 {
+				return nil
+			}
+			return classify(syscall.Errno(errno))
+		}
+	}
+}
+
+// classify wraps the errnos callers act on in the package's errors
+func classify(errno syscall.Errno) error {
+	switch errno {
+	case syscall.EEXIST:
+		return fmt.Errorf("%w: %v", ErrExists, errno)
+	case syscall.EADDRNOTAVAIL:
+		return fmt.Errorf("%w: %v", ErrNotFound, errno)
+	case syscall.EPERM, syscall.EACCES:
+		return fmt.Errorf("%w: %v", ErrPermission, errno)
+	}
+	return errno
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package netconfig
+
+import (
+	"fmt"
+	"net"
+)
+
+// Netlink is only supported on Linux, elsewhere use Command
+type Netlink struct{}
+
+var _ Configurator = Netlink{}
+
+// AddAddress fails, as netlink is only available on Linux
+func (Netlink) AddAddress(nic string, addr *net.IPNet) error {
+	return fmt.Errorf("netlink address configuration is only supported on Linux")
+}
+
+// RemoveAddress fails, as netlink is only available on Linux
+func (Netlink) RemoveAddress(nic string, addr *net.IPNet) error {
+	return fmt.Errorf("netlink address configuration is only supported on Linux")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vmware/govmomi/object"
+)
+
+// powerUsage describes the power subcommand
+const powerUsage = `Usage: vbmc-vsphere power <on|off|reset|cycle|status> [-config config.json] <vm name or UUID>
+`
+
+// powerStateTimeout bounds how long a power cycle waits for the VM to power off
+const powerStateTimeout = 2 * time.Minute
+
+// runPower runs the power subcommand and returns the process exit code
+func runPower(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, powerUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("power "+args[0], flag.ExitOnError)
+	configFile := flags.String("config", "config.json", "Path to configuration file")
+	_ = flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, powerUsage)
+		return 2
+	}
+
+	if err := power(args[0], flags.Arg(0), *configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// power applies a power operation to the VM named by nameOrUUID
+func power(op, nameOrUUID, configFile string) error {
+	switch op {
+	case "on", "off", "reset", "cycle", "status":
+	default:
+		return fmt.Errorf("unknown power operation %q", op)
+	}
+
+	cfg, err := config.LoadFromFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	vsClient, vm, err := findVM(ctx, cfg, nameOrUUID)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "on":
+		err = vsClient.PowerOnVM(ctx, vm)
+	case "off":
+		err = vsClient.PowerOffVM(ctx, vm)
+	case "reset":
+		err = vsClient.ResetVM(ctx, vm)
+	case "cycle":
+		// Matches the BMC's power cycle: off, wait, delay, on
+		if err = vsClient.PowerOffVM(ctx, vm); err != nil {
+			break
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, powerStateTimeout)
+		err = vsClient.WaitForPowerState(waitCtx, vm, "poweredOff")
+		cancel()
+		if err != nil {
+			break
+		}
+		time.Sleep(time.Duration(cfg.Server.PowerCycleDelay) * time.Second)
+		err = vsClient.PowerOnVM(ctx, vm)
+	}
+	if err != nil {
+		return fmt.Errorf("power %s failed for VM %s: %v", op, nameOrUUID, err)
+	}
+
+	state, err := vsClient.GetVMPowerState(ctx, vm)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", nameOrUUID, state)
+	return nil
+}
+
+// findVM finds the VM named by nameOrUUID in the configured vCenters, in
+// order, returning it with a client of its vCenter
+func findVM(ctx context.Context, cfg *config.Config, nameOrUUID string) (*vsphere.Client, *object.VirtualMachine, error) {
+	var lastErr error
+	for _, v := range cfg.VCenterTargets() {
+		vsClient, err := newVCenterClient(ctx, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		vm, err := vsClient.FindVM(ctx, nameOrUUID)
+		if err == nil {
+			return vsClient, vm, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
@@ -0,0 +1,249 @@
+// Package sdr keeps an IPMI Sensor Data Record (SDR) repository
+package sdr
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Record ID values with a special meaning in requests and responses
+const (
+	FirstRecord = 0x0000 // The first record
+	LastRecord  = 0xffff // The last record, or no next record
+)
+
+// Fields of the records' headers and keys
+const (
+	Version           = 0x51 // IPMI v1.5 and v2.0
+	RecordTypeFull    = 0x01
+	RecordTypeCompact = 0x02
+	HeaderSize        = 5
+	OwnerBMC          = 0x20 // Slave address 0x20
+	ownerLUN          = 0x00
+)
+
+// Entities sensors are attached to
+const (
+	EntityProcessor     = 0x03
+	EntitySystemBoard   = 0x07
+	EntitySystemChassis = 0x17
+	EntityFan           = 0x1d
+	EntityMemoryDevice  = 0x20
+)
+
+// Sensor types and event/reading types of analog sensors
+const (
+	SensorTypeTemperature = 0x01
+	SensorTypeFan         = 0x04
+	SensorTypeOtherUnits  = 0x0b
+	EventTypeThreshold    = 0x01
+)
+
+// Sensor units
+const (
+	UnitDegreesC = 1
+	UnitRPM      = 18
+	UnitHertz    = 19
+	UnitMegabyte = 72
+)
+
+// Fields of the records' bodies
+const (
+	initScanning   = 0x40 // Scanning enabled at initialization
+	initEvents     = 0x20 // Events enabled at initialization
+	capAutoRearm   = 0x40
+	capThresholds  = 0x04 // Thresholds are readable, as given by the readable mask
+	capNoEvents    = 0x03 // Event message control: the sensor generates none
+	thresholdLC    = 0x02 // Lower critical, in the readable threshold mask
+	thresholdUC    = 0x10 // Upper critical, in the readable threshold mask
+	comparisonC    = 0x20 // Critical comparison returned, in the reading masks
+	nominalGiven   = 0x01 // Nominal reading specified, in the analog flags
+	unitsNoReading = 0xc0 // Discrete sensors have no analog reading
+	idStringASCII  = 0xc0 // 8-bit ASCII, in the type/length byte
+	maxIDString    = 16
+)
+
+// ErrNotFound is returned for a record ID that isn't in the repository
+var ErrNotFound = errors.New("SDR record not found")
+
+// Record is a sensor data record
+type Record interface {
+	// encode returns the record type and the record's key and body
+	encode() (uint8, []byte)
+}
+
+// Discrete describes a discrete sensor, encoded as a compact sensor record
+type Discrete struct {
+	Number    uint8
+	Entity    uint8
+	Type      uint8  // Sensor type, as in SEL events
+	EventType uint8  // Event/reading type code
+	States    uint16 // Offsets the sensor can assert and report
+	Name      string
+}
+
+// encode encodes the sensor as a compact sensor record
+func (d Discrete) encode() (uint8, []byte) {
+	name := d.Name
+	if len(name) > maxIDString {
+		name = name[:maxIDString]
+	}
+	body := make([]byte, 27, 27+len(name))
+	body[0] = OwnerBMC
+	body[1] = ownerLUN
+	body[2] = d.Number
+	body[3] = d.Entity
+	body[4] = 0x01 // Entity instance
+	body[5] = initScanning | initEvents
+	body[6] = capAutoRearm
+	body[7] = d.Type
+	body[8] = d.EventType
+	binary.LittleEndian.PutUint16(body[9:], d.States)  // Assertion events
+	binary.LittleEndian.PutUint16(body[11:], d.States) // Deassertion events
+	binary.LittleEndian.PutUint16(body[13:], d.States) // Readable states
+	body[15] = unitsNoReading
+	// Units, record sharing, hysteresis and OEM fields are left zero
+	body[26] = idStringASCII | uint8(len(name))
+	return RecordTypeCompact, append(body, name...)
+}
+
+// Analog describes a sensor with a linear reading, encoded as a full
+// sensor record. A raw reading r stands for r * M * 10^Exponent units.
+type Analog struct {
+	Number     uint8
+	Entity     uint8
+	Type       uint8 // Sensor type, other units when zero
+	Unit       uint8
+	M          uint16 // 10 bits
+	Exponent   int8   // 4 bits, -8 to 7
+	Thresholds *Thresholds
+	Name       string
+}
+
+// Thresholds are the raw nominal reading and critical thresholds of an
+// analog sensor
+type Thresholds struct {
+	Nominal       uint8
+	LowerCritical uint8
+	UpperCritical uint8
+}
+
+// encode encodes the sensor as a full sensor record, with the critical
+// thresholds and nominal reading when it has them
+func (a Analog) encode() (uint8, []byte) {
+	name := a.Name
+	if len(name) > maxIDString {
+		name = name[:maxIDString]
+	}
+	body := make([]byte, 43, 43+len(name))
+	body[0] = OwnerBMC
+	body[1] = ownerLUN
+	body[2] = a.Number
+	body[3] = a.Entity
+	body[4] = 0x01 // Entity instance
+	body[5] = initScanning
+	body[6] = capNoEvents
+	body[7] = a.Type
+	if a.Type == 0 {
+		body[7] = SensorTypeOtherUnits
+	}
+	body[8] = EventTypeThreshold
+	// Unsigned readings, no rate or modifier unit
+	body[16] = a.Unit
+	body[18] = 0x00 // Linear
+	body[19] = uint8(a.M)
+	body[20] = uint8(a.M>>8) << 6
+	body[24] = uint8(a.Exponent) << 4 // B exponent is zero
+	body[29] = 0xff                   // Maximum reading
+	body[30] = 0x00                   // Minimum reading
+	if t := a.Thresholds; t != nil {
+		body[6] |= capThresholds
+		body[10] = comparisonC // Lower threshold reading mask
+		body[12] = comparisonC // Upper threshold reading mask
+		body[13] = thresholdLC | thresholdUC
+		body[25] = nominalGiven
+		body[26] = t.Nominal
+		body[32] = t.UpperCritical
+		body[35] = t.LowerCritical
+	}
+	// No hysteresis
+	body[42] = idStringASCII | uint8(len(name))
+	return RecordTypeFull, append(body, name...)
+}
+
+// Info describes the state of the repository
+type Info struct {
+	Entries int
+	Added   time.Time
+}
+
+// Repository holds the records of a BMC's sensors. Records are added when
+// it is created and never change, so no update cancels a reservation.
+type Repository struct {
+	mu          sync.Mutex
+	records     [][]byte // Encoded records, record ID 1 first
+	added       time.Time
+	reservation uint16
+}
+
+// New creates a repository holding records, numbered from 1 in order
+func New(added time.Time, records ...Record) *Repository {
+	repo := &Repository{added: added}
+	for i, r := range records {
+		recordType, body := r.encode()
+		buf := make([]byte, HeaderSize, HeaderSize+len(body))
+		binary.LittleEndian.PutUint16(buf[0:], uint16(i+1))
+		buf[2] = Version
+		buf[3] = recordType
+		buf[4] = uint8(len(body))
+		repo.records = append(repo.records, append(buf, body...))
+	}
+	return repo
+}
+
+// Info returns the state of the repository
+func (r *Repository) Info() Info {
+	return Info{Entries: len(r.records), Added: r.added}
+}
+
+// Reserve returns a new reservation ID, canceling the previous one
+func (r *Repository) Reserve() uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reservation++
+	if r.reservation == 0 {
+		r.reservation = 1 // 0 is never a valid reservation
+	}
+	return r.reservation
+}
+
+// Reserved reports whether id is the current reservation
+func (r *Repository) Reserved(id uint16) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return id != 0 && id == r.reservation
+}
+
+// Get returns the encoded record with the given ID, or the first or last
+// record, and the ID of the record after it. The next ID is LastRecord
+// after the last record.
+func (r *Repository) Get(id uint16) ([]byte, uint16, error) {
+	i := int(id) - 1
+	switch id {
+	case FirstRecord:
+		i = 0
+	case LastRecord:
+		i = len(r.records) - 1
+	}
+	if i < 0 || i >= len(r.records) {
+		return nil, 0, ErrNotFound
+	}
+
+	next := uint16(LastRecord)
+	if i+1 < len(r.records) {
+		next = uint16(i + 2)
+	}
+	return r.records[i], next, nil
+}
@@ -0,0 +1,219 @@
+// Package sel keeps an in-memory IPMI System Event Log (SEL)
+package sel
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vbmc-vsphere/clock"
+)
+
+// RecordSize is the size of an encoded SEL record
+const RecordSize = 16
+
+// Record ID values with a special meaning in requests and responses
+const (
+	FirstRecord = 0x0000 // The oldest record
+	LastRecord  = 0xffff // The newest record, or no next record
+)
+
+// Fields of the system event records the BMC logs
+const (
+	RecordTypeSystemEvent   = 0x02
+	GeneratorBMC            = 0x0020 // Slave address 0x20, LUN 0
+	EventMessageRevision    = 0x04   // IPMI v1.5 and later
+	EventTypeSensorSpecific = 0x6f
+	Deassertion             = 0x80 // Set in the event type for deassertion events
+)
+
+// Sensor types of the events the BMC logs
+const (
+	SensorTypePowerUnit     = 0x09
+	SensorTypeSystemRestart = 0x1d
+	SensorTypeWatchdog2     = 0x23
+)
+
+var (
+	// ErrNotFound is returned for a record ID that isn't in the log
+	ErrNotFound = errors.New("SEL record not found")
+	// ErrReservation is returned for a reservation that isn't current
+	ErrReservation = errors.New("SEL reservation canceled")
+)
+
+// Event is a system event to log
+type Event struct {
+	SensorType   uint8
+	SensorNumber uint8
+	EventType    uint8    // Event/reading type code, with Deassertion set for deassertions
+	Data         [3]uint8 // Event data 1 holds the offset of the event within the sensor type
+}
+
+// Record is a logged event
+type Record struct {
+	ID        uint16
+	Timestamp time.Time
+	Event
+}
+
+// MarshalBinary encodes the record in the SEL record format, least
+// significant byte first
+func (r Record) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, RecordSize)
+	binary.LittleEndian.PutUint16(buf[0:], r.ID)
+	buf[2] = RecordTypeSystemEvent
+	binary.LittleEndian.PutUint32(buf[3:], Timestamp(r.Timestamp))
+	binary.LittleEndian.PutUint16(buf[7:], GeneratorBMC)
+	buf[9] = EventMessageRevision
+	buf[10] = r.SensorType
+	buf[11] = r.SensorNumber
+	buf[12] = r.EventType
+	copy(buf[13:], r.Data[:])
+	return buf, nil
+}
+
+// Timestamp encodes a time as seconds since the epoch. The zero time is
+// encoded as unspecified.
+func Timestamp(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0xffffffff
+	}
+	return uint32(t.Unix())
+}
+
+// Info describes the state of the log
+type Info struct {
+	Entries  int
+	Free     int       // Bytes left before the oldest record is evicted
+	Added    time.Time // Zero if nothing was added yet
+	Erased   time.Time // Zero if the log was never cleared
+	Overflow bool      // Records were evicted to make room
+}
+
+// Log is a fixed-size ring of SEL records. Once full, adding a record
+// evicts the oldest. Record IDs keep increasing across evictions and
+// clears, so clients can tell new records from old ones.
+type Log struct {
+	mu          sync.Mutex
+	clock       clock.Clock
+	records     []Record // Oldest first
+	capacity    int
+	lastID      uint16
+	reservation uint16
+	added       time.Time
+	erased      time.Time
+	overflow    bool
+}
+
+// New creates a log holding up to capacity records
+func New(capacity int, clk clock.Clock) *Log {
+	return &Log{
+		clock:    clk,
+		records:  make([]Record, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+// Add logs an event at the current time and returns its record
+func (l *Log) Add(e Event) Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	if l.lastID == FirstRecord || l.lastID == LastRecord {
+		l.lastID = 1 // Both are reserved
+	}
+	r := Record{ID: l.lastID, Timestamp: l.clock.Now(), Event: e}
+
+	if len(l.records) == l.capacity {
+		copy(l.records, l.records[1:])
+		l.records = l.records[:len(l.records)-1]
+		l.overflow = true
+	}
+	l.records = append(l.records, r)
+	l.added = r.Timestamp
+	return r
+}
+
+// Info returns the state of the log
+func (l *Log) Info() Info {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Info{
+		Entries:  len(l.records),
+		Free:     (l.capacity - len(l.records)) * RecordSize,
+		Added:    l.added,
+		Erased:   l.erased,
+		Overflow: l.overflow,
+	}
+}
+
+// Reserve returns a new reservation ID, canceling the previous one
+func (l *Log) Reserve() uint16 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reservation++
+	if l.reservation == 0 {
+		l.reservation = 1 // 0 is never a valid reservation
+	}
+	return l.reservation
+}
+
+// Reserved reports whether id is the current reservation
+func (l *Log) Reserved(id uint16) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return id != 0 && id == l.reservation
+}
+
+// Get returns the record with the given ID, or the first or last record,
+// and the ID of the record after it. The next ID is LastRecord after the
+// newest record.
+func (l *Log) Get(id uint16) (Record, uint16, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.records) == 0 {
+		return Record{}, 0, ErrNotFound
+	}
+
+	i := -1
+	switch id {
+	case FirstRecord:
+		i = 0
+	case LastRecord:
+		i = len(l.records) - 1
+	default:
+		for j, r := range l.records {
+			if r.ID == id {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		return Record{}, 0, ErrNotFound
+	}
+
+	next := uint16(LastRecord)
+	if i+1 < len(l.records) {
+		next = l.records[i+1].ID
+	}
+	return l.records[i], next, nil
+}
+
+// Clear erases every record. The caller must hold the current reservation,
+// which stays valid so the client can poll the erasure status with it.
+func (l *Log) Clear(reservation uint16) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reservation == 0 || reservation != l.reservation {
+		return ErrReservation
+	}
+	l.records = l.records[:0]
+	l.overflow = false
+	l.erased = l.clock.Now()
+	return nil
+}
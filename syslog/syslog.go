@@ -0,0 +1,156 @@
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventField is the log field that marks an entry as an event to forward
+const EventField = "event"
+
+// queueSize bounds the events waiting to be sent. Events arriving while
+// the queue is full are dropped so logging never blocks IPMI handling.
+const queueSize = 256
+
+// dialTimeout bounds how long a connection attempt to the server may take
+const dialTimeout = 2 * time.Second
+
+// facilities maps syslog facility names to codes per RFC 5424
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severities maps syslog severity names to codes per RFC 5424
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Hook is a logrus hook forwarding event entries to a remote syslog server
+// as RFC 5424 messages. Entries without an event field are ignored.
+type Hook struct {
+	network  string
+	address  string
+	priority int
+	hostname string
+	queue    chan string
+	dropped  atomic.Uint64
+	log      *logrus.Entry
+}
+
+// NewHook creates a hook sending to address over network (udp or tcp)
+// with the given facility and severity names
+func NewHook(network, address, facility, severity string) (*Hook, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("invalid syslog network: %s (must be udp or tcp)", network)
+	}
+	f, ok := facilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility: %s", facility)
+	}
+	s, ok := severities[severity]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog severity: %s", severity)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	h := &Hook{
+		network:  network,
+		address:  address,
+		priority: f*8 + s,
+		hostname: hostname,
+		queue:    make(chan string, queueSize),
+		log:      logrus.WithField("component", "syslog"),
+	}
+	go h.send()
+
+	return h, nil
+}
+
+// Levels returns the levels the hook fires for
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues an event entry for sending without blocking
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	event, ok := entry.Data[EventField]
+	if !ok {
+		return nil
+	}
+
+	select {
+	case h.queue <- h.format(entry, fmt.Sprint(event)):
+	default:
+		if h.dropped.Add(1) == 1 {
+			h.log.Warn("Syslog queue full, dropping events")
+		}
+	}
+	return nil
+}
+
+// format encodes an entry as an RFC 5424 message. The event name is the
+// MSGID and the remaining fields are appended to the message as key=value.
+func (h *Hook) format(entry *logrus.Entry, event string) string {
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		if k != EventField {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var msg strings.Builder
+	msg.WriteString(entry.Message)
+	for _, k := range keys {
+		fmt.Fprintf(&msg, " %s=%q", k, fmt.Sprint(entry.Data[k]))
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s vbmc-vsphere %d %s - %s",
+		h.priority, entry.Time.UTC().Format(time.RFC3339Nano), h.hostname, os.Getpid(), event, msg.String())
+}
+
+// send writes queued events to the server, reconnecting after failures.
+// Events that can't be sent are dropped with a local warning.
+func (h *Hook) send() {
+	var conn net.Conn
+	for msg := range h.queue {
+		if conn == nil {
+			c, err := net.DialTimeout(h.network, h.address, dialTimeout)
+			if err != nil {
+				h.log.Warnf("Failed to connect to syslog server %s, dropping event: %v", h.address, err)
+				continue
+			}
+			conn = c
+		}
+
+		frame := msg
+		if h.network == "tcp" {
+			frame = fmt.Sprintf("%d %s", len(msg), msg) // Octet counting per RFC 6587
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+		if _, err := conn.Write([]byte(frame)); err != nil {
+			h.log.Warnf("Failed to send event to syslog server %s: %v", h.address, err)
+			_ = conn.Close()
+			conn = nil
+			continue
+		}
+		if n := h.dropped.Swap(0); n > 0 {
+			h.log.Warnf("Dropped %d events while the syslog queue was full", n)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbmc-vsphere/config"
+	"github.com/vbmc-vsphere/metrics"
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vmware/govmomi/object"
+)
+
+// target is a vCenter whose VMs get BMCs, with the clients logged in to it
+type target struct {
+	cfg         config.VCenterConfig
+	vsClient    *vsphere.Client
+	privClients map[string]vsphere.VMClient // By IPMI privilege level
+}
+
+// connectTarget logs in to a vCenter with its main account, and separately
+// for each IPMI privilege level with its own credentials
+func connectTarget(ctx context.Context, log *logrus.Logger, v config.VCenterConfig) (*target, error) {
+	sourceIP, err := v.SourceAddress()
+	if err != nil {
+		return nil, fmt.Errorf("invalid vCenter source address: %v", err)
+	}
+	vsClient, err := vsphere.NewClient(ctx, v.IP, v.User, v.Password, v.Datacenter, sourceIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vSphere client: %v", err)
+	}
+	retry := vsphere.RetryPolicy{
+		Attempts: v.TaskAttempts,
+		Delay:    time.Duration(v.TaskRetryDelayMs) * time.Millisecond,
+	}
+	vsClient.SetRetryPolicy(retry)
+
+	privClients := make(map[string]vsphere.VMClient, len(v.PrivilegeCredentials))
+	for level, creds := range v.PrivilegeCredentials {
+		client, err := vsphere.NewClient(ctx, v.IP, creds.User, creds.Password, v.Datacenter, sourceIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vSphere client for %s sessions: %v", level, err)
+		}
+		client.SetRetryPolicy(retry)
+		privClients[level] = client
+		log.Infof("Commands from %s sessions use vCenter user %s", level, creds.User)
+	}
+	return &target{cfg: v, vsClient: vsClient, privClients: privClients}, nil
+}
+
+// newVCenterClient logs in to a vCenter with its main account, for the
+// subcommands
+func newVCenterClient(ctx context.Context, v config.VCenterConfig) (*vsphere.Client, error) {
+	sourceIP, err := v.SourceAddress()
+	if err != nil {
+		return nil, fmt.Errorf("invalid vCenter source address: %v", err)
+	}
+	vsClient, err := vsphere.NewClient(ctx, v.IP, v.User, v.Password, v.Datacenter, sourceIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vSphere client: %v", err)
+	}
+	return vsClient, nil
+}
+
+// vmKey returns the IP database key of a VM of vCenter v. Managed object
+// IDs such as vm-42 repeat across vCenters, so the keys of a named
+// vCenter's VMs are prefixed with its name.
+func vmKey(v config.VCenterConfig, vm *object.VirtualMachine) string {
+	if v.Name == "" {
+		return vsphere.VMKey(vm)
+	}
+	return v.Name + "/" + vsphere.VMKey(vm)
+}
+
+// key returns the IP database key of one of the target's VMs
+func (t *target) key(vm *object.VirtualMachine) string {
+	return vmKey(t.cfg, vm)
+}
+
+// label names the target in logs
+func (t *target) label() string {
+	if t.cfg.Name != "" {
+		return t.cfg.Name
+	}
+	return t.cfg.IP
+}
+
+// targetVM is a VM and the vCenter it was found in
+type targetVM struct {
+	vm     *object.VirtualMachine
+	target *target
+}
+
+// key returns the VM's IP database key
+func (v targetVM) key() string {
+	return v.target.key(v.vm)
+}
+
+// listVMs lists the VMs of every target in its requested power state,
+// merged into one list sorted like selectVMs sorts a single vCenter's
+func listVMs(ctx context.Context, log *logrus.Logger, targets []*target) ([]targetVM, error) {
+	var all []targetVM
+	for _, t := range targets {
+		log.Infof("Retrieving VMs of vCenter %s from folder: %s", t.label(), t.cfg.Folder)
+		vms, err := t.vsClient.GetVMs(ctx, t.cfg.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get VMs of vCenter %s: %v", t.label(), err)
+		}
+		log.Infof("Found %d VMs", len(vms))
+
+		// Only manage VMs in the requested power state
+		vms, err = selectVMs(ctx, log, t.vsClient, t.cfg.PowerStateFilter, vms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select VMs of vCenter %s: %v", t.label(), err)
+		}
+		for _, vm := range vms {
+			all = append(all, targetVM{vm: vm, target: t})
+		}
+	}
+	sortTargetVMs(all)
+	return all, nil
+}
+
+// sortTargetVMs orders VMs by name, then by key, so IP assignment and the
+// max_vms cap are deterministic across vCenters
+func sortTargetVMs(vms []targetVM) {
+	sort.SliceStable(vms, func(i, j int) bool {
+		if vms[i].vm.Name() != vms[j].vm.Name() {
+			return vms[i].vm.Name() < vms[j].vm.Name()
+		}
+		return vms[i].key() < vms[j].key()
+	})
+}
+
+// capVMs returns the first max VMs, logging the rest and counting them as
+// unmanaged. VMs are sorted first, so the same VMs are managed on every
+// start. A max of 0 is unlimited.
+func capVMs(log *logrus.Logger, vms []targetVM, max int) []targetVM {
+	if max <= 0 || len(vms) <= max {
+		metrics.SetUnmanagedVMs(0)
+		return vms
+	}
+	overflow := vms[max:]
+	names := make([]string, len(overflow))
+	for i, v := range overflow {
+		names[i] = v.vm.Name()
+	}
+	log.Warnf("Found %d VMs but server.max_vms is %d, not managing %d VMs: %v",
+		len(vms), max, len(overflow), names)
+	metrics.SetUnmanagedVMs(len(overflow))
+	return vms[:max]
+}
+
+// byTarget groups VMs by their vCenter
+func byTarget(vms []targetVM) map[*target][]*object.VirtualMachine {
+	groups := make(map[*target][]*object.VirtualMachine)
+	for _, v := range vms {
+		groups[v.target] = append(groups[v.target], v.vm)
+	}
+	return groups
+}
+
+// duplicateKeys returns the keys of VMs sharing their instance UUID with
+// another VM of the same vCenter, logging each conflict
+func duplicateKeys(ctx context.Context, log *logrus.Logger, vms []targetVM) map[string]bool {
+	duplicates := make(map[string]bool)
+	for t, group := range byTarget(vms) {
+		shared := duplicateUUIDs(ctx, log, t.vsClient, group)
+		for _, vm := range group {
+			if shared[vsphere.VMKey(vm)] {
+				duplicates[t.key(vm)] = true
+			}
+		}
+	}
+	return duplicates
+}
+
+// healthCheck returns a check that every vCenter answers
+func healthCheck(targets []*target) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for _, t := range targets {
+			if err := t.vsClient.Healthy(ctx); err != nil {
+				if len(targets) == 1 {
+					return err
+				}
+				return fmt.Errorf("vCenter %s: %w", t.label(), err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testLogger returns a logger that discards its output
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// testVMs returns VMs of t with the given names and references vm-1, vm-2
+// and so on, in order
+func testVMs(t *target, names ...string) []targetVM {
+	vms := make([]targetVM, len(names))
+	for i, name := range names {
+		vm := object.NewVirtualMachine(nil, types.ManagedObjectReference{Type: "VirtualMachine", Value: fmt.Sprintf("vm-%d", i+1)})
+		vm.InventoryPath = "/DC0/vm/" + name
+		vms[i] = targetVM{vm: vm, target: t}
+	}
+	return vms
+}
+
+// vmNames returns the names of vms in order
+func vmNames(vms []targetVM) []string {
+	names := make([]string, len(vms))
+	for i, v := range vms {
+		names[i] = v.vm.Name()
+	}
+	return names
+}
+
+func TestCapVMsIsDeterministic(t *testing.T) {
+	vc := &target{}
+	vms := testVMs(vc, "db-01", "web-02", "app-01", "web-01", "app-02")
+	reversed := make([]targetVM, len(vms))
+	for i, v := range vms {
+		reversed[len(vms)-1-i] = v
+	}
+
+	want := []string{"app-01", "app-02", "db-01"}
+	for _, order := range [][]targetVM{vms, reversed} {
+		sortTargetVMs(order)
+		if got := vmNames(capVMs(testLogger(), order, 3)); !slices.Equal(got, want) {
+			t.Errorf("capped to %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCapVMsUnlimited(t *testing.T) {
+	vms := testVMs(&target{}, "a", "b", "c")
+	if got := capVMs(testLogger(), vms, 0); len(got) != 3 {
+		t.Errorf("max_vms 0 kept %d VMs, want 3", len(got))
+	}
+	if got := capVMs(testLogger(), vms, 5); len(got) != 3 {
+		t.Errorf("max_vms above the VM count kept %d VMs, want 3", len(got))
+	}
+}
@@ -0,0 +1,74 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/event"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// reconfigWatch tracks the VM reconfigure events already seen
+type reconfigWatch struct {
+	mu    sync.Mutex
+	since time.Time // vCenter time of the last event seen, or of connecting
+	key   int32     // Key of the last event seen, as keys only grow
+}
+
+// InvalidateCache drops the data cached for the VM with a reference value,
+// as returned by VMKey, or for every VM when vmID is empty, so the next
+// reads ask vCenter again. Clearing every VM also retries an unavailable
+// tagging service at once.
+func (c *Client) InvalidateCache(vmID string) {
+	c.tagCache.mu.Lock()
+	defer c.tagCache.mu.Unlock()
+	if vmID == "" {
+		c.tagCache.vms = make(map[string]cachedTags)
+		c.tagCache.unavailable = time.Time{}
+		return
+	}
+	delete(c.tagCache.vms, vmID)
+}
+
+// ReconfiguredVMs returns the reference values of the VMs vCenter reports
+// as reconfigured since the last call, or since the client connected, and
+// drops their cached data
+func (c *Client) ReconfiguredVMs(ctx context.Context) ([]string, error) {
+	c.reconfig.mu.Lock()
+	defer c.reconfig.mu.Unlock()
+
+	since := c.reconfig.since
+	filter := types.EventFilterSpec{
+		EventTypeId: []string{"VmReconfiguredEvent"},
+		Time:        &types.EventFilterSpecByTime{BeginTime: &since},
+	}
+	events, err := event.NewManager(c.client.Client).QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VM reconfigure events: %v", err)
+	}
+
+	last := c.reconfig.key
+	seen := make(map[string]bool)
+	var vms []string
+	for _, e := range events {
+		ev := e.GetEvent()
+		if ev.Key <= last {
+			continue // Seen by the last call, as the begin time is inclusive
+		}
+		c.reconfig.key = max(c.reconfig.key, ev.Key)
+		if ev.CreatedTime.After(c.reconfig.since) {
+			c.reconfig.since = ev.CreatedTime
+		}
+		if ev.Vm == nil || seen[ev.Vm.Vm.Value] {
+			continue
+		}
+		seen[ev.Vm.Vm.Value] = true
+		vms = append(vms, ev.Vm.Vm.Value)
+	}
+	for _, vmID := range vms {
+		c.InvalidateCache(vmID)
+	}
+	return vms, nil
+}
@@ -2,28 +2,153 @@ package vsphere
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/fault"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
+// ErrTaskInProgress is returned when another vCenter task is already running on the VM
+var ErrTaskInProgress = errors.New("another task is in progress on the VM")
+
+// ErrTaskCanceled is returned when a task was cancelled before completing
+var ErrTaskCanceled = errors.New("task was cancelled")
+
+// ErrNoTask is returned by CancelTask when no task is running for the VM
+var ErrNoTask = errors.New("no task in progress for the VM")
+
+// ErrHardwareState is returned when a VM's sizing can't be changed in its
+// current power state
+var ErrHardwareState = errors.New("VM must be powered off, or have hot-add enabled and only grow")
+
+// ErrHardwareLimit is returned when requested sizing is invalid or exceeds
+// what the VM's host provides
+var ErrHardwareLimit = errors.New("requested hardware is invalid or exceeds host limits")
+
+// ErrNoBootDevice is returned when the VM has no device of the requested
+// boot device type
+var ErrNoBootDevice = errors.New("VM has no device of the requested boot type")
+
+// ErrFirmwareState is returned when a VM's firmware can't be changed
+// because it isn't powered off
+var ErrFirmwareState = errors.New("VM must be powered off to change its firmware")
+
+// ErrSuspendState is returned when a VM can't be suspended because it
+// isn't powered on
+var ErrSuspendState = errors.New("only a powered-on VM can be suspended")
+
+// ErrToolsUnavailable is returned when a guest operation needs VMware
+// Tools and they aren't running in the VM
+var ErrToolsUnavailable = errors.New("VMware Tools are not running in the VM")
+
+// ErrNMIUnavailable is returned when an NMI can't be sent to the VM, e.g.
+// because it is powered off or its host predates the NMI API
+var ErrNMIUnavailable = errors.New("NMI can't be sent to the VM")
+
+// ErrStatsUnavailable is returned when a VM has no usage statistics,
+// e.g. because it is powered off
+var ErrStatsUnavailable = errors.New("VM usage statistics are not available")
+
+// ErrVMNotFound is returned when no VM matches a UUID or reference
+var ErrVMNotFound = errors.New("VM not found")
+
+// ErrUnreachable is returned while vCenter can't be reached. After a
+// connection failure calls fail fast for unreachableCooldown before the
+// next attempt is allowed through.
+var ErrUnreachable = errors.New("vCenter is unreachable")
+
+const (
+	unreachableCooldown = 10 * time.Second
+	connectTimeout      = 5 * time.Second
+)
+
+// checkReachable fails fast while vCenter is considered unreachable
+func (c *Client) checkReachable() error {
+	if time.Now().UnixNano() < c.unreachableUntil.Load() {
+		return ErrUnreachable
+	}
+	return nil
+}
+
+// checkFault maps well-known vCenter faults to package errors so callers
+// can tell transient conditions from hard failures. Connection failures
+// also start the unreachable cooldown.
+func (c *Client) checkFault(err error) error {
+	if err == nil {
+		return nil
+	}
+	var urlErr *url.Error
+	var opErr *net.OpError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) {
+		c.unreachableUntil.Store(time.Now().Add(unreachableCooldown).UnixNano())
+		c.log.Warnf("vCenter unreachable, failing fast for %s: %v", unreachableCooldown, err)
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if fault.Is(err, &types.TaskInProgress{}) {
+		return fmt.Errorf("%w: %v", ErrTaskInProgress, err)
+	}
+	if fault.Is(err, &types.RequestCanceled{}) {
+		return fmt.Errorf("%w: %v", ErrTaskCanceled, err)
+	}
+	if fault.Is(err, &types.ToolsUnavailable{}) {
+		return fmt.Errorf("%w: %v", ErrToolsUnavailable, err)
+	}
+	return err
+}
+
+// RetryPolicy controls how often a power or reconfigure task that hits a
+// transient vCenter fault is tried again
+type RetryPolicy struct {
+	Attempts int           // Tries in all, 1 to never retry
+	Delay    time.Duration // Wait before the first retry, doubling for each one after
+}
+
+// retryable reports whether err is a transient fault worth retrying, such
+// as another operation holding the VM. Faults the caller acts on, like a
+// task in progress or vCenter being unreachable, are returned at once.
+func retryable(err error) bool {
+	return fault.Is(err, &types.ResourceInUse{}) ||
+		fault.Is(err, &types.ConcurrentAccess{}) ||
+		fault.Is(err, &types.HostCommunication{})
+}
+
 // Client represents a vSphere client
 type Client struct {
 	client     *govmomi.Client
 	finder     *find.Finder
 	datacenter *object.Datacenter
 	log        *logrus.Entry
+	tasksMu    sync.Mutex
+	tasks      map[string]*object.Task // In-flight tasks by VM reference value
+	user       *url.Userinfo           // Credentials, reused for the vAPI session
+	tagCache   tagCache
+	reconfig   reconfigWatch
+	retry      RetryPolicy
+
+	unreachableUntil atomic.Int64 // Unix nanoseconds until which calls fail fast
 }
 
-// NewClient creates a new vSphere client
-func NewClient(ctx context.Context, vcenterIP, username, password, datacenter string) (*Client, error) {
+// NewClient creates a new vSphere client. If sourceIP is set, the connection
+// to vCenter is made from that local address.
+func NewClient(ctx context.Context, vcenterIP, username, password, datacenter string, sourceIP net.IP) (*Client, error) {
 	log := logrus.WithField("component", "vsphere")
 	log.Debugf("Connecting to vCenter at %s", vcenterIP)
 	u, err := url.Parse(fmt.Sprintf("https://%s/sdk", vcenterIP))
@@ -33,10 +158,29 @@ func NewClient(ctx context.Context, vcenterIP, username, password, datacenter st
 	u.User = url.UserPassword(username, password)
 
 	log.Debug("Creating new govmomi client")
-	client, err := govmomi.NewClient(ctx, u, true)
+	soapClient := soap.NewClient(u, true)
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout, // Keep IPMI responses fast when vCenter is down
+		KeepAlive: 30 * time.Second,
+	}
+	if sourceIP != nil {
+		log.Debugf("Binding vCenter connection to source address %s", sourceIP)
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	soapClient.DefaultTransport().DialContext = dialer.DialContext
+
+	vimClient, err := vim25.NewClient(ctx, soapClient)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create vSphere client: %v", err)
 	}
+	vimClient.RoundTripper = newRelogin(vimClient, u.User, log)
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err := client.Login(ctx, u.User); err != nil {
+		return nil, fmt.Errorf("failed to log in to vSphere: %v", err)
+	}
 
 	log.Debug("Creating new Finder")
 	finder := find.NewFinder(client.Client, true)
@@ -48,30 +192,132 @@ func NewClient(ctx context.Context, vcenterIP, username, password, datacenter st
 	}
 	finder.SetDatacenter(dc)
 
+	// Reconfigure events are watched from now on, by vCenter's clock
+	now, err := methods.GetCurrentTime(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vCenter time: %v", err)
+	}
+
 	log.Info("Successfully connected to vSphere")
 	return &Client{
 		client:     client,
 		finder:     finder,
 		datacenter: dc,
 		log:        log,
+		tasks:      make(map[string]*object.Task),
+		user:       u.User,
+		retry:      RetryPolicy{Attempts: 1},
+		tagCache: tagCache{
+			categories: make(map[string]string),
+			vms:        make(map[string]cachedTags),
+		},
+		reconfig: reconfigWatch{since: *now},
 	}, nil
 }
 
+// bind returns vm bound to this client's session, so a VM found by one
+// client can be operated on with another client's credentials
+func (c *Client) bind(vm *object.VirtualMachine) *object.VirtualMachine {
+	if vm.Client() == c.client.Client {
+		return vm
+	}
+	bound := object.NewVirtualMachine(c.client.Client, vm.Reference())
+	bound.InventoryPath = vm.InventoryPath
+	return bound
+}
+
+// waitTask waits for a task while tracking it as the VM's in-flight task so
+// it can be cancelled with CancelTask
+func (c *Client) waitTask(ctx context.Context, vm *object.VirtualMachine, task *object.Task) error {
+	key := vm.Reference().Value
+
+	c.tasksMu.Lock()
+	c.tasks[key] = task
+	c.tasksMu.Unlock()
+
+	defer func() {
+		c.tasksMu.Lock()
+		if c.tasks[key] == task {
+			delete(c.tasks, key)
+		}
+		c.tasksMu.Unlock()
+	}()
+
+	return c.checkFault(task.Wait(ctx))
+}
+
+// SetRetryPolicy sets how tasks that hit transient faults are retried.
+// Clients start out never retrying.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// runTask starts a task with start and waits for it, trying again with
+// exponential backoff while it fails with a retryable fault. A retry that
+// couldn't start before ctx's deadline isn't attempted; the last error is
+// returned instead.
+func (c *Client) runTask(ctx context.Context, vm *object.VirtualMachine, action string, start func(context.Context) (*object.Task, error)) error {
+	delay := c.retry.Delay
+	for attempt := 1; ; attempt++ {
+		err := c.runTaskOnce(ctx, vm, action, start)
+		if err == nil || attempt >= c.retry.Attempts || !retryable(err) {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		c.log.Warnf("Failed to %s %s, retrying in %s (attempt %d of %d): %v",
+			action, vm.Reference().Value, delay, attempt+1, c.retry.Attempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// runTaskOnce starts a task with start and waits for it
+func (c *Client) runTaskOnce(ctx context.Context, vm *object.VirtualMachine, action string, start func(context.Context) (*object.Task, error)) error {
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	task, err := start(ctx)
+	if err != nil {
+		return c.checkFault(fmt.Errorf("failed to %s: %w", action, err))
+	}
+	return c.waitTask(ctx, vm, task)
+}
+
+// CancelTask cancels the in-flight task started for a VM, if any
+func (c *Client) CancelTask(ctx context.Context, vm *object.VirtualMachine) error {
+	c.tasksMu.Lock()
+	task, ok := c.tasks[vm.Reference().Value]
+	c.tasksMu.Unlock()
+	if !ok {
+		return ErrNoTask
+	}
+
+	c.log.Infof("Cancelling task %s for VM %s", task.Reference().Value, vm.Reference().Value)
+	if err := task.Cancel(ctx); err != nil {
+		return fmt.Errorf("failed to cancel task: %v", err)
+	}
+	return nil
+}
+
 // GetVMs returns all VMs in the specified folder or datacenter
 func (c *Client) GetVMs(ctx context.Context, folderPath string) ([]*object.VirtualMachine, error) {
-	var vms []*object.VirtualMachine
-	var err error
-
+	pattern := "*"
 	if folderPath != "" {
 		folder, err := c.finder.Folder(ctx, "/"+c.datacenter.Name()+"/vm"+folderPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to find folder: %v", err)
 		}
-		vms, err = c.finder.VirtualMachineList(ctx, folder.InventoryPath+"/*")
-	} else {
-		vms, err = c.finder.VirtualMachineList(ctx, "*")
+		pattern = folder.InventoryPath + "/*"
 	}
 
+	vms, err := c.finder.VirtualMachineList(ctx, pattern)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list VMs: %v", err)
 	}
@@ -79,104 +325,795 @@ func (c *Client) GetVMs(ctx context.Context, folderPath string) ([]*object.Virtu
 	return vms, nil
 }
 
+// FindVM resolves a VM in the datacenter by BIOS UUID or by name
+func (c *Client) FindVM(ctx context.Context, nameOrUUID string) (*object.VirtualMachine, error) {
+	if vm, err := c.FindVMByUUID(ctx, nameOrUUID, false); err == nil {
+		return vm, nil
+	}
+
+	vm, err := c.finder.VirtualMachine(ctx, nameOrUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find VM %s: %v", nameOrUUID, err)
+	}
+	return vm, nil
+}
+
+// FindVMByUUID resolves a VM in the datacenter by its BIOS UUID, or by its
+// instance UUID if instanceUUID is set
+func (c *Client) FindVMByUUID(ctx context.Context, uuid string, instanceUUID bool) (*object.VirtualMachine, error) {
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	ref, err := object.NewSearchIndex(c.client.Client).FindByUuid(ctx, c.datacenter, uuid, true, &instanceUUID)
+	if err != nil {
+		return nil, c.checkFault(fmt.Errorf("failed to search for VM %s: %w", uuid, err))
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("%w: no VM with UUID %s", ErrVMNotFound, uuid)
+	}
+	return c.FindVMByMoRef(ctx, ref.Reference().Value)
+}
+
+// FindVMByMoRef resolves a VM by its managed object reference value, e.g.
+// "vm-42". Unlike names, references are stable across renames.
+func (c *Client) FindVMByMoRef(ctx context.Context, ref string) (*object.VirtualMachine, error) {
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	moref := types.ManagedObjectReference{Type: "VirtualMachine", Value: ref}
+	obj, err := c.finder.ObjectReference(ctx, moref) // Fills in the inventory path, and so the name
+	if err != nil {
+		if fault.Is(err, &types.ManagedObjectNotFound{}) {
+			return nil, fmt.Errorf("%w: no VM with reference %s", ErrVMNotFound, ref)
+		}
+		return nil, c.checkFault(fmt.Errorf("failed to find VM %s: %w", ref, err))
+	}
+	vm, ok := obj.(*object.VirtualMachine)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a VM", ErrVMNotFound, ref)
+	}
+	return vm, nil
+}
+
+// Healthy checks vCenter answers an authenticated call, reading the
+// datacenter's name. An expired session is renewed on the way.
+func (c *Client) Healthy(ctx context.Context) error {
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	if _, err := c.datacenter.ObjectName(ctx); err != nil {
+		return c.checkFault(fmt.Errorf("failed to query vCenter: %w", err))
+	}
+	return nil
+}
+
 // GetVMPowerState returns the power state of a VM
 func (c *Client) GetVMPowerState(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return "", err
+	}
 	var o mo.VirtualMachine
 	err := vm.Properties(ctx, vm.Reference(), []string{"runtime.powerState"}, &o)
 	if err != nil {
-		return "", fmt.Errorf("failed to get VM properties: %v", err)
+		return "", c.checkFault(fmt.Errorf("failed to get VM properties: %w", err))
 	}
 	return string(o.Runtime.PowerState), nil
 }
 
-// PowerOnVM powers on a VM
-func (c *Client) PowerOnVM(ctx context.Context, vm *object.VirtualMachine) error {
-	task, err := vm.PowerOn(ctx)
+// GetVMConnectionState returns the connection state of a VM, which is
+// "connected" once vCenter can manage it. A VM still being cloned or on a
+// disconnected host reports another state.
+func (c *Client) GetVMConnectionState(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return "", err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"runtime.connectionState"}, &o)
 	if err != nil {
-		return fmt.Errorf("failed to power on VM: %v", err)
+		return "", c.checkFault(fmt.Errorf("failed to get VM properties: %w", err))
 	}
-	return task.Wait(ctx)
+	return string(o.Runtime.ConnectionState), nil
 }
 
-// PowerOffVM powers off a VM
-func (c *Client) PowerOffVM(ctx context.Context, vm *object.VirtualMachine) error {
-	task, err := vm.PowerOff(ctx)
+// GetPowerStates returns the power state of each VM keyed by managed object
+// reference value, fetched in a single property collector call
+func (c *Client) GetPowerStates(ctx context.Context, vms []*object.VirtualMachine) (map[string]string, error) {
+	states := make(map[string]string, len(vms))
+	if len(vms) == 0 {
+		return states, nil
+	}
+
+	refs := make([]types.ManagedObjectReference, len(vms))
+	for i, vm := range vms {
+		refs[i] = vm.Reference()
+	}
+
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	var mvms []mo.VirtualMachine
+	pc := property.DefaultCollector(c.client.Client)
+	if err := pc.Retrieve(ctx, refs, []string{"runtime.powerState"}, &mvms); err != nil {
+		return nil, c.checkFault(fmt.Errorf("failed to get VM power states: %w", err))
+	}
+
+	for _, mvm := range mvms {
+		states[mvm.Self.Value] = string(mvm.Runtime.PowerState)
+	}
+	return states, nil
+}
+
+// WaitForPowerState blocks until the VM reaches the given power state
+func (c *Client) WaitForPowerState(ctx context.Context, vm *object.VirtualMachine, state string) error {
+	vm = c.bind(vm)
+	if err := vm.WaitForPowerState(ctx, types.VirtualMachinePowerState(state)); err != nil {
+		return fmt.Errorf("failed waiting for VM power state %s: %v", state, err)
+	}
+	return nil
+}
+
+// GetInstanceUUID returns the vCenter instance UUID of a VM
+func (c *Client) GetInstanceUUID(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return "", err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.instanceUuid"}, &o)
+	if err != nil {
+		return "", c.checkFault(fmt.Errorf("failed to get VM instance UUID: %w", err))
+	}
+	if o.Config == nil {
+		return "", nil
+	}
+	return o.Config.InstanceUuid, nil
+}
+
+// VMIdentity names a VM for its inventory data
+type VMIdentity struct {
+	Name  string
+	UUID  string // BIOS UUID
+	MoRef string // Managed object reference value
+}
+
+// GetVMIdentity returns the VM's current name, BIOS UUID and managed
+// object reference
+func (c *Client) GetVMIdentity(ctx context.Context, vm *object.VirtualMachine) (*VMIdentity, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"name", "config.uuid"}, &o)
+	if err != nil {
+		return nil, c.checkFault(fmt.Errorf("failed to get VM identity: %w", err))
+	}
+	id := &VMIdentity{Name: o.Name, MoRef: VMKey(vm)}
+	if o.Config != nil {
+		id.UUID = o.Config.Uuid
+	}
+	return id, nil
+}
+
+// GetInstanceUUIDs returns the instance UUIDs of several VMs in a single
+// round trip, keyed by VM reference value
+func (c *Client) GetInstanceUUIDs(ctx context.Context, vms []*object.VirtualMachine) (map[string]string, error) {
+	uuids := make(map[string]string, len(vms))
+	if len(vms) == 0 {
+		return uuids, nil
+	}
+
+	refs := make([]types.ManagedObjectReference, len(vms))
+	for i, vm := range vms {
+		refs[i] = vm.Reference()
+	}
+
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	var mvms []mo.VirtualMachine
+	pc := property.DefaultCollector(c.client.Client)
+	if err := pc.Retrieve(ctx, refs, []string{"config.instanceUuid"}, &mvms); err != nil {
+		return nil, c.checkFault(fmt.Errorf("failed to get VM instance UUIDs: %w", err))
+	}
+
+	for _, mvm := range mvms {
+		if mvm.Config != nil {
+			uuids[mvm.Self.Value] = mvm.Config.InstanceUuid
+		}
+	}
+	return uuids, nil
+}
+
+// GetVMAnnotation returns the notes field of a VM
+func (c *Client) GetVMAnnotation(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return "", err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.annotation"}, &o)
+	if err != nil {
+		return "", c.checkFault(fmt.Errorf("failed to get VM annotation: %w", err))
+	}
+	if o.Config == nil {
+		return "", nil
+	}
+	return o.Config.Annotation, nil
+}
+
+// identifyMarker starts the line SetIdentifyAnnotation keeps in VM notes
+const identifyMarker = "vBMC identify: "
+
+// SetIdentifyAnnotation records in the VM's notes whether its BMC is being
+// identified, so the VM stands out in the vCenter UI. The state is kept on
+// one line, replaced on each call, and the rest of the notes are left
+// alone. The line stays after identify is turned off, as vCenter can't be
+// asked to empty the notes.
+func (c *Client) SetIdentifyAnnotation(ctx context.Context, vm *object.VirtualMachine, on bool) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.annotation", "config.changeVersion"}, &o)
+	if err != nil {
+		return c.checkFault(fmt.Errorf("failed to get VM annotation: %w", err))
+	}
+	if o.Config == nil {
+		return fmt.Errorf("VM config is not available")
+	}
+
+	var lines []string
+	if o.Config.Annotation != "" {
+		for _, line := range strings.Split(o.Config.Annotation, "\n") {
+			if !strings.HasPrefix(line, identifyMarker) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	state := "off"
+	if on {
+		state = "on"
+	}
+	lines = append(lines, identifyMarker+state)
+
+	// The change version makes the reconfigure fail rather than overwrite
+	// notes edited in the meantime
+	spec := types.VirtualMachineConfigSpec{
+		ChangeVersion: o.Config.ChangeVersion,
+		Annotation:    strings.Join(lines, "\n"),
+	}
+	return c.runTask(ctx, vm, "update VM annotation", func(ctx context.Context) (*object.Task, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+}
+
+// VMHardware describes the virtual hardware sizing of a VM
+type VMHardware struct {
+	NumCPU   int
+	MemoryMB int
+}
+
+// GetVMHardware returns the configured number of vCPUs and memory of a VM
+func (c *Client) GetVMHardware(ctx context.Context, vm *object.VirtualMachine) (*VMHardware, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware"}, &o)
+	if err != nil {
+		return nil, c.checkFault(fmt.Errorf("failed to get VM hardware: %w", err))
+	}
+	if o.Config == nil {
+		return nil, fmt.Errorf("VM config is not available")
+	}
+	return &VMHardware{
+		NumCPU:   int(o.Config.Hardware.NumCPU),
+		MemoryMB: int(o.Config.Hardware.MemoryMB),
+	}, nil
+}
+
+// VMStats is the CPU and memory usage of a running VM
+type VMStats struct {
+	CPUUsageMHz    int
+	ActiveMemoryMB int // Guest memory recently touched
+}
+
+// GetVMStats returns the VM's usage from its quick stats. VMs that aren't
+// powered on have none and fail with ErrStatsUnavailable.
+func (c *Client) GetVMStats(ctx context.Context, vm *object.VirtualMachine) (*VMStats, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return nil, err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"runtime.powerState", "summary.quickStats"}, &o)
 	if err != nil {
-		return fmt.Errorf("failed to power off VM: %v", err)
+		return nil, c.checkFault(fmt.Errorf("failed to get VM stats: %w", err))
+	}
+	if o.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+		return nil, ErrStatsUnavailable
+	}
+	return &VMStats{
+		CPUUsageMHz:    int(o.Summary.QuickStats.OverallCpuUsage),
+		ActiveMemoryMB: int(o.Summary.QuickStats.GuestMemoryUsage),
+	}, nil
+}
+
+// SetVMHardware changes the vCPU count and memory of a VM. The VM must be
+// powered off unless hot-add is enabled and the change only adds capacity.
+func (c *Client) SetVMHardware(ctx context.Context, vm *object.VirtualMachine, hw VMHardware) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	if hw.NumCPU <= 0 || hw.MemoryMB <= 0 || hw.MemoryMB%4 != 0 {
+		return fmt.Errorf("%w: %d vCPUs, %d MB", ErrHardwareLimit, hw.NumCPU, hw.MemoryMB)
+	}
+
+	var o mo.VirtualMachine
+	props := []string{"config.hardware", "config.cpuHotAddEnabled", "config.memoryHotAddEnabled", "runtime.powerState", "runtime.host"}
+	if err := vm.Properties(ctx, vm.Reference(), props, &o); err != nil {
+		return c.checkFault(fmt.Errorf("failed to get VM config: %w", err))
+	}
+	if o.Config == nil {
+		return fmt.Errorf("VM config is not available")
+	}
+
+	if o.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		cpu, mem := int(o.Config.Hardware.NumCPU), int(o.Config.Hardware.MemoryMB)
+		cpuOK := hw.NumCPU == cpu || (hw.NumCPU > cpu && o.Config.CpuHotAddEnabled != nil && *o.Config.CpuHotAddEnabled)
+		memOK := hw.MemoryMB == mem || (hw.MemoryMB > mem && o.Config.MemoryHotAddEnabled != nil && *o.Config.MemoryHotAddEnabled)
+		if !cpuOK || !memOK {
+			return ErrHardwareState
+		}
+	}
+
+	if o.Runtime.Host != nil {
+		var host mo.HostSystem
+		err := property.DefaultCollector(c.client.Client).RetrieveOne(ctx, *o.Runtime.Host, []string{"summary.hardware"}, &host)
+		if err != nil {
+			return c.checkFault(fmt.Errorf("failed to get host hardware: %w", err))
+		}
+		if limits := host.Summary.Hardware; limits != nil {
+			if hw.NumCPU > int(limits.NumCpuThreads) || int64(hw.MemoryMB)<<20 > limits.MemorySize {
+				return fmt.Errorf("%w: host has %d threads and %d MB", ErrHardwareLimit, limits.NumCpuThreads, limits.MemorySize>>20)
+			}
+		}
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		NumCPUs:  int32(hw.NumCPU),
+		MemoryMB: int64(hw.MemoryMB),
+	}
+	return c.runTask(ctx, vm, "reconfigure VM", func(ctx context.Context) (*object.Task, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+}
+
+// SetCustomAttribute sets a custom attribute on a VM, defining the attribute
+// for virtual machines if it doesn't exist yet
+func (c *Client) SetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name, value string) error {
+	vm = c.bind(vm)
+	m, err := object.GetCustomFieldsManager(c.client.Client)
+	if err != nil {
+		return fmt.Errorf("failed to get custom fields manager: %v", err)
+	}
+
+	key, err := m.FindKey(ctx, name)
+	if errors.Is(err, object.ErrKeyNameNotFound) {
+		def, addErr := m.Add(ctx, name, "VirtualMachine", nil, nil)
+		if addErr != nil {
+			return fmt.Errorf("failed to define custom attribute %s: %v", name, addErr)
+		}
+		key, err = def.Key, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find custom attribute %s: %v", name, err)
+	}
+
+	if err := m.Set(ctx, vm.Reference(), key, value); err != nil {
+		return fmt.Errorf("failed to set custom attribute %s: %v", name, err)
+	}
+	return nil
+}
+
+// GetCustomAttribute returns the value of a custom attribute on a VM, or
+// an empty string if the attribute isn't defined or set
+func (c *Client) GetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name string) (string, error) {
+	vm = c.bind(vm)
+	m, err := object.GetCustomFieldsManager(c.client.Client)
+	if err != nil {
+		return "", fmt.Errorf("failed to get custom fields manager: %v", err)
+	}
+
+	key, err := m.FindKey(ctx, name)
+	if errors.Is(err, object.ErrKeyNameNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find custom attribute %s: %v", name, err)
+	}
+
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"customValue"}, &o); err != nil {
+		return "", c.checkFault(fmt.Errorf("failed to get custom attributes: %w", err))
+	}
+	for _, v := range o.CustomValue {
+		if sv, ok := v.(*types.CustomFieldStringValue); ok && sv.Key == key {
+			return sv.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// ShutdownGuestVM asks the guest OS to shut down through VMware Tools. It
+// returns once the request is accepted, not when the VM is off.
+func (c *Client) ShutdownGuestVM(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	if err := vm.ShutdownGuest(ctx); err != nil {
+		return c.checkFault(fmt.Errorf("failed to shut down guest: %w", err))
+	}
+	return nil
+}
+
+// RebootGuestVM asks the guest OS to reboot through VMware Tools. It
+// returns once the request is accepted, not when the guest is back up.
+func (c *Client) RebootGuestVM(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
 	}
-	return task.Wait(ctx)
+	if err := vm.RebootGuest(ctx); err != nil {
+		return c.checkFault(fmt.Errorf("failed to reboot guest: %w", err))
+	}
+	return nil
+}
+
+// PowerOnVM powers on a VM. A VM that is already powered on is left
+// alone and counts as success.
+func (c *Client) PowerOnVM(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	err := c.runTask(ctx, vm, "power on VM", vm.PowerOn)
+	return c.alreadyInState(ctx, vm, err, types.VirtualMachinePowerStatePoweredOn)
+}
+
+// PowerOffVM powers off a VM. A VM that is already powered off is left
+// alone and counts as success.
+func (c *Client) PowerOffVM(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	err := c.runTask(ctx, vm, "power off VM", vm.PowerOff)
+	return c.alreadyInState(ctx, vm, err, types.VirtualMachinePowerStatePoweredOff)
+}
+
+// SuspendVM suspends a VM. A VM that is already suspended is left alone
+// and counts as success. Powering it on resumes it.
+func (c *Client) SuspendVM(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	err := c.runTask(ctx, vm, "suspend VM", vm.Suspend)
+	err = c.alreadyInState(ctx, vm, err, types.VirtualMachinePowerStateSuspended)
+	if fault.Is(err, &types.InvalidPowerState{}) {
+		return fmt.Errorf("%w: %v", ErrSuspendState, err)
+	}
+	return err
+}
+
+// alreadyInState clears err when a power operation failed because the VM
+// is already in the state it asked for. The fault is confirmed against the
+// VM's current power state, as vCenter also raises it for states the
+// operation can't start from.
+func (c *Client) alreadyInState(ctx context.Context, vm *object.VirtualMachine, err error, want types.VirtualMachinePowerState) error {
+	if !fault.Is(err, &types.InvalidPowerState{}) {
+		return err
+	}
+	state, stateErr := c.GetVMPowerState(ctx, vm)
+	if stateErr != nil || state != string(want) {
+		return err
+	}
+	c.log.Debugf("VM %s is already %s", vm.Reference().Value, want)
+	return nil
 }
 
 // ResetVM performs a hard reset of a VM
 func (c *Client) ResetVM(ctx context.Context, vm *object.VirtualMachine) error {
-	task, err := vm.Reset(ctx)
+	vm = c.bind(vm)
+	return c.runTask(ctx, vm, "reset VM", vm.Reset)
+}
+
+// SendNMI sends a non-maskable interrupt to the VM's guest, as a physical
+// BMC's diagnostic interrupt does. The call returns once the interrupt is
+// delivered; there is no task.
+func (c *Client) SendNMI(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	_, err := methods.SendNMI(ctx, c.client.Client, &types.SendNMI{This: vm.Reference()})
+	if fault.Is(err, &types.InvalidPowerState{}) || fault.Is(err, &types.NotSupported{}) || fault.Is(err, &types.MethodNotFound{}) {
+		return fmt.Errorf("%w: %v", ErrNMIUnavailable, err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to reset VM: %v", err)
+		return c.checkFault(fmt.Errorf("failed to send NMI: %w", err))
 	}
-	return task.Wait(ctx)
+	return nil
 }
 
 // BootDevice represents a VM boot device
 type BootDevice string
 
 const (
+	BootDeviceNone   BootDevice = "" // No boot order override
 	BootDeviceHDD    BootDevice = "hdd"
 	BootDeviceCDROM  BootDevice = "cdrom"
 	BootDevicePXE    BootDevice = "pxe"
 	BootDeviceFloppy BootDevice = "floppy"
 )
 
-// SetNextBoot sets the next boot device for a VM
-func (c *Client) SetNextBoot(ctx context.Context, vm *object.VirtualMachine, device BootDevice) error {
-	var err error
-	var bootOptions *types.VirtualMachineBootOptions
-
-	// Get current configuration
-	var vmConfig mo.VirtualMachine
-	err = vm.Properties(ctx, vm.Reference(), []string{"config"}, &vmConfig)
-	if err != nil {
-		return fmt.Errorf("failed to get VM config: %v", err)
-	}
-
-	// Create boot options if they don't exist
-	if vmConfig.Config.BootOptions == nil {
-		bootOptions = &types.VirtualMachineBootOptions{}
-	} else {
-		bootOptions = vmConfig.Config.BootOptions
-	}
+// SetNextBoot sets the next boot device for a VM. For PXE, network selects
+// the NIC to boot from by device name (e.g. "ethernet-1") or network name;
+// when empty the first NIC is used.
+func (c *Client) SetNextBoot(ctx context.Context, vm *object.VirtualMachine, device BootDevice, network string) error {
+	vm = c.bind(vm)
+	var order []types.BaseVirtualMachineBootOptionsBootableDevice
 
 	// Set boot order based on device
 	switch device {
 	case BootDeviceHDD:
-		bootOptions.BootOrder = []types.BaseVirtualMachineBootOptionsBootableDevice{
+		order = []types.BaseVirtualMachineBootOptionsBootableDevice{
 			&types.VirtualMachineBootOptionsBootableDiskDevice{},
 		}
 	case BootDeviceCDROM:
-		bootOptions.BootOrder = []types.BaseVirtualMachineBootOptionsBootableDevice{
+		order = []types.BaseVirtualMachineBootOptionsBootableDevice{
 			&types.VirtualMachineBootOptionsBootableCdromDevice{},
 		}
 	case BootDevicePXE:
-		bootOptions.BootOrder = []types.BaseVirtualMachineBootOptionsBootableDevice{
-			&types.VirtualMachineBootOptionsBootableEthernetDevice{},
+		nic := &types.VirtualMachineBootOptionsBootableEthernetDevice{}
+		if network != "" {
+			key, err := c.pxeDeviceKey(ctx, vm, network)
+			if err != nil {
+				return err
+			}
+			nic.DeviceKey = key
 		}
+		order = []types.BaseVirtualMachineBootOptionsBootableDevice{nic}
 	case BootDeviceFloppy:
-		bootOptions.BootOrder = []types.BaseVirtualMachineBootOptionsBootableDevice{
+		// Modern and EFI VMs usually have no floppy drive to boot from
+		if err := c.checkReachable(); err != nil {
+			return err
+		}
+		devices, err := vm.Device(ctx)
+		if err != nil {
+			return c.checkFault(fmt.Errorf("failed to get VM devices: %w", err))
+		}
+		if len(devices.SelectByType((*types.VirtualFloppy)(nil))) == 0 {
+			return fmt.Errorf("%w: floppy", ErrNoBootDevice)
+		}
+		order = []types.BaseVirtualMachineBootOptionsBootableDevice{
 			&types.VirtualMachineBootOptionsBootableFloppyDevice{},
 		}
 	default:
 		return fmt.Errorf("unsupported boot device: %s", device)
 	}
 
+	return c.applyBootOrder(ctx, vm, order)
+}
+
+// pxeDeviceKey returns the device key of the VM's NIC matching network
+func (c *Client) pxeDeviceKey(ctx context.Context, vm *object.VirtualMachine, network string) (int32, error) {
+	if err := c.checkReachable(); err != nil {
+		return 0, err
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return 0, c.checkFault(fmt.Errorf("failed to get VM devices: %w", err))
+	}
+	if key, ok := nicForNetwork(devices, network, ""); ok {
+		return key, nil
+	}
+
+	// Distributed port group backings only carry the port group's key
+	ref, err := c.finder.Network(ctx, network)
+	if err != nil {
+		return 0, fmt.Errorf("no NIC matches PXE network %s", network)
+	}
+	if key, ok := nicForNetwork(devices, network, ref.Reference().Value); ok {
+		return key, nil
+	}
+	return 0, fmt.Errorf("no NIC matches PXE network %s", network)
+}
+
+// nicForNetwork returns the device key of the first NIC whose device name
+// or network name is network, or whose distributed port group key is
+// portgroup when that is set
+func nicForNetwork(devices object.VirtualDeviceList, network, portgroup string) (int32, bool) {
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		card, ok := device.(types.BaseVirtualEthernetCard)
+		if !ok {
+			continue
+		}
+		nic := card.GetVirtualEthernetCard()
+		if devices.Name(device) == network {
+			return nic.Key, true
+		}
+
+		switch backing := nic.Backing.(type) {
+		case *types.VirtualEthernetCardNetworkBackingInfo:
+			if backing.DeviceName == network {
+				return nic.Key, true
+			}
+		case *types.VirtualEthernetCardDistributedVirtualPortBackingInfo:
+			if portgroup != "" && backing.Port.PortgroupKey == portgroup {
+				return nic.Key, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// HasBootOrder reports whether the VM has an explicit boot order configured
+func (c *Client) HasBootOrder(ctx context.Context, vm *object.VirtualMachine) (bool, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return false, err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.bootOptions"}, &o)
+	if err != nil {
+		return false, c.checkFault(fmt.Errorf("failed to get VM boot options: %w", err))
+	}
+	return o.Config != nil && o.Config.BootOptions != nil && len(o.Config.BootOptions.BootOrder) > 0, nil
+}
+
+// GetNextBoot returns the device the VM boots from first, or BootDeviceNone
+// when the VM has no explicit boot order
+func (c *Client) GetNextBoot(ctx context.Context, vm *object.VirtualMachine) (BootDevice, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return BootDeviceNone, err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.bootOptions"}, &o)
+	if err != nil {
+		return BootDeviceNone, c.checkFault(fmt.Errorf("failed to get VM boot options: %w", err))
+	}
+	if o.Config == nil || o.Config.BootOptions == nil || len(o.Config.BootOptions.BootOrder) == 0 {
+		return BootDeviceNone, nil
+	}
+
+	switch o.Config.BootOptions.BootOrder[0].(type) {
+	case *types.VirtualMachineBootOptionsBootableDiskDevice:
+		return BootDeviceHDD, nil
+	case *types.VirtualMachineBootOptionsBootableCdromDevice:
+		return BootDeviceCDROM, nil
+	case *types.VirtualMachineBootOptionsBootableEthernetDevice:
+		return BootDevicePXE, nil
+	case *types.VirtualMachineBootOptionsBootableFloppyDevice:
+		return BootDeviceFloppy, nil
+	}
+	return BootDeviceNone, nil // Cleared with an untyped device
+}
+
+// Firmware a VM boots with
+const (
+	FirmwareBIOS = "bios"
+	FirmwareEFI  = "efi"
+)
+
+// GetFirmware returns the firmware the VM boots with. VMs that don't name
+// one boot with BIOS.
+func (c *Client) GetFirmware(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return "", err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.firmware"}, &o)
+	if err != nil {
+		return "", c.checkFault(fmt.Errorf("failed to get VM firmware: %w", err))
+	}
+	if o.Config == nil || o.Config.Firmware == "" {
+		return FirmwareBIOS, nil
+	}
+	return o.Config.Firmware, nil
+}
+
+// SetFirmware switches the firmware the VM boots with. Setting the current
+// firmware does nothing; changing it fails with ErrFirmwareState unless
+// the VM is powered off.
+func (c *Client) SetFirmware(ctx context.Context, vm *object.VirtualMachine, firmware string) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	var o mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.firmware", "runtime.powerState"}, &o)
+	if err != nil {
+		return c.checkFault(fmt.Errorf("failed to get VM firmware: %w", err))
+	}
+	current := FirmwareBIOS
+	if o.Config != nil && o.Config.Firmware != "" {
+		current = o.Config.Firmware
+	}
+	if current == firmware {
+		return nil
+	}
+	if o.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		return fmt.Errorf("%w: it boots with %s", ErrFirmwareState, current)
+	}
+
+	spec := types.VirtualMachineConfigSpec{Firmware: firmware}
+	return c.runTask(ctx, vm, "reconfigure VM", func(ctx context.Context) (*object.Task, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+}
+
+// SetBootOrder sets an explicit boot order for a VM. Each entry is a device
+// type ("disk", "cdrom", "ethernet", "floppy") or a specific device name
+// such as "ethernet-1", resolved against the VM's devices.
+func (c *Client) SetBootOrder(ctx context.Context, vm *object.VirtualMachine, order []string) error {
+	vm = c.bind(vm)
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return c.checkFault(fmt.Errorf("failed to get VM devices: %w", err))
+	}
+
+	bootOrder := devices.BootOrder(order)
+	if len(bootOrder) == 0 {
+		return fmt.Errorf("no devices match boot order %v", order)
+	}
+
+	return c.applyBootOrder(ctx, vm, bootOrder)
+}
+
+// ClearBootOrder removes the VM's explicit boot order so it boots from
+// its default devices again
+func (c *Client) ClearBootOrder(ctx context.Context, vm *object.VirtualMachine) error {
+	vm = c.bind(vm)
+	// A bootable device without a type clears the boot order, as govc does
+	return c.applyBootOrder(ctx, vm, []types.BaseVirtualMachineBootOptionsBootableDevice{
+		&types.VirtualMachineBootOptionsBootableDevice{},
+	})
+}
+
+// applyBootOrder reconfigures the VM with the given boot order
+func (c *Client) applyBootOrder(ctx context.Context, vm *object.VirtualMachine, order []types.BaseVirtualMachineBootOptionsBootableDevice) error {
+	var bootOptions *types.VirtualMachineBootOptions
+	if err := c.checkReachable(); err != nil {
+		return err
+	}
+
+	// Get current configuration
+	var vmConfig mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config"}, &vmConfig)
+	if err != nil {
+		return c.checkFault(fmt.Errorf("failed to get VM config: %w", err))
+	}
+
+	// Create boot options if they don't exist
+	if vmConfig.Config.BootOptions == nil {
+		bootOptions = &types.VirtualMachineBootOptions{}
+	} else {
+		bootOptions = vmConfig.Config.BootOptions
+	}
+	bootOptions.BootOrder = order
+
 	// Create spec for reconfiguration
 	spec := types.VirtualMachineConfigSpec{
 		BootOptions: bootOptions,
 	}
 
 	// Apply the configuration
-	task, err := vm.Reconfigure(ctx, spec)
-	if err != nil {
-		return fmt.Errorf("failed to reconfigure VM: %v", err)
-	}
-
-	return task.Wait(ctx)
+	return c.runTask(ctx, vm, "reconfigure VM", func(ctx context.Context) (*object.Task, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
 }
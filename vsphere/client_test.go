@@ -0,0 +1,637 @@
+package vsphere
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/fault"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/rest"
+	_ "github.com/vmware/govmomi/vapi/simulator" // Registers the tagging service
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testVCenter is a simulated vCenter whose methods can be made to fail
+type testVCenter struct {
+	model  *simulator.Model
+	server *simulator.Server
+
+	mu     sync.Mutex
+	faults map[string][]types.BaseMethodFault // Returned by the next calls of a method, in order
+	calls  map[string]int
+	specs  []types.VirtualMachineConfigSpec // Passed to ReconfigVM_Task, in order
+}
+
+// newTestVCenter starts a simulated vCenter with a host, two VMs and the
+// tagging service
+func newTestVCenter(t *testing.T) *testVCenter {
+	t.Helper()
+	model := simulator.VPX()
+	model.Cluster = 0
+	model.Host = 1
+	model.Machine = 2
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	model.Service.RegisterEndpoints = true // Serve the tagging service too
+
+	v := &testVCenter{
+		model:  model,
+		faults: make(map[string][]types.BaseMethodFault),
+		calls:  make(map[string]int),
+	}
+	model.Map().Handler = v.handle
+	v.server = model.Service.NewServer()
+	t.Cleanup(func() {
+		v.server.Close()
+		model.Remove()
+	})
+	return v
+}
+
+// handle counts calls, records reconfigure specs and fails calls with the
+// faults queued for the method
+func (v *testVCenter) handle(ctx *simulator.Context, m *simulator.Method) (mo.Reference, types.BaseMethodFault) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.calls[m.Name]++
+	if req, ok := m.Body.(*types.ReconfigVM_Task); ok {
+		v.specs = append(v.specs, req.Spec)
+	}
+	if faults := v.faults[m.Name]; len(faults) > 0 {
+		v.faults[m.Name] = faults[1:]
+		return nil, faults[0]
+	}
+	return nil, nil
+}
+
+// fail makes the next calls of a method fail with faults, one per call
+func (v *testVCenter) fail(method string, faults ...types.BaseMethodFault) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.faults[method] = append(v.faults[method], faults...)
+}
+
+// called returns how many times a method was called
+func (v *testVCenter) called(method string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls[method]
+}
+
+// reconfigured returns the specs the VMs were reconfigured with
+func (v *testVCenter) reconfigured() []types.VirtualMachineConfigSpec {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]types.VirtualMachineConfigSpec(nil), v.specs...)
+}
+
+// client connects a new client to the simulated vCenter
+func (v *testVCenter) client(t *testing.T) *Client {
+	t.Helper()
+	password, _ := v.server.URL.User.Password()
+	c, err := NewClient(context.Background(), v.server.URL.Host, v.server.URL.User.Username(), password, "DC0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testVM returns the first simulated VM, powered off
+func testVM(t *testing.T, c *Client) *object.VirtualMachine {
+	t.Helper()
+	ctx := context.Background()
+	vms, err := c.GetVMs(ctx, "")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("no VMs in the simulated vCenter: %v", err)
+	}
+	vm := vms[0]
+	if err := c.PowerOffVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestGetVMsInFolderReportsListFailure(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	root, err := c.finder.Folder(ctx, "/DC0/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lab, err := root.CreateFolder(ctx, "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := lab.MoveInto(ctx, []types.ManagedObjectReference{vm.Reference()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The folder lookup takes three property reads and succeeds, then
+	// listing the folder's VMs fails
+	v.fail("RetrievePropertiesEx", nil, nil, nil, &types.InvalidArgument{})
+	vms, err := c.GetVMs(ctx, "/lab")
+	if err == nil || !strings.Contains(err.Error(), "failed to list VMs") {
+		t.Errorf("listing the folder's VMs returned %d VMs and %v, want the list error", len(vms), err)
+	}
+	if vms, err := c.GetVMs(ctx, "/lab"); err != nil || len(vms) != 1 {
+		t.Errorf("listing the folder's VMs again returned %d VMs and %v, want its VM", len(vms), err)
+	}
+}
+
+func TestTaskInProgress(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+
+	v.fail("PowerOnVM_Task", &types.TaskInProgress{})
+	err := c.PowerOnVM(context.Background(), vm)
+	if !errors.Is(err, ErrTaskInProgress) {
+		t.Errorf("power on during another task returned %v, want %v", err, ErrTaskInProgress)
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	if err := c.CancelTask(ctx, vm); !errors.Is(err, ErrNoTask) {
+		t.Errorf("cancelling without a task returned %v, want %v", err, ErrNoTask)
+	}
+
+	v.fail("PowerOnVM_Task", &types.RequestCanceled{})
+	if err := c.PowerOnVM(ctx, vm); !errors.Is(err, ErrTaskCanceled) {
+		t.Errorf("cancelled power on returned %v, want %v", err, ErrTaskCanceled)
+	}
+}
+
+func TestUnreachableFailsFast(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	v.server.Close()
+	if _, err := c.GetVMPowerState(ctx, vm); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("power state with vCenter down returned %v, want %v", err, ErrUnreachable)
+	}
+
+	// Calls during the cooldown fail without trying vCenter, so the bare
+	// error comes back
+	vms := []*object.VirtualMachine{vm}
+	if _, err := c.GetPowerStates(ctx, vms); err != ErrUnreachable {
+		t.Errorf("power states during the cooldown returned %v, want %v", err, ErrUnreachable)
+	}
+	if _, err := c.GetInstanceUUIDs(ctx, vms); err != ErrUnreachable {
+		t.Errorf("instance UUIDs during the cooldown returned %v, want %v", err, ErrUnreachable)
+	}
+	if err := c.PowerOnVM(ctx, vm); err != ErrUnreachable {
+		t.Errorf("power on during the cooldown returned %v, want %v", err, ErrUnreachable)
+	}
+}
+
+func TestReconfigureRetriesTransientFaults(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 2, Delay: time.Millisecond})
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	for name, reconfigure := range map[string]func() error{
+		"SetFirmware":           func() error { return c.SetFirmware(ctx, vm, FirmwareEFI) },
+		"SetVMHardware":         func() error { return c.SetVMHardware(ctx, vm, VMHardware{NumCPU: 2, MemoryMB: 2048}) },
+		"SetIdentifyAnnotation": func() error { return c.SetIdentifyAnnotation(ctx, vm, true) },
+	} {
+		before := v.called("ReconfigVM_Task")
+		v.fail("ReconfigVM_Task", &types.ResourceInUse{})
+		if err := reconfigure(); err != nil {
+			t.Errorf("%s with the VM briefly in use: %v", name, err)
+		}
+		if n := v.called("ReconfigVM_Task") - before; n != 2 {
+			t.Errorf("%s reconfigured the VM %d times, want 2", name, n)
+		}
+	}
+}
+
+func TestSetFirmwareRequestsEFI(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	if err := c.SetFirmware(ctx, vm, FirmwareEFI); err != nil {
+		t.Fatalf("switching to EFI: %v", err)
+	}
+	specs := v.reconfigured()
+	if len(specs) != 1 || specs[0].Firmware != FirmwareEFI {
+		t.Fatalf("reconfigured with %+v, want a single spec requesting EFI", specs)
+	}
+	if fw, err := c.GetFirmware(ctx, vm); err != nil || fw != FirmwareEFI {
+		t.Errorf("VM boots with %q (%v) after the switch, want %q", fw, err, FirmwareEFI)
+	}
+
+	// Asking for the firmware the VM already has doesn't reconfigure it
+	if err := c.SetFirmware(ctx, vm, FirmwareEFI); err != nil {
+		t.Errorf("switching to EFI again: %v", err)
+	}
+	if n := len(v.reconfigured()); n != 1 {
+		t.Errorf("reconfigured %d times, want 1", n)
+	}
+}
+
+func TestExpiredSessionRenewed(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	// Logging out ends the session on the server the way expiry does. The
+	// simulator answers property reads without a session, so the first
+	// call after it is a task.
+	if err := c.client.SessionManager.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	logins := v.called("Login")
+	if err := c.PowerOnVM(ctx, vm); err != nil {
+		t.Fatalf("power on with an expired session: %v", err)
+	}
+	if n := v.called("Login") - logins; n != 1 {
+		t.Errorf("logged in %d times to renew the session, want 1", n)
+	}
+	if n := v.called("PowerOnVM_Task"); n != 2 {
+		t.Errorf("power on sent %d times, want the failed call and its retry", n)
+	}
+	if state, err := c.GetVMPowerState(ctx, vm); err != nil || state != string(types.VirtualMachinePowerStatePoweredOn) {
+		t.Errorf("power state is %s (%v) with the renewed session, want poweredOn", state, err)
+	}
+	if n := v.called("Login") - logins; n != 1 {
+		t.Errorf("logged in %d times in all, want 1", n)
+	}
+}
+
+func TestPowerTaskRetries(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 4, Delay: time.Millisecond})
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	// Transient faults are retried until the task succeeds
+	v.fail("PowerOnVM_Task", &types.ResourceInUse{}, &types.ConcurrentAccess{})
+	if err := c.PowerOnVM(ctx, vm); err != nil {
+		t.Fatalf("power on after two transient faults: %v", err)
+	}
+	if n := v.called("PowerOnVM_Task"); n != 3 {
+		t.Errorf("powered on %d times, want 3", n)
+	}
+
+	// ...but only as often as the policy allows
+	v.fail("ResetVM_Task", &types.ResourceInUse{}, &types.ResourceInUse{}, &types.ResourceInUse{}, &types.ResourceInUse{})
+	if err := c.ResetVM(ctx, vm); !fault.Is(err, &types.ResourceInUse{}) {
+		t.Errorf("reset failing every attempt returned %v, want the ResourceInUse fault", err)
+	}
+	if n := v.called("ResetVM_Task"); n != 4 {
+		t.Errorf("reset %d times, want 4 attempts", n)
+	}
+
+	// Other faults aren't
+	before := v.called("PowerOffVM_Task")
+	v.fail("PowerOffVM_Task", &types.NotSupported{})
+	if err := c.PowerOffVM(ctx, vm); !fault.Is(err, &types.NotSupported{}) {
+		t.Errorf("power off with a permanent fault returned %v, want the NotSupported fault", err)
+	}
+	if n := v.called("PowerOffVM_Task") - before; n != 1 {
+		t.Errorf("powered off %d times after a permanent fault, want 1", n)
+	}
+}
+
+func TestPowerTaskRetryRespectsDeadline(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 3, Delay: time.Minute})
+	vm := testVM(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v.fail("PowerOnVM_Task", &types.ResourceInUse{})
+	start := time.Now()
+	if err := c.PowerOnVM(ctx, vm); !fault.Is(err, &types.ResourceInUse{}) {
+		t.Errorf("power on whose retry would pass the deadline returned %v, want the ResourceInUse fault", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("power on took %s, want it to give up without waiting", d)
+	}
+	if n := v.called("PowerOnVM_Task"); n != 1 {
+		t.Errorf("powered on %d times, want 1", n)
+	}
+}
+
+func TestPowerAlreadyInState(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	before := v.called("PowerOffVM_Task")
+	if err := c.PowerOffVM(ctx, vm); err != nil {
+		t.Errorf("power off when off: %v", err)
+	}
+	if n := v.called("PowerOffVM_Task") - before; n != 1 {
+		t.Errorf("power off when off sent %d tasks, want 1", n)
+	}
+
+	if err := c.PowerOnVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PowerOnVM(ctx, vm); err != nil {
+		t.Errorf("power on when on: %v", err)
+	}
+
+	// The fault is only forgiven for the state asked for
+	if err := c.SuspendVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PowerOffVM(ctx, vm); err != nil {
+		t.Errorf("power off when suspended: %v", err)
+	}
+	if err := c.SuspendVM(ctx, vm); !errors.Is(err, ErrSuspendState) {
+		t.Errorf("suspend when off returned %v, want %v", err, ErrSuspendState)
+	}
+}
+
+func TestReconfiguredVMsDropsCachedTags(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	cached := cachedTags{tags: map[string][]string{"env": {"prod"}}, expires: time.Now().Add(tagCacheTTL)}
+	c.tagCache.vms[VMKey(vm)] = cached
+	c.tagCache.vms["vm-other"] = cached
+
+	if vms, err := c.ReconfiguredVMs(ctx); err != nil || len(vms) != 0 {
+		t.Fatalf("reconfigured VMs before any change are %v (%v)", vms, err)
+	}
+	if err := c.SetFirmware(ctx, vm, FirmwareEFI); err != nil {
+		t.Fatal(err)
+	}
+	vms, err := c.ReconfiguredVMs(ctx)
+	if err != nil || len(vms) != 1 || vms[0] != VMKey(vm) {
+		t.Fatalf("reconfigured VMs are %v (%v), want [%s]", vms, err, VMKey(vm))
+	}
+	if _, ok := c.tagCache.vms[VMKey(vm)]; ok {
+		t.Error("tags of the reconfigured VM still cached")
+	}
+	if _, ok := c.tagCache.vms["vm-other"]; !ok {
+		t.Error("tags of another VM dropped")
+	}
+
+	// Events are only reported once
+	if vms, err := c.ReconfiguredVMs(ctx); err != nil || len(vms) != 0 {
+		t.Errorf("reconfigured VMs on the next check are %v (%v), want none", vms, err)
+	}
+}
+
+func TestInvalidateCache(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	cached := cachedTags{tags: map[string][]string{"env": {"prod"}}, expires: time.Now().Add(tagCacheTTL)}
+	c.tagCache.vms["vm-1"] = cached
+	c.tagCache.vms["vm-2"] = cached
+	c.tagCache.unavailable = time.Now().Add(tagCacheTTL)
+
+	c.InvalidateCache("vm-1")
+	if _, ok := c.tagCache.vms["vm-1"]; ok || len(c.tagCache.vms) != 1 {
+		t.Errorf("cached tags after invalidating vm-1: %v", c.tagCache.vms)
+	}
+	if c.tagCache.unavailable.IsZero() {
+		t.Error("invalidating one VM retries the tagging service")
+	}
+
+	c.InvalidateCache("")
+	if len(c.tagCache.vms) != 0 || !c.tagCache.unavailable.IsZero() {
+		t.Errorf("cache not cleared: %d VMs, unavailable until %v", len(c.tagCache.vms), c.tagCache.unavailable)
+	}
+}
+
+func TestGetVMTags(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	rc := rest.NewClient(c.client.Client)
+	if err := rc.Login(ctx, c.user); err != nil {
+		t.Fatal(err)
+	}
+	manager := tags.NewManager(rc)
+	categoryID, err := manager.CreateCategory(ctx, &tags.Category{Name: "environment", Cardinality: "MULTIPLE", AssociableTypes: []string{"VirtualMachine"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"prod", "eu"} {
+		tagID, err := manager.CreateTag(ctx, &tags.Tag{Name: name, CategoryID: categoryID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.AttachTag(ctx, tagID, vm.Reference()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := c.GetVMTags(ctx, vm)
+	if err != nil {
+		t.Fatalf("GetVMTags: %v", err)
+	}
+	if want := []string{"eu", "prod"}; len(got) != 1 || !slices.Equal(got["environment"], want) {
+		t.Errorf("tags are %v, want environment: %v", got, want)
+	}
+}
+
+func TestGetVMTagsUnavailable(t *testing.T) {
+	// A standalone ESXi host has no tagging service
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	model.Service.RegisterEndpoints = true
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+	password, _ := server.URL.User.Password()
+	c, err := NewClient(context.Background(), server.URL.Host, server.URL.User.Username(), password, "ha-datacenter", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := testVM(t, c)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetVMTags(context.Background(), vm); !errors.Is(err, ErrTagsUnavailable) {
+			t.Errorf("GetVMTags on ESXi returned %v, want %v", err, ErrTagsUnavailable)
+		}
+	}
+	if c.tagCache.unavailable.IsZero() {
+		t.Error("unavailable tagging service not remembered")
+	}
+}
+
+func TestPXESelectsNonFirstNIC(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	// The first NIC is on the distributed port group; add a second one on
+	// the standard network
+	network, err := c.finder.Network(ctx, "VM Network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backing, err := network.EthernetCardBackingInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := object.EthernetCardTypes().CreateEthernetCard("vmxnet3", backing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddDevice(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nics := devices.SelectByType((*types.VirtualEthernetCard)(nil))
+	if len(nics) != 2 {
+		t.Fatalf("VM has %d NICs, want 2", len(nics))
+	}
+	second := nics[1].GetVirtualDevice().Key
+
+	for _, network := range []string{"VM Network", devices.Name(nics[1])} {
+		if err := c.SetNextBoot(ctx, vm, BootDevicePXE, network); err != nil {
+			t.Fatalf("PXE from %s: %v", network, err)
+		}
+		specs := v.reconfigured()
+		order := specs[len(specs)-1].BootOptions.BootOrder
+		nic, ok := order[0].(*types.VirtualMachineBootOptionsBootableEthernetDevice)
+		if len(order) != 1 || !ok || nic.DeviceKey != second {
+			t.Errorf("PXE from %s set boot order %+v, want the second NIC %d", network, order, second)
+		}
+	}
+}
+
+func TestFindVMByUUIDAndMoRef(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	ctx := context.Background()
+
+	// Create a VM with known UUIDs
+	const biosUUID, instanceUUID = "42112233-4455-6677-8899-aabbccddeeff", "50112233-4455-6677-8899-aabbccddeeff"
+	folder, err := c.finder.Folder(ctx, "/DC0/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := c.finder.DefaultResourcePool(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := types.VirtualMachineConfigSpec{
+		Name:         "known-uuid",
+		GuestId:      string(types.VirtualMachineGuestOsIdentifierOtherGuest64),
+		Uuid:         biosUUID,
+		InstanceUuid: instanceUUID,
+		Files:        &types.VirtualMachineFileInfo{VmPathName: "[LocalDS_0]"},
+	}
+	task, err := folder.CreateVM(ctx, spec, pool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := task.WaitForResult(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := info.Result.(types.ManagedObjectReference).Value
+
+	for _, tc := range []struct {
+		name string
+		find func() (*object.VirtualMachine, error)
+	}{
+		{"BIOS UUID", func() (*object.VirtualMachine, error) { return c.FindVMByUUID(ctx, biosUUID, false) }},
+		{"instance UUID", func() (*object.VirtualMachine, error) { return c.FindVMByUUID(ctx, instanceUUID, true) }},
+		{"managed object reference", func() (*object.VirtualMachine, error) { return c.FindVMByMoRef(ctx, ref) }},
+	} {
+		vm, err := tc.find()
+		if err != nil {
+			t.Errorf("by %s: %v", tc.name, err)
+			continue
+		}
+		if vm.Reference().Value != ref || vm.Name() != "known-uuid" {
+			t.Errorf("by %s found %s (%s), want known-uuid (%s)", tc.name, vm.Name(), vm.Reference().Value, ref)
+		}
+	}
+
+	// The instance UUID isn't a BIOS UUID, and unknown references aren't found
+	if _, err := c.FindVMByUUID(ctx, instanceUUID, false); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("instance UUID as a BIOS UUID found %v, want %v", err, ErrVMNotFound)
+	}
+	if _, err := c.FindVMByMoRef(ctx, "vm-missing"); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("unknown reference returned %v, want %v", err, ErrVMNotFound)
+	}
+}
+
+func TestGetVMStatsFromQuickStats(t *testing.T) {
+	v := newTestVCenter(t)
+	c := v.client(t)
+	vm := testVM(t, c)
+	ctx := context.Background()
+
+	if _, err := c.GetVMStats(ctx, vm); !errors.Is(err, ErrStatsUnavailable) {
+		t.Errorf("stats of a powered off VM returned %v, want %v", err, ErrStatsUnavailable)
+	}
+
+	if err := c.PowerOnVM(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Report usage in the simulated VM's quick stats
+	simVM := v.model.Map().Get(vm.Reference()).(*simulator.VirtualMachine)
+	sctx := simulator.NewContext()
+	sctx.Map = v.model.Map()
+	sctx.WithLock(simVM, func() {
+		stats := simVM.Summary.QuickStats
+		stats.OverallCpuUsage = 1234
+		stats.GuestMemoryUsage = 2048
+		sctx.Update(simVM, []types.PropertyChange{{Name: "summary.quickStats", Val: stats}})
+	})
+
+	got, err := c.GetVMStats(ctx, vm)
+	if err != nil {
+		t.Fatalf("stats of a powered on VM: %v", err)
+	}
+	if got.CPUUsageMHz != 1234 || got.ActiveMemoryMB != 2048 {
+		t.Errorf("stats are %+v, want 1234 MHz and 2048 MB", got)
+	}
+}
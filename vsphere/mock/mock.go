@@ -0,0 +1,444 @@
+// Package mock provides a fake vSphere client that keeps VM state in
+// memory, so BMCs can be driven without a vCenter
+package mock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/vbmc-vsphere/vsphere"
+	"github.com/vmware/govmomi/object"
+)
+
+// Call is a method called on the client
+type Call struct {
+	Method string
+	VM     string // VM reference value
+}
+
+// VM is the state the client keeps for a VM
+type VM struct {
+	PowerState       string // poweredOn, poweredOff or suspended
+	ConnectionState  string
+	BootOrder        []string // vSphere device types or names, nil for none
+	Firmware         string   // bios or efi, empty for bios
+	InstanceUUID     string
+	Name             string // Defaults to the object's name
+	UUID             string // BIOS UUID
+	Annotation       string
+	Hardware         vsphere.VMHardware
+	Stats            vsphere.VMStats // Reported while powered on
+	CustomAttributes map[string]string
+	ToolsRunning     bool
+	IgnoresShutdown  bool // The guest accepts shutdown requests but keeps running
+	NoFloppy         bool // The VM has no floppy drive to boot from
+}
+
+// Client is a fake vsphere.VMClient. VMs start powered off and connected
+// the first time they are used. Errors set for a method are returned
+// instead of changing state.
+type Client struct {
+	mu     sync.Mutex
+	vms    map[string]*VM
+	errors map[string]error
+	calls  []Call
+}
+
+var _ vsphere.VMClient = (*Client)(nil)
+
+// New returns a client with no VMs
+func New() *Client {
+	return &Client{
+		vms:    make(map[string]*VM),
+		errors: make(map[string]error),
+	}
+}
+
+// VM returns a copy of the state of a VM
+func (c *Client) VM(vm *object.VirtualMachine) VM {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.vm(vm)
+}
+
+// SetVM replaces the state of a VM
+func (c *Client) SetVM(vm *object.VirtualMachine, state VM) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vms[vm.Reference().Value] = &state
+}
+
+// SetError makes a method fail with err, or succeed again if err is nil
+func (c *Client) SetError(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[method] = err
+}
+
+// Calls returns the methods called so far, in order
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// vm returns the state of a VM, creating it on first use. Must be called
+// with the client locked.
+func (c *Client) vm(vm *object.VirtualMachine) *VM {
+	key := vm.Reference().Value
+	state, ok := c.vms[key]
+	if !ok {
+		state = &VM{
+			PowerState:       "poweredOff",
+			ConnectionState:  "connected",
+			CustomAttributes: make(map[string]string),
+		}
+		c.vms[key] = state
+	}
+	return state
+}
+
+// call records a call and returns the VM's state and the error set for
+// the method. Must be called with the client locked.
+func (c *Client) call(method string, vm *object.VirtualMachine) (*VM, error) {
+	c.calls = append(c.calls, Call{Method: method, VM: vm.Reference().Value})
+	return c.vm(vm), c.errors[method]
+}
+
+// PowerOnVM powers the VM on
+func (c *Client) PowerOnVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("PowerOnVM", vm)
+	if err != nil {
+		return err
+	}
+	state.PowerState = "poweredOn"
+	return nil
+}
+
+// PowerOffVM powers the VM off
+func (c *Client) PowerOffVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("PowerOffVM", vm)
+	if err != nil {
+		return err
+	}
+	state.PowerState = "poweredOff"
+	return nil
+}
+
+// SuspendVM suspends a powered-on VM
+func (c *Client) SuspendVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SuspendVM", vm)
+	if err != nil {
+		return err
+	}
+	if state.PowerState == "poweredOff" {
+		return vsphere.ErrSuspendState
+	}
+	state.PowerState = "suspended"
+	return nil
+}
+
+// ResetVM leaves a powered-on VM on
+func (c *Client) ResetVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.call("ResetVM", vm)
+	return err
+}
+
+// GetVMPowerState returns the VM's power state
+func (c *Client) GetVMPowerState(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMPowerState", vm)
+	return state.PowerState, err
+}
+
+// SetNextBoot makes the device type the only entry of the boot order. A
+// VM without a floppy drive can't boot from floppy.
+func (c *Client) SetNextBoot(ctx context.Context, vm *object.VirtualMachine, device vsphere.BootDevice, network string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetNextBoot", vm)
+	if err != nil {
+		return err
+	}
+	if device == vsphere.BootDeviceFloppy && state.NoFloppy {
+		return fmt.Errorf("%w: floppy", vsphere.ErrNoBootDevice)
+	}
+	state.BootOrder = []string{string(device)}
+	return nil
+}
+
+// WaitForPowerState returns at once if the VM is in the state, and fails
+// otherwise as nothing else changes it
+func (c *Client) WaitForPowerState(ctx context.Context, vm *object.VirtualMachine, powerState string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("WaitForPowerState", vm)
+	if err != nil {
+		return err
+	}
+	if state.PowerState != powerState {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// ShutdownGuestVM powers the VM off if VMware Tools are running, unless
+// the guest ignores shutdown requests
+func (c *Client) ShutdownGuestVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("ShutdownGuestVM", vm)
+	if err != nil {
+		return err
+	}
+	if !state.ToolsRunning {
+		return vsphere.ErrToolsUnavailable
+	}
+	if !state.IgnoresShutdown {
+		state.PowerState = "poweredOff"
+	}
+	return nil
+}
+
+// RebootGuestVM succeeds if VMware Tools are running
+func (c *Client) RebootGuestVM(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("RebootGuestVM", vm)
+	if err != nil {
+		return err
+	}
+	if !state.ToolsRunning {
+		return vsphere.ErrToolsUnavailable
+	}
+	return nil
+}
+
+// SendNMI succeeds if the VM is powered on
+func (c *Client) SendNMI(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SendNMI", vm)
+	if err != nil {
+		return err
+	}
+	if state.PowerState != "poweredOn" {
+		return vsphere.ErrNMIUnavailable
+	}
+	return nil
+}
+
+// GetVMConnectionState returns the VM's connection state
+func (c *Client) GetVMConnectionState(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMConnectionState", vm)
+	return state.ConnectionState, err
+}
+
+// CancelTask reports there is no task, as calls complete at once
+func (c *Client) CancelTask(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.call("CancelTask", vm); err != nil {
+		return err
+	}
+	return vsphere.ErrNoTask
+}
+
+// GetNextBoot returns the device type of the first boot order entry
+func (c *Client) GetNextBoot(ctx context.Context, vm *object.VirtualMachine) (vsphere.BootDevice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetNextBoot", vm)
+	if err != nil || len(state.BootOrder) == 0 {
+		return vsphere.BootDeviceNone, err
+	}
+	return vsphere.BootDevice(state.BootOrder[0]), nil
+}
+
+// HasBootOrder reports whether the VM has a boot order
+func (c *Client) HasBootOrder(ctx context.Context, vm *object.VirtualMachine) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("HasBootOrder", vm)
+	return len(state.BootOrder) > 0, err
+}
+
+// SetBootOrder replaces the VM's boot order
+func (c *Client) SetBootOrder(ctx context.Context, vm *object.VirtualMachine, order []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetBootOrder", vm)
+	if err != nil {
+		return err
+	}
+	state.BootOrder = append([]string(nil), order...)
+	return nil
+}
+
+// ClearBootOrder removes the VM's boot order
+func (c *Client) ClearBootOrder(ctx context.Context, vm *object.VirtualMachine) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("ClearBootOrder", vm)
+	if err != nil {
+		return err
+	}
+	state.BootOrder = nil
+	return nil
+}
+
+// GetFirmware returns the VM's firmware
+func (c *Client) GetFirmware(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetFirmware", vm)
+	if err != nil || state.Firmware == "" {
+		return vsphere.FirmwareBIOS, err
+	}
+	return state.Firmware, nil
+}
+
+// SetFirmware changes the VM's firmware while it is powered off
+func (c *Client) SetFirmware(ctx context.Context, vm *object.VirtualMachine, firmware string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetFirmware", vm)
+	if err != nil {
+		return err
+	}
+	current := state.Firmware
+	if current == "" {
+		current = vsphere.FirmwareBIOS
+	}
+	if current == firmware {
+		return nil
+	}
+	if state.PowerState != "poweredOff" {
+		return vsphere.ErrFirmwareState
+	}
+	state.Firmware = firmware
+	return nil
+}
+
+// GetInstanceUUID returns the VM's instance UUID
+func (c *Client) GetInstanceUUID(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetInstanceUUID", vm)
+	return state.InstanceUUID, err
+}
+
+// GetVMIdentity returns the VM's name, BIOS UUID and reference
+func (c *Client) GetVMIdentity(ctx context.Context, vm *object.VirtualMachine) (*vsphere.VMIdentity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMIdentity", vm)
+	if err != nil {
+		return nil, err
+	}
+	id := &vsphere.VMIdentity{Name: state.Name, UUID: state.UUID, MoRef: vsphere.VMKey(vm)}
+	if id.Name == "" {
+		id.Name = vm.Name()
+	}
+	return id, nil
+}
+
+// GetVMAnnotation returns the VM's notes
+func (c *Client) GetVMAnnotation(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMAnnotation", vm)
+	return state.Annotation, err
+}
+
+// SetIdentifyAnnotation replaces the VM's notes with the identify line
+func (c *Client) SetIdentifyAnnotation(ctx context.Context, vm *object.VirtualMachine, on bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetIdentifyAnnotation", vm)
+	if err != nil {
+		return err
+	}
+	state.Annotation = "vBMC identify: off"
+	if on {
+		state.Annotation = "vBMC identify: on"
+	}
+	return nil
+}
+
+// GetVMHardware returns the VM's sizing
+func (c *Client) GetVMHardware(ctx context.Context, vm *object.VirtualMachine) (*vsphere.VMHardware, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMHardware", vm)
+	if err != nil {
+		return nil, err
+	}
+	hw := state.Hardware
+	return &hw, nil
+}
+
+// GetVMStats returns the VM's usage while it is powered on
+func (c *Client) GetVMStats(ctx context.Context, vm *object.VirtualMachine) (*vsphere.VMStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetVMStats", vm)
+	if err != nil {
+		return nil, err
+	}
+	if state.PowerState != "poweredOn" {
+		return nil, vsphere.ErrStatsUnavailable
+	}
+	stats := state.Stats
+	return &stats, nil
+}
+
+// SetVMHardware changes the VM's sizing while it is powered off
+func (c *Client) SetVMHardware(ctx context.Context, vm *object.VirtualMachine, hw vsphere.VMHardware) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetVMHardware", vm)
+	if err != nil {
+		return err
+	}
+	if state.PowerState != "poweredOff" {
+		return vsphere.ErrHardwareState
+	}
+	state.Hardware = hw
+	return nil
+}
+
+// GetCustomAttribute returns a custom attribute of the VM
+func (c *Client) GetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("GetCustomAttribute", vm)
+	return state.CustomAttributes[name], err
+}
+
+// SetCustomAttribute sets a custom attribute of the VM
+func (c *Client) SetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.call("SetCustomAttribute", vm)
+	if err != nil {
+		return err
+	}
+	if state.CustomAttributes == nil {
+		state.CustomAttributes = make(map[string]string)
+	}
+	state.CustomAttributes[name] = value
+	return nil
+}
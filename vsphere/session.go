@@ -0,0 +1,80 @@
+package vsphere
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/fault"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// relogin is a soap.RoundTripper that logs in again when vCenter reports
+// the session has expired, then retries the failed call once. vCenter
+// expires idle sessions, which otherwise breaks every call until restart.
+type relogin struct {
+	soap.RoundTripper
+	login func(ctx context.Context) error
+	log   *logrus.Entry
+
+	mu         sync.Mutex
+	generation uint64 // Incremented on each login, so concurrent callers log in once
+}
+
+// newRelogin wraps the round tripper of a vim25 client. Logins bypass the
+// wrapper, so a rejected login can't recurse.
+func newRelogin(c *vim25.Client, user *url.Userinfo, log *logrus.Entry) *relogin {
+	direct := *c
+	return &relogin{
+		RoundTripper: c.RoundTripper,
+		login: func(ctx context.Context) error {
+			return session.NewManager(&direct).Login(ctx, user)
+		},
+		log: log,
+	}
+}
+
+// RoundTrip performs a call, logging in again and retrying once if the
+// session has expired
+func (r *relogin) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	r.mu.Lock()
+	generation := r.generation
+	r.mu.Unlock()
+
+	err := r.RoundTripper.RoundTrip(ctx, req, res)
+	if err == nil || !fault.Is(err, &types.NotAuthenticated{}) {
+		return err
+	}
+
+	if lerr := r.renew(ctx, generation); lerr != nil {
+		r.log.Errorf("Failed to renew expired vCenter session: %v", lerr)
+		return err
+	}
+
+	// Decoding doesn't clear the fault of the failed attempt
+	v := reflect.ValueOf(res).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return r.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+// renew logs in again unless another call already did since generation
+func (r *relogin) renew(ctx context.Context, generation uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.generation != generation {
+		return nil
+	}
+
+	r.log.Warn("vCenter session expired, logging in again")
+	if err := r.login(ctx); err != nil {
+		return err
+	}
+	r.generation++
+	r.log.Info("Renewed vCenter session")
+	return nil
+}
@@ -0,0 +1,103 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+)
+
+// ErrTagsUnavailable is returned when vCenter's tagging service can't be
+// used, e.g. when connected to a standalone ESXi host
+var ErrTagsUnavailable = errors.New("vSphere tagging service is unavailable")
+
+// tagCacheTTL is how long a VM's tags, or an unavailable tagging service,
+// are remembered before vCenter is asked again
+const tagCacheTTL = 5 * time.Minute
+
+// tagCache holds the vAPI session used for tags and the tags read so far
+type tagCache struct {
+	mu          sync.Mutex
+	rest        *rest.Client          // Logged in on first use
+	categories  map[string]string     // Category name by ID
+	vms         map[string]cachedTags // Tags by VM reference value
+	unavailable time.Time             // Until when the tagging service is not retried
+}
+
+// cachedTags is a VM's tags by category name and when they go stale
+type cachedTags struct {
+	tags    map[string][]string
+	expires time.Time
+}
+
+// GetVMTags returns the names of the tags attached to the VM, by category
+// name. Results are cached for tagCacheTTL. ErrTagsUnavailable is returned
+// when vCenter has no tagging service.
+func (c *Client) GetVMTags(ctx context.Context, vm *object.VirtualMachine) (map[string][]string, error) {
+	c.tagCache.mu.Lock()
+	defer c.tagCache.mu.Unlock()
+
+	now := time.Now()
+	key := vm.Reference().Value
+	if cached, ok := c.tagCache.vms[key]; ok && now.Before(cached.expires) {
+		return cached.tags, nil
+	}
+	if now.Before(c.tagCache.unavailable) {
+		return nil, ErrTagsUnavailable
+	}
+
+	manager, err := c.tagManager(ctx)
+	if err != nil {
+		c.tagCache.unavailable = now.Add(tagCacheTTL)
+		return nil, err
+	}
+
+	attached, err := manager.GetAttachedTags(ctx, vm)
+	if err != nil {
+		if rest.IsStatusError(err, http.StatusUnauthorized) {
+			c.tagCache.rest = nil // Log in again next time
+		}
+		return nil, fmt.Errorf("failed to get tags of VM %s: %v", vm.Name(), err)
+	}
+
+	byCategory := make(map[string][]string)
+	for _, tag := range attached {
+		category, ok := c.tagCache.categories[tag.CategoryID]
+		if !ok {
+			cat, err := manager.GetCategory(ctx, tag.CategoryID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tag category %s: %v", tag.CategoryID, err)
+			}
+			category = cat.Name
+			c.tagCache.categories[tag.CategoryID] = category
+		}
+		byCategory[category] = append(byCategory[category], tag.Name)
+	}
+	for _, names := range byCategory {
+		sort.Strings(names)
+	}
+
+	c.tagCache.vms[key] = cachedTags{tags: byCategory, expires: now.Add(tagCacheTTL)}
+	return byCategory, nil
+}
+
+// tagManager returns a tag manager on this client's vAPI session, logging
+// in with the client's credentials on first use. Must be called with the
+// tag cache locked.
+func (c *Client) tagManager(ctx context.Context) (*tags.Manager, error) {
+	if c.tagCache.rest == nil {
+		rc := rest.NewClient(c.client.Client)
+		if err := rc.Login(ctx, c.user); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTagsUnavailable, err)
+		}
+		c.tagCache.rest = rc
+	}
+	return tags.NewManager(c.tagCache.rest), nil
+}
@@ -0,0 +1,58 @@
+package vsphere
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/object"
+)
+
+// VMPower powers a VM on and off and picks the device it boots from
+type VMPower interface {
+	PowerOnVM(ctx context.Context, vm *object.VirtualMachine) error
+	PowerOffVM(ctx context.Context, vm *object.VirtualMachine) error
+	ResetVM(ctx context.Context, vm *object.VirtualMachine) error
+	GetVMPowerState(ctx context.Context, vm *object.VirtualMachine) (string, error)
+	SetNextBoot(ctx context.Context, vm *object.VirtualMachine, device BootDevice, network string) error
+}
+
+// VMClient is everything a BMC does to its VM through vCenter. Client
+// implements it; the mock package provides a fake for tests.
+type VMClient interface {
+	VMPower
+
+	// Power
+	WaitForPowerState(ctx context.Context, vm *object.VirtualMachine, state string) error
+	SuspendVM(ctx context.Context, vm *object.VirtualMachine) error
+	ShutdownGuestVM(ctx context.Context, vm *object.VirtualMachine) error
+	RebootGuestVM(ctx context.Context, vm *object.VirtualMachine) error
+	SendNMI(ctx context.Context, vm *object.VirtualMachine) error
+	GetVMConnectionState(ctx context.Context, vm *object.VirtualMachine) (string, error)
+	CancelTask(ctx context.Context, vm *object.VirtualMachine) error
+
+	// Boot order
+	GetNextBoot(ctx context.Context, vm *object.VirtualMachine) (BootDevice, error)
+	HasBootOrder(ctx context.Context, vm *object.VirtualMachine) (bool, error)
+	SetBootOrder(ctx context.Context, vm *object.VirtualMachine, order []string) error
+	ClearBootOrder(ctx context.Context, vm *object.VirtualMachine) error
+	GetFirmware(ctx context.Context, vm *object.VirtualMachine) (string, error)
+	SetFirmware(ctx context.Context, vm *object.VirtualMachine, firmware string) error
+
+	// Configuration
+	GetInstanceUUID(ctx context.Context, vm *object.VirtualMachine) (string, error)
+	GetVMIdentity(ctx context.Context, vm *object.VirtualMachine) (*VMIdentity, error)
+	GetVMAnnotation(ctx context.Context, vm *object.VirtualMachine) (string, error)
+	SetIdentifyAnnotation(ctx context.Context, vm *object.VirtualMachine, on bool) error
+	GetVMHardware(ctx context.Context, vm *object.VirtualMachine) (*VMHardware, error)
+	SetVMHardware(ctx context.Context, vm *object.VirtualMachine, hw VMHardware) error
+	GetVMStats(ctx context.Context, vm *object.VirtualMachine) (*VMStats, error)
+	GetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name string) (string, error)
+	SetCustomAttribute(ctx context.Context, vm *object.VirtualMachine, name, value string) error
+}
+
+var _ VMClient = (*Client)(nil)
+
+// VMKey returns the key a VM is stored under in the IP database, its
+// managed object reference value. Unlike names, it is stable across renames.
+func VMKey(vm *object.VirtualMachine) string {
+	return vm.Reference().Value
+}